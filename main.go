@@ -1,16 +1,45 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/AntonioDaria/surfe/src/config"
+	"github.com/AntonioDaria/surfe/src/events"
+	"github.com/AntonioDaria/surfe/src/graphql"
+	"github.com/AntonioDaria/surfe/src/grpc"
 	"github.com/AntonioDaria/surfe/src/handlers/action"
+	audit_handler "github.com/AntonioDaria/surfe/src/handlers/audit"
+	"github.com/AntonioDaria/surfe/src/handlers/docs"
+	graphql_handler "github.com/AntonioDaria/surfe/src/handlers/graphql"
+	health_handler "github.com/AntonioDaria/surfe/src/handlers/health"
+	profile_handler "github.com/AntonioDaria/surfe/src/handlers/profile"
+	"github.com/AntonioDaria/surfe/src/handlers/stream"
 	"github.com/AntonioDaria/surfe/src/handlers/user"
+	webhook_handler "github.com/AntonioDaria/surfe/src/handlers/webhook"
+	"github.com/AntonioDaria/surfe/src/health"
+	"github.com/AntonioDaria/surfe/src/metrics"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/middleware/deadline"
+	"github.com/AntonioDaria/surfe/src/middleware/deprecation"
+	"github.com/AntonioDaria/surfe/src/middleware/idempotency"
+	"github.com/AntonioDaria/surfe/src/middleware/ratelimit"
+	"github.com/AntonioDaria/surfe/src/middleware/requestlog"
+	"github.com/AntonioDaria/surfe/src/openapi"
 	action_repo "github.com/AntonioDaria/surfe/src/repository/action"
+	audit_repo "github.com/AntonioDaria/surfe/src/repository/audit"
 	user_repo "github.com/AntonioDaria/surfe/src/repository/user"
+	webhook_repo "github.com/AntonioDaria/surfe/src/repository/webhook"
 	"github.com/AntonioDaria/surfe/src/router"
 	"github.com/AntonioDaria/surfe/src/server"
 	action_service "github.com/AntonioDaria/surfe/src/services/action"
+	audit_service "github.com/AntonioDaria/surfe/src/services/audit"
+	profile_service "github.com/AntonioDaria/surfe/src/services/profile"
 	users_service "github.com/AntonioDaria/surfe/src/services/user"
+	webhook_service "github.com/AntonioDaria/surfe/src/services/webhook"
+	"github.com/AntonioDaria/surfe/src/tracing"
 
 	"github.com/rs/zerolog"
 )
@@ -19,37 +48,323 @@ func main() {
 	// Set up logger
 	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
 
-	// Load User JSON data
-	userRepo, err := user_repo.NewUserRepo("./src/repository/data/users.json")
+	if err := run(logger); err != nil {
+		logger.Fatal().Err(err).Msg("Service stopped")
+	}
+}
+
+// run starts the service and serves until it is shut down. Failures are returned
+// rather than exiting, so the repositories opened on the way are always closed.
+func run(logger zerolog.Logger) error {
+	// Load configuration from the environment
+	cfg, err := config.Load()
 	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to load user data")
+		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
-	// Load Action JSON data
-	actionRepo, err := action_repo.NewActionRepo("./src/repository/data/actions.json")
+	// Set up tracing
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		ServiceName: "surfe",
+		Exporter:    cfg.TracingExporter,
+		FilePath:    cfg.TracingFile,
+	})
 	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to load action data")
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
+	// The service reports not ready until the data is loaded and validated
+	healthState := health.NewState()
+
+	// Set up Prometheus metrics
+	appMetrics := metrics.New()
+
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create the data directory: %w", err)
+	}
+
+	// Load the users, from the JSON data until they are first changed
+	loadStart := time.Now()
+	userRepo, err := user_repo.OpenUserRepo(cfg.UsersFile, "./src/repository/data/users.json")
+	if err != nil {
+		return fmt.Errorf("failed to load user data: %w", err)
+	}
+	appMetrics.ObserveDataLoad("users", time.Since(loadStart))
+
+	// Load the actions from their log, which is seeded with the JSON data on the first start
+	loadStart = time.Now()
+	var actionRepo *action_repo.RepositoryImpl
+	if cfg.ActionLogFile != "" {
+		actionRepo, err = action_repo.OpenActionRepo(cfg.ActionLogFile, cfg.ActionSnapshotFile, "./src/repository/data/actions.json")
+	} else {
+		actionRepo, err = action_repo.NewActionRepo("./src/repository/data/actions.json")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load action data: %w", err)
+	}
+	appMetrics.ObserveDataLoad("actions", time.Since(loadStart))
+	defer actionRepo.Close()
+
+	if cfg.ActionLogFile != "" {
+		recovery := actionRepo.Recovery()
+		if recovery.TruncatedBytes > 0 {
+			logger.Warn().Int64("bytes", recovery.TruncatedBytes).Msg("Truncated a torn write at the end of the action log")
+		}
+		if recovery.SnapshotErr != nil {
+			logger.Warn().Err(recovery.SnapshotErr).Msg("Ignoring the action snapshot")
+		}
+		logger.Info().
+			Int("records", recovery.Records).
+			Int("snapshot_actions", recovery.SnapshotActions).
+			Bool("seeded", recovery.Seeded).
+			Msg("Action log loaded")
+	}
+
+	// Publish new actions to the live streams
+	eventHub := events.NewHub(cfg.StreamReplaySize)
+	actionRepo.SetPublisher(eventHub)
+
+	// Load the registered webhooks and their delivery log
+	webhookRepo, err := webhook_repo.NewWebhookRepo(cfg.WebhooksFile, cfg.WebhookDeliveriesFile)
+	if err != nil {
+		return fmt.Errorf("failed to load webhook data: %w", err)
+	}
+	defer webhookRepo.Close()
+
+	// Load the audit trail of the changes made to users and actions
+	auditRepo, err := audit_repo.NewAuditRepo(cfg.AuditFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the audit trail: %w", err)
+	}
+	defer auditRepo.Close()
+	auditService := audit_service.NewAuditService(auditRepo)
+
+	appMetrics.RegisterRepositorySize("users", userRepo.Count)
+	appMetrics.RegisterRepositorySize("actions", actionRepo.Count)
+	appMetrics.RegisterRepositorySize("webhooks", webhookRepo.Count)
+	appMetrics.RegisterRepositorySize("audit", auditRepo.Count)
+
+	// Validate the data before taking traffic
+	healthState.AddCheck("users", func() error {
+		if userRepo.Count() == 0 {
+			return fmt.Errorf("no users loaded")
+		}
+		return nil
+	})
+	healthState.AddCheck("actions", func() error {
+		if actionRepo.Count() == 0 {
+			return fmt.Errorf("no actions loaded")
+		}
+		return nil
+	})
+	if err := healthState.MarkReady(); err != nil {
+		return fmt.Errorf("data validation failed: %w", err)
 	}
 
 	// Initialize user service and handler
-	userService := users_service.NewUserService(userRepo)
+	userService := users_service.NewUserService(userRepo, actionRepo)
+	userService.SetAuditor(auditService)
 	userHandler := user.NewHandler(userService, logger)
 
-	actionService := action_service.NewActionService(actionRepo)
+	// Changes to the referral index are pushed to the clients following them
+	referralFeed := events.NewReferralFeed()
+	actionServiceImpl := action_service.NewActionService(actionRepo, userRepo)
+	actionServiceImpl.OnReferralIndexChange(referralFeed.Update)
+	actionServiceImpl.SetAuditor(auditService)
+
+	actionService := appMetrics.InstrumentActionService(actionServiceImpl)
 	actionHandler := action.NewHandler(actionService, logger)
 
+	// Profiles summarize a user's activity from both services
+	profileService := profile_service.NewProfileService(userService, actionService)
+
+	// Keep the referral index up to date as referrals are stored
+	go events.RefreshOnReferrals(context.Background(), eventHub, func(ctx context.Context) error {
+		_, err := actionService.GetReferralIndex(ctx)
+		return err
+	}, logger)
+
+	// Periodically save the state derived from the actions
+	snapshotCtx, stopSnapshots := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(cfg.SnapshotInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-snapshotCtx.Done():
+				return
+			case <-ticker.C:
+				if err := actionRepo.Snapshot(snapshotCtx); err != nil && snapshotCtx.Err() == nil {
+					logger.Error().Err(err).Msg("Failed to save the action snapshot")
+				}
+			}
+		}
+	}()
+
+	// Deliver new actions to the registered webhooks until the server stops
+	webhookDispatcher := webhook_service.NewDispatcher(webhookRepo, webhook_service.DispatcherConfig{
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		Backoff:     cfg.WebhookRetryBackoff,
+		Timeout:     cfg.WebhookTimeout,
+	}, logger)
+	dispatchCtx, stopDispatching := context.WithCancel(context.Background())
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		webhookDispatcher.Run(dispatchCtx, eventHub)
+	}()
+	// Background work stops before the repositories close when the startup fails below
+	defer func() {
+		stopDispatching()
+		<-dispatcherDone
+	}()
+	defer stopSnapshots()
+
+	// Initialize the GraphQL executor over both services
+	graphQLExecutor, err := graphql.NewExecutor(userService, actionService)
+	if err != nil {
+		return fmt.Errorf("failed to set up GraphQL: %w", err)
+	}
+
+	webhookService := webhook_service.NewWebhookService(webhookRepo)
+
 	// Group handlers
 	handlers := &router.Handlers{
-		UserHandler:   userHandler,
-		ActionHandler: actionHandler,
+		UserHandler:    userHandler,
+		ActionHandler:  actionHandler,
+		HealthHandler:  health_handler.NewHandler(healthState, logger),
+		DocsHandler:    docs.NewHandler(openapi.Spec()),
+		GraphQLHandler: graphql_handler.NewHandler(graphQLExecutor, logger),
+		StreamHandler:  stream.NewHandler(eventHub, referralFeed, cfg.StreamHeartbeat, logger),
+		WebhookHandler: webhook_handler.NewHandler(webhookService, logger),
+		AuditHandler:   audit_handler.NewHandler(auditService, logger),
+		ProfileHandler: profile_handler.NewHandler(profileService, logger),
+	}
+
+	// Load API keys from the configuration and the keys file
+	apiKeys, err := auth.ParseKeys(cfg.APIKeys)
+	if err != nil {
+		return fmt.Errorf("failed to parse API keys: %w", err)
+	}
+	if cfg.APIKeysFile != "" {
+		fileKeys, err := auth.LoadKeysFile(cfg.APIKeysFile)
+		if err != nil {
+			return fmt.Errorf("failed to load API keys file: %w", err)
+		}
+		apiKeys = append(apiKeys, fileKeys...)
+	}
+
+	// Load the JWKS used to verify bearer tokens
+	var jwtVerifier *auth.JWTVerifier
+	if cfg.JWKSFile != "" {
+		keySet, err := auth.NewKeySet(cfg.JWKSFile)
+		if err != nil {
+			return fmt.Errorf("failed to load JWKS file: %w", err)
+		}
+		jwtVerifier = auth.NewJWTVerifier(keySet, auth.JWTConfig{
+			Audience: cfg.JWTAudience,
+			Issuer:   cfg.JWTIssuer,
+			Leeway:   cfg.JWTLeeway,
+		})
+	}
+
+	// Parse the rate limits
+	defaultLimit, err := ratelimit.ParseLimit(cfg.RateLimitDefault)
+	if err != nil {
+		return fmt.Errorf("failed to parse default rate limit: %w", err)
+	}
+	routeLimits, err := ratelimit.ParseRouteLimits(cfg.RateLimits)
+	if err != nil {
+		return fmt.Errorf("failed to parse route rate limits: %w", err)
+	}
+	ipLimit, err := ratelimit.ParseLimit(cfg.RateLimitIP)
+	if err != nil {
+		return fmt.Errorf("failed to parse IP rate limit: %w", err)
+	}
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), defaultLimit, routeLimits)
+
+	// Parse the request timeouts
+	routeTimeouts, err := deadline.ParseRouteTimeouts(cfg.RequestTimeouts)
+	if err != nil {
+		return fmt.Errorf("failed to parse route timeouts: %w", err)
+	}
+
+	deadlines := deadline.New(cfg.RequestTimeout, routeTimeouts)
+
+	// Load the OpenAPI document requests are validated against
+	apiDoc, err := openapi.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load OpenAPI document: %w", err)
+	}
+
+	// Erasing a user also purges the copies of their data held outside the
+	// repositories: webhook deliveries, replayed events and cached responses
+	idempotencyStore := idempotency.NewMemoryStore(cfg.IdempotencyMaxKeys)
+	userService.OnErase(webhookService.EraseUser)
+	userService.OnErase(func(ctx context.Context, userID int) error {
+		eventHub.Forget(userID)
+		idempotencyStore.ForgetUser(userID)
+		return nil
+	})
+
+	// Set up route middlewares
+	middlewares := &router.Middlewares{
+		RateLimit:   limiter,
+		IPRateLimit: limiter.IP(ipLimit),
+		Deadlines:   deadlines,
+		Validator:   openapi.NewValidator(apiDoc),
+		Deprecation: &deprecation.Policy{
+			Deprecated: cfg.LegacyDeprecatedAt,
+			Sunset:     cfg.LegacySunset,
+		},
+		Idempotency: idempotency.New(idempotency.Config{TTL: cfg.IdempotencyTTL, Store: idempotencyStore}),
+		RequestLog:  requestlog.New(logger),
+		Tracing:     tracing.Middleware(),
+		Metrics:     appMetrics,
+	}
+	// Without credentials every route would be public, which must be asked for explicitly
+	var authenticator *auth.Authenticator
+	switch {
+	case len(apiKeys) > 0 || jwtVerifier != nil:
+		authenticator = auth.NewAuthenticator(apiKeys, jwtVerifier)
+		middlewares.Auth = authenticator
+	case cfg.AuthDisabled:
+		logger.Warn().Msg("Authentication is disabled, all routes are public")
+	default:
+		return fmt.Errorf("no API keys or JWKS file configured, set AUTH_DISABLED=true to serve every route publicly")
 	}
 
 	// Initialize router
-	httpRouter := router.New(handlers)
+	httpRouter := router.New(handlers, middlewares)
+
+	// Initialize the gRPC server, which shares the services and credentials of the HTTP API
+	grpcServer := grpc.NewServer(userService, actionService, authenticator, grpc.Limits{
+		RateLimit: limiter,
+		IPLimit:   ipLimit,
+		Deadlines: deadlines,
+	}, logger)
 
 	// Set up server and run the server
-	httpServer := server.New(logger, httpRouter)
-	if err := httpServer.Run(); err != nil {
-		logger.Fatal().Err(err).Msg("server failure")
+	httpServer := server.New(logger, httpRouter, grpcServer, cfg.GRPCAddr, healthState, cfg.ShutdownDrainDelay)
+	// A failed server is shut down like on a signal, so the state below is still saved
+	runErr := httpServer.Run()
+
+	// Pending webhook deliveries are resumed on the next start
+	stopDispatching()
+	<-dispatcherDone
+
+	// Save the derived state so the next start doesn't recompute it
+	stopSnapshots()
+	if err := actionRepo.Snapshot(context.Background()); err != nil {
+		logger.Error().Err(err).Msg("Failed to save the action snapshot")
+	}
+
+	// Flush the spans still buffered by the exporter
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error().Err(err).Msg("Failed to flush traces")
 	}
+
+	return runErr
 }
@@ -0,0 +1,57 @@
+// Package atomicfile replaces files so a crash leaves either the previous or
+// the new contents behind, never a partial file.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Write replaces the file at path with data
+func Write(path string, data []byte) error {
+	file, err := Replace(path, data)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Replace replaces the file at path with data and returns it opened for
+// reading and writing, positioned at its end. The data is written to a synced
+// temporary file in the same directory, which is renamed over path, and the
+// directory is synced so the rename itself survives a crash.
+func Replace(path string, data []byte) (*os.File, error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	if err := syncDir(dir); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// syncDir flushes the entries of dir to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
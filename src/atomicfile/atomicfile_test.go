@@ -0,0 +1,53 @@
+package atomicfile
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite_ReplacesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	require.NoError(t, os.WriteFile(path, []byte("previous contents"), 0o644))
+
+	require.NoError(t, Write(path, []byte("new")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	// No temporary file is left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestReplace_ReturnsFileAtEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+
+	file, err := Replace(path, []byte("first\n"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = file.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	_, err = file.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(data))
+
+	onDisk, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(onDisk))
+}
+
+func TestReplace_MissingDirectory(t *testing.T) {
+	_, err := Replace(filepath.Join(t.TempDir(), "missing", "data.json"), []byte("data"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,307 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Config holds the service settings that can be tuned through environment variables
+type Config struct {
+	// IdempotencyTTL is how long responses to requests sent with an Idempotency-Key are kept
+	IdempotencyTTL time.Duration
+	// IdempotencyMaxKeys is how many idempotency keys are remembered at most
+	IdempotencyMaxKeys int
+	// APIKeys lists API keys as semicolon separated "name:secret:scope,scope" entries
+	APIKeys string
+	// APIKeysFile is the path of a JSON file with hashed API keys
+	APIKeysFile string
+	// JWKSFile is the path of a JWKS file with the public keys used to verify bearer tokens
+	JWKSFile string
+	// JWTAudience and JWTIssuer are the aud and iss bearer tokens must carry, when set
+	JWTAudience string
+	JWTIssuer   string
+	// JWTLeeway is the clock skew tolerated when checking the exp and nbf of bearer tokens
+	JWTLeeway time.Duration
+	// AuthDisabled lets the service start without API keys or a JWKS file, leaving every route public
+	AuthDisabled bool
+	// RateLimitDefault is the per-client limit of routes without their own, as "requests/duration"
+	RateLimitDefault string
+	// RateLimitIP is the limit of every request of an IP address, checked before authentication
+	RateLimitIP string
+	// RateLimits overrides the limit of specific routes as comma separated "route=requests/duration" entries
+	RateLimits string
+	// RequestTimeout bounds how long routes without their own timeout may spend on a request
+	RequestTimeout time.Duration
+	// RequestTimeouts overrides the timeout of specific routes as comma separated "route=duration" entries
+	RequestTimeouts string
+	// TracingExporter selects where spans are exported: none, stdout or file
+	TracingExporter string
+	// TracingFile is the file spans are written to by the file exporter
+	TracingFile string
+	// LegacyDeprecatedAt and LegacySunset are when the unversioned routes were deprecated and when they will be removed
+	LegacyDeprecatedAt time.Time
+	LegacySunset       time.Time
+	// StreamHeartbeat is how often idle event streams send a heartbeat
+	StreamHeartbeat time.Duration
+	// StreamReplaySize is how many recent events are kept for clients resuming a stream
+	StreamReplaySize int
+	// DataDir is the directory the files below are kept in unless they are set explicitly
+	DataDir string
+	// UsersFile is where users are saved once they change, nothing is saved when empty
+	UsersFile string
+	// AuditFile is where the audit trail is appended to, it is kept in memory only when empty
+	AuditFile string
+	// ActionLogFile is the append-only log actions are stored in, actions are only kept in memory when empty
+	ActionLogFile string
+	// ActionSnapshotFile is where the state derived from the action log is saved, to speed up restarts
+	ActionSnapshotFile string
+	// SnapshotInterval is how often the derived state is saved
+	SnapshotInterval time.Duration
+	// WebhooksFile is where webhooks are saved, nothing is saved when empty
+	WebhooksFile string
+	// WebhookDeliveriesFile is the append-only log of webhook deliveries, nothing is saved when empty
+	WebhookDeliveriesFile string
+	// WebhookMaxAttempts is how many times a webhook delivery is attempted before it fails
+	WebhookMaxAttempts int
+	// WebhookRetryBackoff is the delay before the first retry of a delivery, doubled for every following one
+	WebhookRetryBackoff time.Duration
+	// WebhookTimeout bounds each webhook delivery attempt
+	WebhookTimeout time.Duration
+	// GRPCAddr is the address the gRPC server listens on
+	GRPCAddr string
+	// ShutdownDrainDelay is how long the server keeps serving after SIGTERM while reporting not ready
+	ShutdownDrainDelay time.Duration
+}
+
+// Load reads the configuration from the environment, falling back to defaults for unset values
+func Load() (*Config, error) {
+	dataDir := "data"
+	if value := os.Getenv("DATA_DIR"); value != "" {
+		dataDir = value
+	}
+
+	cfg := &Config{
+		IdempotencyTTL:     24 * time.Hour,
+		IdempotencyMaxKeys: 10_000,
+		APIKeys:            os.Getenv("API_KEYS"),
+		APIKeysFile:        os.Getenv("API_KEYS_FILE"),
+		JWKSFile:           os.Getenv("JWKS_FILE"),
+		JWTAudience:        os.Getenv("JWT_AUDIENCE"),
+		JWTIssuer:          os.Getenv("JWT_ISSUER"),
+		JWTLeeway:          30 * time.Second,
+
+		// Analytics endpoints scan every action, so they get tighter limits
+		RateLimitDefault: "120/1m",
+		RateLimitIP:      "600/1m",
+		RateLimits:       "/actions/referral=10/1m,/actions/:actionType/next=30/1m",
+
+		// Analytics computations are abandoned sooner than other requests
+		RequestTimeout:  10 * time.Second,
+		RequestTimeouts: "/actions/referral=5s,/actions/:actionType/next=5s",
+
+		TracingExporter: "none",
+		TracingFile:     "traces.json",
+
+		LegacyDeprecatedAt: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+		LegacySunset:       time.Date(2027, 4, 19, 0, 0, 0, 0, time.UTC),
+
+		StreamHeartbeat:  15 * time.Second,
+		StreamReplaySize: 1000,
+
+		DataDir:            dataDir,
+		UsersFile:          filepath.Join(dataDir, "users.json"),
+		AuditFile:          filepath.Join(dataDir, "audit.log"),
+		ActionLogFile:      filepath.Join(dataDir, "actions.log"),
+		ActionSnapshotFile: filepath.Join(dataDir, "actions.snapshot"),
+		SnapshotInterval:   5 * time.Minute,
+
+		WebhooksFile:          filepath.Join(dataDir, "webhooks.json"),
+		WebhookDeliveriesFile: filepath.Join(dataDir, "webhook_deliveries.log"),
+		WebhookMaxAttempts:    5,
+		WebhookRetryBackoff:   time.Second,
+		WebhookTimeout:        10 * time.Second,
+
+		GRPCAddr: ":50051",
+
+		ShutdownDrainDelay: 5 * time.Second,
+	}
+
+	if value := os.Getenv("RATE_LIMIT_DEFAULT"); value != "" {
+		cfg.RateLimitDefault = value
+	}
+	if value := os.Getenv("RATE_LIMIT_IP"); value != "" {
+		cfg.RateLimitIP = value
+	}
+	if value := os.Getenv("RATE_LIMITS"); value != "" {
+		cfg.RateLimits = value
+	}
+	if value := os.Getenv("REQUEST_TIMEOUTS"); value != "" {
+		cfg.RequestTimeouts = value
+	}
+	if value := os.Getenv("TRACING_EXPORTER"); value != "" {
+		cfg.TracingExporter = value
+	}
+	if value := os.Getenv("TRACING_FILE"); value != "" {
+		cfg.TracingFile = value
+	}
+	if value := os.Getenv("GRPC_ADDR"); value != "" {
+		cfg.GRPCAddr = value
+	}
+	if value, ok := os.LookupEnv("USERS_FILE"); ok {
+		cfg.UsersFile = value
+	}
+	if value, ok := os.LookupEnv("AUDIT_FILE"); ok {
+		cfg.AuditFile = value
+	}
+	if value, ok := os.LookupEnv("ACTION_LOG_FILE"); ok {
+		cfg.ActionLogFile = value
+	}
+	if value, ok := os.LookupEnv("ACTION_SNAPSHOT_FILE"); ok {
+		cfg.ActionSnapshotFile = value
+	}
+	if value, ok := os.LookupEnv("WEBHOOKS_FILE"); ok {
+		cfg.WebhooksFile = value
+	}
+	if value, ok := os.LookupEnv("WEBHOOK_DELIVERIES_FILE"); ok {
+		cfg.WebhookDeliveriesFile = value
+	}
+
+	if err := durationFromEnv("IDEMPOTENCY_TTL", &cfg.IdempotencyTTL); err != nil {
+		return nil, err
+	}
+	if err := intFromEnv("IDEMPOTENCY_MAX_KEYS", &cfg.IdempotencyMaxKeys); err != nil {
+		return nil, err
+	}
+	if err := leewayFromEnv("JWT_LEEWAY", &cfg.JWTLeeway); err != nil {
+		return nil, err
+	}
+	if err := boolFromEnv("AUTH_DISABLED", &cfg.AuthDisabled); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("REQUEST_TIMEOUT", &cfg.RequestTimeout); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("SHUTDOWN_DRAIN_DELAY", &cfg.ShutdownDrainDelay); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("STREAM_HEARTBEAT", &cfg.StreamHeartbeat); err != nil {
+		return nil, err
+	}
+	if err := intFromEnv("STREAM_REPLAY_SIZE", &cfg.StreamReplaySize); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("SNAPSHOT_INTERVAL", &cfg.SnapshotInterval); err != nil {
+		return nil, err
+	}
+	if err := intFromEnv("WEBHOOK_MAX_ATTEMPTS", &cfg.WebhookMaxAttempts); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("WEBHOOK_RETRY_BACKOFF", &cfg.WebhookRetryBackoff); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("WEBHOOK_TIMEOUT", &cfg.WebhookTimeout); err != nil {
+		return nil, err
+	}
+
+	if err := timeFromEnv("LEGACY_DEPRECATED_AT", &cfg.LegacyDeprecatedAt); err != nil {
+		return nil, err
+	}
+	if err := timeFromEnv("LEGACY_SUNSET", &cfg.LegacySunset); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// durationFromEnv overrides dst with the duration stored in the named variable, if set
+func durationFromEnv(name string, dst *time.Duration) error {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("invalid %s: must be positive", name)
+	}
+
+	*dst = d
+	return nil
+}
+
+// leewayFromEnv overrides dst with the duration stored in the named variable, if
+// set. Unlike other durations it may be zero, to tolerate no clock skew at all.
+func leewayFromEnv(name string, dst *time.Duration) error {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if d < 0 {
+		return fmt.Errorf("invalid %s: must not be negative", name)
+	}
+
+	*dst = d
+	return nil
+}
+
+// intFromEnv overrides dst with the non-negative integer stored in the named variable, if set
+func intFromEnv(name string, dst *int) error {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid %s: must be a non-negative integer", name)
+	}
+
+	*dst = n
+	return nil
+}
+
+// boolFromEnv overrides dst with the boolean stored in the named variable, if set
+func boolFromEnv(name string, dst *bool) error {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: must be true or false", name)
+	}
+
+	*dst = b
+	return nil
+}
+
+// timeFromEnv overrides dst with the date (2006-01-02) or RFC 3339 time stored in the named variable, if set
+func timeFromEnv(name string, dst *time.Time) error {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: expected a date or an RFC 3339 time", name)
+	}
+
+	*dst = t
+	return nil
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TTL", "")
+	t.Setenv("IDEMPOTENCY_MAX_KEYS", "")
+	t.Setenv("DATA_DIR", "")
+	t.Setenv("AUTH_DISABLED", "")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, cfg.IdempotencyTTL)
+	assert.Equal(t, 10_000, cfg.IdempotencyMaxKeys)
+	assert.Equal(t, 30*time.Second, cfg.JWTLeeway)
+	assert.False(t, cfg.AuthDisabled)
+	assert.Equal(t, "120/1m", cfg.RateLimitDefault)
+	assert.Equal(t, "600/1m", cfg.RateLimitIP)
+	assert.Equal(t, "none", cfg.TracingExporter)
+	assert.Equal(t, 5*time.Second, cfg.ShutdownDrainDelay)
+	assert.Equal(t, 10*time.Second, cfg.RequestTimeout)
+	assert.Equal(t, ":50051", cfg.GRPCAddr)
+	assert.Equal(t, 15*time.Second, cfg.StreamHeartbeat)
+	assert.Equal(t, 1000, cfg.StreamReplaySize)
+	assert.Equal(t, "data", cfg.DataDir)
+	assert.Equal(t, "data/users.json", cfg.UsersFile)
+	assert.Equal(t, "data/audit.log", cfg.AuditFile)
+	assert.Equal(t, "data/actions.log", cfg.ActionLogFile)
+	assert.Equal(t, "data/actions.snapshot", cfg.ActionSnapshotFile)
+	assert.Equal(t, 5*time.Minute, cfg.SnapshotInterval)
+	assert.Equal(t, "data/webhooks.json", cfg.WebhooksFile)
+	assert.Equal(t, "data/webhook_deliveries.log", cfg.WebhookDeliveriesFile)
+	assert.Equal(t, 5, cfg.WebhookMaxAttempts)
+	assert.Equal(t, time.Second, cfg.WebhookRetryBackoff)
+	assert.True(t, cfg.LegacySunset.After(cfg.LegacyDeprecatedAt))
+	assert.Contains(t, cfg.RateLimits, "/actions/referral=")
+}
+
+func TestLoad_FromEnv(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TTL", "90m")
+	t.Setenv("IDEMPOTENCY_MAX_KEYS", "500")
+	t.Setenv("API_KEYS", "reporting:secret:users:read")
+	t.Setenv("API_KEYS_FILE", "/etc/surfe/keys.json")
+	t.Setenv("JWT_LEEWAY", "0s")
+	t.Setenv("AUTH_DISABLED", "true")
+	t.Setenv("RATE_LIMITS", "/actions/referral=1/1s")
+	t.Setenv("REQUEST_TIMEOUT", "3s")
+	t.Setenv("GRPC_ADDR", ":9090")
+	t.Setenv("STREAM_REPLAY_SIZE", "0")
+	t.Setenv("DATA_DIR", "/srv/surfe")
+	t.Setenv("AUDIT_FILE", "/var/lib/surfe/audit.log")
+	t.Setenv("ACTION_LOG_FILE", "/var/lib/surfe/actions.log")
+	t.Setenv("SNAPSHOT_INTERVAL", "30s")
+	t.Setenv("WEBHOOKS_FILE", "")
+	t.Setenv("WEBHOOK_DELIVERIES_FILE", "/var/lib/surfe/deliveries.log")
+	t.Setenv("WEBHOOK_RETRY_BACKOFF", "30s")
+	t.Setenv("LEGACY_SUNSET", "2027-01-31")
+	t.Setenv("LEGACY_DEPRECATED_AT", "2026-11-01T12:00:00Z")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, cfg.IdempotencyTTL)
+	assert.Equal(t, 500, cfg.IdempotencyMaxKeys)
+	assert.Equal(t, "reporting:secret:users:read", cfg.APIKeys)
+	assert.Equal(t, "/etc/surfe/keys.json", cfg.APIKeysFile)
+	assert.Equal(t, time.Duration(0), cfg.JWTLeeway)
+	assert.True(t, cfg.AuthDisabled)
+	assert.Equal(t, "/actions/referral=1/1s", cfg.RateLimits)
+	assert.Equal(t, 3*time.Second, cfg.RequestTimeout)
+	assert.Equal(t, ":9090", cfg.GRPCAddr)
+	assert.Equal(t, 0, cfg.StreamReplaySize)
+	assert.Equal(t, "/srv/surfe", cfg.DataDir)
+	assert.Equal(t, "/srv/surfe/users.json", cfg.UsersFile)
+	assert.Equal(t, "/var/lib/surfe/audit.log", cfg.AuditFile)
+	assert.Equal(t, "/var/lib/surfe/actions.log", cfg.ActionLogFile)
+	assert.Equal(t, 30*time.Second, cfg.SnapshotInterval)
+	assert.Equal(t, "", cfg.WebhooksFile)
+	assert.Equal(t, "/var/lib/surfe/deliveries.log", cfg.WebhookDeliveriesFile)
+	assert.Equal(t, 30*time.Second, cfg.WebhookRetryBackoff)
+	assert.Equal(t, time.Date(2027, 1, 31, 0, 0, 0, 0, time.UTC), cfg.LegacySunset)
+	assert.Equal(t, time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC), cfg.LegacyDeprecatedAt)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for _, value := range []string{"soon", "-1h"} {
+		t.Setenv("IDEMPOTENCY_TTL", value)
+
+		_, err := Load()
+		assert.Error(t, err, "value %q", value)
+	}
+}
+
+func TestLoad_InvalidLeeway(t *testing.T) {
+	t.Setenv("JWT_LEEWAY", "-5s")
+
+	_, err := Load()
+	assert.Error(t, err)
+}
+
+func TestLoad_InvalidSunset(t *testing.T) {
+	t.Setenv("LEGACY_SUNSET", "next spring")
+
+	_, err := Load()
+	assert.Error(t, err)
+}
+
+func TestLoad_InvalidAuthDisabled(t *testing.T) {
+	t.Setenv("AUTH_DISABLED", "maybe")
+
+	_, err := Load()
+	assert.Error(t, err)
+}
@@ -0,0 +1,44 @@
+// Package disconnect notices clients closing their connection while a response
+// is being computed or streamed, which fasthttp doesn't report.
+package disconnect
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// pollInterval is how often the connection is checked
+const pollInterval = 100 * time.Millisecond
+
+// Watch calls cancel when the client closes conn before ctx is done. The
+// connection is polled without consuming any pipelined request. Connections
+// that aren't sockets, like the ones of app.Test, are never reported closed.
+func Watch(ctx context.Context, conn net.Conn, cancel context.CancelFunc) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok || !canDetect {
+		return
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if peerClosed(raw) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+}
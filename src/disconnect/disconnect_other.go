@@ -0,0 +1,12 @@
+//go:build !unix
+
+package disconnect
+
+import "syscall"
+
+// Disconnects are only detected on unix systems
+const canDetect = false
+
+func peerClosed(raw syscall.RawConn) bool {
+	return false
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package disconnect
+
+import "syscall"
+
+const canDetect = true
+
+// peerClosed reports whether the peer closed the connection, by peeking at
+// the socket without blocking. Data sent by the peer is left to be read.
+func peerClosed(raw syscall.RawConn) bool {
+	var closed bool
+	err := raw.Read(func(fd uintptr) bool {
+		var buf [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		closed = (n == 0 && err == nil) || err == syscall.ECONNRESET
+		return true
+	})
+	return err == nil && closed
+}
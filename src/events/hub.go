@@ -0,0 +1,210 @@
+// Package events fans out the actions stored by the repository to live subscribers.
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind before it is dropped
+const subscriberBuffer = 256
+
+// Event is an action published by the hub. Its ID orders it among the other
+// events of the process and lets subscribers resume after it.
+type Event struct {
+	ID     string
+	Action models.Action
+}
+
+// Filter selects the events a subscriber receives. Zero fields match everything.
+type Filter struct {
+	UserID *int
+	Type   models.ActionType
+}
+
+func (f Filter) matches(action models.Action) bool {
+	if f.UserID != nil && action.UserID != *f.UserID {
+		return false
+	}
+	return f.Type == "" || action.Type == f.Type
+}
+
+// Hub is an in-process pub/sub of new actions. It keeps the latest events in a
+// bounded buffer, so subscribers that reconnect can catch up on what they missed.
+type Hub struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	replay      []sequenced
+	replaySize  int
+	subscribers map[*Subscription]struct{}
+}
+
+type sequenced struct {
+	seq   uint64
+	event Event
+	// forgotten is set once the event was removed, it keeps its place so
+	// subscribers resuming after it still find where the buffer starts
+	forgotten bool
+}
+
+// NewHub returns a hub keeping the last replaySize events for resumption
+func NewHub(replaySize int) *Hub {
+	return &Hub{
+		// Sequences restart with the process, the epoch tells them apart
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		replaySize:  replaySize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends the actions to the matching subscribers, in order. It never
+// blocks: subscribers that fell too far behind are dropped and must resubscribe.
+func (h *Hub) Publish(actions ...models.Action) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, action := range actions {
+		h.seq++
+		e := sequenced{seq: h.seq, event: Event{ID: h.eventID(h.seq), Action: action}}
+
+		if h.replaySize > 0 {
+			if len(h.replay) == h.replaySize {
+				h.replay = h.replay[1:]
+			}
+			h.replay = append(h.replay, e)
+		}
+
+		for sub := range h.subscribers {
+			if !sub.filter.matches(action) {
+				continue
+			}
+			select {
+			case sub.events <- e.event:
+			default:
+				h.drop(sub)
+			}
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events matching filter. When
+// lastEventID is set, the buffered events published after it are delivered
+// first. An ID from a previous process replays the whole buffer. The
+// subscription reports a gap when events published after lastEventID are no
+// longer buffered.
+func (h *Hub) Subscribe(lastEventID string, filter Filter) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscription{hub: h, filter: filter, events: make(chan Event, subscriberBuffer)}
+
+	if lastEventID != "" {
+		after, ok := h.parseEventID(lastEventID)
+		if !ok {
+			after = 0
+		}
+
+		// The buffer starts right after the last event that fell out of it. The
+		// events a previous process published after the ID are always lost.
+		dropped := h.seq - uint64(len(h.replay))
+		sub.gap = !ok || after < dropped
+
+		var missed []Event
+		for _, e := range h.replay {
+			if e.seq > after && !e.forgotten && filter.matches(e.event.Action) {
+				missed = append(missed, e.event)
+			}
+		}
+
+		// The replay must fit in the channel alongside the live events that follow
+		if len(missed) > 0 {
+			sub.events = make(chan Event, len(missed)+subscriberBuffer)
+			for _, e := range missed {
+				sub.events <- e
+			}
+		}
+	}
+
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Forget removes the actions involving a user from the replay buffer, so they
+// aren't replayed once the user was erased
+func (h *Hub) Forget(userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.replay {
+		if h.replay[i].event.Action.Involves(userID) {
+			h.replay[i].event = Event{}
+			h.replay[i].forgotten = true
+		}
+	}
+}
+
+// Subscribers returns the number of active subscriptions
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers)
+}
+
+// drop removes a subscription and closes its channel. The lock must be held.
+func (h *Hub) drop(sub *Subscription) {
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func (h *Hub) eventID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", h.epoch, seq)
+}
+
+// parseEventID returns the sequence of an event ID issued by this process
+func (h *Hub) parseEventID(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != h.epoch {
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
+
+// Subscription receives the events of a hub until it is closed
+type Subscription struct {
+	hub    *Hub
+	filter Filter
+	events chan Event
+	gap    bool
+}
+
+// Gap reports whether events published after the last event ID given to
+// Subscribe were missed, as they no longer were in the replay buffer. The
+// subscriber must then rebuild its state from scratch rather than from events.
+func (s *Subscription) Gap() bool {
+	return s.gap
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// subscription is closed or dropped for falling behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close unsubscribes. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.drop(s)
+}
@@ -0,0 +1,140 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// receive returns the IDs of the actions already delivered to the subscription
+func receive(sub *Subscription) []int {
+	var ids []int
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return ids
+			}
+			ids = append(ids, e.Action.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestHub_Publish(t *testing.T) {
+	hub := NewHub(10)
+
+	userID := 1
+	all := hub.Subscribe("", Filter{})
+	byUser := hub.Subscribe("", Filter{UserID: &userID})
+	byType := hub.Subscribe("", Filter{Type: models.ActionTypeReferUser})
+
+	hub.Publish(
+		models.Action{ID: 1, UserID: 1, Type: models.ActionTypeWelcome},
+		models.Action{ID: 2, UserID: 2, Type: models.ActionTypeReferUser},
+		models.Action{ID: 3, UserID: 1, Type: models.ActionTypeReferUser},
+	)
+
+	assert.Equal(t, []int{1, 2, 3}, receive(all))
+	assert.Equal(t, []int{1, 3}, receive(byUser))
+	assert.Equal(t, []int{2, 3}, receive(byType))
+}
+
+func TestHub_Resume(t *testing.T) {
+	hub := NewHub(3)
+
+	sub := hub.Subscribe("", Filter{})
+	for id := 1; id <= 5; id++ {
+		hub.Publish(models.Action{ID: id})
+	}
+
+	var ids []string
+	for range 5 {
+		ids = append(ids, (<-sub.Events()).ID)
+	}
+	sub.Close()
+
+	// Events after the last one seen are replayed, as far as the buffer goes back
+	assert.Equal(t, []int{4, 5}, receive(hub.Subscribe(ids[2], Filter{})))
+	assert.Equal(t, []int{3, 4, 5}, receive(hub.Subscribe(ids[0], Filter{})))
+	assert.Empty(t, receive(hub.Subscribe(ids[4], Filter{})))
+
+	// IDs from a previous process replay the whole buffer
+	assert.Equal(t, []int{3, 4, 5}, receive(hub.Subscribe("old-12", Filter{})))
+
+	// New subscribers only get new events
+	assert.Empty(t, receive(hub.Subscribe("", Filter{})))
+}
+
+func TestHub_Resume_Gap(t *testing.T) {
+	hub := NewHub(3)
+
+	sub := hub.Subscribe("", Filter{})
+	for id := 1; id <= 5; id++ {
+		hub.Publish(models.Action{ID: id})
+	}
+
+	var ids []string
+	for range 5 {
+		ids = append(ids, (<-sub.Events()).ID)
+	}
+	sub.Close()
+
+	// Event 2 fell out of the buffer, so resuming after event 1 misses it
+	assert.True(t, hub.Subscribe(ids[0], Filter{}).Gap())
+	assert.False(t, hub.Subscribe(ids[1], Filter{}).Gap())
+	assert.False(t, hub.Subscribe(ids[4], Filter{}).Gap())
+	assert.False(t, hub.Subscribe("", Filter{}).Gap())
+
+	// What a previous process published after the ID is unknown
+	assert.True(t, hub.Subscribe("old-12", Filter{}).Gap())
+}
+
+func TestHub_Forget(t *testing.T) {
+	hub := NewHub(10)
+
+	sub := hub.Subscribe("", Filter{})
+	hub.Publish(
+		models.Action{ID: 1, UserID: 1, Type: models.ActionTypeWelcome},
+		models.Action{ID: 2, UserID: 2, Type: models.ActionTypeReferUser, TargetUser: 1},
+		models.Action{ID: 3, UserID: 2, Type: models.ActionTypeWelcome},
+	)
+	first := (<-sub.Events()).ID
+	sub.Close()
+
+	// The user's actions and the referrals of them are no longer replayed, and
+	// the events that were forgotten don't count as missed
+	hub.Forget(1)
+	resumed := hub.Subscribe(first, Filter{})
+	assert.Equal(t, []int{3}, receive(resumed))
+	assert.False(t, resumed.Gap())
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := NewHub(0)
+
+	sub := hub.Subscribe("", Filter{})
+	assert.Equal(t, 1, hub.Subscribers())
+
+	sub.Close()
+	sub.Close()
+	assert.Equal(t, 0, hub.Subscribers())
+
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+}
+
+func TestHub_DropsSlowSubscribers(t *testing.T) {
+	hub := NewHub(0)
+
+	slow := hub.Subscribe("", Filter{})
+	for id := 0; id <= subscriberBuffer; id++ {
+		hub.Publish(models.Action{ID: id})
+	}
+
+	// Publishing never blocks, the subscriber is dropped once its buffer is full
+	assert.Equal(t, 0, hub.Subscribers())
+	assert.Len(t, receive(slow), subscriberBuffer)
+}
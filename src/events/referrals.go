@@ -0,0 +1,199 @@
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/rs/zerolog"
+)
+
+// watchBuffer is how many updates a watch can fall behind before it is dropped
+const watchBuffer = 512
+
+// ReferralUpdate is the new referral index of a user
+type ReferralUpdate struct {
+	UserID        int `json:"userId"`
+	ReferralIndex int `json:"referralIndex"`
+}
+
+// ReferralFeed notifies watches of the referral index changes of the users they
+// watch. It mirrors the index, so new watches start from the current values.
+type ReferralFeed struct {
+	mu      sync.Mutex
+	index   map[int]int
+	watches map[*ReferralWatch]struct{}
+}
+
+func NewReferralFeed() *ReferralFeed {
+	return &ReferralFeed{
+		index:   make(map[int]int),
+		watches: make(map[*ReferralWatch]struct{}),
+	}
+}
+
+// Update records the new index of the users in changes and notifies the watches
+// of those users. It never blocks: watches that fell too far behind are dropped.
+func (f *ReferralFeed) Update(changes map[int]int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for userID, index := range changes {
+		f.index[userID] = index
+	}
+
+	for watch := range f.watches {
+		f.notify(watch, changes)
+	}
+}
+
+// notify delivers the changes to a watch, dropping it if it falls behind. The
+// lock must be held.
+func (f *ReferralFeed) notify(watch *ReferralWatch, changes map[int]int) {
+	for userID, index := range changes {
+		if !watch.users[userID] {
+			continue
+		}
+		select {
+		case watch.updates <- ReferralUpdate{UserID: userID, ReferralIndex: index}:
+		default:
+			f.drop(watch)
+			return
+		}
+	}
+}
+
+// Watch returns a watch of no users, see ReferralWatch.Add
+func (f *ReferralFeed) Watch() *ReferralWatch {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	watch := &ReferralWatch{
+		feed:    f,
+		users:   make(map[int]bool),
+		updates: make(chan ReferralUpdate, watchBuffer),
+	}
+	f.watches[watch] = struct{}{}
+	return watch
+}
+
+// drop removes a watch and closes its channel. The lock must be held.
+func (f *ReferralFeed) drop(watch *ReferralWatch) {
+	if _, ok := f.watches[watch]; ok {
+		delete(f.watches, watch)
+		close(watch.updates)
+	}
+}
+
+// ReferralWatch receives the referral index updates of a set of users
+type ReferralWatch struct {
+	feed    *ReferralFeed
+	users   map[int]bool
+	updates chan ReferralUpdate
+}
+
+// Updates returns the channel updates are delivered on. It is closed when the
+// watch is closed or dropped for falling behind.
+func (w *ReferralWatch) Updates() <-chan ReferralUpdate {
+	return w.updates
+}
+
+// Add starts watching the users. Their current index is delivered first, so
+// no change can be missed between reading it and watching it.
+func (w *ReferralWatch) Add(userIDs ...int) {
+	w.feed.mu.Lock()
+	defer w.feed.mu.Unlock()
+
+	for _, userID := range userIDs {
+		if _, ok := w.feed.watches[w]; !ok {
+			return
+		}
+		if w.users[userID] {
+			continue
+		}
+		w.users[userID] = true
+
+		select {
+		case w.updates <- ReferralUpdate{UserID: userID, ReferralIndex: w.feed.index[userID]}:
+		default:
+			w.feed.drop(w)
+		}
+	}
+}
+
+// Remove stops watching the users
+func (w *ReferralWatch) Remove(userIDs ...int) {
+	w.feed.mu.Lock()
+	defer w.feed.mu.Unlock()
+
+	for _, userID := range userIDs {
+		delete(w.users, userID)
+	}
+}
+
+// Len returns the number of watched users
+func (w *ReferralWatch) Len() int {
+	w.feed.mu.Lock()
+	defer w.feed.mu.Unlock()
+
+	return len(w.users)
+}
+
+// Close stops the watch. It is safe to call more than once.
+func (w *ReferralWatch) Close() {
+	w.feed.mu.Lock()
+	defer w.feed.mu.Unlock()
+
+	w.feed.drop(w)
+}
+
+// RefreshOnReferrals calls refresh whenever referrals are published to the hub,
+// so the referral index is brought up to date as they are stored. It returns
+// once ctx is done.
+func RefreshOnReferrals(ctx context.Context, hub *Hub, refresh func(ctx context.Context) error, logger zerolog.Logger) {
+	for {
+		sub := hub.Subscribe("", Filter{Type: models.ActionTypeReferUser})
+
+		// Refreshing reads the stored actions rather than the events, so
+		// nothing is missed while (re)subscribing
+		if err := refresh(ctx); err != nil && ctx.Err() == nil {
+			logger.Error().Err(err).Msg("Failed to refresh the referral index")
+		}
+
+		if !waitForReferrals(ctx, sub, refresh, logger) {
+			sub.Close()
+			return
+		}
+	}
+}
+
+// waitForReferrals refreshes on every batch of events until the subscription is
+// dropped, reporting false once ctx is done
+func waitForReferrals(ctx context.Context, sub *Subscription, refresh func(ctx context.Context) error, logger zerolog.Logger) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case _, ok := <-sub.Events():
+			if !ok {
+				return true
+			}
+
+			// A single refresh covers the events that arrived meanwhile
+			for drained := false; !drained; {
+				select {
+				case _, ok := <-sub.Events():
+					if !ok {
+						return true
+					}
+				default:
+					drained = true
+				}
+			}
+
+			if err := refresh(ctx); err != nil && ctx.Err() == nil {
+				logger.Error().Err(err).Msg("Failed to refresh the referral index")
+			}
+		}
+	}
+}
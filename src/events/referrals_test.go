@@ -0,0 +1,107 @@
+package events
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// updates returns the updates already delivered to the watch
+func updates(watch *ReferralWatch) []ReferralUpdate {
+	var received []ReferralUpdate
+	for {
+		select {
+		case u, ok := <-watch.Updates():
+			if !ok {
+				return received
+			}
+			received = append(received, u)
+		default:
+			return received
+		}
+	}
+}
+
+func TestReferralFeed_Update(t *testing.T) {
+	feed := NewReferralFeed()
+	feed.Update(map[int]int{1: 3})
+
+	watch := feed.Watch()
+	defer watch.Close()
+
+	// The current index is delivered when a user is added, including users without referrals
+	watch.Add(1, 2)
+	assert.ElementsMatch(t, []ReferralUpdate{{UserID: 1, ReferralIndex: 3}, {UserID: 2}}, updates(watch))
+
+	// Only changes of watched users are delivered
+	feed.Update(map[int]int{1: 4, 5: 1})
+	assert.Equal(t, []ReferralUpdate{{UserID: 1, ReferralIndex: 4}}, updates(watch))
+
+	watch.Remove(1)
+	feed.Update(map[int]int{1: 5, 2: 1})
+	assert.Equal(t, []ReferralUpdate{{UserID: 2, ReferralIndex: 1}}, updates(watch))
+	assert.Equal(t, 1, watch.Len())
+}
+
+func TestReferralFeed_DropsSlowWatches(t *testing.T) {
+	feed := NewReferralFeed()
+
+	watch := feed.Watch()
+	watch.Add(1)
+	for i := range watchBuffer {
+		feed.Update(map[int]int{1: i + 1})
+	}
+
+	_, ok := <-watch.Updates()
+	assert.True(t, ok)
+	for range watchBuffer - 1 {
+		<-watch.Updates()
+	}
+
+	// The watch was closed rather than blocking the feed
+	_, ok = <-watch.Updates()
+	assert.False(t, ok)
+
+	watch.Add(2)
+	watch.Close()
+}
+
+func TestRefreshOnReferrals(t *testing.T) {
+	hub := NewHub(0)
+
+	var refreshes atomic.Int32
+	refreshed := make(chan struct{}, 10)
+	refresh := func(ctx context.Context) error {
+		refreshes.Add(1)
+		refreshed <- struct{}{}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		RefreshOnReferrals(ctx, hub, refresh, zerolog.New(os.Stderr))
+	}()
+
+	// The index is refreshed on start, then on every referral
+	<-refreshed
+	hub.Publish(models.Action{ID: 1, Type: models.ActionTypeWelcome})
+	hub.Publish(models.Action{ID: 2, Type: models.ActionTypeReferUser, TargetUser: 3})
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("referral did not refresh the index")
+	}
+
+	cancel()
+	<-stopped
+	assert.Equal(t, int32(2), refreshes.Load())
+}
@@ -0,0 +1,54 @@
+package graphql
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	gql "github.com/graph-gophers/graphql-go"
+	gqlotel "github.com/graph-gophers/graphql-go/trace/otel"
+)
+
+//go:embed schema.graphql
+var schema string
+
+// MaxDepth bounds how deeply queries can nest, e.g. referrals of referrals
+const MaxDepth = 8
+
+// MaxComplexity bounds how many users and actions a query can resolve, as a
+// query within MaxDepth can still fan out to every user through the referrals
+const MaxComplexity = 1000
+
+var ErrTooComplex = fmt.Errorf("the query resolves more than %d users and actions", MaxComplexity)
+
+// Executor runs GraphQL queries over users, actions and referrals through the services
+type Executor struct {
+	schema        *gql.Schema
+	userService   user_s.Service
+	actionService action_s.Service
+}
+
+func NewExecutor(userService user_s.Service, actionService action_s.Service) (*Executor, error) {
+	s, err := gql.ParseSchema(schema, &queryResolver{},
+		gql.MaxDepth(MaxDepth),
+		gql.Tracer(gqlotel.DefaultTracer()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GraphQL schema: %w", err)
+	}
+
+	return &Executor{
+		schema:        s,
+		userService:   userService,
+		actionService: actionService,
+	}, nil
+}
+
+// Exec runs a query with its own set of loaders, so lookups are batched and
+// cached within the query but never shared between queries
+func (e *Executor) Exec(ctx context.Context, query, operationName string, variables map[string]any) *gql.Response {
+	ctx = withLoaders(ctx, newLoaders(e.userService, e.actionService))
+	return e.schema.Exec(ctx, query, operationName, variables)
+}
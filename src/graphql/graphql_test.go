@@ -0,0 +1,229 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/models"
+	action_mock "github.com/AntonioDaria/surfe/src/services/action/mock"
+	user_mock "github.com/AntonioDaria/surfe/src/services/user/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+var createdAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestExecutor(t *testing.T) (*Executor, *user_mock.MockService, *action_mock.MockService) {
+	ctrl := gomock.NewController(t)
+
+	userService := user_mock.NewMockService(ctrl)
+	actionService := action_mock.NewMockService(ctrl)
+
+	executor, err := NewExecutor(userService, actionService)
+	assert.NoError(t, err)
+
+	return executor, userService, actionService
+}
+
+// users returns a GetUsersByIDs stub over users 1 to 3
+func users(_ context.Context, ids []int) map[int]*models.User {
+	result := make(map[int]*models.User)
+	for _, id := range ids {
+		if id >= 1 && id <= 3 {
+			result[id] = &models.User{ID: id, Name: "User " + string(rune('0'+id)), CreatedAt: createdAt}
+		}
+	}
+	return result
+}
+
+func exec(t *testing.T, ctx context.Context, executor *Executor, query string) (map[string]any, []string) {
+	t.Helper()
+
+	response := executor.Exec(ctx, query, "", nil)
+
+	var data map[string]any
+	if len(response.Data) > 0 {
+		assert.NoError(t, json.Unmarshal(response.Data, &data))
+	}
+
+	var errs []string
+	for _, err := range response.Errors {
+		errs = append(errs, err.Message)
+	}
+	return data, errs
+}
+
+func TestExec_User(t *testing.T) {
+	executor, userService, actionService := newTestExecutor(t)
+
+	userService.EXPECT().GetUsersByIDs(gomock.Any(), gomock.Any()).DoAndReturn(users).AnyTimes()
+	actionService.EXPECT().GetActionsByUserIDs(gomock.Any(), []int{1}).Return(map[int][]models.Action{
+		1: {
+			{ID: 10, Type: models.ActionTypeWelcome, UserID: 1, CreatedAt: createdAt},
+			{ID: 11, Type: models.ActionTypeReferUser, UserID: 1, TargetUser: 2, CreatedAt: createdAt},
+		},
+	}, nil)
+	actionService.EXPECT().GetReferrers(gomock.Any(), []int{1}).Return(map[int]int{}, nil)
+
+	data, errs := exec(t, context.Background(), executor, `{
+		user(id: 1) {
+			name
+			actionCount
+			actions { id type targetUser { id } }
+			referredBy { id }
+			referrals { name }
+		}
+	}`)
+
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]any{
+		"user": map[string]any{
+			"name":        "User 1",
+			"actionCount": float64(2),
+			"actions": []any{
+				map[string]any{"id": float64(10), "type": "WELCOME", "targetUser": nil},
+				map[string]any{"id": float64(11), "type": "REFER_USER", "targetUser": map[string]any{"id": float64(2)}},
+			},
+			"referredBy": nil,
+			"referrals":  []any{map[string]any{"name": "User 2"}},
+		},
+	}, data)
+}
+
+func TestExec_UserNotFound(t *testing.T) {
+	executor, userService, _ := newTestExecutor(t)
+
+	userService.EXPECT().GetUsersByIDs(gomock.Any(), []int{42}).DoAndReturn(users)
+
+	data, errs := exec(t, context.Background(), executor, `{ user(id: 42) { name } }`)
+
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]any{"user": nil}, data)
+}
+
+func TestExec_BatchesLookups(t *testing.T) {
+	executor, userService, actionService := newTestExecutor(t)
+
+	// Each field is fetched for all users in a single call
+	userService.EXPECT().GetUsersByIDs(gomock.Any(), []int{1, 2, 3}).DoAndReturn(users).Times(1)
+	actionService.EXPECT().GetActionsByUserIDs(gomock.Any(), gomock.InAnyOrder([]int{1, 2, 3})).
+		Return(map[int][]models.Action{1: {{ID: 10}}, 3: {{ID: 11}, {ID: 12}}}, nil).Times(1)
+	actionService.EXPECT().GetReferrers(gomock.Any(), gomock.InAnyOrder([]int{1, 2, 3})).
+		Return(map[int]int{2: 1, 3: 1}, nil).Times(1)
+
+	data, errs := exec(t, context.Background(), executor, `{
+		users(ids: [1, 2, 3]) { actionCount referredBy { id } }
+	}`)
+
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]any{
+		"users": []any{
+			map[string]any{"actionCount": float64(1), "referredBy": nil},
+			map[string]any{"actionCount": float64(0), "referredBy": map[string]any{"id": float64(1)}},
+			map[string]any{"actionCount": float64(2), "referredBy": map[string]any{"id": float64(1)}},
+		},
+	}, data)
+}
+
+func TestExec_TooManyUsers(t *testing.T) {
+	executor, _, _ := newTestExecutor(t)
+
+	ids, _ := json.Marshal(make([]int, MaxUsersPerQuery+1))
+	_, errs := exec(t, context.Background(), executor, `{ users(ids: `+string(ids)+`) { id } }`)
+
+	assert.Equal(t, []string{ErrTooManyUsers.Error()}, errs)
+}
+
+func TestExec_Scopes(t *testing.T) {
+	executor, userService, actionService := newTestExecutor(t)
+
+	userService.EXPECT().GetUsersByIDs(gomock.Any(), gomock.Any()).DoAndReturn(users).AnyTimes()
+	actionService.EXPECT().GetActionsByUserIDs(gomock.Any(), []int{1}).Return(map[int][]models.Action{
+		1: {{ID: 1, UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2, CreatedAt: createdAt}},
+	}, nil).AnyTimes()
+
+	// Token holders can only read their own user, and analytics need their own scope
+	ctx := auth.ContextWithPrincipal(context.Background(), &auth.Principal{
+		Name: "1", Subject: "1", Scopes: []auth.Scope{auth.ScopeUsersRead},
+	})
+
+	data, errs := exec(t, ctx, executor, `{ user(id: 1) { actionCount } }`)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]any{"user": map[string]any{"actionCount": float64(1)}}, data)
+
+	_, errs = exec(t, ctx, executor, `{ user(id: 2) { name } }`)
+	assert.Equal(t, []string{ErrOtherUsers.Error()}, errs)
+
+	_, errs = exec(t, ctx, executor, `{ users(ids: [1, 2]) { name } }`)
+	assert.Equal(t, []string{ErrOtherUsers.Error()}, errs)
+
+	// The users they referred are part of their data, but not those users' own
+	data, errs = exec(t, ctx, executor, `{ user(id: 1) { referrals { name } } }`)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]any{"user": map[string]any{"referrals": []any{map[string]any{"name": "User 2"}}}}, data)
+
+	_, errs = exec(t, ctx, executor, `{ user(id: 1) { referrals { actionCount } } }`)
+	assert.Equal(t, []string{"access to the actions of other users is not allowed"}, errs)
+
+	_, errs = exec(t, ctx, executor, `{ user(id: 1) { referrals { referredBy { id } } } }`)
+	assert.Equal(t, []string{"access to the referrer of other users is not allowed"}, errs)
+
+	_, errs = exec(t, ctx, executor, `{ user(id: 1) { referralIndex } }`)
+	assert.Equal(t, []string{"missing the analytics:read scope"}, errs)
+
+	_, errs = exec(t, ctx, executor, `{ nextActionProbabilities(actionType: WELCOME) { probability } }`)
+	assert.Equal(t, []string{"missing the analytics:read scope"}, errs)
+}
+
+func TestExec_Analytics(t *testing.T) {
+	executor, userService, actionService := newTestExecutor(t)
+
+	userService.EXPECT().GetUsersByIDs(gomock.Any(), gomock.Any()).DoAndReturn(users).AnyTimes()
+	// The referral index is computed once per query
+	actionService.EXPECT().GetReferralIndex(gomock.Any()).Return(map[int]int{1: 2}, nil).Times(1)
+	actionService.EXPECT().GetNextActionProbabilities(gomock.Any(), models.ActionTypeWelcome).
+		Return(map[models.ActionType]float64{models.ActionTypeViewContacts: 0.25, models.ActionTypeAddContact: 0.75}, nil)
+
+	data, errs := exec(t, context.Background(), executor, `{
+		users(ids: [1, 2]) { referralIndex }
+		nextActionProbabilities(actionType: WELCOME) { actionType probability }
+	}`)
+
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]any{
+		"users": []any{
+			map[string]any{"referralIndex": float64(2)},
+			map[string]any{"referralIndex": float64(0)},
+		},
+		"nextActionProbabilities": []any{
+			map[string]any{"actionType": "ADD_CONTACT", "probability": 0.75},
+			map[string]any{"actionType": "VIEW_CONTACTS", "probability": 0.25},
+		},
+	}, data)
+}
+
+func TestExec_MaxDepth(t *testing.T) {
+	executor, _, _ := newTestExecutor(t)
+
+	_, errs := exec(t, context.Background(), executor, `{
+		user(id: 1) { referrals { referrals { referrals { referrals { referrals { referrals { referrals { referrals { id } } } } } } } } }
+	}`)
+
+	assert.NotEmpty(t, errs)
+}
+
+func TestExec_MaxComplexity(t *testing.T) {
+	executor, userService, actionService := newTestExecutor(t)
+
+	// The user and their actions add up to one more than the limit
+	actions := make([]models.Action, MaxComplexity)
+	userService.EXPECT().GetUsersByIDs(gomock.Any(), gomock.Any()).DoAndReturn(users).AnyTimes()
+	actionService.EXPECT().GetActionsByUserIDs(gomock.Any(), []int{1}).Return(map[int][]models.Action{1: actions}, nil)
+
+	_, errs := exec(t, context.Background(), executor, `{ user(id: 1) { actions { id } } }`)
+
+	assert.Equal(t, []string{ErrTooComplex.Error()}, errs)
+}
@@ -0,0 +1,115 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	"github.com/graph-gophers/dataloader/v7"
+)
+
+// batchWait is how long loaders collect keys before fetching them in one batch
+const batchWait = 2 * time.Millisecond
+
+// loaders batch the lookups made while resolving one query, so that resolving
+// a field for many users costs a single pass over the repositories instead of
+// one per user. They also cache the results for the rest of the query.
+type loaders struct {
+	users     *dataloader.Loader[int, *models.User]
+	actions   *dataloader.Loader[int, []models.Action]
+	referrers *dataloader.Loader[int, *int]
+
+	referralOnce  sync.Once
+	referralIndex map[int]int
+	referralErr   error
+	actionService action_s.Service
+
+	// resolved counts the users and actions resolved so far, against MaxComplexity
+	resolved atomic.Int64
+}
+
+type loadersKey struct{}
+
+func newLoaders(userService user_s.Service, actionService action_s.Service) *loaders {
+	return &loaders{
+		users: dataloader.NewBatchedLoader(func(ctx context.Context, ids []int) []*dataloader.Result[*models.User] {
+			users := userService.GetUsersByIDs(ctx, ids)
+
+			results := make([]*dataloader.Result[*models.User], len(ids))
+			for i, id := range ids {
+				results[i] = &dataloader.Result[*models.User]{Data: users[id]}
+			}
+			return results
+		}, dataloader.WithWait[int, *models.User](batchWait)),
+
+		actions: dataloader.NewBatchedLoader(func(ctx context.Context, ids []int) []*dataloader.Result[[]models.Action] {
+			actions, err := actionService.GetActionsByUserIDs(ctx, ids)
+
+			results := make([]*dataloader.Result[[]models.Action], len(ids))
+			for i, id := range ids {
+				results[i] = &dataloader.Result[[]models.Action]{Data: actions[id], Error: err}
+			}
+			return results
+		}, dataloader.WithWait[int, []models.Action](batchWait)),
+
+		referrers: dataloader.NewBatchedLoader(func(ctx context.Context, ids []int) []*dataloader.Result[*int] {
+			referrers, err := actionService.GetReferrers(ctx, ids)
+
+			results := make([]*dataloader.Result[*int], len(ids))
+			for i, id := range ids {
+				results[i] = &dataloader.Result[*int]{Error: err}
+				if referrer, ok := referrers[id]; ok {
+					results[i].Data = &referrer
+				}
+			}
+			return results
+		}, dataloader.WithWait[int, *int](batchWait)),
+
+		actionService: actionService,
+	}
+}
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// user loads a user, returning nil when it doesn't exist
+func (l *loaders) user(ctx context.Context, id int) (*models.User, error) {
+	return l.users.Load(ctx, id)()
+}
+
+// usersByIDs loads several users, with nil for the ones that don't exist
+func (l *loaders) usersByIDs(ctx context.Context, ids []int) ([]*models.User, error) {
+	users, errs := l.users.LoadMany(ctx, ids)()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// charge counts n more users or actions resolved by the query, failing once
+// the query resolves more than MaxComplexity of them
+func (l *loaders) charge(n int) error {
+	if l.resolved.Add(int64(n)) > MaxComplexity {
+		return ErrTooComplex
+	}
+	return nil
+}
+
+// referralIndexOf returns the referral index of a user, computing the index once per query
+func (l *loaders) referralIndexOf(ctx context.Context, id int) (int, error) {
+	l.referralOnce.Do(func() {
+		l.referralIndex, l.referralErr = l.actionService.GetReferralIndex(ctx)
+	})
+	return l.referralIndex[id], l.referralErr
+}
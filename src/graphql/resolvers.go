@@ -0,0 +1,289 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/models"
+)
+
+// MaxUsersPerQuery is the largest number of IDs accepted by the users query
+const MaxUsersPerQuery = 100
+
+var (
+	ErrTooManyUsers = fmt.Errorf("at most %d users can be requested at once", MaxUsersPerQuery)
+	// ErrOtherUsers is returned to token holders querying users other than their own
+	ErrOtherUsers = errors.New("access to other users is not allowed")
+)
+
+// queryResolver resolves the fields of the Query type
+type queryResolver struct{}
+
+func (r *queryResolver) User(ctx context.Context, args struct{ ID int32 }) (*userResolver, error) {
+	if !canAccessUser(ctx, int(args.ID)) {
+		return nil, ErrOtherUsers
+	}
+
+	l := loadersFrom(ctx)
+
+	user, err := l.user(ctx, int(args.ID))
+	if err != nil || user == nil {
+		return nil, err
+	}
+	if err := l.charge(1); err != nil {
+		return nil, err
+	}
+	return &userResolver{user: user}, nil
+}
+
+func (r *queryResolver) Users(ctx context.Context, args struct{ IDs []int32 }) ([]*userResolver, error) {
+	if len(args.IDs) > MaxUsersPerQuery {
+		return nil, ErrTooManyUsers
+	}
+
+	ids := make([]int, len(args.IDs))
+	for i, id := range args.IDs {
+		ids[i] = int(id)
+		if !canAccessUser(ctx, ids[i]) {
+			return nil, ErrOtherUsers
+		}
+	}
+
+	l := loadersFrom(ctx)
+
+	users, err := l.usersByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.charge(len(users)); err != nil {
+		return nil, err
+	}
+	return newUserResolvers(users), nil
+}
+
+func (r *queryResolver) NextActionProbabilities(ctx context.Context, args struct{ ActionType string }) ([]*actionProbabilityResolver, error) {
+	if err := requireScope(ctx, auth.ScopeAnalyticsRead); err != nil {
+		return nil, err
+	}
+
+	probabilities, err := loadersFrom(ctx).actionService.GetNextActionProbabilities(ctx, models.ActionType(args.ActionType))
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*actionProbabilityResolver, 0, len(probabilities))
+	for actionType, probability := range probabilities {
+		resolvers = append(resolvers, &actionProbabilityResolver{actionType: actionType, probability: probability})
+	}
+	sort.Slice(resolvers, func(i, j int) bool { return resolvers[i].actionType < resolvers[j].actionType })
+	return resolvers, nil
+}
+
+// userResolver resolves the fields of the User type
+type userResolver struct {
+	user *models.User
+}
+
+// newUserResolvers wraps users, keeping nil for the ones that weren't found
+func newUserResolvers(users []*models.User) []*userResolver {
+	resolvers := make([]*userResolver, len(users))
+	for i, user := range users {
+		if user != nil {
+			resolvers[i] = &userResolver{user: user}
+		}
+	}
+	return resolvers
+}
+
+func (r *userResolver) ID() int32 {
+	return int32(r.user.ID)
+}
+
+func (r *userResolver) Name() string {
+	return r.user.Name
+}
+
+func (r *userResolver) CreatedAt() string {
+	return r.user.CreatedAt.Format(time.RFC3339)
+}
+
+func (r *userResolver) Actions(ctx context.Context) ([]*actionResolver, error) {
+	actions, err := r.actions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadersFrom(ctx).charge(len(actions)); err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*actionResolver, len(actions))
+	for i := range actions {
+		resolvers[i] = &actionResolver{action: actions[i]}
+	}
+	return resolvers, nil
+}
+
+func (r *userResolver) ActionCount(ctx context.Context) (int32, error) {
+	actions, err := r.actions(ctx)
+	return int32(len(actions)), err
+}
+
+func (r *userResolver) ReferralIndex(ctx context.Context) (int32, error) {
+	if err := requireScope(ctx, auth.ScopeAnalyticsRead); err != nil {
+		return 0, err
+	}
+
+	index, err := loadersFrom(ctx).referralIndexOf(ctx, r.user.ID)
+	return int32(index), err
+}
+
+// ReferredBy resolves who referred the user, which token holders can only read for their own user
+func (r *userResolver) ReferredBy(ctx context.Context) (*userResolver, error) {
+	if !canAccessUser(ctx, r.user.ID) {
+		return nil, errors.New("access to the referrer of other users is not allowed")
+	}
+
+	l := loadersFrom(ctx)
+
+	referrer, err := l.referrers.Load(ctx, r.user.ID)()
+	if err != nil || referrer == nil {
+		return nil, err
+	}
+
+	user, err := l.user(ctx, *referrer)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	if err := l.charge(1); err != nil {
+		return nil, err
+	}
+	return &userResolver{user: user}, nil
+}
+
+func (r *userResolver) Referrals(ctx context.Context) ([]*userResolver, error) {
+	actions, err := r.actions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var referred []int
+	for _, action := range actions {
+		if action.Type == models.ActionTypeReferUser {
+			referred = append(referred, action.TargetUser)
+		}
+	}
+
+	l := loadersFrom(ctx)
+
+	users, err := l.usersByIDs(ctx, referred)
+	if err != nil {
+		return nil, err
+	}
+
+	// Referrals of users that no longer exist are skipped, as the list is non-null
+	resolvers := make([]*userResolver, 0, len(users))
+	for _, resolver := range newUserResolvers(users) {
+		if resolver != nil {
+			resolvers = append(resolvers, resolver)
+		}
+	}
+	if err := l.charge(len(resolvers)); err != nil {
+		return nil, err
+	}
+	return resolvers, nil
+}
+
+// actions loads the user's actions, which token holders can only read for their own user
+func (r *userResolver) actions(ctx context.Context) ([]models.Action, error) {
+	if !canAccessUser(ctx, r.user.ID) {
+		return nil, errors.New("access to the actions of other users is not allowed")
+	}
+
+	return loadersFrom(ctx).actions.Load(ctx, r.user.ID)()
+}
+
+// actionResolver resolves the fields of the Action type
+type actionResolver struct {
+	action models.Action
+}
+
+func (r *actionResolver) ID() int32 {
+	return int32(r.action.ID)
+}
+
+func (r *actionResolver) Type() string {
+	return string(r.action.Type)
+}
+
+func (r *actionResolver) User(ctx context.Context) (*userResolver, error) {
+	l := loadersFrom(ctx)
+
+	user, err := l.user(ctx, r.action.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %d of action %d not found", r.action.UserID, r.action.ID)
+	}
+	if err := l.charge(1); err != nil {
+		return nil, err
+	}
+	return &userResolver{user: user}, nil
+}
+
+func (r *actionResolver) TargetUser(ctx context.Context) (*userResolver, error) {
+	if r.action.Type != models.ActionTypeReferUser {
+		return nil, nil
+	}
+
+	l := loadersFrom(ctx)
+
+	user, err := l.user(ctx, r.action.TargetUser)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	if err := l.charge(1); err != nil {
+		return nil, err
+	}
+	return &userResolver{user: user}, nil
+}
+
+func (r *actionResolver) CreatedAt() string {
+	return r.action.CreatedAt.Format(time.RFC3339)
+}
+
+// actionProbabilityResolver resolves the fields of the ActionProbability type
+type actionProbabilityResolver struct {
+	actionType  models.ActionType
+	probability float64
+}
+
+func (r *actionProbabilityResolver) ActionType() string {
+	return string(r.actionType)
+}
+
+func (r *actionProbabilityResolver) Probability() float64 {
+	return r.probability
+}
+
+// canAccessUser reports whether the caller may read the data of a user. Token
+// holders are limited to their own user, and requests without a principal are
+// let through, as authentication is disabled when there is none.
+func canAccessUser(ctx context.Context, userID int) bool {
+	principal := auth.PrincipalFromContext(ctx)
+	return principal == nil || principal.CanAccessUser(strconv.Itoa(userID))
+}
+
+// requireScope rejects callers lacking scope. Requests without a principal are
+// let through, as authentication is disabled when there is none.
+func requireScope(ctx context.Context, scope auth.Scope) error {
+	if principal := auth.PrincipalFromContext(ctx); principal != nil && !principal.HasScope(scope) {
+		return fmt.Errorf("missing the %s scope", scope)
+	}
+	return nil
+}
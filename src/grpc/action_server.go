@@ -0,0 +1,110 @@
+package grpc
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/AntonioDaria/surfe/src/grpc/pb"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/models"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type actionServer struct {
+	pb.UnimplementedActionServiceServer
+	actionService action_s.Service
+	userService   user_s.Service
+}
+
+func (s *actionServer) CountActions(ctx context.Context, req *pb.CountActionsRequest) (*pb.CountActionsResponse, error) {
+	if err := requireSelf(ctx, req.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	count, err := s.actionService.GetActionCountByUserID(ctx, int(req.GetUserId()))
+	if err != nil {
+		return nil, statusError(err, "failed to retrieve action count")
+	}
+	return &pb.CountActionsResponse{Count: int64(count)}, nil
+}
+
+func (s *actionServer) NextActionProbabilities(ctx context.Context, req *pb.NextActionProbabilitiesRequest) (*pb.NextActionProbabilitiesResponse, error) {
+	probabilities, err := s.actionService.GetNextActionProbabilities(ctx, models.ActionType(req.GetActionType()))
+	if err != nil {
+		return nil, statusError(err, "failed to compute next action probabilities")
+	}
+
+	resp := &pb.NextActionProbabilitiesResponse{Probabilities: make(map[string]float64, len(probabilities))}
+	for t, probability := range probabilities {
+		resp.Probabilities[string(t)] = probability
+	}
+	return resp, nil
+}
+
+func (s *actionServer) ReferralIndex(ctx context.Context, _ *pb.ReferralIndexRequest) (*pb.ReferralIndexResponse, error) {
+	referralIndex, err := s.actionService.GetReferralIndex(ctx)
+	if err != nil {
+		return nil, statusError(err, "failed to compute referral index")
+	}
+
+	resp := &pb.ReferralIndexResponse{ReferralIndex: make(map[int64]int64, len(referralIndex))}
+	for userID, index := range referralIndex {
+		resp.ReferralIndex[int64(userID)] = int64(index)
+	}
+	return resp, nil
+}
+
+func (s *actionServer) ListActions(req *pb.ListActionsRequest, stream pb.ActionService_ListActionsServer) error {
+	ctx := stream.Context()
+
+	if err := requireSelf(ctx, req.GetUserId()); err != nil {
+		return err
+	}
+
+	actionType := models.ActionType(req.GetType())
+	if actionType != "" && !actionType.IsValid() {
+		return status.Error(codes.InvalidArgument, "unknown action type")
+	}
+
+	userID := int(req.GetUserId())
+	if _, err := s.userService.GetUserByID(ctx, userID); err != nil {
+		return statusError(err, "failed to retrieve user")
+	}
+
+	actions, err := s.actionService.GetActionsByUserIDs(ctx, []int{userID})
+	if err != nil {
+		return statusError(err, "failed to retrieve actions")
+	}
+
+	for _, action := range actions[userID] {
+		if actionType != "" && action.Type != actionType {
+			continue
+		}
+		if err := stream.Send(toAction(action)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireSelf only lets token holders access their own user, like auth.RequireSelf does for HTTP routes
+func requireSelf(ctx context.Context, userID int64) error {
+	if principal := auth.PrincipalFromContext(ctx); principal != nil && !principal.CanAccessUser(strconv.FormatInt(userID, 10)) {
+		return status.Error(codes.PermissionDenied, "access to other users is not allowed")
+	}
+	return nil
+}
+
+func toAction(action models.Action) *pb.Action {
+	return &pb.Action{
+		Id:         int64(action.ID),
+		Type:       string(action.Type),
+		UserId:     int64(action.UserID),
+		TargetUser: int64(action.TargetUser),
+		CreatedAt:  timestamppb.New(action.CreatedAt),
+	}
+}
@@ -0,0 +1,71 @@
+// Package grpc serves the user and action services over gRPC, next to the HTTP API.
+package grpc
+
+//go:generate protoc -I proto --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative surfe.proto
+
+import (
+	"context"
+	"errors"
+
+	"github.com/AntonioDaria/surfe/src/grpc/pb"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/middleware/deadline"
+	"github.com/AntonioDaria/surfe/src/middleware/ratelimit"
+	action_repo "github.com/AntonioDaria/surfe/src/repository/action"
+	user_repo "github.com/AntonioDaria/surfe/src/repository/user"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	"github.com/rs/zerolog"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// Limits bounds the calls the way the HTTP routes are bounded, sharing their
+// configuration. Each of them is optional.
+type Limits struct {
+	// RateLimit limits the calls of each client, in the buckets of the matching HTTP routes
+	RateLimit *ratelimit.Limiter
+	// IPLimit limits the calls of each IP address before authentication, when
+	// RateLimit is set and it is not zero
+	IPLimit ratelimit.Limit
+	// Deadlines bounds how long each call may take
+	Deadlines *deadline.Deadlines
+}
+
+// NewServer creates a gRPC server exposing the services. Calls are authenticated
+// like the HTTP routes when authenticator is set, and are public otherwise.
+func NewServer(userService user_s.Service, actionService action_s.Service, authenticator *auth.Authenticator, limits Limits, logger zerolog.Logger) *gogrpc.Server {
+	interceptors := &interceptors{authenticator: authenticator, limits: limits, logger: logger}
+
+	// Calls go through the same steps as HTTP requests, in the same order
+	server := gogrpc.NewServer(
+		gogrpc.ChainUnaryInterceptor(interceptors.unaryLog, interceptors.unaryIPRateLimit, interceptors.unaryAuth,
+			interceptors.unaryRateLimit, interceptors.unaryDeadline),
+		gogrpc.ChainStreamInterceptor(interceptors.streamLog, interceptors.streamIPRateLimit, interceptors.streamAuth,
+			interceptors.streamRateLimit, interceptors.streamDeadline),
+	)
+
+	pb.RegisterUserServiceServer(server, &userServer{userService: userService})
+	pb.RegisterActionServiceServer(server, &actionServer{actionService: actionService, userService: userService})
+
+	// Let clients such as grpcurl discover the services
+	reflection.Register(server)
+
+	return server
+}
+
+// statusError converts a service error to a gRPC status, hiding the details of unexpected errors
+func statusError(err error, message string) error {
+	switch {
+	case errors.Is(err, user_repo.ErrUserNotFound), errors.Is(err, action_repo.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, action_s.ErrUnknownActionType):
+		return status.Error(codes.InvalidArgument, "unknown action type")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, message)
+	}
+}
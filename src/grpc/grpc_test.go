@@ -0,0 +1,278 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/grpc/pb"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/middleware/deadline"
+	"github.com/AntonioDaria/surfe/src/middleware/ratelimit"
+	"github.com/AntonioDaria/surfe/src/models"
+	action_repo "github.com/AntonioDaria/surfe/src/repository/action"
+	user_repo "github.com/AntonioDaria/surfe/src/repository/user"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+	action_mock "github.com/AntonioDaria/surfe/src/services/action/mock"
+	user_mock "github.com/AntonioDaria/surfe/src/services/user/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var createdAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestClient serves the services in memory and returns a connection to them
+func newTestClient(t *testing.T, authenticator *auth.Authenticator) (*gogrpc.ClientConn, *user_mock.MockService, *action_mock.MockService) {
+	return newLimitedTestClient(t, authenticator, Limits{})
+}
+
+// newLimitedTestClient is newTestClient with rate limits and deadlines
+func newLimitedTestClient(t *testing.T, authenticator *auth.Authenticator, limits Limits) (*gogrpc.ClientConn, *user_mock.MockService, *action_mock.MockService) {
+	ctrl := gomock.NewController(t)
+	userService := user_mock.NewMockService(ctrl)
+	actionService := action_mock.NewMockService(ctrl)
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	server := NewServer(userService, actionService, authenticator, limits, logger)
+
+	listener := bufconn.Listen(1024 * 1024)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := gogrpc.NewClient("passthrough:///bufnet",
+		gogrpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		gogrpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, userService, actionService
+}
+
+func TestGetUser(t *testing.T) {
+	conn, userService, _ := newTestClient(t, nil)
+	client := pb.NewUserServiceClient(conn)
+
+	userService.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1, Name: "Ada", CreatedAt: createdAt}, nil)
+	userService.EXPECT().GetUserByID(gomock.Any(), 2).Return(nil, user_repo.ErrUserNotFound)
+
+	user, err := client.GetUser(context.Background(), &pb.GetUserRequest{Id: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), user.GetId())
+	assert.Equal(t, "Ada", user.GetName())
+	assert.Equal(t, createdAt, user.GetCreatedAt().AsTime())
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserRequest{Id: 2})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestCountActions(t *testing.T) {
+	conn, _, actionService := newTestClient(t, nil)
+	client := pb.NewActionServiceClient(conn)
+
+	actionService.EXPECT().GetActionCountByUserID(gomock.Any(), 1).Return(3, nil)
+	actionService.EXPECT().GetActionCountByUserID(gomock.Any(), 2).Return(0, action_repo.ErrUserNotFound)
+
+	resp, err := client.CountActions(context.Background(), &pb.CountActionsRequest{UserId: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), resp.GetCount())
+
+	_, err = client.CountActions(context.Background(), &pb.CountActionsRequest{UserId: 2})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestNextActionProbabilities(t *testing.T) {
+	conn, _, actionService := newTestClient(t, nil)
+	client := pb.NewActionServiceClient(conn)
+
+	actionService.EXPECT().GetNextActionProbabilities(gomock.Any(), models.ActionTypeWelcome).
+		Return(map[models.ActionType]float64{models.ActionTypeAddContact: 1}, nil)
+	actionService.EXPECT().GetNextActionProbabilities(gomock.Any(), models.ActionTypeAddContact).
+		Return(nil, context.DeadlineExceeded)
+	actionService.EXPECT().GetNextActionProbabilities(gomock.Any(), models.ActionType("NOPE")).
+		Return(nil, action_s.ErrUnknownActionType)
+
+	resp, err := client.NextActionProbabilities(context.Background(), &pb.NextActionProbabilitiesRequest{ActionType: "WELCOME"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"ADD_CONTACT": 1}, resp.GetProbabilities())
+
+	_, err = client.NextActionProbabilities(context.Background(), &pb.NextActionProbabilitiesRequest{ActionType: "NOPE"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Abandoned computations report the context error
+	_, err = client.NextActionProbabilities(context.Background(), &pb.NextActionProbabilitiesRequest{ActionType: "ADD_CONTACT"})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestReferralIndex(t *testing.T) {
+	conn, _, actionService := newTestClient(t, nil)
+	client := pb.NewActionServiceClient(conn)
+
+	actionService.EXPECT().GetReferralIndex(gomock.Any()).Return(map[int]int{1: 2, 2: 0}, nil)
+
+	resp, err := client.ReferralIndex(context.Background(), &pb.ReferralIndexRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]int64{1: 2, 2: 0}, resp.GetReferralIndex())
+}
+
+func TestListActions(t *testing.T) {
+	conn, userService, actionService := newTestClient(t, nil)
+	client := pb.NewActionServiceClient(conn)
+
+	userService.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1}, nil).Times(2)
+	actionService.EXPECT().GetActionsByUserIDs(gomock.Any(), []int{1}).Return(map[int][]models.Action{
+		1: {
+			{ID: 10, Type: models.ActionTypeWelcome, UserID: 1, CreatedAt: createdAt},
+			{ID: 11, Type: models.ActionTypeReferUser, UserID: 1, TargetUser: 2, CreatedAt: createdAt},
+		},
+	}, nil).Times(2)
+
+	receive := func(req *pb.ListActionsRequest) []int64 {
+		stream, err := client.ListActions(context.Background(), req)
+		assert.NoError(t, err)
+
+		var ids []int64
+		for {
+			action, err := stream.Recv()
+			if err == io.EOF {
+				return ids
+			}
+			assert.NoError(t, err)
+			ids = append(ids, action.GetId())
+		}
+	}
+
+	assert.Equal(t, []int64{10, 11}, receive(&pb.ListActionsRequest{UserId: 1}))
+	assert.Equal(t, []int64{11}, receive(&pb.ListActionsRequest{UserId: 1, Type: "REFER_USER"}))
+}
+
+func TestAuth(t *testing.T) {
+	authenticator := auth.NewAuthenticator([]auth.APIKey{
+		{Name: "reporting", Hash: auth.HashKey("reporting-key"), Scopes: []auth.Scope{auth.ScopeUsersRead}},
+	}, nil)
+
+	conn, _, actionService := newTestClient(t, authenticator)
+	client := pb.NewActionServiceClient(conn)
+
+	actionService.EXPECT().GetActionCountByUserID(gomock.Any(), 1).Return(3, nil)
+
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+	}
+
+	_, err := client.CountActions(context.Background(), &pb.CountActionsRequest{UserId: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.CountActions(withKey("nope"), &pb.CountActionsRequest{UserId: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.CountActions(withKey("reporting-key"), &pb.CountActionsRequest{UserId: 1})
+	assert.NoError(t, err)
+
+	_, err = client.ReferralIndex(withKey("reporting-key"), &pb.ReferralIndexRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Streams are authenticated too
+	stream, err := client.ListActions(context.Background(), &pb.ListActionsRequest{UserId: 1})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestRequireSelf(t *testing.T) {
+	token := auth.ContextWithPrincipal(context.Background(), &auth.Principal{Name: "1", Subject: "1"})
+
+	assert.NoError(t, requireSelf(token, 1))
+	assert.Equal(t, codes.PermissionDenied, status.Code(requireSelf(token, 2)))
+
+	// API key callers are not restricted
+	apiKey := auth.ContextWithPrincipal(context.Background(), &auth.Principal{Name: "reporting"})
+	assert.NoError(t, requireSelf(apiKey, 2))
+}
+
+func TestGetUser_OtherUser(t *testing.T) {
+	userService := user_mock.NewMockService(gomock.NewController(t))
+	server := &userServer{userService: userService}
+
+	userService.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1, Name: "Ada", CreatedAt: createdAt}, nil)
+
+	// Token holders can only read their own user, like over HTTP
+	ctx := auth.ContextWithPrincipal(context.Background(), &auth.Principal{Name: "1", Subject: "1"})
+	_, err := server.GetUser(ctx, &pb.GetUserRequest{Id: 1})
+	assert.NoError(t, err)
+
+	_, err = server.GetUser(ctx, &pb.GetUserRequest{Id: 2})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestMethodScopes(t *testing.T) {
+	// Every method must declare its scope, or it is unreachable with authentication enabled
+	for _, service := range []gogrpc.ServiceDesc{pb.UserService_ServiceDesc, pb.ActionService_ServiceDesc} {
+		for _, method := range service.Methods {
+			assert.Contains(t, methodScopes, "/"+service.ServiceName+"/"+method.MethodName)
+		}
+		for _, stream := range service.Streams {
+			assert.Contains(t, methodScopes, "/"+service.ServiceName+"/"+stream.StreamName)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Limit{Requests: 100, Per: time.Minute},
+		map[string]ratelimit.Limit{"/actions/referral": {Requests: 1, Per: time.Minute}})
+	conn, _, actionService := newLimitedTestClient(t, nil, Limits{RateLimit: limiter})
+	client := pb.NewActionServiceClient(conn)
+
+	actionService.EXPECT().GetReferralIndex(gomock.Any()).Return(map[int]int{}, nil).Times(1)
+
+	_, err := client.ReferralIndex(context.Background(), &pb.ReferralIndexRequest{})
+	assert.NoError(t, err)
+
+	// The call shares the limit of the HTTP route
+	var header metadata.MD
+	_, err = client.ReferralIndex(context.Background(), &pb.ReferralIndexRequest{}, gogrpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"60"}, header.Get("retry-after"))
+}
+
+func TestIPRateLimit_Before_Auth(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Limit{Requests: 100, Per: time.Minute}, nil)
+	authenticator := auth.NewAuthenticator([]auth.APIKey{{Name: "reporting", Hash: auth.HashKey("secret"), Scopes: []auth.Scope{auth.ScopeAnalyticsRead}}}, nil)
+	conn, _, _ := newLimitedTestClient(t, authenticator, Limits{RateLimit: limiter, IPLimit: ratelimit.Limit{Requests: 1, Per: time.Minute}})
+	client := pb.NewActionServiceClient(conn)
+
+	// Guessing API keys is throttled too
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "guess")
+	_, err := client.ReferralIndex(ctx, &pb.ReferralIndexRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.ReferralIndex(ctx, &pb.ReferralIndexRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestDeadline(t *testing.T) {
+	deadlines := deadline.New(time.Minute, map[string]time.Duration{"/actions/referral": 10 * time.Millisecond})
+	conn, _, actionService := newLimitedTestClient(t, nil, Limits{Deadlines: deadlines})
+	client := pb.NewActionServiceClient(conn)
+
+	actionService.EXPECT().GetReferralIndex(gomock.Any()).DoAndReturn(func(ctx context.Context) (map[int]int, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	// The call is bounded by the timeout of the HTTP route, although the client set none
+	_, err := client.ReferralIndex(context.Background(), &pb.ReferralIndexRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
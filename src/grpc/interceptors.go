@@ -0,0 +1,247 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/grpc/pb"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/middleware/ratelimit"
+	"github.com/rs/zerolog"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// methodScopes is the scope each method requires, matching its HTTP route.
+// Methods missing from it are rejected when authentication is enabled.
+var methodScopes = map[string]auth.Scope{
+	pb.UserService_GetUser_FullMethodName:                   auth.ScopeUsersRead,
+	pb.ActionService_CountActions_FullMethodName:            auth.ScopeUsersRead,
+	pb.ActionService_ListActions_FullMethodName:             auth.ScopeUsersRead,
+	pb.ActionService_NextActionProbabilities_FullMethodName: auth.ScopeAnalyticsRead,
+	pb.ActionService_ReferralIndex_FullMethodName:           auth.ScopeAnalyticsRead,
+}
+
+// methodRoutes is the HTTP route template each method shares its rate limit
+// and deadline with. Methods missing from it are limited under their own name,
+// with the default limit and timeout.
+var methodRoutes = map[string]string{
+	pb.UserService_GetUser_FullMethodName:                   "/user/:id",
+	pb.ActionService_CountActions_FullMethodName:            "/users/:id/actions/count",
+	pb.ActionService_NextActionProbabilities_FullMethodName: "/actions/:actionType/next",
+	pb.ActionService_ReferralIndex_FullMethodName:           "/actions/referral",
+}
+
+type interceptors struct {
+	authenticator *auth.Authenticator
+	limits        Limits
+	logger        zerolog.Logger
+}
+
+func routeOf(method string) string {
+	if route, ok := methodRoutes[method]; ok {
+		return route
+	}
+	return method
+}
+
+func (i *interceptors) unaryIPRateLimit(ctx context.Context, req any, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (any, error) {
+	if err := i.limitIP(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (i *interceptors) streamIPRateLimit(srv any, stream gogrpc.ServerStream, info *gogrpc.StreamServerInfo, handler gogrpc.StreamHandler) error {
+	if err := i.limitIP(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// limitIP applies the IP address limit before authentication, like the HTTP IP rate limit
+func (i *interceptors) limitIP(ctx context.Context) error {
+	if i.limits.RateLimit == nil || i.limits.IPLimit.Requests == 0 {
+		return nil
+	}
+
+	result, err := i.limits.RateLimit.TakeIP(peerIP(ctx), i.limits.IPLimit)
+	if err != nil || result.Allowed {
+		return nil
+	}
+	return rateLimited(ctx, result)
+}
+
+func (i *interceptors) unaryRateLimit(ctx context.Context, req any, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (any, error) {
+	if err := i.limit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (i *interceptors) streamRateLimit(srv any, stream gogrpc.ServerStream, info *gogrpc.StreamServerInfo, handler gogrpc.StreamHandler) error {
+	if err := i.limit(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// limit takes a call from the bucket of the caller for the method's route,
+// which is shared with the HTTP route
+func (i *interceptors) limit(ctx context.Context, method string) error {
+	if i.limits.RateLimit == nil {
+		return nil
+	}
+
+	client := ratelimit.ClientKey(auth.PrincipalFromContext(ctx), peerIP(ctx))
+	result, _, err := i.limits.RateLimit.Take(routeOf(method), client)
+	if err != nil || result.Allowed {
+		// Don't turn an unavailable store into an outage
+		return nil
+	}
+	return rateLimited(ctx, result)
+}
+
+// rateLimited returns the error of a call over its rate limit, telling the
+// client when to retry in the retry-after header
+func rateLimited(ctx context.Context, result ratelimit.Result) error {
+	_ = gogrpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(ratelimit.CeilSeconds(result.RetryAfter))))
+	return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+}
+
+func (i *interceptors) unaryDeadline(ctx context.Context, req any, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (any, error) {
+	if i.limits.Deadlines == nil {
+		return handler(ctx, req)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, i.limits.Deadlines.Timeout(routeOf(info.FullMethod)))
+	defer cancel()
+	return handler(ctx, req)
+}
+
+func (i *interceptors) streamDeadline(srv any, stream gogrpc.ServerStream, info *gogrpc.StreamServerInfo, handler gogrpc.StreamHandler) error {
+	if i.limits.Deadlines == nil {
+		return handler(srv, stream)
+	}
+
+	ctx, cancel := context.WithTimeout(stream.Context(), i.limits.Deadlines.Timeout(routeOf(info.FullMethod)))
+	defer cancel()
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
+
+// peerIP returns the IP address of the caller, or its whole address when it has none
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+func (i *interceptors) unaryAuth(ctx context.Context, req any, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (any, error) {
+	ctx, err := i.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (i *interceptors) streamAuth(srv any, stream gogrpc.ServerStream, info *gogrpc.StreamServerInfo, handler gogrpc.StreamHandler) error {
+	ctx, err := i.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticate checks the credentials sent in the x-api-key or authorization
+// metadata, and returns a context carrying the caller's principal
+func (i *interceptors) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if i.authenticator == nil {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	token, _ := auth.BearerToken(first(md, "authorization"))
+
+	principal, _, err := i.authenticator.Authenticate(first(md, "x-api-key"), token)
+	switch {
+	case errors.Is(err, auth.ErrMissingCredentials):
+		return nil, status.Error(codes.Unauthenticated, "missing credentials")
+	case errors.Is(err, auth.ErrInvalidBearerToken):
+		return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+	case err != nil:
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+
+	scope, ok := methodScopes[method]
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "method is not available")
+	}
+	if !principal.HasScope(scope) {
+		return nil, status.Error(codes.PermissionDenied, "missing the "+string(scope)+" scope")
+	}
+
+	return auth.ContextWithPrincipal(ctx, principal), nil
+}
+
+func (i *interceptors) unaryLog(ctx context.Context, req any, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	i.log(info.FullMethod, start, err)
+	return resp, err
+}
+
+func (i *interceptors) streamLog(srv any, stream gogrpc.ServerStream, info *gogrpc.StreamServerInfo, handler gogrpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, stream)
+	i.log(info.FullMethod, start, err)
+	return err
+}
+
+// log writes one access log line per call, like the requestlog middleware does for HTTP requests
+func (i *interceptors) log(method string, start time.Time, err error) {
+	code := status.Code(err)
+
+	event := i.logger.Info()
+	switch code {
+	case codes.OK:
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		event = i.logger.Error().Err(err)
+	default:
+		event = i.logger.Warn()
+	}
+
+	event.
+		Str("method", method).
+		Str("code", code.String()).
+		Dur("latency", time.Since(start)).
+		Msg("grpc call")
+}
+
+// contextStream replaces the context of a server stream
+type contextStream struct {
+	gogrpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// first returns the first value of a metadata key, or an empty string
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,359 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: surfe.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	UserService_GetUser_FullMethodName = "/surfe.v1.UserService/GetUser"
+)
+
+// UserServiceClient is the client API for UserService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// UserService exposes the users, like GET /v1/user/:id
+type UserServiceClient interface {
+	// GetUser returns a user, or NOT_FOUND when there is none with the ID
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
+}
+
+type userServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUserServiceClient(cc grpc.ClientConnInterface) UserServiceClient {
+	return &userServiceClient{cc}
+}
+
+func (c *userServiceClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, UserService_GetUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UserServiceServer is the server API for UserService service.
+// All implementations must embed UnimplementedUserServiceServer
+// for forward compatibility.
+//
+// UserService exposes the users, like GET /v1/user/:id
+type UserServiceServer interface {
+	// GetUser returns a user, or NOT_FOUND when there is none with the ID
+	GetUser(context.Context, *GetUserRequest) (*User, error)
+	mustEmbedUnimplementedUserServiceServer()
+}
+
+// UnimplementedUserServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedUserServiceServer struct{}
+
+func (UnimplementedUserServiceServer) GetUser(context.Context, *GetUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedUserServiceServer) mustEmbedUnimplementedUserServiceServer() {}
+func (UnimplementedUserServiceServer) testEmbeddedByValue()                     {}
+
+// UnsafeUserServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UserServiceServer will
+// result in compilation errors.
+type UnsafeUserServiceServer interface {
+	mustEmbedUnimplementedUserServiceServer()
+}
+
+func RegisterUserServiceServer(s grpc.ServiceRegistrar, srv UserServiceServer) {
+	// If the following call pancis, it indicates UnimplementedUserServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&UserService_ServiceDesc, srv)
+}
+
+func _UserService_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_GetUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// UserService_ServiceDesc is the grpc.ServiceDesc for UserService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UserService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "surfe.v1.UserService",
+	HandlerType: (*UserServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetUser",
+			Handler:    _UserService_GetUser_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "surfe.proto",
+}
+
+const (
+	ActionService_CountActions_FullMethodName            = "/surfe.v1.ActionService/CountActions"
+	ActionService_NextActionProbabilities_FullMethodName = "/surfe.v1.ActionService/NextActionProbabilities"
+	ActionService_ReferralIndex_FullMethodName           = "/surfe.v1.ActionService/ReferralIndex"
+	ActionService_ListActions_FullMethodName             = "/surfe.v1.ActionService/ListActions"
+)
+
+// ActionServiceClient is the client API for ActionService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ActionService exposes the actions and the analytics computed from them
+type ActionServiceClient interface {
+	// CountActions returns how many actions a user took
+	CountActions(ctx context.Context, in *CountActionsRequest, opts ...grpc.CallOption) (*CountActionsResponse, error)
+	// NextActionProbabilities returns how likely each action type is to follow the given one
+	NextActionProbabilities(ctx context.Context, in *NextActionProbabilitiesRequest, opts ...grpc.CallOption) (*NextActionProbabilitiesResponse, error)
+	// ReferralIndex returns the number of users each user referred, directly or indirectly
+	ReferralIndex(ctx context.Context, in *ReferralIndexRequest, opts ...grpc.CallOption) (*ReferralIndexResponse, error)
+	// ListActions streams a user's actions in the order they were recorded
+	ListActions(ctx context.Context, in *ListActionsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Action], error)
+}
+
+type actionServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewActionServiceClient(cc grpc.ClientConnInterface) ActionServiceClient {
+	return &actionServiceClient{cc}
+}
+
+func (c *actionServiceClient) CountActions(ctx context.Context, in *CountActionsRequest, opts ...grpc.CallOption) (*CountActionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CountActionsResponse)
+	err := c.cc.Invoke(ctx, ActionService_CountActions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *actionServiceClient) NextActionProbabilities(ctx context.Context, in *NextActionProbabilitiesRequest, opts ...grpc.CallOption) (*NextActionProbabilitiesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NextActionProbabilitiesResponse)
+	err := c.cc.Invoke(ctx, ActionService_NextActionProbabilities_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *actionServiceClient) ReferralIndex(ctx context.Context, in *ReferralIndexRequest, opts ...grpc.CallOption) (*ReferralIndexResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReferralIndexResponse)
+	err := c.cc.Invoke(ctx, ActionService_ReferralIndex_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *actionServiceClient) ListActions(ctx context.Context, in *ListActionsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Action], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ActionService_ServiceDesc.Streams[0], ActionService_ListActions_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ListActionsRequest, Action]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ActionService_ListActionsClient = grpc.ServerStreamingClient[Action]
+
+// ActionServiceServer is the server API for ActionService service.
+// All implementations must embed UnimplementedActionServiceServer
+// for forward compatibility.
+//
+// ActionService exposes the actions and the analytics computed from them
+type ActionServiceServer interface {
+	// CountActions returns how many actions a user took
+	CountActions(context.Context, *CountActionsRequest) (*CountActionsResponse, error)
+	// NextActionProbabilities returns how likely each action type is to follow the given one
+	NextActionProbabilities(context.Context, *NextActionProbabilitiesRequest) (*NextActionProbabilitiesResponse, error)
+	// ReferralIndex returns the number of users each user referred, directly or indirectly
+	ReferralIndex(context.Context, *ReferralIndexRequest) (*ReferralIndexResponse, error)
+	// ListActions streams a user's actions in the order they were recorded
+	ListActions(*ListActionsRequest, grpc.ServerStreamingServer[Action]) error
+	mustEmbedUnimplementedActionServiceServer()
+}
+
+// UnimplementedActionServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedActionServiceServer struct{}
+
+func (UnimplementedActionServiceServer) CountActions(context.Context, *CountActionsRequest) (*CountActionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountActions not implemented")
+}
+func (UnimplementedActionServiceServer) NextActionProbabilities(context.Context, *NextActionProbabilitiesRequest) (*NextActionProbabilitiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NextActionProbabilities not implemented")
+}
+func (UnimplementedActionServiceServer) ReferralIndex(context.Context, *ReferralIndexRequest) (*ReferralIndexResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReferralIndex not implemented")
+}
+func (UnimplementedActionServiceServer) ListActions(*ListActionsRequest, grpc.ServerStreamingServer[Action]) error {
+	return status.Errorf(codes.Unimplemented, "method ListActions not implemented")
+}
+func (UnimplementedActionServiceServer) mustEmbedUnimplementedActionServiceServer() {}
+func (UnimplementedActionServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeActionServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ActionServiceServer will
+// result in compilation errors.
+type UnsafeActionServiceServer interface {
+	mustEmbedUnimplementedActionServiceServer()
+}
+
+func RegisterActionServiceServer(s grpc.ServiceRegistrar, srv ActionServiceServer) {
+	// If the following call pancis, it indicates UnimplementedActionServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ActionService_ServiceDesc, srv)
+}
+
+func _ActionService_CountActions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountActionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ActionServiceServer).CountActions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ActionService_CountActions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ActionServiceServer).CountActions(ctx, req.(*CountActionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ActionService_NextActionProbabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NextActionProbabilitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ActionServiceServer).NextActionProbabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ActionService_NextActionProbabilities_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ActionServiceServer).NextActionProbabilities(ctx, req.(*NextActionProbabilitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ActionService_ReferralIndex_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReferralIndexRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ActionServiceServer).ReferralIndex(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ActionService_ReferralIndex_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ActionServiceServer).ReferralIndex(ctx, req.(*ReferralIndexRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ActionService_ListActions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListActionsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ActionServiceServer).ListActions(m, &grpc.GenericServerStream[ListActionsRequest, Action]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ActionService_ListActionsServer = grpc.ServerStreamingServer[Action]
+
+// ActionService_ServiceDesc is the grpc.ServiceDesc for ActionService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ActionService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "surfe.v1.ActionService",
+	HandlerType: (*ActionServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CountActions",
+			Handler:    _ActionService_CountActions_Handler,
+		},
+		{
+			MethodName: "NextActionProbabilities",
+			Handler:    _ActionService_NextActionProbabilities_Handler,
+		},
+		{
+			MethodName: "ReferralIndex",
+			Handler:    _ActionService_ReferralIndex_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListActions",
+			Handler:       _ActionService_ListActions_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "surfe.proto",
+}
@@ -0,0 +1,35 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/AntonioDaria/surfe/src/grpc/pb"
+	"github.com/AntonioDaria/surfe/src/models"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type userServer struct {
+	pb.UnimplementedUserServiceServer
+	userService user_s.Service
+}
+
+func (s *userServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.User, error) {
+	if err := requireSelf(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userService.GetUserByID(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, statusError(err, "failed to retrieve user")
+	}
+	return toUser(user), nil
+}
+
+func toUser(user *models.User) *pb.User {
+	return &pb.User{
+		Id:        int64(user.ID),
+		Name:      user.Name,
+		CreatedAt: timestamppb.New(user.CreatedAt),
+	}
+}
@@ -1,15 +1,34 @@
 package action
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
+	"hash/fnv"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/middleware/idempotency"
 	"github.com/AntonioDaria/surfe/src/models"
 	"github.com/AntonioDaria/surfe/src/repository/action"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
 	"github.com/gofiber/fiber/v2"
 )
 
+// MaxBulkActions is the largest number of actions accepted in a single bulk request
+const MaxBulkActions = 1000
+
+// AnalyticsCacheControl lets clients keep analytics responses but makes them
+// revalidate with If-None-Match, which is answered with 304 until the data changes
+const AnalyticsCacheControl = "private, no-cache"
+
+// etagEpoch tells apart dataset versions of different processes, which all start counting from zero
+var etagEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+
 type ActionCountResponse struct {
 	Count int `json:"count"`
 }
@@ -20,19 +39,19 @@ func (h *Handler) GetActionCountByUserIDHandler(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	userID, err := strconv.Atoi(idParam)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to parse user ID")
+		h.log(c).Error().Err(err).Msg("Failed to parse user ID")
 		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid user ID")
 	}
 
 	// Retrieve the action count using the service layer
-	count, err := h.actionService.GetActionCountByUserID(userID)
+	count, err := h.actionService.GetActionCountByUserID(c.UserContext(), userID)
 	if err != nil {
 		if errors.Is(err, action.ErrUserNotFound) {
-			h.logger.Error().Err(err).Msg("User not found")
+			h.log(c).Error().Err(err).Msg("User not found")
 			return utils.JsonError(c, fiber.StatusNotFound, "User not found")
 		}
 
-		h.logger.Error().Err(err).Msg("Failed to retrieve action count")
+		h.log(c).Error().Err(err).Msg("Failed to retrieve action count")
 		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to retrieve action count")
 	}
 
@@ -47,7 +66,14 @@ type NextActionProbabilitiesResponse struct {
 func (h *Handler) GetNextActionProbabilitiesHandler(c *fiber.Ctx) error {
 	actionType := models.ActionType(c.Params("actionType"))
 
-	probabilities := h.actionService.GetNextActionProbabilities(actionType)
+	if h.notModified(c, "next:"+string(actionType)) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	probabilities, err := h.actionService.GetNextActionProbabilities(c.UserContext(), actionType)
+	if err != nil {
+		return h.computationError(c, err, "Failed to compute next action probabilities")
+	}
 
 	return c.JSON(NextActionProbabilitiesResponse{Probabilities: probabilities})
 }
@@ -57,7 +83,196 @@ type ReferralIndexResponse struct {
 }
 
 func (h *Handler) GetReferralIndexHandler(c *fiber.Ctx) error {
+	if h.notModified(c, "referral") {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	referralIndex, err := h.actionService.GetReferralIndex(c.UserContext())
+	if err != nil {
+		return h.computationError(c, err, "Failed to compute referral index")
+	}
 
-	referralIndex := h.actionService.GetReferralIndex()
 	return c.JSON(ReferralIndexResponse{ReferralIndex: referralIndex})
 }
+
+// computationError writes the response for a failed analytics computation,
+// answering 503 when it was abandoned because the request ran out of time
+func (h *Handler) computationError(c *fiber.Ctx, err error, message string) error {
+	// Errors must not be mistaken for a cacheable result
+	c.Response().Header.Del(fiber.HeaderETag)
+	c.Response().Header.Del(fiber.HeaderCacheControl)
+
+	if utils.IsContextError(err) {
+		h.log(c).Warn().Err(err).Msg("Computation abandoned")
+		return utils.JsonError(c, fiber.StatusServiceUnavailable, "Request timed out")
+	}
+	if errors.Is(err, action_s.ErrUnknownActionType) {
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid action type")
+	}
+
+	h.log(c).Error().Err(err).Msg(message)
+	return utils.JsonError(c, fiber.StatusInternalServerError, message)
+}
+
+// notModified sets the caching headers of an analytics response, identified by
+// key, and reports whether the client's copy is still current
+func (h *Handler) notModified(c *fiber.Ctx, key string) bool {
+	version := h.actionService.DatasetVersion(c.UserContext())
+
+	c.Set(fiber.HeaderETag, analyticsETag(key, version))
+	c.Set(fiber.HeaderCacheControl, AnalyticsCacheControl)
+
+	return c.Fresh()
+}
+
+// analyticsETag returns the entity tag of an analytics result computed from the given dataset version
+func analyticsETag(key string, version uint64) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(etagEpoch + "|" + key + "|" + strconv.FormatUint(version, 10)))
+	return `"` + strconv.FormatUint(hash.Sum64(), 16) + `"`
+}
+
+type BulkActionResult struct {
+	Index  int            `json:"index"`
+	Status string         `json:"status"`
+	Action *models.Action `json:"action,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+type BulkActionsResponse struct {
+	Created  int                `json:"created"`
+	Rejected int                `json:"rejected"`
+	Results  []BulkActionResult `json:"results"`
+}
+
+const (
+	BulkStatusCreated  = "created"
+	BulkStatusRejected = "rejected"
+)
+
+// CreateActionsBulkHandler stores a batch of actions sent either as a JSON array
+// or as NDJSON, reporting the outcome of every item individually
+func (h *Handler) CreateActionsBulkHandler(c *fiber.Ctx) error {
+	items, err := splitBulkBody(c.Body(), c.Get(fiber.HeaderContentType))
+	if err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to parse bulk actions body")
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if len(items) == 0 {
+		return utils.JsonError(c, fiber.StatusBadRequest, "No actions provided")
+	}
+	if len(items) > MaxBulkActions {
+		return utils.JsonError(c, fiber.StatusRequestEntityTooLarge, "Too many actions in a single request")
+	}
+
+	results := make([]BulkActionResult, len(items))
+	actions := make([]models.Action, 0, len(items))
+	indexes := make([]int, 0, len(items))
+
+	// Decode every item on its own so one malformed entry doesn't reject the batch
+	for i, item := range items {
+		results[i].Index = i
+
+		var a models.Action
+		if err := json.Unmarshal(item, &a); err != nil {
+			results[i].Status = BulkStatusRejected
+			results[i].Error = "invalid action: " + err.Error()
+			continue
+		}
+
+		actions = append(actions, a)
+		indexes = append(indexes, i)
+	}
+
+	if len(actions) > 0 {
+		stored, err := h.actionService.AddActions(c.UserContext(), actions)
+		if err != nil {
+			h.log(c).Error().Err(err).Msg("Failed to store bulk actions")
+			return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to store actions")
+		}
+
+		for i, res := range stored {
+			result := &results[indexes[i]]
+			if res.Err != nil {
+				result.Status = BulkStatusRejected
+				result.Error = res.Err.Error()
+				continue
+			}
+			result.Status = BulkStatusCreated
+			result.Action = res.Action
+
+			idempotency.TagUsers(c, res.Action.UserID)
+			if res.Action.Type == models.ActionTypeReferUser {
+				idempotency.TagUsers(c, res.Action.TargetUser)
+			}
+		}
+	}
+
+	response := BulkActionsResponse{Results: results}
+	for _, res := range results {
+		if res.Status == BulkStatusCreated {
+			response.Created++
+		} else {
+			response.Rejected++
+		}
+	}
+
+	return c.JSON(response)
+}
+
+// DeleteActionHandler soft deletes an action, which hides it from every read
+func (h *Handler) DeleteActionHandler(c *fiber.Ctx) error {
+	actionID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to parse action ID")
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid action ID")
+	}
+
+	// Token holders can only delete their own actions
+	var canDelete func(userID int) bool
+	if principal := auth.PrincipalFromContext(c.UserContext()); principal != nil {
+		canDelete = func(userID int) bool { return principal.CanAccessUser(strconv.Itoa(userID)) }
+	}
+
+	if _, err := h.actionService.DeleteAction(c.UserContext(), actionID, canDelete); err != nil {
+		if errors.Is(err, action.ErrActionNotFound) {
+			h.log(c).Error().Err(err).Msg("Action not found")
+			return utils.JsonError(c, fiber.StatusNotFound, "Action not found")
+		}
+
+		h.log(c).Error().Err(err).Msg("Failed to delete action")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to delete action")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// splitBulkBody splits a bulk request body into raw JSON items. Bodies are treated
+// as NDJSON when the content type says so or when they don't start with an array.
+func splitBulkBody(body []byte, contentType string) ([]json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(body)
+
+	if !strings.HasPrefix(contentType, "application/x-ndjson") && bytes.HasPrefix(trimmed, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	var items []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(trimmed)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, json.RawMessage(append([]byte(nil), line...)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
@@ -1,18 +1,23 @@
 package action
 
 import (
+	"context"
 	"errors"
+	"io"
 	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"encoding/json"
 
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
 	"github.com/AntonioDaria/surfe/src/models"
 	"github.com/AntonioDaria/surfe/src/repository/action"
+	"github.com/AntonioDaria/surfe/src/repository/user"
 	action_s "github.com/AntonioDaria/surfe/src/services/action"
 	action_mock "github.com/AntonioDaria/surfe/src/services/action/mock"
 	"github.com/gofiber/fiber/v2"
@@ -32,7 +37,7 @@ func TestGetActionCountByUserIDHandler_Success(t *testing.T) {
 	handler := NewHandler(mockService, logger)
 
 	// Define the expected behavior and result
-	mockService.EXPECT().GetActionCountByUserID(1).Return(100, nil)
+	mockService.EXPECT().GetActionCountByUserID(gomock.Any(), 1).Return(100, nil)
 
 	// Set up the Fiber app
 	app := fiber.New()
@@ -57,7 +62,7 @@ func TestGetActionCountByUserIDHandler_User_Not_Found(t *testing.T) {
 	handler := NewHandler(mockService, logger)
 
 	// Define the expected behavior and result
-	mockService.EXPECT().GetActionCountByUserID(1).Return(0, action.ErrUserNotFound)
+	mockService.EXPECT().GetActionCountByUserID(gomock.Any(), 1).Return(0, action.ErrUserNotFound)
 
 	// Set up the Fiber app
 	app := fiber.New()
@@ -104,7 +109,7 @@ func TestGetActionCountByUserIDHandler_Internal_Server_Error(t *testing.T) {
 	handler := NewHandler(mockService, logger)
 
 	// Define the expected behavior and result
-	mockService.EXPECT().GetActionCountByUserID(1).Return(0, errors.New("internal server error"))
+	mockService.EXPECT().GetActionCountByUserID(gomock.Any(), 1).Return(0, errors.New("internal server error"))
 
 	// Set up the Fiber app
 	app := fiber.New()
@@ -126,7 +131,12 @@ func TestGetActionCountByUserIDIntegration(t *testing.T) {
 		t.Fatalf("Failed to initialize repository: %v", err)
 	}
 
-	actionService := action_s.NewActionService(actionRepo)
+	userRepo, err := user.NewUserRepo("../../repository/data/users.json")
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	actionService := action_s.NewActionService(actionRepo, userRepo)
 	handler := NewHandler(actionService, logger)
 
 	app := fiber.New()
@@ -145,6 +155,15 @@ func TestGetActionCountByUserIDIntegration(t *testing.T) {
 
 	assert.Equal(t, 49, countResponse.Count)
 
+	// User without actions
+	req = httptest.NewRequest(http.MethodGet, "/users/12/actions/count", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	err = json.NewDecoder(resp.Body).Decode(&countResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, countResponse.Count)
+
 	// User not found
 	req = httptest.NewRequest(http.MethodGet, "/users/1000/actions/count", nil)
 	resp, _ = app.Test(req, -1)
@@ -172,7 +191,8 @@ func TestGetNextActionProbabilitiesHandler(t *testing.T) {
 	}
 
 	// Define the expected behavior and result
-	mockService.EXPECT().GetNextActionProbabilities(models.ActionTypeAddContact).Return(mockReturn)
+	mockService.EXPECT().DatasetVersion(gomock.Any()).Return(uint64(1))
+	mockService.EXPECT().GetNextActionProbabilities(gomock.Any(), models.ActionTypeAddContact).Return(mockReturn, nil)
 	app := fiber.New()
 	app.Get("/actions/:actionType/probabilities", handler.GetNextActionProbabilitiesHandler)
 
@@ -184,6 +204,133 @@ func TestGetNextActionProbabilitiesHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func TestGetNextActionProbabilitiesHandler_TimedOut(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := action_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	mockService.EXPECT().DatasetVersion(gomock.Any()).Return(uint64(1))
+	mockService.EXPECT().GetNextActionProbabilities(gomock.Any(), models.ActionTypeAddContact).Return(nil, context.DeadlineExceeded)
+	app := fiber.New()
+	app.Get("/actions/:actionType/probabilities", handler.GetNextActionProbabilitiesHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/actions/ADD_CONTACT/probabilities", nil)
+	resp, _ := app.Test(req, -1)
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestGetNextActionProbabilitiesHandler_UnknownType(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := action_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	mockService.EXPECT().DatasetVersion(gomock.Any()).Return(uint64(1))
+	mockService.EXPECT().GetNextActionProbabilities(gomock.Any(), models.ActionType("DANCE")).
+		Return(nil, action_s.ErrUnknownActionType)
+	app := fiber.New()
+	app.Get("/actions/:actionType/probabilities", handler.GetNextActionProbabilitiesHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/actions/DANCE/probabilities", nil)
+	resp, _ := app.Test(req, -1)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.JSONEq(t, `{"error":"Invalid action type"}`, string(body))
+	assert.Empty(t, resp.Header.Get(fiber.HeaderETag))
+}
+
+func TestGetReferralIndexHandler_Error(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := action_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	mockService.EXPECT().DatasetVersion(gomock.Any()).Return(uint64(1))
+	mockService.EXPECT().GetReferralIndex(gomock.Any()).Return(nil, errors.New("boom"))
+	app := fiber.New()
+	app.Get("/actions/referral", handler.GetReferralIndexHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/actions/referral", nil)
+	resp, _ := app.Test(req, -1)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(fiber.HeaderETag))
+}
+
+func TestGetReferralIndexHandler_ConditionalGet(t *testing.T) {
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2},
+		},
+	}
+
+	actionService := action_s.NewActionService(actionRepo, nil)
+	handler := NewHandler(actionService, zerolog.New(os.Stderr))
+
+	app := fiber.New()
+	app.Get("/actions/referral", handler.GetReferralIndexHandler)
+
+	// The first response carries the entity tag of the current dataset
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/actions/referral", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, AnalyticsCacheControl, resp.Header.Get(fiber.HeaderCacheControl))
+	etag := resp.Header.Get(fiber.HeaderETag)
+	assert.NotEmpty(t, etag)
+
+	// Revalidating with it is answered without a body
+	req := httptest.NewRequest(http.MethodGet, "/actions/referral", nil)
+	req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// Once the dataset changes the old tag no longer matches
+	_, err := actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 2, Type: models.ActionTypeReferUser, TargetUser: 3},
+	})
+	assert.NoError(t, err)
+
+	req = httptest.NewRequest(http.MethodGet, "/actions/referral", nil)
+	req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get(fiber.HeaderETag))
+
+	var referralIndexResponse ReferralIndexResponse
+	err = json.NewDecoder(resp.Body).Decode(&referralIndexResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, referralIndexResponse.ReferralIndex[1])
+}
+
+func TestGetNextActionProbabilitiesHandler_ETagPerActionType(t *testing.T) {
+	actionService := action_s.NewActionService(&action.RepositoryImpl{}, nil)
+	handler := NewHandler(actionService, zerolog.New(os.Stderr))
+
+	app := fiber.New()
+	app.Get("/actions/:actionType/next", handler.GetNextActionProbabilitiesHandler)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/actions/ADD_CONTACT/next", nil), -1)
+	addContactETag := resp.Header.Get(fiber.HeaderETag)
+
+	// A tag for one action type doesn't validate another
+	req := httptest.NewRequest(http.MethodGet, "/actions/REFER_USER/next", nil)
+	req.Header.Set(fiber.HeaderIfNoneMatch, addContactETag)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, addContactETag, resp.Header.Get(fiber.HeaderETag))
+}
+
 func TestGetNextActionProbabilitiesHandler_Precision(t *testing.T) {
 	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
 
@@ -197,7 +344,7 @@ func TestGetNextActionProbabilitiesHandler_Precision(t *testing.T) {
 		},
 	}
 
-	actionService := action_s.NewActionService(actionRepo)
+	actionService := action_s.NewActionService(actionRepo, nil)
 	handler := NewHandler(actionService, logger)
 
 	app := fiber.New()
@@ -239,7 +386,7 @@ func TestGetNextActionProbabilitiesHandler_Integration(t *testing.T) {
 		},
 	}
 
-	actionService := action_s.NewActionService(actionRepo)
+	actionService := action_s.NewActionService(actionRepo, nil)
 	handler := NewHandler(actionService, logger)
 
 	app := fiber.New()
@@ -274,7 +421,7 @@ func TestGetReferralIndex_Integration(t *testing.T) {
 		},
 	}
 
-	actionService := action_s.NewActionService(actionRepo)
+	actionService := action_s.NewActionService(actionRepo, nil)
 	handler := NewHandler(actionService, zerolog.New(os.Stderr))
 
 	app := fiber.New()
@@ -302,3 +449,169 @@ func TestGetReferralIndex_Integration(t *testing.T) {
 	assert.Equal(t, 0, referralIndex[4]) // User 4 has no referrals
 	assert.Equal(t, 0, referralIndex[5]) // User 5 has no referrals
 }
+
+func TestCreateActionsBulkHandler_Integration(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: models.ActionTypeAddContact, CreatedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	userRepo, err := user.NewUserRepo("../../repository/data/users.json")
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	actionService := action_s.NewActionService(actionRepo, userRepo)
+	handler := NewHandler(actionService, logger)
+
+	app := fiber.New()
+	app.Post("/actions/bulk", handler.CreateActionsBulkHandler)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "JSON array",
+			contentType: fiber.MIMEApplicationJSON,
+			body:        `[{"type":"ADD_CONTACT","userId":1},{"type":"NOPE","userId":1},{"type":"REFER_USER","userId":2,"targetUser":3}]`,
+		},
+		{
+			name:        "NDJSON",
+			contentType: "application/x-ndjson",
+			body:        "{\"type\":\"ADD_CONTACT\",\"userId\":1}\n{\"type\":\"NOPE\",\"userId\":1}\n\n{\"type\":\"REFER_USER\",\"userId\":2,\"targetUser\":3}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(actionRepo.GetAllActions(context.Background()))
+
+			req := httptest.NewRequest(http.MethodPost, "/actions/bulk", strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, tt.contentType)
+			resp, _ := app.Test(req, -1)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var bulkResponse BulkActionsResponse
+			err := json.NewDecoder(resp.Body).Decode(&bulkResponse)
+			assert.NoError(t, err)
+
+			assert.Equal(t, 2, bulkResponse.Created)
+			assert.Equal(t, 1, bulkResponse.Rejected)
+			assert.Len(t, bulkResponse.Results, 3)
+
+			assert.Equal(t, BulkStatusCreated, bulkResponse.Results[0].Status)
+			assert.Equal(t, BulkStatusRejected, bulkResponse.Results[1].Status)
+			assert.NotEmpty(t, bulkResponse.Results[1].Error)
+			assert.Equal(t, BulkStatusCreated, bulkResponse.Results[2].Status)
+			assert.Equal(t, 3, bulkResponse.Results[2].Action.TargetUser)
+
+			// Only the valid actions were stored
+			assert.Len(t, actionRepo.GetAllActions(context.Background()), before+2)
+		})
+	}
+}
+
+func TestCreateActionsBulkHandler_Bad_Request(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := action_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	app := fiber.New()
+	app.Post("/actions/bulk", handler.CreateActionsBulkHandler)
+
+	for _, body := range []string{`[{"type":"ADD_CONTACT"`, ``, `[]`} {
+		req := httptest.NewRequest(http.MethodPost, "/actions/bulk", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, _ := app.Test(req, -1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "body %q", body)
+	}
+}
+
+func TestCreateActionsBulkHandler_Internal_Server_Error(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := action_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	mockService.EXPECT().AddActions(gomock.Any(), gomock.Any()).Return(nil, errors.New("internal server error"))
+
+	app := fiber.New()
+	app.Post("/actions/bulk", handler.CreateActionsBulkHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/actions/bulk", strings.NewReader(`[{"type":"ADD_CONTACT","userId":1}]`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, _ := app.Test(req, -1)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestDeleteActionHandler(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	tests := []struct {
+		name   string
+		path   string
+		err    error
+		status int
+	}{
+		{name: "deleted", path: "/actions/0", status: http.StatusNoContent},
+		{name: "invalid ID", path: "/actions/abc", status: http.StatusBadRequest},
+		{name: "not found", path: "/actions/0", err: action.ErrActionNotFound, status: http.StatusNotFound},
+		{name: "storage failure", path: "/actions/0", err: errors.New("disk full"), status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockService := action_mock.NewMockService(ctrl)
+			handler := NewHandler(mockService, logger)
+
+			if tt.status != http.StatusBadRequest {
+				mockService.EXPECT().DeleteAction(gomock.Any(), 0, gomock.Nil()).Return(&models.Action{}, tt.err)
+			}
+
+			app := fiber.New()
+			app.Delete("/actions/:id", handler.DeleteActionHandler)
+
+			resp, _ := app.Test(httptest.NewRequest(http.MethodDelete, tt.path, nil), -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestDeleteActionHandler_Owner(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	mockService := action_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	// Token holders may only delete the actions of their own user
+	mockService.EXPECT().DeleteAction(gomock.Any(), 7, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ int, canDelete func(userID int) bool) (*models.Action, error) {
+			assert.True(t, canDelete(1))
+			assert.False(t, canDelete(2))
+			return &models.Action{}, nil
+		})
+
+	app := fiber.New()
+	app.Delete("/actions/:id", func(c *fiber.Ctx) error {
+		c.SetUserContext(auth.ContextWithPrincipal(c.UserContext(), &auth.Principal{Subject: "1", Scopes: []auth.Scope{auth.ScopeActionsWrite}}))
+		return c.Next()
+	}, handler.DeleteActionHandler)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodDelete, "/actions/7", nil), -1)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
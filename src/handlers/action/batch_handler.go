@@ -0,0 +1,57 @@
+package action
+
+import (
+	"strconv"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+type BatchActionCountResult struct {
+	Status string `json:"status"`
+	Count  *int   `json:"count,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchActionCountsResponse struct {
+	Found    int `json:"found"`
+	NotFound int `json:"notFound"`
+	// Results holds the outcome of the count of every requested user, keyed by user ID
+	Results map[int]BatchActionCountResult `json:"results"`
+}
+
+// GetActionCountsBatchHandler handles requests to count the actions of several
+// users at once, reporting the users that aren't found rather than failing the whole batch
+func (h *Handler) GetActionCountsBatchHandler(c *fiber.Ctx) error {
+	ids, batchErr := utils.ParseBatchIDs(c.Body())
+	if batchErr != nil {
+		return utils.JsonError(c, batchErr.Code, batchErr.Message)
+	}
+
+	// Token holders can only count their own actions
+	if principal := auth.PrincipalFromContext(c.UserContext()); principal != nil {
+		for _, id := range ids {
+			if !principal.CanAccessUser(strconv.Itoa(id)) {
+				return utils.JsonError(c, fiber.StatusForbidden, "Access to other users is not allowed")
+			}
+		}
+	}
+
+	counts := h.actionService.GetActionCountsByUserIDs(c.UserContext(), ids)
+
+	response := BatchActionCountsResponse{Results: make(map[int]BatchActionCountResult, len(ids))}
+	for _, id := range ids {
+		count, ok := counts[id]
+		if !ok {
+			response.NotFound++
+			response.Results[id] = BatchActionCountResult{Status: utils.BatchStatusNotFound, Error: "User not found"}
+			continue
+		}
+
+		response.Found++
+		response.Results[id] = BatchActionCountResult{Status: utils.BatchStatusFound, Count: &count}
+	}
+
+	return c.JSON(response)
+}
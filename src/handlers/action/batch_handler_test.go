@@ -0,0 +1,115 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/repository/action"
+	"github.com/AntonioDaria/surfe/src/repository/user"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+	action_mock "github.com/AntonioDaria/surfe/src/services/action/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetActionCountsBatchHandler_Integration(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	actionRepo, err := action.NewActionRepo("../../repository/data/actions.json")
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	userRepo, err := user.NewUserRepo("../../repository/data/users.json")
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	handler := NewHandler(action_s.NewActionService(actionRepo, userRepo), logger)
+
+	app := fiber.New()
+	app.Post("/users/actions/count/batch", handler.GetActionCountsBatchHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/users/actions/count/batch", strings.NewReader(`{"ids":[1,1000,1]}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var response BatchActionCountsResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	assert.NoError(t, err)
+
+	// Duplicate IDs are looked up once
+	count := 49
+	assert.Equal(t, BatchActionCountsResponse{
+		Found:    1,
+		NotFound: 1,
+		Results: map[int]BatchActionCountResult{
+			1:    {Status: "found", Count: &count},
+			1000: {Status: "not_found", Error: "User not found"},
+		},
+	}, response)
+}
+
+func TestGetActionCountsBatchHandler_Bad_Request(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	handler := NewHandler(action_mock.NewMockService(ctrl), logger)
+
+	app := fiber.New()
+	app.Post("/users/actions/count/batch", handler.GetActionCountsBatchHandler)
+
+	tooMany := `{"ids":[` + strings.Repeat("1,", 1000) + `1]}`
+	for body, status := range map[string]int{
+		`{"ids":[1,`:  http.StatusBadRequest,
+		`{"ids":"1"}`: http.StatusBadRequest,
+		`{"ids":[]}`:  http.StatusBadRequest,
+		``:            http.StatusBadRequest,
+		tooMany:       http.StatusRequestEntityTooLarge,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/users/actions/count/batch", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, _ := app.Test(req, -1)
+		assert.Equal(t, status, resp.StatusCode, "body %.20q", body)
+	}
+}
+
+func TestGetActionCountsBatchHandler_Other_Users(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := action_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+	mockService.EXPECT().GetActionCountsByUserIDs(gomock.Any(), []int{1}).Return(map[int]int{1: 2})
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.SetUserContext(auth.ContextWithPrincipal(context.Background(), &auth.Principal{Name: "1", Subject: "1"}))
+		return c.Next()
+	})
+	app.Post("/users/actions/count/batch", handler.GetActionCountsBatchHandler)
+
+	// Token holders can count their own actions, but not those of other users
+	for body, status := range map[string]int{
+		`{"ids":[1]}`:   http.StatusOK,
+		`{"ids":[1,2]}`: http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/users/actions/count/batch", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, _ := app.Test(req, -1)
+		assert.Equal(t, status, resp.StatusCode, "body %s", body)
+	}
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/audit"
+	audit_s "github.com/AntonioDaria/surfe/src/services/audit"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AuditResponse struct {
+	Records []models.AuditRecord `json:"records"`
+	// NextCursor is passed as the cursor query parameter to get the next page,
+	// and left out on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// GetAuditHandler returns a page of the audit trail, optionally narrowed down
+// to an entity type with the entity query parameter, and to a single entity
+// with id. Pages are sized with limit and followed with cursor.
+func (h *Handler) GetAuditHandler(c *fiber.Ctx) error {
+	query := audit.Query{Entity: models.AuditEntity(c.Query("entity"))}
+	if idParam := c.Query("id"); idParam != "" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			h.log(c).Error().Err(err).Msg("Failed to parse entity ID")
+			return utils.JsonError(c, fiber.StatusBadRequest, "Invalid entity ID")
+		}
+		query.EntityID = &id
+	}
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return utils.JsonError(c, fiber.StatusBadRequest, "Invalid limit")
+		}
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		var err error
+		if query.After, err = strconv.Atoi(cursor); err != nil {
+			return utils.JsonError(c, fiber.StatusBadRequest, "Invalid cursor")
+		}
+	}
+
+	page, err := h.auditService.GetRecords(c.UserContext(), query)
+	if err != nil {
+		if errors.Is(err, audit_s.ErrUnknownEntity) || errors.Is(err, audit_s.ErrEntityRequired) || errors.Is(err, audit_s.ErrInvalidPage) {
+			return utils.JsonError(c, fiber.StatusBadRequest, err.Error())
+		}
+
+		h.log(c).Error().Err(err).Msg("Failed to retrieve audit trail")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to retrieve audit trail")
+	}
+
+	response := AuditResponse{Records: page.Records}
+	if page.Next != 0 {
+		response.NextCursor = strconv.Itoa(page.Next)
+	}
+	return c.JSON(response)
+}
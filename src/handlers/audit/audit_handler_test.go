@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/audit"
+	audit_s "github.com/AntonioDaria/surfe/src/services/audit"
+	audit_mock "github.com/AntonioDaria/surfe/src/services/audit/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newApp(t *testing.T) (*fiber.App, *audit_mock.MockService) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	mockService := audit_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	app := fiber.New()
+	app.Get("/audit", handler.GetAuditHandler)
+
+	return app, mockService
+}
+
+func TestGetAuditHandler(t *testing.T) {
+	app, mockService := newApp(t)
+
+	id := 0
+	query := audit.Query{Entity: models.AuditEntityAction, EntityID: &id, After: 4, Limit: 2}
+	mockService.EXPECT().GetRecords(gomock.Any(), query).Return(audit_s.Page{Records: []models.AuditRecord{
+		{ID: 5, Entity: models.AuditEntityAction, EntityID: 0, Operation: models.AuditOperationCreate, Actor: "ops"},
+		{ID: 6, Entity: models.AuditEntityAction, EntityID: 0, Operation: models.AuditOperationDelete, Actor: "ops"},
+	}, Next: 6}, nil)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/audit?entity=action&id=0&limit=2&cursor=4", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body AuditResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Len(t, body.Records, 2)
+	assert.Equal(t, models.AuditOperationDelete, body.Records[1].Operation)
+	assert.Equal(t, "6", body.NextCursor)
+}
+
+func TestGetAuditHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		err    error
+		status int
+	}{
+		{name: "invalid ID", query: "?entity=user&id=abc", status: http.StatusBadRequest},
+		{name: "invalid limit", query: "?limit=ten", status: http.StatusBadRequest},
+		{name: "invalid cursor", query: "?cursor=abc", status: http.StatusBadRequest},
+		{name: "limit out of range", query: "?limit=5000", err: audit_s.ErrInvalidPage, status: http.StatusBadRequest},
+		{name: "unknown entity", query: "?entity=webhook", err: audit_s.ErrUnknownEntity, status: http.StatusBadRequest},
+		{name: "ID without entity", query: "?id=1", err: audit_s.ErrEntityRequired, status: http.StatusBadRequest},
+		{name: "storage failure", query: "", err: errors.New("disk full"), status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mockService := newApp(t)
+			if tt.err != nil {
+				mockService.EXPECT().GetRecords(gomock.Any(), gomock.Any()).Return(audit_s.Page{}, tt.err)
+			}
+
+			resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/audit"+tt.query, nil), -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package audit
+
+import (
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	audit_s "github.com/AntonioDaria/surfe/src/services/audit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+type Handler struct {
+	auditService audit_s.Service
+	logger       zerolog.Logger
+}
+
+func NewHandler(auditService audit_s.Service, logger zerolog.Logger) *Handler {
+	return &Handler{
+		auditService: auditService,
+		logger:       logger,
+	}
+}
+
+// log returns the request scoped logger, falling back to the handler's logger
+func (h *Handler) log(c *fiber.Ctx) *zerolog.Logger {
+	logger := utils.Logger(c, h.logger)
+	return &logger
+}
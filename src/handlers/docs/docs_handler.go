@@ -0,0 +1,25 @@
+package docs
+
+import (
+	_ "embed"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// docsPage renders the OpenAPI document as a reference page. It is
+// self-contained, so the docs work without access to a CDN.
+//
+//go:embed docs.html
+var docsPage []byte
+
+// OpenAPIHandler serves the OpenAPI document
+func (h *Handler) OpenAPIHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	return c.Send(h.spec)
+}
+
+// DocsHandler serves the API reference page
+func (h *Handler) DocsHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Send(docsPage)
+}
@@ -0,0 +1,42 @@
+package docs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	spec := []byte(`{"openapi":"3.0.3"}`)
+	handler := NewHandler(spec)
+
+	app := fiber.New()
+	app.Get("/openapi.json", handler.OpenAPIHandler)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/openapi.json", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, fiber.MIMEApplicationJSONCharsetUTF8, resp.Header.Get(fiber.HeaderContentType))
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, spec, body)
+}
+
+func TestDocsHandler(t *testing.T) {
+	handler := NewHandler(nil)
+
+	app := fiber.New()
+	app.Get("/docs", handler.DocsHandler)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/docs", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, fiber.MIMETextHTMLCharsetUTF8, resp.Header.Get(fiber.HeaderContentType))
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `data-spec-url="/openapi.json"`)
+	// The page doesn't depend on any external asset
+	assert.NotContains(t, string(body), "https://")
+}
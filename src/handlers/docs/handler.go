@@ -0,0 +1,10 @@
+package docs
+
+type Handler struct {
+	spec []byte
+}
+
+// NewHandler returns the handler serving the OpenAPI document spec and its reference page
+func NewHandler(spec []byte) *Handler {
+	return &Handler{spec: spec}
+}
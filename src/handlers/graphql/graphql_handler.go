@@ -0,0 +1,45 @@
+package graphql
+
+import (
+	"encoding/json"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Request is a GraphQL query, sent as a JSON body or as query string parameters
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// GraphQLHandler runs a GraphQL query. Errors raised while resolving the query
+// are reported in the errors field of a 200 response, as GraphQL clients expect.
+func (h *Handler) GraphQLHandler(c *fiber.Ctx) error {
+	var req Request
+
+	if c.Method() == fiber.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return utils.JsonError(c, fiber.StatusBadRequest, "Invalid variables")
+			}
+		}
+	} else if err := json.Unmarshal(c.Body(), &req); err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to parse GraphQL request")
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if req.Query == "" {
+		return utils.JsonError(c, fiber.StatusBadRequest, "Missing query")
+	}
+
+	response := h.executor.Exec(c.UserContext(), req.Query, req.OperationName, req.Variables)
+	if len(response.Errors) > 0 {
+		h.log(c).Warn().Interface("errors", response.Errors).Msg("GraphQL query failed")
+	}
+
+	return c.JSON(response)
+}
@@ -0,0 +1,101 @@
+package graphql
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/graphql"
+	"github.com/AntonioDaria/surfe/src/models"
+	action_mock "github.com/AntonioDaria/surfe/src/services/action/mock"
+	user_mock "github.com/AntonioDaria/surfe/src/services/user/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestApp(t *testing.T) *fiber.App {
+	ctrl := gomock.NewController(t)
+
+	userService := user_mock.NewMockService(ctrl)
+	userService.EXPECT().GetUsersByIDs(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, ids []int) map[int]*models.User {
+		return map[int]*models.User{1: {ID: 1, Name: "Ada"}}
+	}).AnyTimes()
+
+	executor, err := graphql.NewExecutor(userService, action_mock.NewMockService(ctrl))
+	assert.NoError(t, err)
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	handler := NewHandler(executor, logger)
+
+	app := fiber.New()
+	app.Get("/graphql", handler.GraphQLHandler)
+	app.Post("/graphql", handler.GraphQLHandler)
+	return app
+}
+
+func TestGraphQLHandler_Post(t *testing.T) {
+	app := newTestApp(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql",
+		strings.NewReader(`{"query":"query($id: Int!) { user(id: $id) { name } }","variables":{"id":1}}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, -1)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{"data":{"user":{"name":"Ada"}}}`, string(body))
+}
+
+func TestGraphQLHandler_Get(t *testing.T) {
+	app := newTestApp(t)
+
+	query := url.Values{"query": {"{ user(id: 1) { name } }"}}
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/graphql?"+query.Encode(), nil), -1)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{"data":{"user":{"name":"Ada"}}}`, string(body))
+}
+
+func TestGraphQLHandler_QueryErrors(t *testing.T) {
+	app := newTestApp(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ user(id: 1) { unknown } }"}`))
+	resp, _ := app.Test(req, -1)
+
+	// Errors in the query are reported in the body, as GraphQL clients expect
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `"errors"`)
+}
+
+func TestGraphQLHandler_BadRequest(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want string
+	}{
+		{"invalid body", httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{`)), "Invalid request body"},
+		{"missing query", httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{}`)), "Missing query"},
+		{"invalid variables", httptest.NewRequest(http.MethodGet, "/graphql?query=%7B%7D&variables=%7B", nil), "Invalid variables"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := app.Test(tt.req, -1)
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, `{"error":"`+tt.want+`"}`, string(body))
+		})
+	}
+}
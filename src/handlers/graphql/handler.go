@@ -0,0 +1,26 @@
+package graphql
+
+import (
+	"github.com/AntonioDaria/surfe/src/graphql"
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+type Handler struct {
+	executor *graphql.Executor
+	logger   zerolog.Logger
+}
+
+func NewHandler(executor *graphql.Executor, logger zerolog.Logger) *Handler {
+	return &Handler{
+		executor: executor,
+		logger:   logger,
+	}
+}
+
+// log returns the request scoped logger, falling back to the handler's logger
+func (h *Handler) log(c *fiber.Ctx) *zerolog.Logger {
+	logger := utils.Logger(c, h.logger)
+	return &logger
+}
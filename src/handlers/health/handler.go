@@ -0,0 +1,30 @@
+package health
+
+import (
+	"runtime/debug"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/health"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+type Handler struct {
+	state     *health.State
+	buildInfo func() (*debug.BuildInfo, bool)
+	logger    zerolog.Logger
+}
+
+func NewHandler(state *health.State, logger zerolog.Logger) *Handler {
+	return &Handler{
+		state:     state,
+		buildInfo: debug.ReadBuildInfo,
+		logger:    logger,
+	}
+}
+
+// log returns the request scoped logger, falling back to the handler's logger
+func (h *Handler) log(c *fiber.Ctx) *zerolog.Logger {
+	logger := utils.Logger(c, h.logger)
+	return &logger
+}
@@ -0,0 +1,75 @@
+package health
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusNotReady = "not ready"
+)
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type VersionResponse struct {
+	Module    string `json:"module"`
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"buildTime,omitempty"`
+	Modified  bool   `json:"modified"`
+}
+
+// HealthzHandler reports that the process is alive
+func (h *Handler) HealthzHandler(c *fiber.Ctx) error {
+	return c.JSON(HealthResponse{Status: StatusOK})
+}
+
+// ReadyzHandler reports whether the service can take traffic, answering 503
+// while the data is loading or once shutdown has begun
+func (h *Handler) ReadyzHandler(c *fiber.Ctx) error {
+	ready, failures := h.state.Readiness()
+	if !ready {
+		h.log(c).Warn().Interface("checks", failures).Msg("Service not ready")
+		return c.Status(fiber.StatusServiceUnavailable).JSON(ReadinessResponse{
+			Status: StatusNotReady,
+			Checks: failures,
+		})
+	}
+
+	return c.JSON(ReadinessResponse{Status: StatusOK})
+}
+
+// VersionHandler returns the build information embedded in the binary
+func (h *Handler) VersionHandler(c *fiber.Ctx) error {
+	info, ok := h.buildInfo()
+	if !ok {
+		return c.JSON(VersionResponse{Version: "unknown"})
+	}
+
+	response := VersionResponse{
+		Module:    info.Main.Path,
+		Version:   info.Main.Version,
+		GoVersion: info.GoVersion,
+	}
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			response.Revision = setting.Value
+		case "vcs.time":
+			response.BuildTime = setting.Value
+		case "vcs.modified":
+			response.Modified = setting.Value == "true"
+		}
+	}
+
+	return c.JSON(response)
+}
@@ -0,0 +1,109 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime/debug"
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/health"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestApp(handler *Handler) *fiber.App {
+	app := fiber.New()
+	app.Get("/healthz", handler.HealthzHandler)
+	app.Get("/readyz", handler.ReadyzHandler)
+	app.Get("/version", handler.VersionHandler)
+	return app
+}
+
+func TestHealthzHandler(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	app := newTestApp(NewHandler(health.NewState(), logger))
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil), -1)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestReadyzHandler_NotReadyBeforeDataLoaded(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	app := newTestApp(NewHandler(health.NewState(), logger))
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil), -1)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	var body ReadinessResponse
+	err := json.NewDecoder(resp.Body).Decode(&body)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusNotReady, body.Status)
+	assert.Contains(t, body.Checks, "data")
+}
+
+func TestReadyzHandler_Ready(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	state := health.NewState()
+	assert.NoError(t, state.MarkReady())
+	app := newTestApp(NewHandler(state, logger))
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body ReadinessResponse
+	err := json.NewDecoder(resp.Body).Decode(&body)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusOK, body.Status)
+}
+
+func TestReadyzHandler_NotReadyWhenShuttingDown(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	state := health.NewState()
+	assert.NoError(t, state.MarkReady())
+	app := newTestApp(NewHandler(state, logger))
+
+	state.MarkShuttingDown()
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil), -1)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// Liveness is unaffected while draining
+	resp, _ = app.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestVersionHandler(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	handler := NewHandler(health.NewState(), logger)
+	handler.buildInfo = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{
+			GoVersion: "go1.23.1",
+			Main:      debug.Module{Path: "github.com/AntonioDaria/surfe", Version: "v1.2.0"},
+			Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "abc123"},
+				{Key: "vcs.time", Value: "2024-10-01T12:00:00Z"},
+				{Key: "vcs.modified", Value: "true"},
+			},
+		}, true
+	}
+	app := newTestApp(handler)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/version", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body VersionResponse
+	err := json.NewDecoder(resp.Body).Decode(&body)
+	assert.NoError(t, err)
+	assert.Equal(t, VersionResponse{
+		Module:    "github.com/AntonioDaria/surfe",
+		Version:   "v1.2.0",
+		GoVersion: "go1.23.1",
+		Revision:  "abc123",
+		BuildTime: "2024-10-01T12:00:00Z",
+		Modified:  true,
+	}, body)
+}
@@ -0,0 +1,26 @@
+package profile
+
+import (
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	profile_s "github.com/AntonioDaria/surfe/src/services/profile"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+type Handler struct {
+	profileService profile_s.Service
+	logger         zerolog.Logger
+}
+
+func NewHandler(profileService profile_s.Service, logger zerolog.Logger) *Handler {
+	return &Handler{
+		profileService: profileService,
+		logger:         logger,
+	}
+}
+
+// log returns the request scoped logger, falling back to the handler's logger
+func (h *Handler) log(c *fiber.Ctx) *zerolog.Logger {
+	logger := utils.Logger(c, h.logger)
+	return &logger
+}
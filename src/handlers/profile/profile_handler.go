@@ -0,0 +1,57 @@
+package profile
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ProfileResponse struct {
+	ID            int                       `json:"id"`
+	Name          string                    `json:"name"`
+	CreatedAt     string                    `json:"createdAt"`
+	TotalActions  int                       `json:"totalActions"`
+	ActionCounts  map[models.ActionType]int `json:"actionCounts"`
+	FirstActionAt *time.Time                `json:"firstActionAt"`
+	LastActionAt  *time.Time                `json:"lastActionAt"`
+	ReferralIndex int                       `json:"referralIndex"`
+	ReferredBy    *int                      `json:"referredBy"`
+	DaysActive    int                       `json:"daysActive"`
+}
+
+// GetProfileHandler handles requests for a user along with a summary of their activity
+func (h *Handler) GetProfileHandler(c *fiber.Ctx) error {
+	userID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to parse user ID")
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	profile, err := h.profileService.GetProfile(c.UserContext(), userID)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			h.log(c).Error().Err(err).Msg("User not found")
+			return utils.JsonError(c, fiber.StatusNotFound, "User not found")
+		}
+		h.log(c).Error().Err(err).Msg("Failed to retrieve profile")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to retrieve profile")
+	}
+
+	return c.JSON(ProfileResponse{
+		ID:            profile.User.ID,
+		Name:          profile.User.Name,
+		CreatedAt:     profile.User.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+		TotalActions:  profile.TotalActions,
+		ActionCounts:  profile.ActionCounts,
+		FirstActionAt: profile.FirstActionAt,
+		LastActionAt:  profile.LastActionAt,
+		ReferralIndex: profile.ReferralIndex,
+		ReferredBy:    profile.ReferredBy,
+		DaysActive:    profile.DaysActive,
+	})
+}
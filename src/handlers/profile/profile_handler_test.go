@@ -0,0 +1,94 @@
+package profile
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/user"
+	profile_s "github.com/AntonioDaria/surfe/src/services/profile"
+	profile_mock "github.com/AntonioDaria/surfe/src/services/profile/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetProfileHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	mockService := profile_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 1, 3, 18, 0, 0, 0, time.UTC)
+	mockService.EXPECT().GetProfile(gomock.Any(), 1).Return(&profile_s.Profile{
+		User:          models.User{ID: 1, Name: "Ferdinande", CreatedAt: time.Date(2020, 7, 14, 5, 48, 54, 798_000_000, time.UTC)},
+		TotalActions:  3,
+		ActionCounts:  map[models.ActionType]int{models.ActionTypeWelcome: 2, models.ActionTypeReferUser: 1},
+		FirstActionAt: &first,
+		LastActionAt:  &last,
+		ReferralIndex: 3,
+		DaysActive:    2,
+	}, nil)
+
+	app := fiber.New()
+	app.Get("/users/:id/profile", handler.GetProfileHandler)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/users/1/profile", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{
+		"id": 1,
+		"name": "Ferdinande",
+		"createdAt": "2020-07-14T05:48:54.798Z",
+		"totalActions": 3,
+		"actionCounts": {"WELCOME": 2, "REFER_USER": 1},
+		"firstActionAt": "2024-01-01T09:00:00Z",
+		"lastActionAt": "2024-01-03T18:00:00Z",
+		"referralIndex": 3,
+		"referredBy": null,
+		"daysActive": 2
+	}`, string(body))
+}
+
+func TestGetProfileHandler_Errors(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	tests := []struct {
+		name   string
+		path   string
+		err    error
+		status int
+	}{
+		{name: "invalid ID", path: "/users/abc/profile", status: http.StatusBadRequest},
+		{name: "not found", path: "/users/1/profile", err: user.ErrUserNotFound, status: http.StatusNotFound},
+		{name: "failure", path: "/users/1/profile", err: errors.New("deadline exceeded"), status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockService := profile_mock.NewMockService(ctrl)
+			handler := NewHandler(mockService, logger)
+
+			if tt.status != http.StatusBadRequest {
+				mockService.EXPECT().GetProfile(gomock.Any(), 1).Return(nil, tt.err)
+			}
+
+			app := fiber.New()
+			app.Get("/users/:id/profile", handler.GetProfileHandler)
+
+			resp, _ := app.Test(httptest.NewRequest(http.MethodGet, tt.path, nil), -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/events"
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+type Handler struct {
+	hub       *events.Hub
+	referrals *events.ReferralFeed
+	heartbeat time.Duration
+	logger    zerolog.Logger
+
+	referralSocket fiber.Handler
+}
+
+// NewHandler returns the handler of the live streams, which send a heartbeat
+// to idle clients every heartbeat
+func NewHandler(hub *events.Hub, referrals *events.ReferralFeed, heartbeat time.Duration, logger zerolog.Logger) *Handler {
+	h := &Handler{
+		hub:       hub,
+		referrals: referrals,
+		heartbeat: heartbeat,
+		logger:    logger,
+	}
+	h.referralSocket = websocket.New(h.serveReferralIndex)
+	return h
+}
+
+// log returns the request scoped logger, falling back to the handler's logger
+func (h *Handler) log(c *fiber.Ctx) *zerolog.Logger {
+	logger := utils.Logger(c, h.logger)
+	return &logger
+}
@@ -0,0 +1,190 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/events"
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+// MaxWatchedUsers is the largest number of users a connection can subscribe to
+const MaxWatchedUsers = 100
+
+// writeTimeout bounds how long a message can take to reach a client
+const writeTimeout = 10 * time.Second
+
+// Locals handed over to the WebSocket connection, which has no fiber.Ctx
+const (
+	principalLocal = "stream.principal"
+	loggerLocal    = "stream.logger"
+	doneLocal      = "stream.done"
+)
+
+// ReferralSubscription is a message sent by clients to change the users they follow
+type ReferralSubscription struct {
+	Subscribe   []int `json:"subscribe"`
+	Unsubscribe []int `json:"unsubscribe"`
+}
+
+// ReferralIndexMessage is sent to clients with the new referral index of a followed user
+type ReferralIndexMessage struct {
+	Type          string `json:"type"`
+	UserID        int    `json:"userId"`
+	ReferralIndex int    `json:"referralIndex"`
+}
+
+// ErrorMessage is sent to clients when one of their messages is rejected
+type ErrorMessage struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+const (
+	MessageTypeReferralIndex = "referralIndex"
+	MessageTypeError         = "error"
+)
+
+// ReferralIndexSocketHandler upgrades the request to a WebSocket on which clients
+// subscribe to users and receive their referral index whenever it changes
+func (h *Handler) ReferralIndexSocketHandler(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return utils.JsonError(c, fiber.StatusUpgradeRequired, "WebSocket upgrade required")
+	}
+
+	c.Locals(principalLocal, auth.PrincipalFromCtx(c))
+	c.Locals(loggerLocal, *h.log(c))
+	// fasthttp closes this channel when the server shuts down
+	c.Locals(doneLocal, c.Context().Done())
+
+	return h.referralSocket(c)
+}
+
+func (h *Handler) serveReferralIndex(conn *websocket.Conn) {
+	principal, _ := conn.Locals(principalLocal).(*auth.Principal)
+	logger, _ := conn.Locals(loggerLocal).(zerolog.Logger)
+	done, _ := conn.Locals(doneLocal).(<-chan struct{})
+
+	watch := h.referrals.Watch()
+	defer watch.Close()
+
+	// The connection supports one reader and one writer, so replies to the
+	// client's messages go through the writing loop below
+	replies := make(chan ErrorMessage)
+	readerDone := make(chan struct{})
+	writerDone := make(chan struct{})
+	defer close(writerDone)
+	go func() {
+		defer close(readerDone)
+		h.readSubscriptions(conn, watch, principal, replies, writerDone)
+	}()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+
+		select {
+		case update, ok := <-watch.Updates():
+			if !ok {
+				logger.Warn().Msg("Referral index subscriber fell behind")
+				h.closeSocket(conn, websocket.ClosePolicyViolation, "Too slow")
+				return
+			}
+			err = h.writeMessage(conn, ReferralIndexMessage{
+				Type:          MessageTypeReferralIndex,
+				UserID:        update.UserID,
+				ReferralIndex: update.ReferralIndex,
+			})
+		case reply := <-replies:
+			err = h.writeMessage(conn, reply)
+		case <-heartbeat.C:
+			// Pinging is also how a disconnected client is noticed
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+		case <-readerDone:
+			return
+		case <-done:
+			h.closeSocket(conn, websocket.CloseGoingAway, "Server shutting down")
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readSubscriptions applies the client's subscriptions to the watch until the
+// connection is closed or the writing loop stops
+func (h *Handler) readSubscriptions(conn *websocket.Conn, watch *events.ReferralWatch, principal *auth.Principal, replies chan<- ErrorMessage, writerDone <-chan struct{}) {
+	reply := func(message string) bool {
+		select {
+		case replies <- ErrorMessage{Type: MessageTypeError, Error: message}:
+			return true
+		case <-writerDone:
+			return false
+		}
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg ReferralSubscription
+		if err := json.Unmarshal(data, &msg); err != nil {
+			if !reply("Invalid message") {
+				return
+			}
+			continue
+		}
+
+		if !canFollow(principal, msg.Subscribe) {
+			if !reply("Access to other users is not allowed") {
+				return
+			}
+			continue
+		}
+
+		watch.Remove(msg.Unsubscribe...)
+		if watch.Len()+len(msg.Subscribe) > MaxWatchedUsers {
+			if !reply(fmt.Sprintf("At most %d users can be followed", MaxWatchedUsers)) {
+				return
+			}
+			continue
+		}
+		watch.Add(msg.Subscribe...)
+	}
+}
+
+// canFollow only lets token holders follow their own user
+func canFollow(principal *auth.Principal, userIDs []int) bool {
+	if principal == nil {
+		return true
+	}
+	for _, userID := range userIDs {
+		if !principal.CanAccessUser(strconv.Itoa(userID)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) writeMessage(conn *websocket.Conn, msg any) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	return conn.WriteJSON(msg)
+}
+
+func (h *Handler) closeSocket(conn *websocket.Conn, code int, text string) {
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(writeTimeout))
+}
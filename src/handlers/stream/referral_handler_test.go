@@ -0,0 +1,101 @@
+package stream
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/events"
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// newSocketServer serves the referral index socket on a real listener, as
+// connections can't be upgraded through app.Test
+func newSocketServer(t *testing.T, feed *events.ReferralFeed) string {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	app := fiber.New()
+	app.Get("/actions/referral/ws", NewHandler(events.NewHub(0), feed, time.Second, logger).ReferralIndexSocketHandler)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() { _ = app.Listener(listener) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	return "ws://" + listener.Addr().String() + "/actions/referral/ws"
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	return conn
+}
+
+func TestReferralIndexSocketHandler(t *testing.T) {
+	feed := events.NewReferralFeed()
+	feed.Update(map[int]int{1: 2})
+	conn := dial(t, newSocketServer(t, feed))
+
+	assert.NoError(t, conn.WriteJSON(ReferralSubscription{Subscribe: []int{1}}))
+
+	// The current index comes first
+	var msg ReferralIndexMessage
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, ReferralIndexMessage{Type: MessageTypeReferralIndex, UserID: 1, ReferralIndex: 2}, msg)
+
+	// Then every change
+	feed.Update(map[int]int{1: 3, 2: 1})
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, ReferralIndexMessage{Type: MessageTypeReferralIndex, UserID: 1, ReferralIndex: 3}, msg)
+}
+
+func TestReferralIndexSocketHandler_InvalidSubscriptions(t *testing.T) {
+	conn := dial(t, newSocketServer(t, events.NewReferralFeed()))
+
+	tooMany := make([]int, MaxWatchedUsers+1)
+	for i := range tooMany {
+		tooMany[i] = i
+	}
+
+	tests := []struct {
+		name    string
+		message any
+		err     string
+	}{
+		{name: "malformed", message: "subscribe", err: "Invalid message"},
+		{name: "too many users", message: ReferralSubscription{Subscribe: tooMany}, err: "At most 100 users can be followed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, conn.WriteJSON(tt.message))
+
+			var msg ErrorMessage
+			assert.NoError(t, conn.ReadJSON(&msg))
+			assert.Equal(t, ErrorMessage{Type: MessageTypeError, Error: tt.err}, msg)
+		})
+	}
+}
+
+func TestReferralIndexSocketHandler_UpgradeRequired(t *testing.T) {
+	url := newSocketServer(t, events.NewReferralFeed())
+
+	resp, err := http.Get(strings.Replace(url, "ws://", "http://", 1))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, fiber.StatusUpgradeRequired, resp.StatusCode)
+}
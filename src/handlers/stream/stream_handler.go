@@ -0,0 +1,142 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/disconnect"
+	"github.com/AntonioDaria/surfe/src/events"
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderLastEventID is sent by EventSource clients when they reconnect
+const HeaderLastEventID = "Last-Event-ID"
+
+// ActionsStreamHandler streams new actions as Server-Sent Events, optionally
+// filtered by the userId and type query parameters. Clients reconnecting with
+// Last-Event-ID first get the buffered events they missed, preceded by a reset
+// event when some of them are no longer buffered.
+func (h *Handler) ActionsStreamHandler(c *fiber.Ctx) error {
+	var filter events.Filter
+
+	userIDParam := c.Query("userId")
+	if userIDParam != "" {
+		userID, err := strconv.Atoi(userIDParam)
+		if err != nil {
+			return utils.JsonError(c, fiber.StatusBadRequest, "Invalid user ID")
+		}
+		filter.UserID = &userID
+	}
+
+	if actionType := c.Query("type"); actionType != "" {
+		filter.Type = models.ActionType(actionType)
+		if !filter.Type.IsValid() {
+			return utils.JsonError(c, fiber.StatusBadRequest, "Invalid action type")
+		}
+	}
+
+	// Token holders can only follow their own actions
+	if principal := auth.PrincipalFromCtx(c); principal != nil && !principal.CanAccessUser(userIDParam) {
+		return utils.JsonError(c, fiber.StatusForbidden, "Access to other users is not allowed")
+	}
+
+	sub := h.hub.Subscribe(c.Get(HeaderLastEventID), filter)
+	logger := *h.log(c)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	// Keep reverse proxies from buffering the stream
+	c.Set("X-Accel-Buffering", "no")
+
+	// fasthttp closes this channel when the server shuts down
+	done := c.Context().Done()
+	conn := c.Context().Conn()
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+
+		// Writes only fail once the socket buffers are full, so the connection
+		// is watched to release the subscription as soon as the client leaves
+		ctx, disconnected := context.WithCancel(context.Background())
+		defer disconnected()
+		disconnect.Watch(ctx, conn, disconnected)
+
+		heartbeat := time.NewTicker(h.heartbeat)
+		defer heartbeat.Stop()
+
+		// Send the headers straight away rather than with the first event
+		if err := comment(w, "connected"); err != nil {
+			return
+		}
+
+		if sub.Gap() {
+			if err := reset(w); err != nil {
+				return
+			}
+		}
+
+		for {
+			select {
+			case e, ok := <-sub.Events():
+				if !ok {
+					// Dropped for falling behind, the client resumes from its last event
+					logger.Warn().Msg("Stream subscriber fell behind")
+					return
+				}
+				if err := send(w, e); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				// Keeps proxies from closing the connection for being idle
+				if err := comment(w, "heartbeat"); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// send writes an event in the text/event-stream format
+func send(w *bufio.Writer, e events.Event) error {
+	data, err := json.Marshal(e.Action)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "id: %s\nevent: action\ndata: %s\n\n", e.ID, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// reset tells the client that events were missed, so it must reload the current
+// state instead of applying the events that follow to what it has. It carries
+// no ID, leaving the client's last event ID as it was.
+func reset(w *bufio.Writer) error {
+	if _, err := fmt.Fprintf(w, "event: reset\ndata: {\"reason\":\"events were missed\"}\n\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// comment writes a comment line, which clients ignore
+func comment(w *bufio.Writer, text string) error {
+	if _, err := fmt.Fprintf(w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	return w.Flush()
+}
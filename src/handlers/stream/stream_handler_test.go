@@ -0,0 +1,151 @@
+package stream
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/events"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer serves the stream on a real listener, as streamed bodies
+// can't be read through app.Test
+func newTestServer(t *testing.T, hub *events.Hub, heartbeat time.Duration) string {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	app := fiber.New()
+	app.Get("/actions/stream", NewHandler(hub, events.NewReferralFeed(), heartbeat, logger).ActionsStreamHandler)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() { _ = app.Listener(listener) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	return "http://" + listener.Addr().String()
+}
+
+// readEvent returns the fields of the next event, skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) map[string]string {
+	t.Helper()
+
+	fields := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && len(fields) > 0:
+			return fields
+		case line == "" || strings.HasPrefix(line, ":"):
+			continue
+		}
+
+		name, value, _ := strings.Cut(line, ": ")
+		fields[name] = value
+	}
+}
+
+func subscribe(t *testing.T, url string, lastEventID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set(HeaderLastEventID, lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func TestActionsStreamHandler(t *testing.T) {
+	hub := events.NewHub(10)
+	url := newTestServer(t, hub, 20*time.Millisecond)
+
+	resp, reader := subscribe(t, url+"/actions/stream?userId=1&type=REFER_USER", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get(fiber.HeaderContentType))
+
+	assert.Eventually(t, func() bool { return hub.Subscribers() == 1 }, time.Second, 5*time.Millisecond)
+	hub.Publish(
+		models.Action{ID: 1, UserID: 1, Type: models.ActionTypeWelcome},
+		models.Action{ID: 2, UserID: 2, Type: models.ActionTypeReferUser, TargetUser: 3},
+		models.Action{ID: 3, UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 4},
+	)
+
+	// Only the matching action is sent
+	event := readEvent(t, reader)
+	assert.Equal(t, "action", event["event"])
+	assert.NotEmpty(t, event["id"])
+	assert.JSONEq(t, `{"id":3,"type":"REFER_USER","userId":1,"targetUser":4,"createdAt":"0001-01-01T00:00:00Z"}`, event["data"])
+}
+
+func TestActionsStreamHandler_Resume(t *testing.T) {
+	hub := events.NewHub(10)
+	url := newTestServer(t, hub, 20*time.Millisecond)
+
+	_, reader := subscribe(t, url+"/actions/stream", "")
+	assert.Eventually(t, func() bool { return hub.Subscribers() == 1 }, time.Second, 5*time.Millisecond)
+
+	hub.Publish(models.Action{ID: 1}, models.Action{ID: 2}, models.Action{ID: 3})
+	first := readEvent(t, reader)
+
+	// Reconnecting with the last event ID replays what was missed
+	_, reader = subscribe(t, url+"/actions/stream", first["id"])
+	assert.Contains(t, readEvent(t, reader)["data"], `"id":2`)
+	assert.Contains(t, readEvent(t, reader)["data"], `"id":3`)
+}
+
+func TestActionsStreamHandler_Resume_Gap(t *testing.T) {
+	hub := events.NewHub(2)
+	url := newTestServer(t, hub, 20*time.Millisecond)
+
+	hub.Publish(models.Action{ID: 1}, models.Action{ID: 2}, models.Action{ID: 3}, models.Action{ID: 4})
+
+	// Event 2 has been dropped from the buffer, so the client is told to reload
+	_, reader := subscribe(t, url+"/actions/stream", "1")
+	event := readEvent(t, reader)
+	assert.Equal(t, "reset", event["event"])
+	assert.Empty(t, event["id"])
+
+	assert.Contains(t, readEvent(t, reader)["data"], `"id":3`)
+	assert.Contains(t, readEvent(t, reader)["data"], `"id":4`)
+}
+
+func TestActionsStreamHandler_Unsubscribes_On_Disconnect(t *testing.T) {
+	hub := events.NewHub(0)
+	// The heartbeat is too slow to be what notices the disconnect
+	url := newTestServer(t, hub, time.Minute)
+
+	resp, _ := subscribe(t, url+"/actions/stream", "")
+	assert.Eventually(t, func() bool { return hub.Subscribers() == 1 }, time.Second, 5*time.Millisecond)
+
+	resp.Body.Close()
+	assert.Eventually(t, func() bool { return hub.Subscribers() == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestActionsStreamHandler_Invalid_Filters(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	app := fiber.New()
+	app.Get("/actions/stream", NewHandler(events.NewHub(0), events.NewReferralFeed(), time.Second, logger).ActionsStreamHandler)
+
+	for _, query := range []string{"userId=abc", "type=NOPE"} {
+		resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/actions/stream?"+query, nil), -1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
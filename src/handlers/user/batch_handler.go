@@ -0,0 +1,64 @@
+package user
+
+import (
+	"strconv"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+type BatchUserResult struct {
+	Status string        `json:"status"`
+	User   *UserResponse `json:"user,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type BatchUsersResponse struct {
+	Found    int `json:"found"`
+	NotFound int `json:"notFound"`
+	// Results holds the outcome of the lookup of every requested ID, keyed by ID
+	Results map[int]BatchUserResult `json:"results"`
+}
+
+// GetUsersBatchHandler handles requests to retrieve several users at once,
+// reporting the users that don't exist rather than failing the whole batch
+func (h *Handler) GetUsersBatchHandler(c *fiber.Ctx) error {
+	ids, batchErr := utils.ParseBatchIDs(c.Body())
+	if batchErr != nil {
+		return utils.JsonError(c, batchErr.Code, batchErr.Message)
+	}
+
+	// Token holders can only read their own user
+	if principal := auth.PrincipalFromContext(c.UserContext()); principal != nil {
+		for _, id := range ids {
+			if !principal.CanAccessUser(strconv.Itoa(id)) {
+				return utils.JsonError(c, fiber.StatusForbidden, "Access to other users is not allowed")
+			}
+		}
+	}
+
+	users := h.userService.GetUsersByIDs(c.UserContext(), ids)
+
+	response := BatchUsersResponse{Results: make(map[int]BatchUserResult, len(ids))}
+	for _, id := range ids {
+		found, ok := users[id]
+		if !ok {
+			response.NotFound++
+			response.Results[id] = BatchUserResult{Status: utils.BatchStatusNotFound, Error: "User not found"}
+			continue
+		}
+
+		response.Found++
+		response.Results[id] = BatchUserResult{
+			Status: utils.BatchStatusFound,
+			User: &UserResponse{
+				ID:        found.ID,
+				Name:      found.Name,
+				CreatedAt: found.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+			},
+		}
+	}
+
+	return c.JSON(response)
+}
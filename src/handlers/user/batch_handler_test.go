@@ -0,0 +1,102 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/models"
+	user_mock "github.com/AntonioDaria/surfe/src/services/user/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUsersBatchHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	mockService := user_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	// Duplicate IDs are looked up once
+	createdAt := time.Date(2020, 6, 24, 4, 33, 53, 24_000_000, time.UTC)
+	mockService.EXPECT().GetUsersByIDs(gomock.Any(), []int{2, 7}).Return(map[int]*models.User{
+		2: {ID: 2, Name: "Amelie", CreatedAt: createdAt},
+	})
+
+	app := fiber.New()
+	app.Post("/users/batch", handler.GetUsersBatchHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/users/batch", strings.NewReader(`{"ids":[2,7,2]}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var response BatchUsersResponse
+	err := json.NewDecoder(resp.Body).Decode(&response)
+	assert.NoError(t, err)
+
+	assert.Equal(t, BatchUsersResponse{
+		Found:    1,
+		NotFound: 1,
+		Results: map[int]BatchUserResult{
+			2: {Status: "found", User: &UserResponse{ID: 2, Name: "Amelie", CreatedAt: "2020-06-24T04:33:53.024Z"}},
+			7: {Status: "not_found", Error: "User not found"},
+		},
+	}, response)
+}
+
+func TestGetUsersBatchHandler_BadRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	handler := NewHandler(user_mock.NewMockService(ctrl), logger)
+
+	app := fiber.New()
+	app.Post("/users/batch", handler.GetUsersBatchHandler)
+
+	for _, body := range []string{`{"ids":[1,`, `{"ids":["a"]}`, `{}`} {
+		req := httptest.NewRequest(http.MethodPost, "/users/batch", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, _ := app.Test(req, -1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "body %q", body)
+	}
+}
+
+func TestGetUsersBatchHandler_Other_Users(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	mockService := user_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+	mockService.EXPECT().GetUsersByIDs(gomock.Any(), []int{1}).Return(map[int]*models.User{1: {ID: 1, Name: "Ferdinande"}})
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.SetUserContext(auth.ContextWithPrincipal(context.Background(), &auth.Principal{Name: "1", Subject: "1"}))
+		return c.Next()
+	})
+	app.Post("/users/batch", handler.GetUsersBatchHandler)
+
+	// Token holders can read their own user, but not other users
+	for body, status := range map[string]int{
+		`{"ids":[1]}`:   http.StatusOK,
+		`{"ids":[1,2]}`: http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/users/batch", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, _ := app.Test(req, -1)
+		assert.Equal(t, status, resp.StatusCode, "body %s", body)
+	}
+}
@@ -1,7 +1,9 @@
 package user
 
 import (
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
 	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog"
 )
 
@@ -16,3 +18,9 @@ func NewHandler(userService user_s.Service, logger zerolog.Logger) *Handler {
 		logger:      logger,
 	}
 }
+
+// log returns the request scoped logger, falling back to the handler's logger
+func (h *Handler) log(c *fiber.Ctx) *zerolog.Logger {
+	logger := utils.Logger(c, h.logger)
+	return &logger
+}
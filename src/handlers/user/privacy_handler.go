@@ -0,0 +1,109 @@
+package user
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/user"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReferralsExport is the referrals.json file of a data export
+type ReferralsExport struct {
+	Made     []models.Action `json:"made"`
+	Received []models.Action `json:"received"`
+}
+
+// ExportUserDataHandler handles requests for everything stored about a user,
+// returned as a ZIP archive of JSON files
+func (h *Handler) ExportUserDataHandler(c *fiber.Ctx) error {
+	userID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to parse user ID")
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	data, err := h.userService.ExportUserData(c.UserContext(), userID)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			h.log(c).Error().Err(err).Msg("User not found")
+			return utils.JsonError(c, fiber.StatusNotFound, "User not found")
+		}
+		h.log(c).Error().Err(err).Msg("Failed to export user data")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to export user data")
+	}
+
+	archive, err := zipUserData(data)
+	if err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to archive user data")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to export user data")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="user-%d.zip"`, userID))
+	return c.Send(archive)
+}
+
+// zipUserData archives the user, their actions and their referrals as one JSON file each
+func zipUserData(data *user_s.UserData) ([]byte, error) {
+	files := []struct {
+		name    string
+		content any
+	}{
+		{name: "user.json", content: data.User},
+		{name: "actions.json", content: data.Actions},
+		{name: "referrals.json", content: ReferralsExport{Made: data.ReferralsMade, Received: data.ReferralsReceived}},
+	}
+
+	exportedAt := time.Now()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		content, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: exportedAt})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// EraseUserHandler erases the personal data of a user, keeping their actions
+// in the analytics without them leading back to the user
+func (h *Handler) EraseUserHandler(c *fiber.Ctx) error {
+	userID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to parse user ID")
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	if err := h.userService.EraseUser(c.UserContext(), userID); err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			h.log(c).Error().Err(err).Msg("User not found")
+			return utils.JsonError(c, fiber.StatusNotFound, "User not found")
+		}
+		h.log(c).Error().Err(err).Msg("Failed to erase user")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to erase user")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,128 @@
+package user
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/user"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	user_mock "github.com/AntonioDaria/surfe/src/services/user/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportUserDataHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	mockService := user_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	referral := models.Action{ID: 2, UserID: 3, Type: models.ActionTypeReferUser, TargetUser: 1}
+	mockService.EXPECT().ExportUserData(gomock.Any(), 1).Return(&user_s.UserData{
+		User:              models.User{ID: 1, Name: "Ferdinande"},
+		Actions:           []models.Action{{ID: 1, UserID: 1, Type: models.ActionTypeWelcome}},
+		ReferralsMade:     []models.Action{},
+		ReferralsReceived: []models.Action{referral},
+	}, nil)
+
+	app := fiber.New()
+	app.Get("/users/:id/data-export", handler.ExportUserDataHandler)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/users/1/data-export", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="user-1.zip"`, resp.Header.Get("Content-Disposition"))
+
+	body, _ := io.ReadAll(resp.Body)
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	assert.NoError(t, err)
+
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		r, err := file.Open()
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(r)
+		files[file.Name] = string(content)
+	}
+	assert.JSONEq(t, `{"id":1,"name":"Ferdinande","createdAt":"0001-01-01T00:00:00Z"}`, files["user.json"])
+	assert.JSONEq(t, `[{"id":1,"type":"WELCOME","userId":1,"createdAt":"0001-01-01T00:00:00Z"}]`, files["actions.json"])
+	assert.JSONEq(t, `{"made":[],"received":[{"id":2,"type":"REFER_USER","userId":3,"targetUser":1,"createdAt":"0001-01-01T00:00:00Z"}]}`, files["referrals.json"])
+}
+
+func TestExportUserDataHandler_Errors(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	tests := []struct {
+		name   string
+		path   string
+		err    error
+		status int
+	}{
+		{name: "invalid ID", path: "/users/abc/data-export", status: http.StatusBadRequest},
+		{name: "not found", path: "/users/1/data-export", err: user.ErrUserNotFound, status: http.StatusNotFound},
+		{name: "storage failure", path: "/users/1/data-export", err: errors.New("disk full"), status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockService := user_mock.NewMockService(ctrl)
+			handler := NewHandler(mockService, logger)
+
+			if tt.status != http.StatusBadRequest {
+				mockService.EXPECT().ExportUserData(gomock.Any(), 1).Return(nil, tt.err)
+			}
+
+			app := fiber.New()
+			app.Get("/users/:id/data-export", handler.ExportUserDataHandler)
+
+			resp, _ := app.Test(httptest.NewRequest(http.MethodGet, tt.path, nil), -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestEraseUserHandler(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	tests := []struct {
+		name   string
+		path   string
+		err    error
+		status int
+	}{
+		{name: "erased", path: "/users/1/erase", status: http.StatusNoContent},
+		{name: "invalid ID", path: "/users/abc/erase", status: http.StatusBadRequest},
+		{name: "not found", path: "/users/1/erase", err: user.ErrUserNotFound, status: http.StatusNotFound},
+		{name: "storage failure", path: "/users/1/erase", err: errors.New("disk full"), status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockService := user_mock.NewMockService(ctrl)
+			handler := NewHandler(mockService, logger)
+
+			if tt.status != http.StatusBadRequest {
+				mockService.EXPECT().EraseUser(gomock.Any(), 1).Return(tt.err)
+			}
+
+			app := fiber.New()
+			app.Post("/users/:id/erase", handler.EraseUserHandler)
+
+			resp, _ := app.Test(httptest.NewRequest(http.MethodPost, tt.path, nil), -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/repository/user"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+type SearchUsersResponse struct {
+	Users []UserResponse `json:"users"`
+	// Total is how many users match the search, on every page
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// SearchUsersHandler handles searches for users by name, with the q query
+// parameter, and by signup date, with createdFrom and createdTo. Results are
+// ordered by sort and paginated with offset and limit.
+func (h *Handler) SearchUsersHandler(c *fiber.Ctx) error {
+	query := user.SearchQuery{
+		Name: c.Query("q"),
+		Sort: user.SearchSort(c.Query("sort")),
+	}
+
+	var err error
+	if query.CreatedFrom, err = parseDateParam(c.Query("createdFrom"), false); err != nil {
+		return utils.JsonError(c, fiber.StatusBadRequest, fmt.Sprintf("Invalid createdFrom: %s", err))
+	}
+	if query.CreatedTo, err = parseDateParam(c.Query("createdTo"), true); err != nil {
+		return utils.JsonError(c, fiber.StatusBadRequest, fmt.Sprintf("Invalid createdTo: %s", err))
+	}
+	if query.Offset, err = parseIntParam(c.Query("offset")); err != nil {
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid offset")
+	}
+	if query.Limit, err = parseIntParam(c.Query("limit")); err != nil {
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid limit")
+	}
+	if query.Limit == 0 {
+		query.Limit = user_s.DefaultSearchLimit
+	}
+
+	result, err := h.userService.SearchUsers(c.UserContext(), query)
+	if err != nil {
+		if errors.Is(err, user_s.ErrUnknownSort) || errors.Is(err, user_s.ErrInvalidDateRange) || errors.Is(err, user_s.ErrInvalidPage) {
+			return utils.JsonError(c, fiber.StatusBadRequest, err.Error())
+		}
+		h.log(c).Error().Err(err).Msg("Failed to search users")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to search users")
+	}
+
+	response := SearchUsersResponse{
+		Users:  make([]UserResponse, len(result.Users)),
+		Total:  result.Total,
+		Offset: query.Offset,
+		Limit:  query.Limit,
+	}
+	for i, found := range result.Users {
+		response.Users[i] = UserResponse{
+			ID:        found.ID,
+			Name:      found.Name,
+			CreatedAt: found.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+		}
+	}
+	return c.JSON(response)
+}
+
+// parseDateParam parses a date (2006-01-02) or RFC 3339 time, or returns the
+// zero time when the parameter is empty. As upper bounds are inclusive but
+// searched as exclusive, end returns the first instant after the date or time.
+func parseDateParam(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		if end {
+			return t.AddDate(0, 0, 1), nil
+		}
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("expected a date or an RFC 3339 time")
+	}
+	if end {
+		return t.Add(time.Nanosecond), nil
+	}
+	return t, nil
+}
+
+// parseIntParam parses an integer query parameter, or returns zero when it is empty
+func parseIntParam(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
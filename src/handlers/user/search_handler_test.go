@@ -0,0 +1,90 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/user"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	user_mock "github.com/AntonioDaria/surfe/src/services/user/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchUsersHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+	mockService := user_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	// Dates cover the whole day, so the upper bound is the start of the next one
+	createdAt := time.Date(2020, 6, 24, 4, 33, 53, 24_000_000, time.UTC)
+	mockService.EXPECT().SearchUsers(gomock.Any(), user.SearchQuery{
+		Name:        "am",
+		CreatedFrom: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		CreatedTo:   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		Sort:        user.SortName,
+		Offset:      5,
+		Limit:       user_s.DefaultSearchLimit,
+	}).Return(user.SearchResult{Users: []models.User{{ID: 2, Name: "Amelie", CreatedAt: createdAt}}, Total: 6}, nil)
+
+	app := fiber.New()
+	app.Get("/users", handler.SearchUsersHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/users?q=am&createdFrom=2020-01-01&createdTo=2020-12-31&sort=name&offset=5", nil)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body SearchUsersResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, SearchUsersResponse{
+		Users:  []UserResponse{{ID: 2, Name: "Amelie", CreatedAt: "2020-06-24T04:33:53.024Z"}},
+		Total:  6,
+		Offset: 5,
+		Limit:  user_s.DefaultSearchLimit,
+	}, body)
+}
+
+func TestSearchUsersHandler_BadRequest(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	tests := []struct {
+		name string
+		path string
+		err  error
+	}{
+		{name: "invalid date", path: "/users?createdFrom=yesterday"},
+		{name: "invalid limit", path: "/users?limit=ten"},
+		{name: "invalid offset", path: "/users?offset=-"},
+		{name: "unknown sort", path: "/users?sort=age", err: user_s.ErrUnknownSort},
+		{name: "empty date range", path: "/users?createdFrom=2021-01-01&createdTo=2020-01-01", err: user_s.ErrInvalidDateRange},
+		{name: "limit too high", path: "/users?limit=1000", err: user_s.ErrInvalidPage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockService := user_mock.NewMockService(ctrl)
+			handler := NewHandler(mockService, logger)
+
+			if tt.err != nil {
+				mockService.EXPECT().SearchUsers(gomock.Any(), gomock.Any()).Return(user.SearchResult{}, tt.err)
+			}
+
+			app := fiber.New()
+			app.Get("/users", handler.SearchUsersHandler)
+
+			resp, _ := app.Test(httptest.NewRequest(http.MethodGet, tt.path, nil), -1)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
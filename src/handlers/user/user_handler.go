@@ -21,18 +21,18 @@ func (h *Handler) GetUserByIDHandler(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	userID, err := strconv.Atoi(idParam)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to parse user ID")
+		h.log(c).Error().Err(err).Msg("Failed to parse user ID")
 		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid user ID")
 	}
 
 	// Retrieve the user using the service layer
-	found_user, err := h.userService.GetUserByID(userID)
+	found_user, err := h.userService.GetUserByID(c.UserContext(), userID)
 	if err != nil {
 		if errors.Is(err, user.ErrUserNotFound) {
-			h.logger.Error().Err(err).Msg("User not found")
+			h.log(c).Error().Err(err).Msg("User not found")
 			return utils.JsonError(c, fiber.StatusNotFound, "User not found")
 		}
-		h.logger.Error().Err(err).Msg("Failed to retrieve user")
+		h.log(c).Error().Err(err).Msg("Failed to retrieve user")
 		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to retrieve user")
 	}
 
@@ -43,3 +43,23 @@ func (h *Handler) GetUserByIDHandler(c *fiber.Ctx) error {
 		CreatedAt: found_user.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
 	})
 }
+
+// DeleteUserHandler soft deletes a user along with their actions, which hides them from every read
+func (h *Handler) DeleteUserHandler(c *fiber.Ctx) error {
+	userID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to parse user ID")
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	if err := h.userService.DeleteUser(c.UserContext(), userID); err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			h.log(c).Error().Err(err).Msg("User not found")
+			return utils.JsonError(c, fiber.StatusNotFound, "User not found")
+		}
+		h.log(c).Error().Err(err).Msg("Failed to delete user")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to delete user")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
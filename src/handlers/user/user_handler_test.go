@@ -37,7 +37,7 @@ func TestGetUserByIDHandler_Success(t *testing.T) {
 		Name:      "John Doe",
 		CreatedAt: time.Now(),
 	}
-	mockService.EXPECT().GetUserByID(1).Return(mockUser, nil)
+	mockService.EXPECT().GetUserByID(gomock.Any(), 1).Return(mockUser, nil)
 
 	// Create a new Fiber app and test request
 	app := fiber.New()
@@ -59,7 +59,7 @@ func TestGetUserByIDHandler_NotFound(t *testing.T) {
 	handler := NewHandler(mockService, logger)
 
 	// Simulate user not found error
-	mockService.EXPECT().GetUserByID(2).Return(nil, user.ErrUserNotFound)
+	mockService.EXPECT().GetUserByID(gomock.Any(), 2).Return(nil, user.ErrUserNotFound)
 
 	app := fiber.New()
 	app.Get("/users/:id", handler.GetUserByIDHandler)
@@ -98,7 +98,7 @@ func TestGetUserByIDHandler_InternalServerError(t *testing.T) {
 	handler := NewHandler(mockService, logger)
 
 	// Simulate internal server error
-	mockService.EXPECT().GetUserByID(3).Return(nil, errors.New("internal server error"))
+	mockService.EXPECT().GetUserByID(gomock.Any(), 3).Return(nil, errors.New("internal server error"))
 
 	app := fiber.New()
 	app.Get("/users/:id", handler.GetUserByIDHandler)
@@ -119,7 +119,7 @@ func TestGetUserByIDIntegration(t *testing.T) {
 	}
 
 	// Set up service and handler
-	userService := user_s.NewUserService(userRepo)
+	userService := user_s.NewUserService(userRepo, nil)
 	userHandler := NewHandler(userService, logger)
 
 	// Create a new Fiber app and register the route
@@ -153,3 +153,37 @@ func TestGetUserByIDIntegration(t *testing.T) {
 	resp, _ = app.Test(req, -1)
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
+
+func TestDeleteUserHandler(t *testing.T) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	tests := []struct {
+		name   string
+		path   string
+		err    error
+		status int
+	}{
+		{name: "deleted", path: "/users/1", status: http.StatusNoContent},
+		{name: "invalid ID", path: "/users/abc", status: http.StatusBadRequest},
+		{name: "not found", path: "/users/1", err: user.ErrUserNotFound, status: http.StatusNotFound},
+		{name: "storage failure", path: "/users/1", err: errors.New("disk full"), status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockService := user_mock.NewMockService(ctrl)
+			handler := NewHandler(mockService, logger)
+
+			if tt.status != http.StatusBadRequest {
+				mockService.EXPECT().DeleteUser(gomock.Any(), 1).Return(tt.err)
+			}
+
+			app := fiber.New()
+			app.Delete("/users/:id", handler.DeleteUserHandler)
+
+			resp, _ := app.Test(httptest.NewRequest(http.MethodDelete, tt.path, nil), -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxBatchIDs is the largest number of IDs accepted in a single batch lookup
+const MaxBatchIDs = 1000
+
+// Statuses of the per-ID results of a batch lookup
+const (
+	BatchStatusFound    = "found"
+	BatchStatusNotFound = "not_found"
+)
+
+// BatchRequest is the body of a batch lookup
+type BatchRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// ParseBatchIDs parses the body of a batch lookup and returns its IDs without
+// duplicates, in the order they were first listed. The error holds the status
+// and message to answer with when the body is invalid or lists too many IDs.
+func ParseBatchIDs(body []byte) ([]int, *fiber.Error) {
+	var request BatchRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+	if len(request.IDs) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "No IDs provided")
+	}
+	if len(request.IDs) > MaxBatchIDs {
+		return nil, fiber.NewError(fiber.StatusRequestEntityTooLarge, "Too many IDs in a single request")
+	}
+
+	seen := make(map[int]bool, len(request.IDs))
+	ids := make([]int, 0, len(request.IDs))
+	for _, id := range request.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"github.com/AntonioDaria/surfe/src/requestid"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+const (
+	loggerKey    = "utils.logger"
+	requestIDKey = "utils.requestID"
+)
+
+// SetRequestLogger stores the request scoped logger and request ID on the context
+func SetRequestLogger(c *fiber.Ctx, requestID string, logger zerolog.Logger) {
+	c.Locals(requestIDKey, requestID)
+	c.Locals(loggerKey, logger)
+	c.SetUserContext(logger.WithContext(requestid.NewContext(c.UserContext(), requestID)))
+}
+
+// RequestID returns the correlation ID of the request, if one was assigned
+func RequestID(c *fiber.Ctx) string {
+	requestID, _ := c.Locals(requestIDKey).(string)
+	return requestID
+}
+
+// Logger returns the request scoped logger, or fallback when there is none, with
+// the matched route and user ID parameter added once the request has been routed
+func Logger(c *fiber.Ctx, fallback zerolog.Logger) zerolog.Logger {
+	logger, ok := c.Locals(loggerKey).(zerolog.Logger)
+	if !ok {
+		logger = fallback
+	}
+
+	fields := logger.With()
+	if route, ok := MatchedRoute(c); ok {
+		fields = fields.Str("route", route)
+	}
+	if userID := c.Params("id"); userID != "" {
+		fields = fields.Str("user_id", userID)
+	}
+
+	return fields.Logger()
+}
+
+// MatchedRoute returns the template of the route serving the request. It reports
+// false when no route matched and only the global middlewares, which are
+// registered on the root path, have run.
+func MatchedRoute(c *fiber.Ctx) (string, bool) {
+	route := c.Route()
+	if route == nil || (route.Path == "/" && c.Path() != "/") {
+		return "", false
+	}
+	return route.Path, true
+}
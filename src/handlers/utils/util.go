@@ -1,7 +1,37 @@
 package utils
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrorResponse is the standard error envelope returned by every endpoint
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
 
 func JsonError(c *fiber.Ctx, statusCode int, message string) error {
-	return c.SendStatus(statusCode)
+	return c.Status(statusCode).JSON(ErrorResponse{Error: message})
+}
+
+// ResponseStatus returns the status code of the response, taking into account
+// an error returned by the handlers that the app's error handler hasn't written yet
+func ResponseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// IsContextError reports whether err was caused by the request's context ending,
+// because its deadline passed or because the server is shutting down
+func IsContextError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
 }
@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	webhook_s "github.com/AntonioDaria/surfe/src/services/webhook"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+type Handler struct {
+	webhookService webhook_s.Service
+	logger         zerolog.Logger
+}
+
+func NewHandler(webhookService webhook_s.Service, logger zerolog.Logger) *Handler {
+	return &Handler{
+		webhookService: webhookService,
+		logger:         logger,
+	}
+}
+
+// log returns the request scoped logger, falling back to the handler's logger
+func (h *Handler) log(c *fiber.Ctx) *zerolog.Logger {
+	logger := utils.Logger(c, h.logger)
+	return &logger
+}
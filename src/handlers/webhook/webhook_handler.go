@@ -0,0 +1,85 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/webhook"
+	webhook_s "github.com/AntonioDaria/surfe/src/services/webhook"
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateWebhookRequest struct {
+	URL    string              `json:"url"`
+	Events []models.ActionType `json:"events"`
+}
+
+// WebhookResponse describes a webhook. The secret is only returned when it is created.
+type WebhookResponse struct {
+	ID        int                 `json:"id"`
+	URL       string              `json:"url"`
+	Events    []models.ActionType `json:"events"`
+	Secret    string              `json:"secret,omitempty"`
+	CreatedAt time.Time           `json:"createdAt"`
+}
+
+type DeliveriesResponse struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+// CreateWebhookHandler registers an endpoint notified of the actions of the requested types
+func (h *Handler) CreateWebhookHandler(c *fiber.Ctx) error {
+	var req CreateWebhookRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to parse webhook body")
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	created, err := h.webhookService.CreateWebhook(c.UserContext(), req.URL, req.Events)
+	if err != nil {
+		if errors.Is(err, webhook_s.ErrInvalidURL) || errors.Is(err, webhook_s.ErrUnknownEvent) {
+			return utils.JsonError(c, fiber.StatusUnprocessableEntity, err.Error())
+		}
+
+		h.log(c).Error().Err(err).Msg("Failed to create webhook")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to create webhook")
+	}
+
+	events := created.Events
+	if events == nil {
+		events = []models.ActionType{}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(WebhookResponse{
+		ID:        created.ID,
+		URL:       created.URL,
+		Events:    events,
+		Secret:    created.Secret,
+		CreatedAt: created.CreatedAt,
+	})
+}
+
+// GetDeliveriesHandler returns the delivery log of a webhook, most recent first
+func (h *Handler) GetDeliveriesHandler(c *fiber.Ctx) error {
+	webhookID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		h.log(c).Error().Err(err).Msg("Failed to parse webhook ID")
+		return utils.JsonError(c, fiber.StatusBadRequest, "Invalid webhook ID")
+	}
+
+	deliveries, err := h.webhookService.GetDeliveries(c.UserContext(), webhookID)
+	if err != nil {
+		if errors.Is(err, webhook.ErrWebhookNotFound) {
+			return utils.JsonError(c, fiber.StatusNotFound, "Webhook not found")
+		}
+
+		h.log(c).Error().Err(err).Msg("Failed to retrieve webhook deliveries")
+		return utils.JsonError(c, fiber.StatusInternalServerError, "Failed to retrieve webhook deliveries")
+	}
+
+	return c.JSON(DeliveriesResponse{Deliveries: deliveries})
+}
@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/webhook"
+	webhook_s "github.com/AntonioDaria/surfe/src/services/webhook"
+	webhook_mock "github.com/AntonioDaria/surfe/src/services/webhook/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newApp(t *testing.T) (*fiber.App, *webhook_mock.MockService) {
+	logger := zerolog.New(os.Stderr).Level(zerolog.DebugLevel).With().Timestamp().Logger()
+
+	ctrl := gomock.NewController(t)
+	mockService := webhook_mock.NewMockService(ctrl)
+	handler := NewHandler(mockService, logger)
+
+	app := fiber.New()
+	app.Post("/webhooks", handler.CreateWebhookHandler)
+	app.Get("/webhooks/:id/deliveries", handler.GetDeliveriesHandler)
+
+	return app, mockService
+}
+
+func TestCreateWebhookHandler(t *testing.T) {
+	app, mockService := newApp(t)
+
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockService.EXPECT().CreateWebhook(gomock.Any(), "https://example.com/hook", []models.ActionType{models.ActionTypeReferUser}).
+		Return(&models.Webhook{
+			ID:        1,
+			URL:       "https://example.com/hook",
+			Events:    []models.ActionType{models.ActionTypeReferUser},
+			Secret:    "whsec_abc",
+			CreatedAt: createdAt,
+		}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks",
+		strings.NewReader(`{"url":"https://example.com/hook","events":["REFER_USER"]}`))
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var body WebhookResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, WebhookResponse{
+		ID:        1,
+		URL:       "https://example.com/hook",
+		Events:    []models.ActionType{models.ActionTypeReferUser},
+		Secret:    "whsec_abc",
+		CreatedAt: createdAt,
+	}, body)
+}
+
+func TestCreateWebhookHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		err    error
+		status int
+	}{
+		{name: "malformed body", body: `{"url":`, status: http.StatusBadRequest},
+		{name: "invalid URL", body: `{"url":"ftp://example.com"}`, err: webhook_s.ErrInvalidURL, status: http.StatusUnprocessableEntity},
+		{name: "unknown event", body: `{"url":"http://example.com","events":["DANCE"]}`, err: webhook_s.ErrUnknownEvent, status: http.StatusUnprocessableEntity},
+		{name: "storage failure", body: `{"url":"http://example.com"}`, err: errors.New("disk full"), status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mockService := newApp(t)
+			if tt.err != nil {
+				mockService.EXPECT().CreateWebhook(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, tt.err)
+			}
+
+			resp, _ := app.Test(httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(tt.body)), -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestGetDeliveriesHandler(t *testing.T) {
+	app, mockService := newApp(t)
+
+	mockService.EXPECT().GetDeliveries(gomock.Any(), 1).Return([]models.WebhookDelivery{
+		{ID: 2, WebhookID: 1, Status: models.DeliveryStatusPending, Attempts: 1, Error: "unexpected status 500"},
+		{ID: 1, WebhookID: 1, Status: models.DeliveryStatusSucceeded, Attempts: 1, StatusCode: 200},
+	}, nil)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/webhooks/1/deliveries", nil), -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body DeliveriesResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Len(t, body.Deliveries, 2)
+	assert.Equal(t, 2, body.Deliveries[0].ID)
+}
+
+func TestGetDeliveriesHandler_Not_Found(t *testing.T) {
+	app, mockService := newApp(t)
+
+	mockService.EXPECT().GetDeliveries(gomock.Any(), 7).Return(nil, webhook.ErrWebhookNotFound)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/webhooks/7/deliveries", nil), -1)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
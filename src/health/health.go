@@ -0,0 +1,94 @@
+package health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Check reports whether a dependency of the service is usable
+type Check struct {
+	Name  string
+	Check func() error
+}
+
+// State tracks whether the service can take traffic. It starts not ready,
+// becomes ready once the data has been loaded and validated, and stops being
+// ready for good as soon as shutdown begins.
+type State struct {
+	ready        atomic.Bool
+	shuttingDown atomic.Bool
+
+	mu     sync.RWMutex
+	checks []Check
+}
+
+func NewState() *State {
+	return &State{}
+}
+
+// AddCheck registers a check that must pass for the service to be ready
+func (s *State) AddCheck(name string, check func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks = append(s.checks, Check{Name: name, Check: check})
+}
+
+// MarkReady runs the checks and records that the data has been loaded and
+// validated. It leaves the service not ready if any check fails.
+func (s *State) MarkReady() error {
+	failures := s.runChecks()
+	if len(failures) > 0 {
+		names := make([]string, 0, len(failures))
+		for name, msg := range failures {
+			names = append(names, name+": "+msg)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("readiness checks failed: %s", strings.Join(names, ", "))
+	}
+
+	s.ready.Store(true)
+	return nil
+}
+
+// MarkShuttingDown makes the service report not ready so load balancers stop routing to it
+func (s *State) MarkShuttingDown() {
+	s.shuttingDown.Store(true)
+}
+
+// ShuttingDown reports whether shutdown has begun
+func (s *State) ShuttingDown() bool {
+	return s.shuttingDown.Load()
+}
+
+// Readiness returns whether the service is ready and the failures preventing it, by name
+func (s *State) Readiness() (bool, map[string]string) {
+	failures := s.runChecks()
+
+	if s.shuttingDown.Load() {
+		failures["shutdown"] = "shutting down"
+	}
+	if !s.ready.Load() {
+		failures["data"] = "data not loaded"
+	}
+
+	return len(failures) == 0, failures
+}
+
+func (s *State) runChecks() map[string]string {
+	failures := make(map[string]string)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, check := range s.checks {
+		if err := check.Check(); err != nil {
+			failures[check.Name] = err.Error()
+		}
+	}
+
+	return failures
+}
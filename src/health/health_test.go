@@ -0,0 +1,46 @@
+package health
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState_NotReadyUntilMarked(t *testing.T) {
+	state := NewState()
+
+	ready, failures := state.Readiness()
+	assert.False(t, ready)
+	assert.Contains(t, failures, "data")
+
+	assert.NoError(t, state.MarkReady())
+
+	ready, failures = state.Readiness()
+	assert.True(t, ready)
+	assert.Empty(t, failures)
+}
+
+func TestState_MarkReadyFailsOnCheck(t *testing.T) {
+	state := NewState()
+	state.AddCheck("users", func() error { return errors.New("no users loaded") })
+
+	err := state.MarkReady()
+	assert.EqualError(t, err, "readiness checks failed: users: no users loaded")
+
+	ready, failures := state.Readiness()
+	assert.False(t, ready)
+	assert.Equal(t, "no users loaded", failures["users"])
+}
+
+func TestState_ShuttingDown(t *testing.T) {
+	state := NewState()
+	assert.NoError(t, state.MarkReady())
+
+	state.MarkShuttingDown()
+
+	assert.True(t, state.ShuttingDown())
+	ready, failures := state.Readiness()
+	assert.False(t, ready)
+	assert.Equal(t, "shutting down", failures["shutdown"])
+}
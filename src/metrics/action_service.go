@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+)
+
+// actionService times the analytics computations of the wrapped service
+type actionService struct {
+	action_s.Service
+	metrics *Metrics
+}
+
+// InstrumentActionService wraps an action service so the time spent computing
+// the referral index and next action probabilities is recorded
+func (m *Metrics) InstrumentActionService(service action_s.Service) action_s.Service {
+	return &actionService{Service: service, metrics: m}
+}
+
+// Abandoned computations are not recorded, so timeouts don't skew the histogram
+
+func (s *actionService) GetNextActionProbabilities(ctx context.Context, actionType models.ActionType) (map[models.ActionType]float64, error) {
+	start := time.Now()
+	probabilities, err := s.Service.GetNextActionProbabilities(ctx, actionType)
+	if err == nil {
+		s.metrics.ObserveComputation("next_action_probabilities", start)
+	}
+	return probabilities, err
+}
+
+func (s *actionService) GetReferralIndex(ctx context.Context) (map[int]int, error) {
+	start := time.Now()
+	referralIndex, err := s.Service.GetReferralIndex(ctx)
+	if err == nil {
+		s.metrics.ObserveComputation("referral_index", start)
+	}
+	return referralIndex, err
+}
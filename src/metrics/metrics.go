@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "surfe"
+
+// unmatchedRoute labels requests that didn't match any route, so scanners
+// hitting random paths can't blow up the label cardinality
+const unmatchedRoute = "unmatched"
+
+// Metrics holds the service's Prometheus collectors in their own registry
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+	computeDuration *prometheus.HistogramVec
+	dataLoad        *prometheus.GaugeVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route template, method and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by route template and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Number of HTTP requests currently being served.",
+		}),
+		computeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "computation_duration_seconds",
+			Help:      "Time spent computing analytics by operation.",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"operation"}),
+		dataLoad: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "data_load_duration_seconds",
+			Help:      "Time it took to load each dataset at startup.",
+		}, []string{"dataset"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.inFlight,
+		m.computeDuration,
+		m.dataLoad,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// RegisterRepositorySize exposes the number of records held by a repository,
+// read through size every time the metrics are scraped
+func (m *Metrics) RegisterRepositorySize(repository string, size func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "repository_records",
+		Help:        "Number of records held by each repository.",
+		ConstLabels: prometheus.Labels{"repository": repository},
+	}, func() float64 {
+		return float64(size())
+	}))
+}
+
+// ObserveDataLoad records how long loading a dataset took
+func (m *Metrics) ObserveDataLoad(dataset string, d time.Duration) {
+	m.dataLoad.WithLabelValues(dataset).Set(d.Seconds())
+}
+
+// ObserveComputation records the time spent in an analytics computation
+func (m *Metrics) ObserveComputation(operation string, start time.Time) {
+	m.computeDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Middleware records the count, latency and in-flight requests per route template
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		err := c.Next()
+
+		status := utils.ResponseStatus(c, err)
+
+		route, ok := utils.MatchedRoute(c)
+		if !ok {
+			route = unmatchedRoute
+		}
+		method := c.Method()
+
+		m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		m.requestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	action_mock "github.com/AntonioDaria/surfe/src/services/action/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// scrape returns the metrics exposed by the app
+func scrape(t *testing.T, app *fiber.App) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+
+	app := fiber.New()
+	app.Use(m.Middleware())
+	app.Get("/metrics", m.Handler())
+	app.Get("/users/:id/actions/count", func(c *fiber.Ctx) error {
+		return c.SendString("1")
+	})
+
+	for _, path := range []string{"/users/1/actions/count", "/users/2/actions/count", "/nope"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		_, _ = app.Test(req, -1)
+	}
+
+	body := scrape(t, app)
+
+	// Requests are labelled with the route template, not the raw path
+	assert.Contains(t, body, `surfe_http_requests_total{method="GET",route="/users/:id/actions/count",status="200"} 2`)
+	assert.Contains(t, body, `surfe_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, body, `surfe_http_request_duration_seconds_count{method="GET",route="/users/:id/actions/count"} 2`)
+	assert.NotContains(t, body, `route="/users/1/actions/count"`)
+
+	// The scrape itself is in flight while the metrics are gathered
+	assert.Contains(t, body, `surfe_http_requests_in_flight 1`)
+}
+
+func TestRepositorySizeAndDataLoad(t *testing.T) {
+	m := New()
+
+	size := 10
+	m.RegisterRepositorySize("actions", func() int { return size })
+	m.ObserveDataLoad("actions", 1500*time.Millisecond)
+
+	app := fiber.New()
+	app.Get("/metrics", m.Handler())
+
+	assert.Contains(t, scrape(t, app), `surfe_repository_records{repository="actions"} 10`)
+
+	// Sizes are read on every scrape
+	size = 12
+	body := scrape(t, app)
+	assert.Contains(t, body, `surfe_repository_records{repository="actions"} 12`)
+	assert.Contains(t, body, `surfe_data_load_duration_seconds{dataset="actions"} 1.5`)
+}
+
+func TestInstrumentActionService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := New()
+
+	mockService := action_mock.NewMockService(ctrl)
+	mockService.EXPECT().GetReferralIndex(gomock.Any()).Return(map[int]int{1: 2}, nil)
+	mockService.EXPECT().GetNextActionProbabilities(gomock.Any(), models.ActionTypeAddContact).Return(map[models.ActionType]float64{}, nil)
+	mockService.EXPECT().GetNextActionProbabilities(gomock.Any(), models.ActionTypeWelcome).Return(nil, context.DeadlineExceeded)
+	mockService.EXPECT().GetActionCountByUserID(gomock.Any(), 1).Return(3, nil)
+
+	service := m.InstrumentActionService(mockService)
+
+	referralIndex, err := service.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 2}, referralIndex)
+	_, err = service.GetNextActionProbabilities(context.Background(), models.ActionTypeAddContact)
+	assert.NoError(t, err)
+
+	// Abandoned computations are not recorded
+	_, err = service.GetNextActionProbabilities(context.Background(), models.ActionTypeWelcome)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Other methods pass straight through
+	count, err := service.GetActionCountByUserID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	app := fiber.New()
+	app.Get("/metrics", m.Handler())
+	body := scrape(t, app)
+
+	assert.Contains(t, body, `surfe_computation_duration_seconds_count{operation="referral_index"} 1`)
+	assert.Contains(t, body, `surfe_computation_duration_seconds_count{operation="next_action_probabilities"} 1`)
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+const HeaderAPIKey = "X-API-Key"
+
+const (
+	principalKey = "auth.principal"
+	claimsKey    = "auth.claims"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Name   string
+	Scopes []Scope
+	// Subject is the token subject for callers authenticated with a JWT
+	Subject string
+}
+
+// HasScope reports whether the principal was granted scope, either directly or through admin
+func (p *Principal) HasScope(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ID identifies the principal, keeping token subjects and API key names apart
+// as they may collide
+func (p *Principal) ID() string {
+	if p.Subject != "" {
+		return "token:" + p.Subject
+	}
+	return "key:" + p.Name
+}
+
+// CanAccessUser reports whether the principal may read the data of the given
+// user. Token holders are limited to their own user, unless they are admins.
+func (p *Principal) CanAccessUser(userID string) bool {
+	return p.CanAccessAllUsers() || p.Subject == userID
+}
+
+// CanAccessAllUsers reports whether the principal may read the data of any
+// user, which token holders can't unless they are admins
+func (p *Principal) CanAccessAllUsers() bool {
+	return p.Subject == "" || p.HasScope(ScopeAdmin)
+}
+
+// principalContextKey stores the principal in the request's user context,
+// for code below the handlers that has no fiber.Ctx
+type principalContextKey struct{}
+
+// PrincipalFromContext returns the principal stored in a request's user context, if any
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return principal
+}
+
+// ContextWithPrincipal returns a copy of ctx carrying the principal
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromCtx returns the principal authenticated for the request, if any
+func PrincipalFromCtx(c *fiber.Ctx) *Principal {
+	principal, _ := c.Locals(principalKey).(*Principal)
+	return principal
+}
+
+// ClaimsFromCtx returns the JWT claims of the request, if it was authenticated with a bearer token
+func ClaimsFromCtx(c *fiber.Ctx) *Claims {
+	claims, _ := c.Locals(claimsKey).(*Claims)
+	return claims
+}
+
+type Authenticator struct {
+	keys map[string]*Principal
+	jwt  *JWTVerifier
+}
+
+// NewAuthenticator indexes the given keys by their hash. Bearer tokens are
+// only accepted when a JWT verifier is given.
+func NewAuthenticator(keys []APIKey, jwtVerifier *JWTVerifier) *Authenticator {
+	a := &Authenticator{
+		keys: make(map[string]*Principal, len(keys)),
+		jwt:  jwtVerifier,
+	}
+	for _, key := range keys {
+		a.keys[key.Hash] = &Principal{Name: key.Name, Scopes: key.Scopes}
+	}
+	return a
+}
+
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidAPIKey      = errors.New("invalid API key")
+	ErrInvalidBearerToken = errors.New("invalid bearer token")
+)
+
+// Authenticate returns the principal identified by a bearer token, when a JWT
+// verifier is set and a token is given, or else by an API key. The claims are
+// only returned for bearer tokens.
+func (a *Authenticator) Authenticate(apiKey, bearerToken string) (*Principal, *Claims, error) {
+	if bearerToken != "" && a.jwt != nil {
+		claims, err := a.jwt.Verify(bearerToken)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidBearerToken, err)
+		}
+		return &Principal{Name: claims.Subject, Scopes: claims.Scopes(), Subject: claims.Subject}, claims, nil
+	}
+
+	if apiKey == "" {
+		return nil, nil, ErrMissingCredentials
+	}
+
+	principal, ok := a.keys[HashKey(apiKey)]
+	if !ok {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	return principal, nil, nil
+}
+
+// Require returns a middleware that rejects requests without a valid API key
+// or bearer token with 401, and requests lacking the scope with 403
+func (a *Authenticator) Require(scope Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, _ := bearerToken(c)
+
+		principal, claims, err := a.Authenticate(c.Get(HeaderAPIKey), token)
+		switch {
+		case errors.Is(err, ErrInvalidBearerToken):
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return utils.JsonError(c, fiber.StatusUnauthorized, "Invalid bearer token")
+		case errors.Is(err, ErrMissingCredentials):
+			return utils.JsonError(c, fiber.StatusUnauthorized, "Missing credentials")
+		case err != nil:
+			return utils.JsonError(c, fiber.StatusUnauthorized, "Invalid API key")
+		}
+
+		if !principal.HasScope(scope) {
+			return utils.JsonError(c, fiber.StatusForbidden, "Missing the "+string(scope)+" scope")
+		}
+
+		if claims != nil {
+			c.Locals(claimsKey, claims)
+		}
+		c.Locals(principalKey, principal)
+		c.SetUserContext(ContextWithPrincipal(c.UserContext(), principal))
+		return c.Next()
+	}
+}
+
+// RequireSelf returns a middleware that only lets token holders access the user
+// identified by the named route parameter when it matches their subject. API key
+// callers and admins are not restricted.
+func RequireSelf(param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal := PrincipalFromCtx(c)
+		if principal == nil {
+			return c.Next()
+		}
+
+		if !principal.CanAccessUser(c.Params(param)) {
+			return utils.JsonError(c, fiber.StatusForbidden, "Access to other users is not allowed")
+		}
+		return c.Next()
+	}
+}
+
+// RequireAllUsers returns a middleware that keeps token holders out of routes
+// going through every user, like searches, as they can only read their own.
+// API key callers and admins are not restricted.
+func RequireAllUsers() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if principal := PrincipalFromCtx(c); principal != nil && !principal.CanAccessAllUsers() {
+			return utils.JsonError(c, fiber.StatusForbidden, "Access to other users is not allowed")
+		}
+		return c.Next()
+	}
+}
+
+// bearerToken extracts the token of an "Authorization: Bearer" header
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	return BearerToken(c.Get(fiber.HeaderAuthorization))
+}
+
+// BearerToken extracts the token of an Authorization header value using the Bearer scheme
+func BearerToken(header string) (string, bool) {
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[7:])
+	return token, token != ""
+}
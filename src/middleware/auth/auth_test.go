@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestApp() *fiber.App {
+	authenticator := NewAuthenticator([]APIKey{
+		{Name: "reporting", Hash: HashKey("reporting-key"), Scopes: []Scope{ScopeUsersRead}},
+		{Name: "admin", Hash: HashKey("admin-key"), Scopes: []Scope{ScopeAdmin}},
+	}, nil)
+
+	app := fiber.New()
+	app.Get("/user/:id", authenticator.Require(ScopeUsersRead), func(c *fiber.Ctx) error {
+		return c.SendString(PrincipalFromCtx(c).Name)
+	})
+	app.Get("/actions/referral", authenticator.Require(ScopeAnalyticsRead), func(c *fiber.Ctx) error {
+		return c.SendString(PrincipalFromCtx(c).Name)
+	})
+
+	return app
+}
+
+func TestRequire(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name   string
+		path   string
+		key    string
+		status int
+	}{
+		{name: "Missing key", path: "/user/1", key: "", status: http.StatusUnauthorized},
+		{name: "Unknown key", path: "/user/1", key: "nope", status: http.StatusUnauthorized},
+		{name: "Key with scope", path: "/user/1", key: "reporting-key", status: http.StatusOK},
+		{name: "Key without scope", path: "/actions/referral", key: "reporting-key", status: http.StatusForbidden},
+		{name: "Admin key", path: "/actions/referral", key: "admin-key", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(HeaderAPIKey, tt.key)
+			}
+			resp, _ := app.Test(req, -1)
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			// Failures use the standard error envelope
+			if tt.status != http.StatusOK {
+				var errorResponse utils.ErrorResponse
+				err := json.NewDecoder(resp.Body).Decode(&errorResponse)
+				assert.NoError(t, err)
+				assert.NotEmpty(t, errorResponse.Error)
+			}
+		})
+	}
+}
+
+func TestRequire_Bearer_Token(t *testing.T) {
+	keys := newTestKeys(t)
+	authenticator := NewAuthenticator(nil, newTestVerifier(t, keys))
+
+	app := fiber.New()
+	app.Get("/users/:id/actions/count", authenticator.Require(ScopeUsersRead), RequireSelf("id"), func(c *fiber.Ctx) error {
+		return c.SendString(ClaimsFromCtx(c).Subject)
+	})
+	app.Get("/users", authenticator.Require(ScopeUsersRead), RequireAllUsers(), func(c *fiber.Ctx) error {
+		return c.SendString(ClaimsFromCtx(c).Subject)
+	})
+
+	adminClaims := validClaims("99")
+	adminClaims.Scope = "admin"
+
+	noScopeClaims := validClaims("1")
+	noScopeClaims.Scope = "analytics:read"
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "Own user", path: "/users/1/actions/count", token: signToken(t, jwt.SigningMethodES256, "ec-1", keys.ecKey, validClaims("1")), status: http.StatusOK},
+		{name: "Other user", path: "/users/2/actions/count", token: signToken(t, jwt.SigningMethodES256, "ec-1", keys.ecKey, validClaims("1")), status: http.StatusForbidden},
+		{name: "Admin", path: "/users/2/actions/count", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsaKey, adminClaims), status: http.StatusOK},
+		{name: "Missing scope", path: "/users/1/actions/count", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsaKey, noScopeClaims), status: http.StatusForbidden},
+		{name: "Invalid token", path: "/users/1/actions/count", token: "not.a.token", status: http.StatusUnauthorized},
+		{name: "All users", path: "/users", token: signToken(t, jwt.SigningMethodES256, "ec-1", keys.ecKey, validClaims("1")), status: http.StatusForbidden},
+		{name: "All users as admin", path: "/users", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsaKey, adminClaims), status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+			resp, _ := app.Test(req, -1)
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	authenticator := NewAuthenticator([]APIKey{
+		{Name: "reporting", Hash: HashKey("reporting-key"), Scopes: []Scope{ScopeUsersRead}},
+	}, nil)
+
+	principal, claims, err := authenticator.Authenticate("reporting-key", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "reporting", principal.Name)
+	assert.Nil(t, claims)
+
+	_, _, err = authenticator.Authenticate("", "")
+	assert.ErrorIs(t, err, ErrMissingCredentials)
+
+	_, _, err = authenticator.Authenticate("nope", "")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	// Bearer tokens are ignored without a JWT verifier
+	_, _, err = authenticator.Authenticate("reporting-key", "not.a.token")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the JWT claims the service understands
+type Claims struct {
+	jwt.RegisteredClaims
+	// Scope holds the granted scopes separated by spaces, as in OAuth 2.0
+	Scope string `json:"scope,omitempty"`
+}
+
+// Scopes returns the scopes granted by the token
+func (c *Claims) Scopes() []Scope {
+	var scopes []Scope
+	for _, scope := range strings.Fields(c.Scope) {
+		scopes = append(scopes, Scope(scope))
+	}
+	return scopes
+}
+
+type JWTConfig struct {
+	Audience string
+	Issuer   string
+	// Leeway is the clock skew tolerated when checking exp and nbf
+	Leeway time.Duration
+}
+
+// JWTVerifier validates RS256 and ES256 signed tokens against a JWKS key set
+type JWTVerifier struct {
+	keys   *KeySet
+	parser *jwt.Parser
+}
+
+func NewJWTVerifier(keys *KeySet, cfg JWTConfig) *JWTVerifier {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+
+	return &JWTVerifier{
+		keys:   keys,
+		parser: jwt.NewParser(options...),
+	}
+}
+
+// Verify checks the token's signature, exp, nbf, aud and iss and returns its claims
+func (v *JWTVerifier) Verify(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := v.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.keys.Key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token: missing subject")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeys struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+
+	return &testKeys{rsaKey: rsaKey, ecKey: ecKey}
+}
+
+// writeJWKS writes the public halves of the keys to a JWKS file
+func writeJWKS(t *testing.T, filePath string, rsaKid string, rsaKey *rsa.PrivateKey, ecKid string, ecKey *ecdsa.PrivateKey) {
+	t.Helper()
+
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	var keys []map[string]string
+	if rsaKey != nil {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": rsaKid,
+			"use": "sig",
+			"n":   encode(rsaKey.N.Bytes()),
+			"e":   encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+		})
+	}
+	if ecKey != nil {
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"kid": ecKid,
+			"crv": "P-256",
+			"x":   encode(ecKey.X.FillBytes(make([]byte, 32))),
+			"y":   encode(ecKey.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatalf("failed to marshal JWKS: %v", err)
+	}
+	if err := os.WriteFile(filePath, data, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func validClaims(subject string) *Claims {
+	now := time.Now()
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{"surfe-api"},
+			Issuer:    "https://gateway.internal",
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Scope: "users:read analytics:read",
+	}
+}
+
+func newTestVerifier(t *testing.T, keys *testKeys) *JWTVerifier {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, filePath, "rsa-1", keys.rsaKey, "ec-1", keys.ecKey)
+
+	keySet, err := NewKeySet(filePath)
+	if err != nil {
+		t.Fatalf("failed to load JWKS: %v", err)
+	}
+
+	return NewJWTVerifier(keySet, JWTConfig{Audience: "surfe-api", Issuer: "https://gateway.internal"})
+}
+
+func TestJWTVerifier_Verify(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(t, keys)
+
+	expired := validClaims("1")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	notYetValid := validClaims("1")
+	notYetValid.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	wrongAudience := validClaims("1")
+	wrongAudience.Audience = jwt.ClaimStrings{"other-api"}
+
+	wrongIssuer := validClaims("1")
+	wrongIssuer.Issuer = "https://evil.example"
+
+	noExpiry := validClaims("1")
+	noExpiry.ExpiresAt = nil
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{name: "RS256", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsaKey, validClaims("1")), valid: true},
+		{name: "ES256", token: signToken(t, jwt.SigningMethodES256, "ec-1", keys.ecKey, validClaims("1")), valid: true},
+		{name: "Expired", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsaKey, expired)},
+		{name: "Not yet valid", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsaKey, notYetValid)},
+		{name: "Wrong audience", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsaKey, wrongAudience)},
+		{name: "Wrong issuer", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsaKey, wrongIssuer)},
+		{name: "Without expiry", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", keys.rsaKey, noExpiry)},
+		{name: "Unknown key ID", token: signToken(t, jwt.SigningMethodRS256, "rsa-2", keys.rsaKey, validClaims("1"))},
+		{name: "Wrong key", token: signToken(t, jwt.SigningMethodRS256, "rsa-1", otherKey, validClaims("1"))},
+		{name: "HS256", token: signToken(t, jwt.SigningMethodHS256, "rsa-1", []byte("secret"), validClaims("1"))},
+		{name: "Garbage", token: "not.a.token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(tt.token)
+			if !tt.valid {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "1", claims.Subject)
+			assert.Equal(t, []Scope{ScopeUsersRead, ScopeAnalyticsRead}, claims.Scopes())
+		})
+	}
+}
+
+func TestKeySet_Reloads_Changed_File(t *testing.T) {
+	keys := newTestKeys(t)
+	rotated := newTestKeys(t)
+
+	filePath := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, filePath, "rsa-1", keys.rsaKey, "", nil)
+
+	keySet, err := NewKeySet(filePath)
+	if err != nil {
+		t.Fatalf("failed to load JWKS: %v", err)
+	}
+
+	now := time.Now()
+	keySet.now = func() time.Time { return now }
+
+	_, err = keySet.Key("rsa-2")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// Rotate the key and make sure the change is visible on the file's timestamp
+	writeJWKS(t, filePath, "rsa-2", rotated.rsaKey, "", nil)
+	modTime := now.Add(time.Minute)
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatalf("failed to touch JWKS: %v", err)
+	}
+
+	// Changes are picked up once the check interval has passed
+	now = now.Add(jwksCheckInterval)
+	key, err := keySet.Key("rsa-2")
+	assert.NoError(t, err)
+	assert.Equal(t, &rotated.rsaKey.PublicKey, key)
+
+	_, err = keySet.Key("rsa-1")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestNewKeySet_Invalid(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(filePath, []byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`), 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+
+	_, err := NewKeySet(filePath)
+	assert.Error(t, err)
+
+	_, err = NewKeySet(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
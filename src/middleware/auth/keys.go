@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type Scope string
+
+const (
+	ScopeUsersRead Scope = "users:read"
+	// ScopeUsersWrite deletes users along with their actions
+	ScopeUsersWrite    Scope = "users:write"
+	ScopeActionsWrite  Scope = "actions:write"
+	ScopeAnalyticsRead Scope = "analytics:read"
+	// ScopeWebhooksManage registers webhooks, which receive the actions of every user
+	ScopeWebhooksManage Scope = "webhooks:manage"
+	// ScopeAuditRead reads the audit trail of the changes made to users and actions
+	ScopeAuditRead Scope = "audit:read"
+	// ScopeAdmin grants every other scope
+	ScopeAdmin Scope = "admin"
+)
+
+var knownScopes = map[Scope]struct{}{
+	ScopeUsersRead:      {},
+	ScopeUsersWrite:     {},
+	ScopeActionsWrite:   {},
+	ScopeAnalyticsRead:  {},
+	ScopeWebhooksManage: {},
+	ScopeAuditRead:      {},
+	ScopeAdmin:          {},
+}
+
+// APIKey describes a key by the SHA-256 hash of its secret; the secret itself is never stored
+type APIKey struct {
+	Name   string  `json:"name"`
+	Hash   string  `json:"hash"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// HashKey returns the hex encoded SHA-256 hash of an API key secret
+func HashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadKeysFile reads API keys from a JSON file holding a list of APIKey entries
+func LoadKeysFile(filePath string) ([]APIKey, error) {
+	file, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(file, &keys); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal API keys: %w", err)
+	}
+
+	for i := range keys {
+		keys[i].Hash = strings.ToLower(keys[i].Hash)
+		if err := validateKey(keys[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// ParseKeys parses API keys given in configuration as semicolon separated
+// "name:secret:scope,scope" entries, hashing the secrets as they are read
+func ParseKeys(spec string) ([]APIKey, error) {
+	var keys []APIKey
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid API key entry %q: expected name:secret:scopes", parts[0])
+		}
+
+		key := APIKey{Name: parts[0], Hash: HashKey(parts[1])}
+		for _, scope := range strings.Split(parts[2], ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				key.Scopes = append(key.Scopes, Scope(scope))
+			}
+		}
+
+		if err := validateKey(key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func validateKey(key APIKey) error {
+	if key.Name == "" {
+		return fmt.Errorf("API key without a name")
+	}
+	if len(key.Hash) != sha256.Size*2 {
+		return fmt.Errorf("API key %q: hash must be a hex encoded SHA-256", key.Name)
+	}
+	if _, err := hex.DecodeString(key.Hash); err != nil {
+		return fmt.Errorf("API key %q: hash must be a hex encoded SHA-256", key.Name)
+	}
+	if len(key.Scopes) == 0 {
+		return fmt.Errorf("API key %q has no scopes", key.Name)
+	}
+	for _, scope := range key.Scopes {
+		if _, ok := knownScopes[scope]; !ok {
+			return fmt.Errorf("API key %q: unknown scope %q", key.Name, scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("reporting:s3cret:users:read,analytics:read; ingest:other:actions:write;")
+	assert.NoError(t, err)
+	assert.Equal(t, []APIKey{
+		{Name: "reporting", Hash: HashKey("s3cret"), Scopes: []Scope{ScopeUsersRead, ScopeAnalyticsRead}},
+		{Name: "ingest", Hash: HashKey("other"), Scopes: []Scope{ScopeActionsWrite}},
+	}, keys)
+}
+
+func TestParseKeys_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"reporting",
+		"reporting::users:read",
+		"reporting:s3cret:",
+		"reporting:s3cret:users:purge",
+	} {
+		_, err := ParseKeys(spec)
+		assert.Error(t, err, "spec %q", spec)
+	}
+}
+
+func TestLoadKeysFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "keys.json")
+	content := `[{"name":"admin","hash":"` + HashKey("root") + `","scopes":["admin"]}]`
+	if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write keys file: %v", err)
+	}
+
+	keys, err := LoadKeysFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, []APIKey{{Name: "admin", Hash: HashKey("root"), Scopes: []Scope{ScopeAdmin}}}, keys)
+}
+
+func TestLoadKeysFile_Plaintext_Secret(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "keys.json")
+	content := `[{"name":"admin","hash":"root","scopes":["admin"]}]`
+	if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write keys file: %v", err)
+	}
+
+	_, err := LoadKeysFile(filePath)
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/disconnect"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ParseRouteTimeouts parses comma separated "route=duration" entries,
+// where route is a route template such as /actions/referral
+func ParseRouteTimeouts(spec string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, timeoutSpec, ok := strings.Cut(entry, "=")
+		if !ok || route == "" {
+			return nil, fmt.Errorf("invalid route timeout %q: expected route=duration", entry)
+		}
+
+		timeout, err := time.ParseDuration(strings.TrimSpace(timeoutSpec))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid route timeout %q: invalid duration", entry)
+		}
+		timeouts[route] = timeout
+	}
+
+	return timeouts, nil
+}
+
+type Deadlines struct {
+	defaultTimeout time.Duration
+	routeTimeouts  map[string]time.Duration
+}
+
+// New returns deadlines applying routeTimeouts to the matching routes and
+// defaultTimeout everywhere else
+func New(defaultTimeout time.Duration, routeTimeouts map[string]time.Duration) *Deadlines {
+	return &Deadlines{
+		defaultTimeout: defaultTimeout,
+		routeTimeouts:  routeTimeouts,
+	}
+}
+
+// Route returns the middleware bounding requests to the given route template.
+// The request context passed to the services is cancelled once the route's
+// timeout elapses, the client disconnects or the server shuts down, whichever
+// comes first.
+func (d *Deadlines) Route(route string) fiber.Handler {
+	timeout := d.Timeout(route)
+
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+
+		// fasthttp closes the request's Done channel on server shutdown
+		stop := context.AfterFunc(c.Context(), cancel)
+		defer stop()
+
+		disconnect.Watch(ctx, c.Context().Conn(), cancel)
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
+// Timeout returns the timeout of the route template, so callers other than the
+// HTTP middleware, like the gRPC server, can apply the same deadlines
+func (d *Deadlines) Timeout(route string) time.Duration {
+	if timeout, ok := d.routeTimeouts[route]; ok {
+		return timeout
+	}
+	return d.defaultTimeout
+}
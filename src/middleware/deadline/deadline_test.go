@@ -0,0 +1,104 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRouteTimeouts(t *testing.T) {
+	timeouts, err := ParseRouteTimeouts("/actions/referral=5s, /actions/:actionType/next=250ms,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"/actions/referral":         5 * time.Second,
+		"/actions/:actionType/next": 250 * time.Millisecond,
+	}, timeouts)
+
+	for _, spec := range []string{"/actions/referral", "=5s", "/actions/referral=soon", "/actions/referral=-1s"} {
+		_, err := ParseRouteTimeouts(spec)
+		assert.Error(t, err, "spec %q", spec)
+	}
+}
+
+func TestDeadlines_Route(t *testing.T) {
+	deadlines := New(time.Minute, map[string]time.Duration{"/slow": 10 * time.Millisecond})
+
+	var remaining map[string]time.Duration
+	handler := func(c *fiber.Ctx) error {
+		deadline, ok := c.UserContext().Deadline()
+		assert.True(t, ok)
+		remaining[c.Path()] = time.Until(deadline)
+		return nil
+	}
+
+	app := fiber.New()
+	app.Get("/slow", deadlines.Route("/slow"), handler)
+	app.Get("/fast", deadlines.Route("/fast"), handler)
+
+	remaining = make(map[string]time.Duration)
+	app.Test(httptest.NewRequest(http.MethodGet, "/slow", nil), -1)
+	app.Test(httptest.NewRequest(http.MethodGet, "/fast", nil), -1)
+
+	assert.LessOrEqual(t, remaining["/slow"], 10*time.Millisecond)
+	assert.Greater(t, remaining["/fast"], 50*time.Second)
+}
+
+func TestDeadlines_CancelsWork(t *testing.T) {
+	deadlines := New(10*time.Millisecond, nil)
+
+	app := fiber.New()
+	app.Get("/referral", deadlines.Route("/referral"), func(c *fiber.Ctx) error {
+		select {
+		case <-c.UserContext().Done():
+			return c.SendStatus(fiber.StatusServiceUnavailable)
+		case <-time.After(time.Second):
+			return c.SendStatus(fiber.StatusOK)
+		}
+	})
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/referral", nil), -1)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestDeadlines_CancelsOnDisconnect(t *testing.T) {
+	deadlines := New(time.Minute, nil)
+
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/referral", deadlines.Route("/referral"), func(c *fiber.Ctx) error {
+		close(started)
+		<-c.UserContext().Done()
+		cancelled <- c.UserContext().Err()
+		return nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	conn.Write([]byte("GET /referral HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	<-started
+	conn.Close()
+
+	select {
+	case err := <-cancelled:
+		assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cancelled after the client disconnected")
+	}
+}
@@ -0,0 +1,36 @@
+package deprecation
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	HeaderDeprecation = "Deprecation"
+	HeaderSunset      = "Sunset"
+)
+
+// Policy describes when deprecated routes were deprecated and when they will be removed
+type Policy struct {
+	Deprecated time.Time
+	Sunset     time.Time
+}
+
+// Successor returns the middleware marking responses of a deprecated route with
+// the Deprecation (RFC 9745) and Sunset (RFC 8594) headers, and linking to the
+// same path under prefix as its successor
+func (p *Policy) Successor(prefix string) fiber.Handler {
+	deprecation := "@" + strconv.FormatInt(p.Deprecated.Unix(), 10)
+	sunset := p.Sunset.UTC().Format(http.TimeFormat)
+
+	return func(c *fiber.Ctx) error {
+		c.Set(HeaderDeprecation, deprecation)
+		c.Set(HeaderSunset, sunset)
+		c.Append(fiber.HeaderLink, `<`+prefix+c.OriginalURL()+`>; rel="successor-version"`)
+
+		return c.Next()
+	}
+}
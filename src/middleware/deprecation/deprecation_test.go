@@ -0,0 +1,31 @@
+package deprecation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Successor(t *testing.T) {
+	policy := &Policy{
+		Deprecated: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+		Sunset:     time.Date(2027, 4, 19, 0, 0, 0, 0, time.UTC),
+	}
+
+	app := fiber.New()
+	app.Get("/user/:id", policy.Successor("/v1"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNotFound)
+	})
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/user/7?fields=name", nil), -1)
+
+	// Error responses are marked too, so clients notice whatever they get back
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "@1792368000", resp.Header.Get(HeaderDeprecation))
+	assert.Equal(t, "Mon, 19 Apr 2027 00:00:00 GMT", resp.Header.Get(HeaderSunset))
+	assert.Equal(t, `</v1/user/7?fields=name>; rel="successor-version"`, resp.Header.Get(fiber.HeaderLink))
+}
@@ -0,0 +1,145 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/gofiber/fiber/v2"
+	fiber_utils "github.com/gofiber/fiber/v2/utils"
+)
+
+// usersKey stores the users tagged by the handler on the request
+const usersKey = "idempotency.users"
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	// MaxKeyLength is the longest idempotency key accepted
+	MaxKeyLength = 255
+)
+
+type Config struct {
+	// TTL is how long a key and its response are remembered
+	TTL time.Duration
+	// Store keeps the records; defaults to an in-memory store
+	Store Store
+}
+
+// Middleware replays the stored response when a request is repeated with the
+// same Idempotency-Key
+type Middleware struct {
+	cfg Config
+}
+
+func New(cfg Config) *Middleware {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore(DefaultMaxRecords)
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	return &Middleware{cfg: cfg}
+}
+
+// Route returns the middleware for the route, given without its version
+// prefix. Reusing a key with a different request is rejected with 422, and a
+// repeat arriving while the original is still being processed is rejected with
+// 409. Requests without the header pass through. Requests are told apart by the
+// route rather than the path they were sent to, so a key can be repeated on
+// any version of the route. Keys are scoped to the authenticated principal, so
+// a client can't replay the response of another client that happened to use
+// the same key.
+func (m *Middleware) Route(route string) fiber.Handler {
+	cfg := m.cfg
+
+	return func(c *fiber.Ctx) error {
+		header := c.Get(HeaderKey)
+		if header == "" {
+			return c.Next()
+		}
+		if len(header) > MaxKeyLength {
+			return utils.JsonError(c, fiber.StatusBadRequest, "Idempotency key is too long")
+		}
+		key := scopedKey(auth.PrincipalFromCtx(c), fiber_utils.CopyString(header))
+
+		fingerprint := requestFingerprint(c, route)
+		existing, reserved := cfg.Store.Reserve(key, fingerprint, cfg.TTL)
+		if !reserved {
+			if existing.Fingerprint != fingerprint {
+				return utils.JsonError(c, fiber.StatusUnprocessableEntity, "Idempotency key was already used for a different request")
+			}
+			if !existing.Completed {
+				return utils.JsonError(c, fiber.StatusConflict, "A request with this idempotency key is still being processed")
+			}
+
+			c.Set(HeaderReplayed, "true")
+			if existing.ContentType != "" {
+				c.Set(fiber.HeaderContentType, existing.ContentType)
+			}
+			return c.Status(existing.StatusCode).Send(existing.Body)
+		}
+
+		if err := c.Next(); err != nil {
+			cfg.Store.Release(key)
+			return err
+		}
+
+		// Server errors are not remembered so the client can retry them
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusInternalServerError {
+			cfg.Store.Release(key)
+			return nil
+		}
+
+		cfg.Store.Complete(key, Record{
+			Fingerprint: fingerprint,
+			StatusCode:  status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        append([]byte(nil), c.Response().Body()...),
+			Users:       taggedUsers(c),
+		})
+
+		return nil
+	}
+}
+
+// TagUsers marks the response as holding the data of the given users, so it is
+// no longer replayed once one of them is erased
+func TagUsers(c *fiber.Ctx, userIDs ...int) {
+	c.Locals(usersKey, append(taggedUsers(c), userIDs...))
+}
+
+func taggedUsers(c *fiber.Ctx) []int {
+	users, _ := c.Locals(usersKey).([]int)
+	return users
+}
+
+// scopedKey returns the key the records of a principal's idempotency key are
+// stored under. Requests made without authentication share one scope.
+func scopedKey(principal *auth.Principal, key string) string {
+	owner := ""
+	if principal != nil {
+		owner = principal.ID()
+	}
+	return owner + "\x00" + key
+}
+
+// requestFingerprint identifies a request by its method, route, the values of
+// the route's parameters and its body
+func requestFingerprint(c *fiber.Ctx, route string) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method()))
+	hash.Write([]byte{0})
+	hash.Write([]byte(route))
+	hash.Write([]byte{0})
+	for _, param := range c.Route().Params {
+		hash.Write([]byte(c.Params(param)))
+		hash.Write([]byte{0})
+	}
+	hash.Write(c.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
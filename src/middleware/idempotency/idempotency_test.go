@@ -0,0 +1,296 @@
+package idempotency
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestApp returns an app whose handler counts how many times it ran
+func newTestApp(store Store, status int) (*fiber.App, *int) {
+	calls := 0
+
+	app := fiber.New()
+	app.Post("/actions/bulk", New(Config{TTL: time.Hour, Store: store}).Route("/actions/bulk"), func(c *fiber.Ctx) error {
+		calls++
+		return c.Status(status).JSON(fiber.Map{"call": calls})
+	})
+
+	return app, &calls
+}
+
+func doRequest(t *testing.T, app *fiber.App, key, body string) (*http.Response, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/actions/bulk", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(HeaderKey, key)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp, string(respBody)
+}
+
+func TestIdempotency_Replays_Stored_Response(t *testing.T) {
+	app, calls := newTestApp(NewMemoryStore(0), fiber.StatusOK)
+
+	resp, body := doRequest(t, app, "key-1", `[{"type":"ADD_CONTACT","userId":1}]`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(HeaderReplayed))
+
+	replayed, replayedBody := doRequest(t, app, "key-1", `[{"type":"ADD_CONTACT","userId":1}]`)
+	assert.Equal(t, http.StatusOK, replayed.StatusCode)
+	assert.Equal(t, "true", replayed.Header.Get(HeaderReplayed))
+	assert.Equal(t, fiber.MIMEApplicationJSON, replayed.Header.Get(fiber.HeaderContentType))
+	assert.Equal(t, body, replayedBody)
+
+	// The handler only ran once
+	assert.Equal(t, 1, *calls)
+}
+
+func TestIdempotency_Different_Body_Conflicts(t *testing.T) {
+	app, calls := newTestApp(NewMemoryStore(0), fiber.StatusOK)
+
+	doRequest(t, app, "key-1", `[{"type":"ADD_CONTACT","userId":1}]`)
+	resp, _ := doRequest(t, app, "key-1", `[{"type":"ADD_CONTACT","userId":2}]`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestIdempotency_Without_Key(t *testing.T) {
+	app, calls := newTestApp(NewMemoryStore(0), fiber.StatusOK)
+
+	doRequest(t, app, "", `[]`)
+	doRequest(t, app, "", `[]`)
+
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotency_Key_Too_Long(t *testing.T) {
+	app, calls := newTestApp(NewMemoryStore(0), fiber.StatusOK)
+
+	resp, _ := doRequest(t, app, strings.Repeat("k", MaxKeyLength+1), `[]`)
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, 0, *calls)
+}
+
+func TestIdempotency_Server_Errors_Are_Not_Stored(t *testing.T) {
+	app, calls := newTestApp(NewMemoryStore(0), fiber.StatusInternalServerError)
+
+	doRequest(t, app, "key-1", `[]`)
+	resp, _ := doRequest(t, app, "key-1", `[]`)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(HeaderReplayed))
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotency_In_Flight_Request_Conflicts(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	app := fiber.New()
+	app.Post("/actions/bulk", New(Config{TTL: time.Hour}).Route("/actions/bulk"), func(c *fiber.Ctx) error {
+		close(started)
+		<-release
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/actions/bulk", strings.NewReader(`[]`))
+		req.Header.Set(HeaderKey, "key-1")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			done <- 0
+			return
+		}
+		done <- resp.StatusCode
+	}()
+
+	// While the first request is still running the key can't be reused
+	<-started
+	resp, _ := doRequest(t, app, "key-1", `[]`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func TestMemoryStore_Expiry(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(0)
+	store.now = func() time.Time { return now }
+
+	_, reserved := store.Reserve("key-1", "fp", time.Hour)
+	assert.True(t, reserved)
+	store.Complete("key-1", Record{Fingerprint: "fp", StatusCode: fiber.StatusOK})
+
+	existing, reserved := store.Reserve("key-1", "fp", time.Hour)
+	assert.False(t, reserved)
+	assert.True(t, existing.Completed)
+
+	// Once the TTL has passed the key can be used again
+	now = now.Add(time.Hour)
+	_, reserved = store.Reserve("key-1", "fp", time.Hour)
+	assert.True(t, reserved)
+}
+
+func TestIdempotency_Keys_Scoped_To_Principal(t *testing.T) {
+	authenticator := auth.NewAuthenticator([]auth.APIKey{
+		{Name: "reporting", Hash: auth.HashKey("reporting-key"), Scopes: []auth.Scope{auth.ScopeActionsWrite}},
+		{Name: "ingest", Hash: auth.HashKey("ingest-key"), Scopes: []auth.Scope{auth.ScopeActionsWrite}},
+	}, nil)
+
+	calls := 0
+	app := fiber.New()
+	app.Post("/actions/bulk", authenticator.Require(auth.ScopeActionsWrite), New(Config{TTL: time.Hour}).Route("/actions/bulk"), func(c *fiber.Ctx) error {
+		calls++
+		return c.JSON(fiber.Map{"principal": auth.PrincipalFromCtx(c).Name})
+	})
+
+	send := func(apiKey string) (*http.Response, string) {
+		req := httptest.NewRequest(http.MethodPost, "/actions/bulk", strings.NewReader(`[]`))
+		req.Header.Set(HeaderKey, "shared-key")
+		req.Header.Set(auth.HeaderAPIKey, apiKey)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	_, body := send("reporting-key")
+	assert.JSONEq(t, `{"principal":"reporting"}`, body)
+
+	// Another client reusing the key gets its own response rather than the first client's
+	resp, body := send("ingest-key")
+	assert.Empty(t, resp.Header.Get(HeaderReplayed))
+	assert.JSONEq(t, `{"principal":"ingest"}`, body)
+	assert.Equal(t, 2, calls)
+
+	// While the same client repeating it gets the stored response
+	resp, body = send("reporting-key")
+	assert.Equal(t, "true", resp.Header.Get(HeaderReplayed))
+	assert.JSONEq(t, `{"principal":"reporting"}`, body)
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotency_Forgotten_User_Is_Not_Replayed(t *testing.T) {
+	store := NewMemoryStore(0)
+
+	calls := 0
+	app := fiber.New()
+	app.Post("/actions/bulk", New(Config{TTL: time.Hour, Store: store}).Route("/actions/bulk"), func(c *fiber.Ctx) error {
+		calls++
+		TagUsers(c, 1)
+		return c.JSON(fiber.Map{"call": calls})
+	})
+
+	doRequest(t, app, "key-1", `[{"type":"ADD_CONTACT","userId":1}]`)
+
+	// Forgetting another user keeps the stored response
+	store.ForgetUser(2)
+	resp, _ := doRequest(t, app, "key-1", `[{"type":"ADD_CONTACT","userId":1}]`)
+	assert.Equal(t, "true", resp.Header.Get(HeaderReplayed))
+
+	// Forgetting the tagged user drops it, so the request runs again
+	store.ForgetUser(1)
+	resp, body := doRequest(t, app, "key-1", `[{"type":"ADD_CONTACT","userId":1}]`)
+	assert.Empty(t, resp.Header.Get(HeaderReplayed))
+	assert.JSONEq(t, `{"call":2}`, body)
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotency_Key_Replayed_Across_Versions(t *testing.T) {
+	calls := 0
+	app := fiber.New()
+	handler := func(c *fiber.Ctx) error {
+		calls++
+		return c.JSON(fiber.Map{"call": calls})
+	}
+	middleware := New(Config{TTL: time.Hour})
+	app.Post("/v1/actions/bulk", middleware.Route("/actions/bulk"), handler)
+	app.Post("/actions/bulk", middleware.Route("/actions/bulk"), handler)
+	app.Delete("/v1/actions/:id", middleware.Route("/actions/:id"), handler)
+
+	send := func(method, path string) (*http.Response, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(`[]`))
+		req.Header.Set(HeaderKey, "key-1")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	send(http.MethodPost, "/v1/actions/bulk")
+
+	// The same request sent to the legacy path is a repeat of the first one
+	resp, body := send(http.MethodPost, "/actions/bulk")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get(HeaderReplayed))
+	assert.JSONEq(t, `{"call":1}`, body)
+
+	// While another route is a different request
+	resp, _ = send(http.MethodDelete, "/v1/actions/1")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, 1, calls)
+}
+
+func TestMemoryStore_Evicts_When_Full(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(2)
+	store.now = func() time.Time { return now }
+
+	store.Reserve("key-1", "fp", time.Hour)
+	store.Complete("key-1", Record{Fingerprint: "fp", StatusCode: fiber.StatusOK})
+	now = now.Add(time.Minute)
+	store.Reserve("key-2", "fp", time.Hour)
+	store.Complete("key-2", Record{Fingerprint: "fp", StatusCode: fiber.StatusOK})
+
+	// The record closest to its expiry makes room for the new key
+	_, reserved := store.Reserve("key-3", "fp", time.Hour)
+	assert.True(t, reserved)
+	assert.Len(t, store.records, 2)
+	assert.NotContains(t, store.records, "key-1")
+
+	// Requests still in flight are never dropped
+	_, reserved = store.Reserve("key-4", "fp", time.Hour)
+	assert.True(t, reserved)
+	assert.Contains(t, store.records, "key-3")
+	assert.NotContains(t, store.records, "key-2")
+}
+
+func TestMemoryStore_ForgetUser_Keeps_In_Flight_Requests(t *testing.T) {
+	store := NewMemoryStore(0)
+
+	store.Reserve("key-1", "fp", time.Hour)
+	store.records["key-1"].Users = []int{1}
+	store.Reserve("key-2", "fp", time.Hour)
+	store.Complete("key-2", Record{Fingerprint: "fp", StatusCode: fiber.StatusOK, Users: []int{1}})
+
+	store.ForgetUser(1)
+
+	existing, reserved := store.Reserve("key-1", "fp", time.Hour)
+	assert.False(t, reserved)
+	assert.False(t, existing.Completed)
+	_, reserved = store.Reserve("key-2", "fp", time.Hour)
+	assert.True(t, reserved)
+}
@@ -0,0 +1,151 @@
+package idempotency
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Record is what the store keeps for an idempotency key
+type Record struct {
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	// Users are the users whose data the response holds
+	Users     []int
+	ExpiresAt time.Time
+}
+
+// Store keeps idempotency records. Implementations must be safe for concurrent use.
+type Store interface {
+	// Reserve claims key for a request with the given fingerprint until it is completed
+	// or released. If the key is already known the existing record is returned instead.
+	Reserve(key, fingerprint string, ttl time.Duration) (existing *Record, reserved bool)
+	// Complete stores the response for a reserved key
+	Complete(key string, record Record)
+	// Release forgets a reserved key so the request can be retried
+	Release(key string)
+	// ForgetUser drops the responses holding the data of a user
+	ForgetUser(userID int)
+}
+
+// DefaultMaxRecords is how many keys a memory store remembers when not told otherwise
+const DefaultMaxRecords = 10_000
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+	// maxRecords bounds how many keys are remembered
+	maxRecords int
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+// NewMemoryStore returns a store remembering at most maxRecords keys, or
+// DefaultMaxRecords when it isn't positive
+func NewMemoryStore(maxRecords int) *MemoryStore {
+	if maxRecords <= 0 {
+		maxRecords = DefaultMaxRecords
+	}
+	return &MemoryStore{
+		records:    make(map[string]*Record),
+		maxRecords: maxRecords,
+		now:        time.Now,
+	}
+}
+
+// Reserve claims key, dropping expired records along the way. When the store is
+// full the completed record closest to its expiry is dropped to make room.
+func (s *MemoryStore) Reserve(key, fingerprint string, ttl time.Duration) (*Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if record, ok := s.records[key]; ok && now.Before(record.ExpiresAt) {
+		existing := *record
+		return &existing, false
+	}
+
+	if len(s.records) >= s.maxRecords {
+		s.evict(now)
+	}
+
+	s.records[key] = &Record{
+		Fingerprint: fingerprint,
+		ExpiresAt:   now.Add(ttl),
+	}
+	return nil, true
+}
+
+// Complete stores the response for key, keeping the expiry set when it was reserved
+func (s *MemoryStore) Complete(key string, record Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reserved, ok := s.records[key]
+	if !ok {
+		return
+	}
+
+	record.Completed = true
+	record.ExpiresAt = reserved.ExpiresAt
+	s.records[key] = &record
+}
+
+// Release forgets key
+func (s *MemoryStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+}
+
+// ForgetUser drops the completed records of responses holding the data of the
+// user. Requests still in flight keep their key, so a repeat can't run them twice.
+func (s *MemoryStore) ForgetUser(userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, record := range s.records {
+		if record.Completed && slices.Contains(record.Users, userID) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// evict drops the expired records, or else the completed record closest to its
+// expiry. Records of requests in flight are kept, and their number is bounded
+// by the requests the server handles at once.
+func (s *MemoryStore) evict(now time.Time) {
+	var oldest string
+	for key, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, key)
+			continue
+		}
+		if record.Completed && (oldest == "" || record.ExpiresAt.Before(s.records[oldest].ExpiresAt)) {
+			oldest = key
+		}
+	}
+	if len(s.records) >= s.maxRecords && oldest != "" {
+		delete(s.records, oldest)
+	}
+}
+
+// sweep removes expired records, at most once a minute
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	HeaderRetryAfter = "Retry-After"
+)
+
+// Limit allows bursts of up to Requests requests, refilled evenly over Per
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// ParseLimit parses a limit written as "requests/duration", e.g. "10/1m"
+func ParseLimit(spec string) (Limit, error) {
+	requests, per, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected requests/duration", spec)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: requests must be a positive integer", spec)
+	}
+
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: invalid duration", spec)
+	}
+
+	return Limit{Requests: n, Per: d}, nil
+}
+
+// ParseRouteLimits parses comma separated "route=requests/duration" entries,
+// where route is a route template such as /actions/referral
+func ParseRouteLimits(spec string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, limitSpec, ok := strings.Cut(entry, "=")
+		if !ok || route == "" {
+			return nil, fmt.Errorf("invalid route rate limit %q: expected route=requests/duration", entry)
+		}
+
+		limit, err := ParseLimit(limitSpec)
+		if err != nil {
+			return nil, err
+		}
+		limits[route] = limit
+	}
+
+	return limits, nil
+}
+
+type Limiter struct {
+	store        Store
+	defaultLimit Limit
+	routeLimits  map[string]Limit
+	now          func() time.Time
+}
+
+// NewLimiter returns a limiter applying routeLimits to the matching routes and
+// defaultLimit everywhere else
+func NewLimiter(store Store, defaultLimit Limit, routeLimits map[string]Limit) *Limiter {
+	return &Limiter{
+		store:        store,
+		defaultLimit: defaultLimit,
+		routeLimits:  routeLimits,
+		now:          time.Now,
+	}
+}
+
+// Route returns the middleware limiting requests to the given route template.
+// Each client gets its own bucket per route, identified by its authenticated
+// principal when there is one and by its IP address otherwise.
+func (l *Limiter) Route(route string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result, limit, err := l.Take(route, ClientKey(auth.PrincipalFromCtx(c), c.IP()))
+		if err != nil {
+			// Don't turn an unavailable store into an outage
+			return c.Next()
+		}
+
+		c.Set(HeaderLimit, strconv.Itoa(limit.Requests))
+		c.Set(HeaderRemaining, strconv.Itoa(result.Remaining))
+		c.Set(HeaderReset, strconv.Itoa(CeilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			c.Set(HeaderRetryAfter, strconv.Itoa(CeilSeconds(result.RetryAfter)))
+			return utils.JsonError(c, fiber.StatusTooManyRequests, "Rate limit exceeded")
+		}
+
+		return c.Next()
+	}
+}
+
+// IP returns a middleware limiting the requests of each IP address to limit,
+// across every route and whichever credentials they carry. It runs before
+// authentication, so requests failing it, like attempts at guessing API keys,
+// are throttled too. Only rejected requests get rate limit headers, leaving the
+// others to the route's limit.
+func (l *Limiter) IP(limit Limit) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result, err := l.TakeIP(c.IP(), limit)
+		if err != nil || result.Allowed {
+			return c.Next()
+		}
+
+		c.Set(HeaderLimit, strconv.Itoa(limit.Requests))
+		c.Set(HeaderRemaining, "0")
+		c.Set(HeaderReset, strconv.Itoa(CeilSeconds(result.Reset)))
+		c.Set(HeaderRetryAfter, strconv.Itoa(CeilSeconds(result.RetryAfter)))
+		return utils.JsonError(c, fiber.StatusTooManyRequests, "Rate limit exceeded")
+	}
+}
+
+// Take takes a request of client, as identified by ClientKey, from its bucket
+// for the route template, and returns the route's limit along with the result.
+// Callers other than the HTTP middleware, like the gRPC server, share the
+// buckets of the HTTP routes this way.
+func (l *Limiter) Take(route, client string) (Result, Limit, error) {
+	limit, ok := l.routeLimits[route]
+	if !ok {
+		limit = l.defaultLimit
+	}
+
+	result, err := l.store.Take(route+"|"+client, limit, l.now())
+	return result, limit, err
+}
+
+// TakeIP takes a request of the IP address from its bucket across every route
+func (l *Limiter) TakeIP(ip string, limit Limit) (Result, error) {
+	return l.store.Take("ip|"+ip, limit, l.now())
+}
+
+// ClientKey identifies a client by its principal when it was authenticated,
+// and by its IP address otherwise
+func ClientKey(principal *auth.Principal, ip string) string {
+	if principal != nil {
+		return "principal:" + principal.Name
+	}
+	return "ip:" + ip
+}
+
+// CeilSeconds rounds a duration up to whole seconds, as sent in Retry-After
+func CeilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/1m")
+	assert.NoError(t, err)
+	assert.Equal(t, Limit{Requests: 10, Per: time.Minute}, limit)
+
+	for _, spec := range []string{"10", "0/1m", "ten/1m", "10/soon", "10/-1s"} {
+		_, err := ParseLimit(spec)
+		assert.Error(t, err, "spec %q", spec)
+	}
+}
+
+func TestParseRouteLimits(t *testing.T) {
+	limits, err := ParseRouteLimits("/actions/referral=10/1m, /actions/:actionType/next=30/1m,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Limit{
+		"/actions/referral":         {Requests: 10, Per: time.Minute},
+		"/actions/:actionType/next": {Requests: 30, Per: time.Minute},
+	}, limits)
+
+	_, err = ParseRouteLimits("/actions/referral")
+	assert.Error(t, err)
+}
+
+func TestMemoryStore_Take(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 2, Per: 2 * time.Second}
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The bucket starts full
+	result, _ := store.Take("client", limit, now)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+
+	result, _ = store.Take("client", limit, now)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 2*time.Second, result.Reset)
+
+	result, _ = store.Take("client", limit, now)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	// Other clients have their own bucket
+	result, _ = store.Take("other", limit, now)
+	assert.True(t, result.Allowed)
+
+	// One token is refilled every second
+	result, _ = store.Take("client", limit, now.Add(time.Second))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+}
+
+func newTestApp(limiter *Limiter) *fiber.App {
+	app := fiber.New()
+
+	// Authenticated routes key their buckets by principal
+	authenticator := auth.NewAuthenticator([]auth.APIKey{
+		{Name: "reporting", Hash: auth.HashKey("reporting-key"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+		{Name: "ingest", Hash: auth.HashKey("ingest-key"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	}, nil)
+
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	app.Get("/actions/referral", limiter.Route("/actions/referral"), ok)
+	app.Get("/user/:id", limiter.Route("/user/:id"), ok)
+	app.Get("/private/user/:id", authenticator.Require(auth.ScopeUsersRead), limiter.Route("/private/user/:id"), ok)
+
+	return app
+}
+
+func TestLimiter_Route(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), Limit{Requests: 5, Per: time.Minute}, map[string]Limit{
+		"/actions/referral": {Requests: 1, Per: time.Minute},
+	})
+	app := newTestApp(limiter)
+
+	req := httptest.NewRequest(http.MethodGet, "/actions/referral", nil)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get(HeaderLimit))
+	assert.Equal(t, "0", resp.Header.Get(HeaderRemaining))
+	assert.Equal(t, "60", resp.Header.Get(HeaderReset))
+
+	req = httptest.NewRequest(http.MethodGet, "/actions/referral", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get(HeaderRetryAfter))
+
+	// Routes without their own limit use the default and a separate bucket
+	req = httptest.NewRequest(http.MethodGet, "/user/1", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get(HeaderLimit))
+	assert.Equal(t, "4", resp.Header.Get(HeaderRemaining))
+}
+
+func TestLimiter_Keyed_By_Principal(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), Limit{Requests: 1, Per: time.Minute}, nil)
+	app := newTestApp(limiter)
+
+	req := httptest.NewRequest(http.MethodGet, "/private/user/1", nil)
+	req.Header.Set(auth.HeaderAPIKey, "reporting-key")
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req = httptest.NewRequest(http.MethodGet, "/private/user/1", nil)
+	req.Header.Set(auth.HeaderAPIKey, "reporting-key")
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Another key from the same IP has its own bucket
+	req = httptest.NewRequest(http.MethodGet, "/private/user/1", nil)
+	req.Header.Set(auth.HeaderAPIKey, "ingest-key")
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestLimiter_IP_Before_Auth(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), Limit{Requests: 10, Per: time.Minute}, nil)
+	authenticator := auth.NewAuthenticator([]auth.APIKey{
+		{Name: "reporting", Hash: auth.HashKey("reporting-key"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	}, nil)
+
+	app := fiber.New()
+	app.Get("/user/:id", limiter.IP(Limit{Requests: 2, Per: time.Minute}), authenticator.Require(auth.ScopeUsersRead),
+		limiter.Route("/user/:id"), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	// Failed authentications use up the IP's tokens
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.Header.Set(auth.HeaderAPIKey, "guess")
+		resp, _ := app.Test(req, -1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// So guessing stops being answered, even with a valid key from the same IP
+	req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+	req.Header.Set(auth.HeaderAPIKey, "reporting-key")
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get(HeaderRetryAfter))
+}
@@ -0,0 +1,85 @@
+package requestlog
+
+import (
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/gofiber/fiber/v2"
+	fiber_utils "github.com/gofiber/fiber/v2/utils"
+	"github.com/rs/zerolog"
+)
+
+const HeaderRequestID = "X-Request-ID"
+
+// maxRequestIDLength is the longest client supplied request ID that is propagated
+const maxRequestIDLength = 128
+
+// New returns a middleware that assigns every request a correlation ID, taken
+// from the X-Request-ID header when the client sent a valid one, attaches a
+// logger carrying it to the context and writes one access log line per request
+func New(logger zerolog.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		requestID := c.Get(HeaderRequestID)
+		if !validRequestID(requestID) {
+			requestID = fiber_utils.UUIDv4()
+		} else {
+			requestID = fiber_utils.CopyString(requestID)
+		}
+		c.Set(HeaderRequestID, requestID)
+
+		requestLogger := logger.With().
+			Str("request_id", requestID).
+			Str("method", c.Method()).
+			Str("path", fiber_utils.CopyString(c.Path())).
+			Logger()
+		utils.SetRequestLogger(c, requestID, requestLogger)
+
+		err := c.Next()
+		if err != nil {
+			// Let the app's error handler write the response so the status below is accurate
+			if handlerErr := c.App().ErrorHandler(c, err); handlerErr != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		status := c.Response().StatusCode()
+		accessLogger := utils.Logger(c, requestLogger)
+
+		event := accessLogger.Info()
+		switch {
+		case status >= fiber.StatusInternalServerError:
+			event = accessLogger.Error()
+		case status >= fiber.StatusBadRequest:
+			event = accessLogger.Warn()
+		}
+
+		event = event.
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Str("ip", c.IP())
+
+		// Streamed bodies are still being written, so their size isn't known yet
+		if !c.Response().IsBodyStream() {
+			event = event.Int("bytes", len(c.Response().Body()))
+		}
+
+		event.Msg("request")
+
+		return nil
+	}
+}
+
+// validRequestID accepts non-empty IDs of printable ASCII without spaces
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] <= ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package requestlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestApp returns an app logging to buf whose handler logs through the request logger
+func newTestApp(buf *bytes.Buffer) *fiber.App {
+	logger := zerolog.New(buf)
+
+	app := fiber.New()
+	app.Use(New(logger))
+	app.Get("/users/:id/actions/count", func(c *fiber.Ctx) error {
+		handlerLogger := utils.Logger(c, zerolog.Nop())
+		handlerLogger.Info().Msg("handler")
+		return c.SendString("42")
+	})
+
+	return app
+}
+
+// logLines decodes the JSON log lines written to buf
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestRequestLog(t *testing.T) {
+	var buf bytes.Buffer
+	app := newTestApp(&buf)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/7/actions/count", nil)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	requestID := resp.Header.Get(HeaderRequestID)
+	assert.NotEmpty(t, requestID)
+
+	lines := logLines(t, &buf)
+	assert.Len(t, lines, 2)
+
+	// The handler's log line carries the request context
+	handlerLine := lines[0]
+	assert.Equal(t, "handler", handlerLine["message"])
+	assert.Equal(t, requestID, handlerLine["request_id"])
+	assert.Equal(t, "GET", handlerLine["method"])
+	assert.Equal(t, "/users/7/actions/count", handlerLine["path"])
+	assert.Equal(t, "/users/:id/actions/count", handlerLine["route"])
+	assert.Equal(t, "7", handlerLine["user_id"])
+
+	// Followed by a single access log line
+	accessLine := lines[1]
+	assert.Equal(t, "request", accessLine["message"])
+	assert.Equal(t, "info", accessLine["level"])
+	assert.Equal(t, requestID, accessLine["request_id"])
+	assert.Equal(t, "/users/:id/actions/count", accessLine["route"])
+	assert.Equal(t, float64(http.StatusOK), accessLine["status"])
+	assert.Equal(t, float64(2), accessLine["bytes"])
+	assert.Contains(t, accessLine, "latency")
+}
+
+func TestRequestLog_Propagates_Request_ID(t *testing.T) {
+	var buf bytes.Buffer
+	app := newTestApp(&buf)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/7/actions/count", nil)
+	req.Header.Set(HeaderRequestID, "upstream-123")
+	resp, _ := app.Test(req, -1)
+
+	assert.Equal(t, "upstream-123", resp.Header.Get(HeaderRequestID))
+	for _, line := range logLines(t, &buf) {
+		assert.Equal(t, "upstream-123", line["request_id"])
+	}
+}
+
+func TestRequestLog_Replaces_Invalid_Request_ID(t *testing.T) {
+	var buf bytes.Buffer
+	app := newTestApp(&buf)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/7/actions/count", nil)
+	req.Header.Set(HeaderRequestID, strings.Repeat("x", maxRequestIDLength+1))
+	resp, _ := app.Test(req, -1)
+
+	requestID := resp.Header.Get(HeaderRequestID)
+	assert.NotEmpty(t, requestID)
+	assert.NotEqual(t, strings.Repeat("x", maxRequestIDLength+1), requestID)
+}
+
+func TestRequestLog_Not_Found(t *testing.T) {
+	var buf bytes.Buffer
+	app := newTestApp(&buf)
+
+	req := httptest.NewRequest(http.MethodGet, "/nope", nil)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	lines := logLines(t, &buf)
+	assert.Len(t, lines, 1)
+	assert.Equal(t, "warn", lines[0]["level"])
+	assert.Equal(t, float64(http.StatusNotFound), lines[0]["status"])
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntity is the kind of record an audit record is about
+type AuditEntity string
+
+const (
+	AuditEntityUser   AuditEntity = "user"
+	AuditEntityAction AuditEntity = "action"
+)
+
+// IsValid reports whether the entity is one the audit trail records
+func (e AuditEntity) IsValid() bool {
+	return e == AuditEntityUser || e == AuditEntityAction
+}
+
+// AuditOperation is the kind of change an audit record describes
+type AuditOperation string
+
+const (
+	AuditOperationCreate AuditOperation = "create"
+	AuditOperationDelete AuditOperation = "delete"
+	AuditOperationErase  AuditOperation = "erase"
+)
+
+// AuditRecord describes one change made to a user or an action
+type AuditRecord struct {
+	ID        int            `json:"id"`
+	Entity    AuditEntity    `json:"entity"`
+	EntityID  int            `json:"entityId"`
+	Operation AuditOperation `json:"operation"`
+	// Actor is the name of the API key or the subject of the token that made the change
+	Actor     string `json:"actor"`
+	RequestID string `json:"requestId,omitempty"`
+	// Before and After hold the entity as JSON, Before is null for creations and
+	// erasures. The records of an erased user hold them without their personal data.
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	Timestamp time.Time       `json:"timestamp"`
+}
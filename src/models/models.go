@@ -1,11 +1,18 @@
 package models
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
 type User struct {
 	ID        int       `json:"id"`
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"createdAt"`
+	// DeletedAt is set when the user was soft deleted, which hides them from every read
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// ErasedAt is set when the user's personal data was erased, which also deletes them
+	ErasedAt *time.Time `json:"erasedAt,omitempty"`
 }
 
 type Action struct {
@@ -14,6 +21,13 @@ type Action struct {
 	UserID     int        `json:"userId"`
 	TargetUser int        `json:"targetUser,omitempty"`
 	CreatedAt  time.Time  `json:"createdAt"`
+	// DeletedAt is set when the action was soft deleted, which hides it from every read
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Involves reports whether the action was performed by the user or refers them
+func (a Action) Involves(userID int) bool {
+	return a.UserID == userID || (a.Type == ActionTypeReferUser && a.TargetUser == userID)
 }
 
 type ActionType string
@@ -23,4 +37,32 @@ const (
 	ActionTypeEditContact  ActionType = "EDIT_CONTACT"
 	ActionTypeReferUser    ActionType = "REFER_USER"
 	ActionTypeViewContacts ActionType = "VIEW_CONTACTS"
+	ActionTypeWelcome      ActionType = "WELCOME"
+	ActionTypeConnectCRM   ActionType = "CONNECT_CRM"
 )
+
+// actionTypes is the registry of action types the service accepts
+var actionTypes = map[ActionType]struct{}{
+	ActionTypeAddContact:   {},
+	ActionTypeEditContact:  {},
+	ActionTypeReferUser:    {},
+	ActionTypeViewContacts: {},
+	ActionTypeWelcome:      {},
+	ActionTypeConnectCRM:   {},
+}
+
+// IsValid reports whether the action type is registered
+func (t ActionType) IsValid() bool {
+	_, ok := actionTypes[t]
+	return ok
+}
+
+// ActionTypes returns the registered action types in alphabetical order
+func ActionTypes() []ActionType {
+	types := make([]ActionType, 0, len(actionTypes))
+	for t := range actionTypes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Webhook is an endpoint notified of the actions of the given types, or of every action when Events is empty
+type Webhook struct {
+	ID     int          `json:"id"`
+	URL    string       `json:"url"`
+	Events []ActionType `json:"events"`
+	// Secret signs the payloads delivered to the endpoint
+	Secret string `json:"secret"`
+	// Owner is the ID of the principal who created the webhook, the only one
+	// besides admins allowed to read its deliveries
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Matches reports whether the webhook is notified of actions of the given type
+func (w *Webhook) Matches(actionType ActionType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, event := range w.Events {
+		if event == actionType {
+			return true
+		}
+	}
+	return false
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending   DeliveryStatus = "pending"
+	DeliveryStatusSucceeded DeliveryStatus = "succeeded"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is the notification of one action to one webhook, and the outcome of its attempts
+type WebhookDelivery struct {
+	ID        int            `json:"id"`
+	WebhookID int            `json:"webhookId"`
+	Event     ActionType     `json:"event"`
+	Action    Action         `json:"action"`
+	Status    DeliveryStatus `json:"status"`
+	Attempts  int            `json:"attempts"`
+	// StatusCode and Error describe the last attempt
+	StatusCode    int        `json:"statusCode,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	CompletedAt   *time.Time `json:"completedAt,omitempty"`
+}
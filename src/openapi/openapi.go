@@ -0,0 +1,126 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Spec returns the OpenAPI document describing the API
+func Spec() []byte {
+	return spec
+}
+
+// methods are the keys of a path item that hold operations
+var methods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// Document is the part of an OpenAPI document needed to check it against the
+// router and validate requests
+type Document struct {
+	// Paths maps a path template and a lower case method to its operation
+	Paths      map[string]map[string]Operation
+	Components Components
+}
+
+type Components struct {
+	Schemas map[string]Schema `json:"schemas"`
+}
+
+type Operation struct {
+	OperationID string      `json:"operationId"`
+	Parameters  []Parameter `json:"parameters"`
+}
+
+type Parameter struct {
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+	Schema   Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref       string   `json:"$ref"`
+	Type      string   `json:"type"`
+	Enum      []string `json:"enum"`
+	Minimum   *float64 `json:"minimum"`
+	Maximum   *float64 `json:"maximum"`
+	MaxLength *int     `json:"maxLength"`
+}
+
+// Load parses the embedded OpenAPI document
+func Load() (*Document, error) {
+	return Parse(spec)
+}
+
+// Parse parses an OpenAPI document
+func Parse(data []byte) (*Document, error) {
+	var raw struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components Components                            `json:"components"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+
+	doc := &Document{
+		Paths:      make(map[string]map[string]Operation),
+		Components: raw.Components,
+	}
+
+	for path, item := range raw.Paths {
+		doc.Paths[path] = make(map[string]Operation)
+		for method, value := range item {
+			// Path items also hold shared fields such as summary
+			if !methods[method] {
+				continue
+			}
+
+			var op Operation
+			if err := json.Unmarshal(value, &op); err != nil {
+				return nil, fmt.Errorf("failed to parse operation %s %s: %w", strings.ToUpper(method), path, err)
+			}
+			doc.Paths[path][method] = op
+		}
+	}
+
+	return doc, nil
+}
+
+// Operation returns the operation documented for a method and a Fiber route path
+func (d *Document) Operation(method, route string) (Operation, bool) {
+	op, ok := d.Paths[PathTemplate(route)][strings.ToLower(method)]
+	return op, ok
+}
+
+// resolve follows a reference to a component schema
+func (d *Document) resolve(schema Schema) Schema {
+	for schema.Ref != "" {
+		name, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/")
+		if !ok {
+			return Schema{}
+		}
+		schema = d.Components.Schemas[name]
+	}
+	return schema
+}
+
+// PathTemplate converts a Fiber route path such as /users/:id/actions/count to
+// its OpenAPI path template, /users/{id}/actions/count
+func PathTemplate(route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			// Drop optional markers and constraints, e.g. :id? or :id<int>
+			name, _, _ = strings.Cut(strings.TrimSuffix(name, "?"), "<")
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,111 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	assert.NoError(t, err)
+
+	op, ok := doc.Operation(fiber.MethodGet, "/v1/users/:id/actions/count")
+	assert.True(t, ok)
+	assert.Equal(t, "getActionCount", op.OperationID)
+
+	_, ok = doc.Operation(fiber.MethodDelete, "/v1/users/:id/actions/count")
+	assert.False(t, ok)
+}
+
+func TestSpec_ActionTypesMatchRegistry(t *testing.T) {
+	doc, err := Load()
+	assert.NoError(t, err)
+
+	documented := doc.Components.Schemas["ActionType"].Enum
+	registered := make([]string, 0, len(documented))
+	for _, actionType := range models.ActionTypes() {
+		registered = append(registered, string(actionType))
+	}
+
+	assert.Equal(t, registered, documented)
+}
+
+func TestPathTemplate(t *testing.T) {
+	assert.Equal(t, "/users/{id}/actions/count", PathTemplate("/users/:id/actions/count"))
+	assert.Equal(t, "/actions/{actionType}/next", PathTemplate("/actions/:actionType/next"))
+	assert.Equal(t, "/users/{id}", PathTemplate("/users/:id<int>"))
+	assert.Equal(t, "/users/{id}", PathTemplate("/users/:id?"))
+	assert.Equal(t, "/actions/referral", PathTemplate("/actions/referral"))
+}
+
+func TestValidator_Route(t *testing.T) {
+	doc, err := Load()
+	assert.NoError(t, err)
+	validator := NewValidator(doc)
+
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+
+	app := fiber.New()
+	app.Get("/v1/user/:id", validator.Route(fiber.MethodGet, "/v1/user/:id"), ok)
+	app.Get("/v1/actions/:actionType/next", validator.Route(fiber.MethodGet, "/v1/actions/:actionType/next"), ok)
+	app.Post("/v1/actions/bulk", validator.Route(fiber.MethodPost, "/v1/actions/bulk"), ok)
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers map[string]string
+		status  int
+		error   string
+	}{
+		{name: "valid user ID", method: http.MethodGet, target: "/v1/user/1", status: http.StatusOK},
+		{name: "negative user ID", method: http.MethodGet, target: "/v1/user/-1", status: http.StatusOK},
+		{name: "non integer user ID", method: http.MethodGet, target: "/v1/user/abc", status: http.StatusBadRequest,
+			error: `Invalid path parameter "id": must be a valid integer`},
+		{name: "fractional user ID", method: http.MethodGet, target: "/v1/user/1.5", status: http.StatusBadRequest,
+			error: `Invalid path parameter "id": must be a valid integer`},
+		{name: "known action type", method: http.MethodGet, target: "/v1/actions/REFER_USER/next", status: http.StatusOK},
+		{name: "unknown action type", method: http.MethodGet, target: "/v1/actions/DANCE/next", status: http.StatusBadRequest,
+			error: `Invalid path parameter "actionType": must be one of ADD_CONTACT, CONNECT_CRM, EDIT_CONTACT, REFER_USER, VIEW_CONTACTS, WELCOME`},
+		{name: "optional header", method: http.MethodPost, target: "/v1/actions/bulk", status: http.StatusOK},
+		{name: "header too long", method: http.MethodPost, target: "/v1/actions/bulk", status: http.StatusBadRequest,
+			headers: map[string]string{"Idempotency-Key": strings.Repeat("k", 256)},
+			error:   `Invalid header parameter "Idempotency-Key": must be at most 255 characters`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			resp, _ := app.Test(req, -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			if tt.error != "" {
+				body, _ := io.ReadAll(resp.Body)
+				var errResp utils.ErrorResponse
+				assert.NoError(t, json.Unmarshal(body, &errResp))
+				assert.Equal(t, tt.error, errResp.Error)
+			}
+		})
+	}
+}
+
+func TestValidator_RouteWithoutParameters(t *testing.T) {
+	doc, err := Load()
+	assert.NoError(t, err)
+	validator := NewValidator(doc)
+
+	assert.Nil(t, validator.Route(fiber.MethodGet, "/healthz"))
+	assert.Nil(t, validator.Route(fiber.MethodGet, "/undocumented"))
+}
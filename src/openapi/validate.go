@@ -0,0 +1,112 @@
+package openapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Validator checks the parameters of requests against the OpenAPI document
+// before they reach the handlers
+type Validator struct {
+	doc *Document
+}
+
+func NewValidator(doc *Document) *Validator {
+	return &Validator{doc: doc}
+}
+
+// Route returns the middleware validating the path, query and header parameters
+// of the operation documented for method and route, or nil when it has none
+func (v *Validator) Route(method, route string) fiber.Handler {
+	op, ok := v.doc.Operation(method, route)
+	if !ok || len(op.Parameters) == 0 {
+		return nil
+	}
+
+	params := make([]Parameter, len(op.Parameters))
+	for i, param := range op.Parameters {
+		param.Schema = v.doc.resolve(param.Schema)
+		params[i] = param
+	}
+
+	return func(c *fiber.Ctx) error {
+		for _, param := range params {
+			value, present := paramValue(c, param)
+			if !present {
+				if param.Required {
+					return utils.JsonError(c, fiber.StatusBadRequest,
+						fmt.Sprintf("Missing %s parameter %q", param.In, param.Name))
+				}
+				continue
+			}
+
+			if err := param.Schema.validate(value); err != nil {
+				return utils.JsonError(c, fiber.StatusBadRequest,
+					fmt.Sprintf("Invalid %s parameter %q: %s", param.In, param.Name, err))
+			}
+		}
+
+		return c.Next()
+	}
+}
+
+func paramValue(c *fiber.Ctx, param Parameter) (string, bool) {
+	var value string
+	switch param.In {
+	case "path":
+		value = c.Params(param.Name)
+	case "query":
+		value = c.Query(param.Name)
+	case "header":
+		value = c.Get(param.Name)
+	}
+	return value, value != ""
+}
+
+// validate checks a parameter value against the schema
+func (s Schema) validate(value string) error {
+	switch s.Type {
+	case "integer", "number":
+		n, err := parseNumber(s.Type, value)
+		if err != nil {
+			return fmt.Errorf("must be a valid %s", s.Type)
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("must be at most %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("must be a boolean")
+		}
+	}
+
+	if s.MaxLength != nil && len(value) > *s.MaxLength {
+		return fmt.Errorf("must be at most %d characters", *s.MaxLength)
+	}
+
+	if len(s.Enum) > 0 {
+		for _, allowed := range s.Enum {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(s.Enum, ", "))
+	}
+
+	return nil
+}
+
+func parseNumber(typ, value string) (float64, error) {
+	if typ == "integer" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		return float64(n), err
+	}
+	return strconv.ParseFloat(value, 64)
+}
@@ -1,26 +1,101 @@
 package action
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/AntonioDaria/surfe/src/models"
+	"go.opentelemetry.io/otel"
 )
 
-var ErrUserNotFound = fmt.Errorf("user not found")
+var (
+	ErrUserNotFound   = fmt.Errorf("user not found")
+	ErrActionNotFound = fmt.Errorf("action not found")
+)
+
+var tracer = otel.Tracer("github.com/AntonioDaria/surfe/src/repository/action")
+
+// cancelCheckInterval is how many users are sorted between checks for cancellation
+const cancelCheckInterval = 64
 
 //go:generate mockgen -source=$GOFILE -destination=mock/action_repository_mock.go -package=mock
 type Repository interface {
-	CountActionsByUserID(userID int) int
-	UserExists(userID int) bool
-	GetSortedActions() []models.Action
-	GetAllActions() []models.Action
+	CountActionsByUserID(ctx context.Context, userID int) int
+	CountActionsByUserIDs(ctx context.Context, userIDs []int) map[int]int
+	GetSortedActions(ctx context.Context) ([]models.Action, error)
+	GetAllActions(ctx context.Context) []models.Action
+	GetAction(ctx context.Context, actionID int) (*models.Action, error)
+	GetActionsByUserIDs(ctx context.Context, userIDs []int) map[int][]models.Action
+	GetReferralsOf(ctx context.Context, userIDs []int) map[int][]models.Action
+	GetStoredActionsOf(ctx context.Context, userID int) []models.Action
+	AddActions(ctx context.Context, actions []models.Action) ([]models.Action, error)
+	Version(ctx context.Context) uint64
+	NextActionCounts(ctx context.Context, actionType models.ActionType) (map[models.ActionType]int, error)
+	GetReferrals(ctx context.Context) []Referral
+	DeleteActions(ctx context.Context, actionIDs []int, at time.Time) ([]models.Action, error)
+	DeleteUserActions(ctx context.Context, userID int, at time.Time) ([]models.Action, error)
+	EraseUser(ctx context.Context, userID int) ([]models.Action, error)
+}
+
+// IsTombstone reports whether a user ID is the tombstone an erased user's actions
+// were moved to rather than a user. Tombstones are negative, unlike user IDs.
+func IsTombstone(userID int) bool {
+	return userID < 0
+}
+
+// Publisher is notified of every batch of actions stored by the repository
+type Publisher interface {
+	Publish(actions ...models.Action)
 }
 
 type RepositoryImpl struct {
+	// Actions holds every action in the order they were stored, soft deleted ones included
 	Actions []models.Action
+
+	mu sync.RWMutex
+	// deleted is how many of the actions were soft deleted
+	deleted int
+	// live holds the actions that weren't deleted, once there are deleted ones
+	live   []models.Action
+	nextID int
+	// version is bumped on every write, so results derived from the actions can be cached
+	version uint64
+	// publisher is notified of new actions, in the order they are stored
+	publisher Publisher
+	// publishMu is taken before mu is released, so actions are published
+	// outside the lock but still in the order they were stored
+	publishMu sync.Mutex
+
+	// log makes stored actions durable, when the repository was opened from one
+	log *Log
+	// snapshotPath is where Snapshot saves the derived state
+	snapshotPath string
+	recovery     Recovery
+
+	// derivedMu guards the derived state and the index
+	derivedMu sync.Mutex
+	derived   *derivedState
+	index     *actionIndex
+}
+
+// Recovery describes how the repository was loaded from its log
+type Recovery struct {
+	LogRecovery
+	// Seeded is set when the log was empty and filled from the seed file
+	Seeded bool
+	// SnapshotActions is how many actions the derived state was restored for
+	// from the snapshot, zero when there was no usable snapshot
+	SnapshotActions int
+	// SnapshotErr is why the snapshot couldn't be used, if there was one
+	SnapshotErr error
 }
 
 // NewActionRepo loads action data from a JSON file and initializes ActionRepo
@@ -35,48 +110,656 @@ func NewActionRepo(filePath string) (*RepositoryImpl, error) {
 		return nil, fmt.Errorf("failed to unmarshal action data: %w", err)
 	}
 
-	return &RepositoryImpl{Actions: actions}, nil
+	return newRepo(actions), nil
 }
 
-// CountActionsByUserID counts the number of actions performed by a user
-func (r *RepositoryImpl) CountActionsByUserID(userID int) int {
-	count := 0
-	for _, action := range r.Actions {
-		if action.UserID == userID {
-			count++
+// newRepo returns a repository of the given actions, some of which may be deleted
+func newRepo(actions []models.Action) *RepositoryImpl {
+	r := &RepositoryImpl{Actions: actions}
+	for _, action := range actions {
+		if action.DeletedAt != nil {
+			r.deleted++
 		}
 	}
-	return count
+	if r.deleted > 0 {
+		r.live = liveActions(actions, r.deleted)
+	}
+	return r
 }
 
-// UserExists checks if a user has performed any actions
-func (r *RepositoryImpl) UserExists(userID int) bool {
-	for _, action := range r.Actions {
-		if action.UserID == userID {
-			return true
+// liveActions returns a copy of the actions without the deleted ones
+func liveActions(actions []models.Action, deleted int) []models.Action {
+	live := make([]models.Action, 0, len(actions)-deleted)
+	for _, action := range actions {
+		if action.DeletedAt == nil {
+			live = append(live, action)
+		}
+	}
+	return live
+}
+
+// visibleLocked returns the actions that weren't deleted. The read lock must be held.
+func (r *RepositoryImpl) visibleLocked() []models.Action {
+	if r.deleted == 0 {
+		return r.Actions
+	}
+	return r.live
+}
+
+// OpenActionRepo loads the actions from the append-only log at logPath, which is
+// the source of truth for them. An empty log is seeded with the JSON file at
+// seedPath. The derived state is restored from the snapshot at snapshotPath, if
+// it matches the log, so only the actions stored after it are folded in again.
+func OpenActionRepo(logPath, snapshotPath, seedPath string) (*RepositoryImpl, error) {
+	log, payloads, logRecovery, err := OpenLog(logPath)
+	if err != nil {
+		return nil, err
+	}
+
+	replayed := replay{lastChange: -1}
+	seeded := false
+	if len(payloads) == 0 {
+		seed, err := NewActionRepo(seedPath)
+		if err != nil {
+			log.Close()
+			return nil, err
+		}
+		if err := log.AppendActions(seed.Actions); err != nil {
+			log.Close()
+			return nil, err
+		}
+		replayed.actions = seed.Actions
+		seeded = true
+	} else if replayed, err = replayActions(payloads); err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	r := newRepo(replayed.actions)
+	r.log = log
+	r.snapshotPath = snapshotPath
+	r.recovery = Recovery{LogRecovery: logRecovery, Seeded: seeded}
+
+	// Logs written before erasures rewrote the log still hold the erased users
+	if replayed.erased {
+		if err := r.rewriteLog(r.Actions); err != nil {
+			log.Close()
+			return nil, err
+		}
+		replayed.lastChange = -1
+	}
+
+	if snapshotPath != "" {
+		r.restoreSnapshot(replayed.lastChange)
+	}
+
+	// Fold in the actions stored after the snapshot while nothing else is running
+	r.derivedMu.Lock()
+	r.stateLocked()
+	r.derivedMu.Unlock()
+
+	return r, nil
+}
+
+// restoreSnapshot takes the derived state from the snapshot when it was saved
+// from the same log. Only actions can be folded in after it, so it is not used
+// when actions were deleted or erased since it was saved.
+func (r *RepositoryImpl) restoreSnapshot(lastChange int) {
+	s, err := readSnapshot(r.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		r.recovery.SnapshotErr = err
+		return
+	}
+
+	applied := s.State.Applied
+	if applied == 0 || applied > len(r.Actions) || r.Actions[applied-1].ID != s.LastID || s.Records > r.log.Records() {
+		r.recovery.SnapshotErr = fmt.Errorf("%w: it doesn't match the log", ErrCorruptSnapshot)
+		return
+	}
+	if lastChange >= s.Records {
+		r.recovery.SnapshotErr = errors.New("actions were deleted or erased after the snapshot was saved")
+		return
+	}
+
+	r.derived = s.State
+	r.recovery.SnapshotActions = applied
+}
+
+// Recovery describes how the repository was loaded by OpenActionRepo
+func (r *RepositoryImpl) Recovery() Recovery {
+	return r.recovery
+}
+
+// Snapshot saves the derived state of the actions stored so far, so it doesn't
+// have to be recomputed from the whole log on the next start. It does nothing
+// when the repository has no snapshot file.
+func (r *RepositoryImpl) Snapshot(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "action.Repository.Snapshot")
+	defer span.End()
+
+	if r.snapshotPath == "" {
+		return nil
+	}
+
+	r.mu.RLock()
+	r.derivedMu.Lock()
+	state := r.stateLocked()
+	err := state.ensureTransitions(ctx, r.Actions)
+	var s snapshot
+	if err == nil && state.Applied > 0 {
+		s = snapshot{LastID: r.Actions[state.Applied-1].ID, Records: r.log.Records(), State: state.clone()}
+	}
+	r.derivedMu.Unlock()
+	r.mu.RUnlock()
+
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if s.State == nil {
+		return nil
+	}
+
+	if err := writeSnapshot(r.snapshotPath, s); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// Close closes the log of a repository opened with OpenActionRepo
+func (r *RepositoryImpl) Close() error {
+	if r.log == nil {
+		return nil
+	}
+	return r.log.Close()
+}
+
+// stateLocked returns the derived state, folding in the actions stored since it
+// was last used. The read lock and derivedMu must be held.
+func (r *RepositoryImpl) stateLocked() *derivedState {
+	// Actions are only ever appended, fewer actions means they were replaced
+	if r.derived == nil || r.derived.Applied > len(r.Actions) {
+		r.derived = newDerivedState()
+	}
+	r.derived.fold(r.Actions)
+
+	return r.derived
+}
+
+// indexLocked returns the index of the actions, indexing the actions stored
+// since it was last used. The read lock and derivedMu must be held.
+func (r *RepositoryImpl) indexLocked() *actionIndex {
+	if r.index == nil || r.index.applied > len(r.Actions) {
+		r.index = newActionIndex()
+	}
+	r.index.fold(r.Actions)
+
+	return r.index
+}
+
+// SetPublisher sets the publisher notified of the actions added from now on
+func (r *RepositoryImpl) SetPublisher(publisher Publisher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.publisher = publisher
+}
+
+// CountActionsByUserID counts the number of actions performed by a user
+func (r *RepositoryImpl) CountActionsByUserID(ctx context.Context, userID int) int {
+	_, span := tracer.Start(ctx, "action.Repository.CountActionsByUserID")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	r.derivedMu.Lock()
+	defer r.derivedMu.Unlock()
+
+	return r.stateLocked().Counts[userID]
+}
+
+// CountActionsByUserIDs counts the actions of several users at once, keyed by
+// user ID. Users without actions are left out.
+func (r *RepositoryImpl) CountActionsByUserIDs(ctx context.Context, userIDs []int) map[int]int {
+	_, span := tracer.Start(ctx, "action.Repository.CountActionsByUserIDs")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	r.derivedMu.Lock()
+	defer r.derivedMu.Unlock()
+
+	state := r.stateLocked()
+	counts := make(map[int]int, len(userIDs))
+	for _, id := range userIDs {
+		if count := state.Counts[id]; count > 0 {
+			counts[id] = count
 		}
 	}
-	return false
+	return counts
 }
 
 // GetSortedActions returns all actions sorted by user and timestamp.
 // This allows to analyze the sequence of actions by user.
-func (r *RepositoryImpl) GetSortedActions() []models.Action {
-	sortedActions := make([]models.Action, len(r.Actions))
-	copy(sortedActions, r.Actions)
+// It stops early with the context's error if the context is cancelled.
+func (r *RepositoryImpl) GetSortedActions(ctx context.Context) ([]models.Action, error) {
+	_, span := tracer.Start(ctx, "action.Repository.GetSortedActions")
+	defer span.End()
+
+	// Group actions by user, so each user's actions can be sorted on their own
+	// and cancellation checked in between
+	r.mu.RLock()
+	visible := r.visibleLocked()
+	byUser := make(map[int][]models.Action)
+	for _, action := range visible {
+		byUser[action.UserID] = append(byUser[action.UserID], action)
+	}
+	total := len(visible)
+	r.mu.RUnlock()
+
+	userIDs := make([]int, 0, len(byUser))
+	for userID := range byUser {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Ints(userIDs)
 
 	// Sort actions by UserID and then by Timestamp within each UserID
-	sort.Slice(sortedActions, func(i, j int) bool {
-		if sortedActions[i].UserID == sortedActions[j].UserID {
-			return sortedActions[i].CreatedAt.Before(sortedActions[j].CreatedAt)
+	sortedActions := make([]models.Action, 0, total)
+	for i, userID := range userIDs {
+		if i%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				span.RecordError(err)
+				return nil, err
+			}
 		}
-		return sortedActions[i].UserID < sortedActions[j].UserID
-	})
 
-	return sortedActions
+		actions := byUser[userID]
+		sort.SliceStable(actions, func(i, j int) bool {
+			return actions[i].CreatedAt.Before(actions[j].CreatedAt)
+		})
+		sortedActions = append(sortedActions, actions...)
+	}
+
+	return sortedActions, nil
 }
 
-// GetAllActions returns all actions
-func (r *RepositoryImpl) GetAllActions() []models.Action {
-	return r.Actions
+// GetAllActions returns a copy of all actions that weren't deleted, which callers
+// may keep and modify without affecting the repository
+func (r *RepositoryImpl) GetAllActions(ctx context.Context) []models.Action {
+	_, span := tracer.Start(ctx, "action.Repository.GetAllActions")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.visibleLocked())
+}
+
+// GetAction returns an action. It returns ErrActionNotFound when the action
+// doesn't exist or was deleted.
+func (r *RepositoryImpl) GetAction(ctx context.Context, actionID int) (*models.Action, error) {
+	_, span := tracer.Start(ctx, "action.Repository.GetAction")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, action := range r.Actions {
+		if action.ID == actionID && action.DeletedAt == nil {
+			return &action, nil
+		}
+	}
+	return nil, ErrActionNotFound
+}
+
+// GetActionsByUserIDs returns the actions of several users that weren't deleted,
+// keyed by user ID in the order they were stored. Users without actions are left out.
+func (r *RepositoryImpl) GetActionsByUserIDs(ctx context.Context, userIDs []int) map[int][]models.Action {
+	_, span := tracer.Start(ctx, "action.Repository.GetActionsByUserIDs")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	r.derivedMu.Lock()
+	defer r.derivedMu.Unlock()
+
+	index := r.indexLocked()
+	result := make(map[int][]models.Action, len(userIDs))
+	for _, id := range userIDs {
+		if actions := collect(r.Actions, index.byUser[id]); len(actions) > 0 {
+			result[id] = actions
+		}
+	}
+	return result
+}
+
+// GetReferralsOf returns the REFER_USER actions referring each of the given users
+// that weren't deleted, keyed by the referred user in the order they were stored.
+// Users nobody referred are left out.
+func (r *RepositoryImpl) GetReferralsOf(ctx context.Context, userIDs []int) map[int][]models.Action {
+	_, span := tracer.Start(ctx, "action.Repository.GetReferralsOf")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	r.derivedMu.Lock()
+	defer r.derivedMu.Unlock()
+
+	index := r.indexLocked()
+	result := make(map[int][]models.Action, len(userIDs))
+	for _, id := range userIDs {
+		if referrals := collect(r.Actions, index.referralsOf[id]); len(referrals) > 0 {
+			result[id] = referrals
+		}
+	}
+	return result
+}
+
+// GetStoredActionsOf returns every action involving a user, the ones they
+// performed and the referrals of them, in the order they were stored. Unlike
+// the other reads, soft deleted actions are included.
+func (r *RepositoryImpl) GetStoredActionsOf(ctx context.Context, userID int) []models.Action {
+	_, span := tracer.Start(ctx, "action.Repository.GetStoredActionsOf")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	r.derivedMu.Lock()
+	defer r.derivedMu.Unlock()
+
+	index := r.indexLocked()
+	positions := append(slices.Clone(index.byUser[userID]), index.referralsOf[userID]...)
+	slices.Sort(positions)
+
+	// A user referring themselves is indexed twice
+	positions = slices.Compact(positions)
+	actions := make([]models.Action, len(positions))
+	for i, pos := range positions {
+		actions[i] = r.Actions[pos]
+	}
+	return actions
+}
+
+// NextActionCounts returns how many times each action type was performed after
+// actionType by the same user, before they performed actionType again.
+// It stops early with the context's error if the context is cancelled.
+func (r *RepositoryImpl) NextActionCounts(ctx context.Context, actionType models.ActionType) (map[models.ActionType]int, error) {
+	ctx, span := tracer.Start(ctx, "action.Repository.NextActionCounts")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	r.derivedMu.Lock()
+	defer r.derivedMu.Unlock()
+
+	state := r.stateLocked()
+	if err := state.ensureTransitions(ctx, r.Actions); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	counts := maps.Clone(state.Transitions[actionType])
+	if counts == nil {
+		counts = make(map[models.ActionType]int)
+	}
+	return counts, nil
+}
+
+// GetReferrals returns every referral in the order they were stored, followed
+// by its removal when it was deleted
+func (r *RepositoryImpl) GetReferrals(ctx context.Context) []Referral {
+	_, span := tracer.Start(ctx, "action.Repository.GetReferrals")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	r.derivedMu.Lock()
+	defer r.derivedMu.Unlock()
+
+	// Referrals are only appended, so the slice handed out never changes
+	referrals := r.stateLocked().Referrals
+	return referrals[:len(referrals):len(referrals)]
+}
+
+// Count returns the number of actions that weren't deleted
+func (r *RepositoryImpl) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.visibleLocked())
+}
+
+// AddActions stores a batch of actions in a single step, assigning each one
+// a new ID. Either the whole batch becomes visible to readers or none of it.
+func (r *RepositoryImpl) AddActions(ctx context.Context, actions []models.Action) ([]models.Action, error) {
+	_, span := tracer.Start(ctx, "action.Repository.AddActions")
+	defer span.End()
+
+	r.mu.Lock()
+
+	if r.nextID == 0 {
+		for _, action := range r.Actions {
+			if action.ID >= r.nextID {
+				r.nextID = action.ID + 1
+			}
+		}
+	}
+
+	stored := make([]models.Action, len(actions))
+	for i, action := range actions {
+		action.ID = r.nextID
+		r.nextID++
+		stored[i] = action
+	}
+
+	// Actions become visible once they are durable
+	if r.log != nil {
+		if err := r.log.AppendActions(stored); err != nil {
+			r.nextID -= len(stored)
+			r.mu.Unlock()
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	r.Actions = append(r.Actions, stored...)
+	if r.deleted > 0 {
+		r.live = append(r.live, stored...)
+	}
+	r.version++
+	publisher := r.publisher
+
+	// Readers aren't held up by subscribers, while the batches stored after
+	// this one wait for it to be published first
+	r.publishMu.Lock()
+	r.mu.Unlock()
+	defer r.publishMu.Unlock()
+
+	if publisher != nil {
+		publisher.Publish(stored...)
+	}
+	return stored, nil
+}
+
+// DeleteActions soft deletes the actions with the given IDs and returns them as
+// deleted. Actions that don't exist or were already deleted are skipped.
+func (r *RepositoryImpl) DeleteActions(ctx context.Context, actionIDs []int, at time.Time) ([]models.Action, error) {
+	_, span := tracer.Start(ctx, "action.Repository.DeleteActions")
+	defer span.End()
+
+	wanted := make(map[int]bool, len(actionIDs))
+	for _, id := range actionIDs {
+		wanted[id] = true
+	}
+
+	deleted, err := r.deleteActions(func(action models.Action) bool { return wanted[action.ID] }, at)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// DeleteUserActions soft deletes the actions performed by a user along with the
+// referrals of the user, which would otherwise point at a deleted user, and
+// returns them as deleted
+func (r *RepositoryImpl) DeleteUserActions(ctx context.Context, userID int, at time.Time) ([]models.Action, error) {
+	_, span := tracer.Start(ctx, "action.Repository.DeleteUserActions")
+	defer span.End()
+
+	deleted, err := r.deleteActions(func(action models.Action) bool { return action.Involves(userID) }, at)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// deleteActions soft deletes the actions that weren't deleted yet and match, in a
+// single step. Like additions, deletions become visible once they are durable.
+func (r *RepositoryImpl) deleteActions(match func(action models.Action) bool, at time.Time) ([]models.Action, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var positions []int
+	var deleted []models.Action
+	for i, action := range r.Actions {
+		if action.DeletedAt == nil && match(action) {
+			action.DeletedAt = &at
+			positions = append(positions, i)
+			deleted = append(deleted, action)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+
+	if r.log != nil {
+		if err := r.log.AppendDeletions(deleted); err != nil {
+			return nil, err
+		}
+	}
+
+	// The derived state must account for the actions before they are marked deleted,
+	// or removing them would take out actions that were never folded in
+	r.derivedMu.Lock()
+	state := r.stateLocked()
+
+	// Readers may still be going through the actions they were handed, so the
+	// actions are copied rather than changed in place
+	actions := slices.Clone(r.Actions)
+	for i, pos := range positions {
+		actions[pos] = deleted[i]
+	}
+	r.Actions = actions
+	state.remove(deleted)
+	r.derivedMu.Unlock()
+
+	r.deleted += len(deleted)
+	r.live = liveActions(r.Actions, r.deleted)
+	r.version++
+
+	return deleted, nil
+}
+
+// EraseUser moves the actions of a user, and the referrals of them, to a new
+// tombstone in a single step and returns them as moved. The analytics keep
+// counting them, but they no longer lead back to the user. The log is rewritten
+// with the moved actions, so neither the user's ID nor which tombstone they
+// were moved to is left on disk. Erasing a user without actions does nothing.
+func (r *RepositoryImpl) EraseUser(ctx context.Context, userID int) ([]models.Action, error) {
+	_, span := tracer.Start(ctx, "action.Repository.EraseUser")
+	defer span.End()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Tombstones are numbered downwards from -1, so each erased user gets their own
+	tombstone := -1
+	var positions []int
+	for i, action := range r.Actions {
+		tombstone = min(tombstone, action.UserID-1)
+		if action.Type == models.ActionTypeReferUser {
+			tombstone = min(tombstone, action.TargetUser-1)
+		}
+		if action.Involves(userID) {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) == 0 {
+		return nil, nil
+	}
+
+	// As with deletions, readers may still be going through the actions
+	actions := slices.Clone(r.Actions)
+	moved := make([]models.Action, len(positions))
+	for i, pos := range positions {
+		moved[i] = rekey(actions[pos], userID, tombstone)
+		actions[pos] = moved[i]
+	}
+
+	if r.log != nil {
+		if err := r.rewriteLog(actions); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	r.derivedMu.Lock()
+	state := r.stateLocked()
+	state.rekey(userID, tombstone, moved)
+	r.Actions = actions
+	// The moved actions are indexed under the user, so the index is rebuilt
+	r.index = nil
+	r.derivedMu.Unlock()
+
+	if r.deleted > 0 {
+		r.live = liveActions(r.Actions, r.deleted)
+	}
+	r.version++
+
+	return moved, nil
+}
+
+// rewriteLog replaces the log with the given actions. The snapshot is removed
+// first, as it could match the rewritten log while still holding what was
+// taken out of it. The next one is saved from the actions as rewritten.
+func (r *RepositoryImpl) rewriteLog(actions []models.Action) error {
+	if r.snapshotPath != "" {
+		if err := os.Remove(r.snapshotPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove action snapshot: %w", err)
+		}
+	}
+	return r.log.RewriteActions(actions)
+}
+
+// rekey returns the action with the user from replaced by the user to, both as
+// the user who performed it and as the user it refers
+func rekey(action models.Action, from, to int) models.Action {
+	if action.UserID == from {
+		action.UserID = to
+	}
+	if action.Type == models.ActionTypeReferUser && action.TargetUser == from {
+		action.TargetUser = to
+	}
+	return action
+}
+
+// Version returns the dataset version, which changes whenever actions are added or deleted
+func (r *RepositoryImpl) Version(ctx context.Context) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.version
 }
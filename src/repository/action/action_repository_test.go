@@ -1,6 +1,11 @@
 package action
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
 	"reflect"
 	"testing"
 	"time"
@@ -25,7 +30,7 @@ func Test_Count_Actions_By_User_ID(t *testing.T) {
 	actionRepo := loadActionRepo(t)
 
 	// Act
-	actions := actionRepo.CountActionsByUserID(1)
+	actions := actionRepo.CountActionsByUserID(context.Background(), 1)
 
 	// Assert
 	if actions != 49 {
@@ -33,29 +38,29 @@ func Test_Count_Actions_By_User_ID(t *testing.T) {
 	}
 }
 
-func Test_User_Exists(t *testing.T) {
+func Test_Count_Actions_By_User_IDs(t *testing.T) {
 	// Arrange
 	actionRepo := loadActionRepo(t)
 
 	// Act
-	userExists := actionRepo.UserExists(1)
+	counts := actionRepo.CountActionsByUserIDs(context.Background(), []int{1, 1000})
 
 	// Assert
-	if !userExists {
-		t.Fatal("expected user to exist")
+	if !reflect.DeepEqual(counts, map[int]int{1: 49}) {
+		t.Fatalf("expected the count of user 1 only, got %v", counts)
 	}
 }
 
-func Test_User_Does_Not_Exist(t *testing.T) {
+func Test_Count_Actions_By_User_ID_Without_Actions(t *testing.T) {
 	// Arrange
 	actionRepo := loadActionRepo(t)
 
 	// Act
-	userExists := actionRepo.UserExists(1000)
+	actions := actionRepo.CountActionsByUserID(context.Background(), 1000)
 
 	// Assert
-	if userExists {
-		t.Fatal("expected user to not exist")
+	if actions != 0 {
+		t.Fatalf("expected user to have no actions, got %d", actions)
 	}
 }
 
@@ -64,9 +69,12 @@ func Test_Get_Sorted_Actions(t *testing.T) {
 	actionRepo := loadActionRepo(t)
 
 	// Act
-	actions := actionRepo.GetSortedActions()
+	actions, err := actionRepo.GetSortedActions(context.Background())
 
 	// Assert
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
 	if len(actions) != 22938 {
 		t.Fatalf("expected actions count to be 22938, got %d", len(actions))
 	}
@@ -84,7 +92,7 @@ func Test_Get_All_Actions(t *testing.T) {
 	actionRepo := loadActionRepo(t)
 
 	// Act
-	actions := actionRepo.GetAllActions()
+	actions := actionRepo.GetAllActions(context.Background())
 
 	// Assert
 	if len(actions) != 22938 {
@@ -92,6 +100,64 @@ func Test_Get_All_Actions(t *testing.T) {
 	}
 }
 
+func Test_Get_All_Actions_Copy(t *testing.T) {
+	// Arrange
+	actionRepo := loadActionRepo(t)
+	ctx := context.Background()
+
+	// Act
+	actions := actionRepo.GetAllActions(ctx)
+	actions[0].UserID = -42
+
+	// Assert
+	if actionRepo.GetAllActions(ctx)[0].UserID == -42 {
+		t.Fatal("expected changes to the returned actions to leave the repository unchanged")
+	}
+}
+
+func TestRepositoryImpl_GetActionsByUserIDs(t *testing.T) {
+	time1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	actionRepo := &RepositoryImpl{Actions: []models.Action{
+		{ID: 1, UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2, CreatedAt: time1},
+		{ID: 2, UserID: 2, Type: models.ActionTypeWelcome, CreatedAt: time1},
+		{ID: 3, UserID: 3, Type: models.ActionTypeReferUser, TargetUser: 2, CreatedAt: time1},
+	}}
+	ctx := context.Background()
+
+	want := map[int][]models.Action{1: {actionRepo.Actions[0]}, 2: {actionRepo.Actions[1]}}
+	if got := actionRepo.GetActionsByUserIDs(ctx, []int{1, 2, 4}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetActionsByUserIDs() = %v, want %v", got, want)
+	}
+	want = map[int][]models.Action{2: {actionRepo.Actions[0], actionRepo.Actions[2]}}
+	if got := actionRepo.GetReferralsOf(ctx, []int{1, 2}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetReferralsOf() = %v, want %v", got, want)
+	}
+
+	// Actions stored, deleted or erased later are reflected in the index
+	added, err := actionRepo.AddActions(ctx, []models.Action{{UserID: 1, Type: models.ActionTypeAddContact, CreatedAt: time1}})
+	if err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+	if _, err := actionRepo.DeleteActions(ctx, []int{1}, time1); err != nil {
+		t.Fatalf("failed to delete actions: %v", err)
+	}
+	want = map[int][]models.Action{1: added}
+	if got := actionRepo.GetActionsByUserIDs(ctx, []int{1}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetActionsByUserIDs() = %v, want %v", got, want)
+	}
+
+	if _, err := actionRepo.EraseUser(ctx, 3); err != nil {
+		t.Fatalf("failed to erase user: %v", err)
+	}
+	if got := actionRepo.GetActionsByUserIDs(ctx, []int{3}); len(got) != 0 {
+		t.Fatalf("expected the erased user to have no actions, got %v", got)
+	}
+	// The referral made by the erased user now comes from their tombstone
+	if got := actionRepo.GetReferralsOf(ctx, []int{2}); len(got[2]) != 1 || !IsTombstone(got[2][0].UserID) {
+		t.Fatalf("expected the referral to come from a tombstone, got %v", got)
+	}
+}
+
 func TestRepositoryImpl_GetSortedActions(t *testing.T) {
 	// Define the time format and parse timestamps
 	timeFormat := "2006-01-02T15:04:05Z"
@@ -172,9 +238,636 @@ func TestRepositoryImpl_GetSortedActions(t *testing.T) {
 			r := &RepositoryImpl{
 				Actions: tt.fields.actions,
 			}
-			if got := r.GetSortedActions(); !reflect.DeepEqual(got, tt.want) {
+			got, err := r.GetSortedActions(context.Background())
+			if err != nil {
+				t.Fatalf("RepositoryImpl.GetSortedActions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("RepositoryImpl.GetSortedActions() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_Get_Sorted_Actions_Cancelled(t *testing.T) {
+	// Arrange
+	actionRepo := loadActionRepo(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	actions, err := actionRepo.GetSortedActions(ctx)
+
+	// Assert
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if actions != nil {
+		t.Fatalf("expected no actions, got %d", len(actions))
+	}
+}
+
+func TestRepositoryImpl_AddActions(t *testing.T) {
+	actionRepo := &RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 4, UserID: 1, Type: models.ActionTypeAddContact},
+			{ID: 7, UserID: 2, Type: models.ActionTypeViewContacts},
+		},
+	}
+
+	version := actionRepo.Version(context.Background())
+
+	stored, err := actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 1, Type: models.ActionTypeEditContact},
+		{ID: 1, UserID: 3, Type: models.ActionTypeWelcome},
+	})
+	if err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+
+	// IDs are assigned by the repository, continuing after the highest existing ID
+	if stored[0].ID != 8 || stored[1].ID != 9 {
+		t.Fatalf("expected IDs 8 and 9, got %d and %d", stored[0].ID, stored[1].ID)
+	}
+
+	if actionRepo.Count() != 4 {
+		t.Fatalf("expected 4 actions, got %d", actionRepo.Count())
+	}
+
+	if actionRepo.CountActionsByUserID(context.Background(), 3) != 1 {
+		t.Fatalf("expected user 3 to have 1 action")
+	}
+
+	// Writes change the dataset version
+	if actionRepo.Version(context.Background()) == version {
+		t.Fatalf("expected the version to change after adding actions")
+	}
+}
+
+// recordingPublisher records the actions published by the repository
+type recordingPublisher struct {
+	published []models.Action
+}
+
+func (p *recordingPublisher) Publish(actions ...models.Action) {
+	p.published = append(p.published, actions...)
+}
+
+func TestRepositoryImpl_AddActions_Publishes(t *testing.T) {
+	actionRepo := &RepositoryImpl{}
+	publisher := &recordingPublisher{}
+	actionRepo.SetPublisher(publisher)
+
+	stored, err := actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 1, Type: models.ActionTypeWelcome},
+		{UserID: 1, Type: models.ActionTypeAddContact},
+	})
+	if err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+
+	// Subscribers see the stored actions, with their assigned IDs
+	if !reflect.DeepEqual(publisher.published, stored) {
+		t.Fatalf("expected %v to be published, got %v", stored, publisher.published)
+	}
+}
+
+// nextActionCounts counts the actions following actionType in the sorted actions, from scratch
+func nextActionCounts(sorted []models.Action, actionType models.ActionType) map[models.ActionType]int {
+	counts := make(map[models.ActionType]int)
+	for i, current := range sorted {
+		if current.Type != actionType {
+			continue
+		}
+		for _, next := range sorted[i+1:] {
+			if next.UserID != current.UserID || next.Type == actionType {
+				break
+			}
+			counts[next.Type]++
+		}
+	}
+	return counts
+}
+
+func TestRepositoryImpl_NextActionCounts(t *testing.T) {
+	actionRepo := loadActionRepo(t)
+
+	check := func() {
+		t.Helper()
+
+		sorted, err := actionRepo.GetSortedActions(context.Background())
+		if err != nil {
+			t.Fatalf("failed to sort actions: %v", err)
+		}
+		for _, actionType := range models.ActionTypes() {
+			counts, err := actionRepo.NextActionCounts(context.Background(), actionType)
+			if err != nil {
+				t.Fatalf("failed to count next actions: %v", err)
+			}
+			if want := nextActionCounts(sorted, actionType); !reflect.DeepEqual(counts, want) {
+				t.Fatalf("expected %v after %s, got %v", want, actionType, counts)
+			}
+		}
+	}
+	check()
+
+	// New actions are folded in, including ones older than the user's latest action
+	_, err := actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 1, Type: models.ActionTypeConnectCRM, CreatedAt: time.Now()},
+		{UserID: 1, Type: models.ActionTypeAddContact, CreatedAt: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+	check()
+
+	_, err = actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 2, Type: models.ActionTypeWelcome, CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+	check()
+}
+
+func TestOpenActionRepo(t *testing.T) {
+	dir := t.TempDir()
+	logPath, snapshotPath := dir+"/actions.log", dir+"/actions.snapshot"
+
+	// An empty log is seeded with the JSON file
+	actionRepo, err := OpenActionRepo(logPath, snapshotPath, "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to open action repository: %v", err)
+	}
+	if !actionRepo.Recovery().Seeded {
+		t.Fatalf("expected the log to be seeded")
+	}
+	seeded := actionRepo.Count()
+
+	stored, err := actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2, CreatedAt: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+	if err := actionRepo.Snapshot(context.Background()); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+	if _, err := actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 1, Type: models.ActionTypeWelcome, CreatedAt: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+	counts, _ := actionRepo.NextActionCounts(context.Background(), models.ActionTypeReferUser)
+	referrals := actionRepo.GetReferrals(context.Background())
+	actionRepo.Close()
+
+	// Reopening replays the log and restores the state from the snapshot
+	actionRepo, err = OpenActionRepo(logPath, snapshotPath, "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to reopen action repository: %v", err)
+	}
+	defer actionRepo.Close()
+
+	recovery := actionRepo.Recovery()
+	if recovery.Seeded || recovery.SnapshotErr != nil || recovery.SnapshotActions != seeded+1 {
+		t.Fatalf("expected the snapshot of %d actions to be used, got %+v", seeded+1, recovery)
+	}
+	if actionRepo.Count() != seeded+2 {
+		t.Fatalf("expected %d actions, got %d", seeded+2, actionRepo.Count())
+	}
+
+	all := actionRepo.GetAllActions(context.Background())
+	if all[seeded].ID != stored[0].ID {
+		t.Fatalf("expected action %d to be replayed, got %d", stored[0].ID, all[seeded].ID)
+	}
+
+	restored, _ := actionRepo.NextActionCounts(context.Background(), models.ActionTypeReferUser)
+	if !reflect.DeepEqual(restored, counts) {
+		t.Fatalf("expected next action counts %v, got %v", counts, restored)
+	}
+	if !reflect.DeepEqual(actionRepo.GetReferrals(context.Background()), referrals) {
+		t.Fatalf("expected the referrals to be restored")
+	}
+}
+
+func TestOpenActionRepo_IgnoresMismatchedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	other, err := OpenActionRepo(dir+"/other.log", dir+"/actions.snapshot", "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to open action repository: %v", err)
+	}
+	if _, err := other.AddActions(context.Background(), []models.Action{{UserID: 1, Type: models.ActionTypeWelcome}}); err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+	if err := other.Snapshot(context.Background()); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+	other.Close()
+
+	// The snapshot covers an action this log doesn't have
+	actionRepo, err := OpenActionRepo(dir+"/actions.log", dir+"/actions.snapshot", "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to open action repository: %v", err)
+	}
+	defer actionRepo.Close()
+
+	recovery := actionRepo.Recovery()
+	if !errors.Is(recovery.SnapshotErr, ErrCorruptSnapshot) || recovery.SnapshotActions != 0 {
+		t.Fatalf("expected the snapshot to be ignored, got %+v", recovery)
+	}
+	if actionRepo.CountActionsByUserID(context.Background(), 1) != loadActionRepo(t).CountActionsByUserID(context.Background(), 1) {
+		t.Fatalf("expected the counts to be derived from the log")
+	}
+}
+
+func TestSnapshot_LargerThanLogRecords(t *testing.T) {
+	path := t.TempDir() + "/actions.snapshot"
+
+	// The state grows with the users, past the size of a log record
+	state := newDerivedState()
+	for i := 0; i < 100_000; i++ {
+		state.Referrals = append(state.Referrals, Referral{Referrer: i, User: i + 1})
+	}
+	if err := writeSnapshot(path, snapshot{LastID: 1, Records: 1, State: state}); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() <= maxRecordSize {
+		t.Fatalf("expected a snapshot over %d bytes, got %v %v", maxRecordSize, info, err)
+	}
+
+	restored, err := readSnapshot(path)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+	if len(restored.State.Referrals) != len(state.Referrals) {
+		t.Fatalf("expected %d referrals, got %d", len(state.Referrals), len(restored.State.Referrals))
+	}
+}
+
+func TestRepositoryImpl_DeleteActions(t *testing.T) {
+	ctx := context.Background()
+	actionRepo := loadActionRepo(t)
+
+	// Pick a referral, so deleting it also takes it out of the referrals
+	var referral models.Action
+	for _, action := range actionRepo.GetAllActions(ctx) {
+		if action.Type == models.ActionTypeReferUser {
+			referral = action
+			break
+		}
+	}
+	if got, err := actionRepo.GetAction(ctx, referral.ID); err != nil || *got != referral {
+		t.Fatalf("expected action %d, got %+v, %v", referral.ID, got, err)
+	}
+	count := actionRepo.CountActionsByUserID(ctx, referral.UserID)
+	total := actionRepo.Count()
+	version := actionRepo.Version(ctx)
+	referrals := len(actionRepo.GetReferrals(ctx))
+
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	deleted, err := actionRepo.DeleteActions(ctx, []int{referral.ID, -1}, at)
+	if err != nil {
+		t.Fatalf("failed to delete actions: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != referral.ID || !deleted[0].DeletedAt.Equal(at) {
+		t.Fatalf("expected action %d to be deleted, got %+v", referral.ID, deleted)
+	}
+
+	if got := actionRepo.CountActionsByUserID(ctx, referral.UserID); got != count-1 {
+		t.Fatalf("expected %d actions, got %d", count-1, got)
+	}
+	if actionRepo.Count() != total-1 || len(actionRepo.GetAllActions(ctx)) != total-1 {
+		t.Fatalf("expected the deleted action to be left out")
+	}
+	if actionRepo.Version(ctx) == version {
+		t.Fatalf("expected the version to change")
+	}
+
+	got := actionRepo.GetReferrals(ctx)
+	if len(got) != referrals+1 || got[referrals] != (Referral{Referrer: referral.UserID, User: referral.TargetUser, Removed: true}) {
+		t.Fatalf("expected the referral to be removed, got %+v", got[referrals:])
+	}
+
+	// Deleting it again does nothing
+	deleted, err = actionRepo.DeleteActions(ctx, []int{referral.ID}, at)
+	if err != nil || len(deleted) != 0 {
+		t.Fatalf("expected nothing to be deleted, got %+v, %v", deleted, err)
+	}
+	if _, err := actionRepo.GetAction(ctx, referral.ID); !errors.Is(err, ErrActionNotFound) {
+		t.Fatalf("expected the deleted action not to be found, got %v", err)
+	}
+
+	// The transitions are recomputed without the deleted action
+	sorted, err := actionRepo.GetSortedActions(ctx)
+	if err != nil {
+		t.Fatalf("failed to sort actions: %v", err)
+	}
+	for _, actionType := range models.ActionTypes() {
+		counts, err := actionRepo.NextActionCounts(ctx, actionType)
+		if err != nil {
+			t.Fatalf("failed to count next actions: %v", err)
+		}
+		if want := nextActionCounts(sorted, actionType); !reflect.DeepEqual(counts, want) {
+			t.Fatalf("expected %v after %s, got %v", want, actionType, counts)
+		}
+	}
+}
+
+func TestRepositoryImpl_DeleteUserActions(t *testing.T) {
+	ctx := context.Background()
+	actionRepo := loadActionRepo(t)
+
+	stored, err := actionRepo.AddActions(ctx, []models.Action{
+		{UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2, CreatedAt: time.Now()},
+		{UserID: 2, Type: models.ActionTypeWelcome, CreatedAt: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+	count := actionRepo.CountActionsByUserID(ctx, 1)
+
+	deleted, err := actionRepo.DeleteUserActions(ctx, 2, time.Now())
+	if err != nil {
+		t.Fatalf("failed to delete actions: %v", err)
+	}
+
+	// The user's own actions and the referral of them are gone
+	if actionRepo.CountActionsByUserID(ctx, 2) != 0 {
+		t.Fatalf("expected user 2 to have no actions left")
+	}
+	if got := actionRepo.CountActionsByUserID(ctx, 1); got != count-1 {
+		t.Fatalf("expected the referral of user 2 to be deleted, got %d actions instead of %d", got, count-1)
+	}
+	ids := make(map[int]bool)
+	for _, action := range deleted {
+		ids[action.ID] = true
+	}
+	if !ids[stored[0].ID] || !ids[stored[1].ID] {
+		t.Fatalf("expected the new actions to be deleted, got %+v", deleted)
+	}
+	for _, action := range actionRepo.GetAllActions(ctx) {
+		if action.UserID == 2 || (action.Type == models.ActionTypeReferUser && action.TargetUser == 2) {
+			t.Fatalf("expected action %d to be deleted", action.ID)
+		}
+	}
+}
+
+func TestRepositoryImpl_GetStoredActionsOf(t *testing.T) {
+	ctx := context.Background()
+	actionRepo := loadActionRepo(t)
+
+	// A new user, so the seeded actions don't involve them
+	userID := 5000
+	stored, err := actionRepo.AddActions(ctx, []models.Action{
+		{UserID: userID, Type: models.ActionTypeWelcome, CreatedAt: time.Now()},
+		{UserID: 1, Type: models.ActionTypeReferUser, TargetUser: userID, CreatedAt: time.Now()},
+		{UserID: userID, Type: models.ActionTypeReferUser, TargetUser: userID, CreatedAt: time.Now()},
+		{UserID: 1, Type: models.ActionTypeWelcome, CreatedAt: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+
+	deleted, err := actionRepo.DeleteActions(ctx, []int{stored[1].ID}, time.Now())
+	if err != nil {
+		t.Fatalf("failed to delete actions: %v", err)
+	}
+
+	// Deleted actions are still stored, and a user referring themselves is returned once
+	want := []models.Action{stored[0], deleted[0], stored[2]}
+	if got := actionRepo.GetStoredActionsOf(ctx, userID); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestOpenActionRepo_ReplaysDeletions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	logPath, snapshotPath := dir+"/actions.log", dir+"/actions.snapshot"
+
+	actionRepo, err := OpenActionRepo(logPath, snapshotPath, "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to open action repository: %v", err)
+	}
+	if err := actionRepo.Snapshot(ctx); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+	if _, err := actionRepo.DeleteUserActions(ctx, 1, time.Now()); err != nil {
+		t.Fatalf("failed to delete actions: %v", err)
+	}
+	total := actionRepo.Count()
+	referrals := actionRepo.GetReferrals(ctx)
+	actionRepo.Close()
+
+	// The snapshot predates the deletion, so the state is rebuilt from the log
+	actionRepo, err = OpenActionRepo(logPath, snapshotPath, "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to reopen action repository: %v", err)
+	}
+	defer actionRepo.Close()
+
+	recovery := actionRepo.Recovery()
+	if recovery.SnapshotErr == nil || recovery.SnapshotActions != 0 {
+		t.Fatalf("expected the snapshot to be ignored, got %+v", recovery)
+	}
+	if actionRepo.Count() != total || actionRepo.CountActionsByUserID(ctx, 1) != 0 {
+		t.Fatalf("expected the deletions to be replayed")
+	}
+
+	// Referrals deleted before the state was built are never folded in
+	for _, referral := range actionRepo.GetReferrals(ctx) {
+		if referral.Removed || referral.Referrer == 1 || referral.User == 1 {
+			t.Fatalf("expected no referral of user 1, got %+v", referral)
+		}
+	}
+	if len(actionRepo.GetReferrals(ctx)) >= len(referrals) {
+		t.Fatalf("expected fewer referrals than the %d folded in before the deletion", len(referrals))
+	}
+
+	// A snapshot saved after the deletion is used
+	if err := actionRepo.Snapshot(ctx); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+	actionRepo.Close()
+
+	actionRepo, err = OpenActionRepo(logPath, snapshotPath, "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to reopen action repository: %v", err)
+	}
+	if recovery := actionRepo.Recovery(); recovery.SnapshotErr != nil {
+		t.Fatalf("expected the snapshot to be used, got %v", recovery.SnapshotErr)
+	}
+	if actionRepo.CountActionsByUserID(ctx, 1) != 0 {
+		t.Fatalf("expected user 1 to stay deleted")
+	}
+}
+
+func TestRepositoryImpl_EraseUser(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	logPath := dir + "/actions.log"
+	snapshotPath := dir + "/actions.snapshot"
+
+	actionRepo, err := OpenActionRepo(logPath, snapshotPath, "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to open action repository: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	added, err := actionRepo.AddActions(ctx, []models.Action{
+		{UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2, CreatedAt: now},
+		{UserID: 2, Type: models.ActionTypeReferUser, TargetUser: 3, CreatedAt: now},
+		{UserID: 2, Type: models.ActionTypeWelcome, CreatedAt: now},
+	})
+	if err != nil {
+		t.Fatalf("failed to add actions: %v", err)
+	}
+	// Deleted actions are erased too
+	if _, err := actionRepo.DeleteActions(ctx, []int{added[2].ID}, now); err != nil {
+		t.Fatalf("failed to delete action: %v", err)
+	}
+	if err := actionRepo.Snapshot(ctx); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+
+	transitions := make(map[models.ActionType]map[models.ActionType]int)
+	for _, actionType := range models.ActionTypes() {
+		transitions[actionType], _ = actionRepo.NextActionCounts(ctx, actionType)
+	}
+	count := actionRepo.CountActionsByUserID(ctx, 2)
+
+	moved, err := actionRepo.EraseUser(ctx, 2)
+	if err != nil {
+		t.Fatalf("failed to erase user: %v", err)
+	}
+	if len(moved) != count+2 {
+		t.Fatalf("expected the %d actions of user 2, the deleted one and the referral of them to move, got %d", count, len(moved))
+	}
+
+	// The actions are moved to a tombstone that still counts in the analytics
+	tombstone := moved[0].UserID
+	if moved[0].Type == models.ActionTypeReferUser && moved[0].TargetUser < 0 {
+		tombstone = moved[0].TargetUser
+	}
+	if !IsTombstone(tombstone) {
+		t.Fatalf("expected the actions to move to a tombstone, got %+v", moved[0])
+	}
+	if actionRepo.CountActionsByUserID(ctx, 2) != 0 || actionRepo.CountActionsByUserID(ctx, tombstone) != count {
+		t.Fatalf("expected the %d actions of user 2 to belong to tombstone %d", count, tombstone)
+	}
+	for actionType, counts := range transitions {
+		got, _ := actionRepo.NextActionCounts(ctx, actionType)
+		if !reflect.DeepEqual(got, counts) {
+			t.Fatalf("expected the transitions from %s to stay %v, got %v", actionType, counts, got)
+		}
+	}
+
+	for _, action := range actionRepo.GetAllActions(ctx) {
+		if action.Involves(2) {
+			t.Fatalf("expected action %d to no longer involve user 2", action.ID)
+		}
+	}
+
+	// Erasing a user without actions does nothing
+	if moved, err := actionRepo.EraseUser(ctx, 2); err != nil || moved != nil {
+		t.Fatalf("expected erasing user 2 again to do nothing, got %v, %v", moved, err)
+	}
+
+	// Nothing on disk leads back to the user: the log is rewritten without any
+	// of their actions or a record of the erasure, and the snapshot is gone
+	payloads := readLogPayloads(t, logPath)
+	for _, payload := range payloads {
+		var record logRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			t.Fatalf("failed to decode log record: %v", err)
+		}
+		if record.Op != "" || record.Involves(2) {
+			t.Fatalf("expected the log to hold no trace of user 2, got %s", payload)
+		}
+	}
+	if len(payloads) != len(actionRepo.Actions) {
+		t.Fatalf("expected one record per action, got %d for %d actions", len(payloads), len(actionRepo.Actions))
+	}
+	if _, err := os.Stat(snapshotPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the snapshot to be removed, got %v", err)
+	}
+
+	// The erased actions are read back from the log, deletions included
+	actions := actionRepo.GetAllActions(ctx)
+	total := actionRepo.Count()
+	actionRepo.Close()
+	actionRepo, err = OpenActionRepo(logPath, snapshotPath, "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to reopen action repository: %v", err)
+	}
+	defer actionRepo.Close()
+
+	if !reflect.DeepEqual(actionRepo.GetAllActions(ctx), actions) || actionRepo.Count() != total {
+		t.Fatalf("expected the replayed actions to match the erased ones")
+	}
+}
+
+func TestOpenActionRepo_RewritesErasureRecords(t *testing.T) {
+	ctx := context.Background()
+	logPath := t.TempDir() + "/actions.log"
+
+	// Logs written before erasures rewrote the log record the user and their tombstone
+	log, _, _, err := OpenLog(logPath)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := log.AppendActions([]models.Action{
+		{ID: 1, UserID: 1, Type: models.ActionTypeWelcome, CreatedAt: now},
+		{ID: 2, UserID: 2, Type: models.ActionTypeReferUser, TargetUser: 1, CreatedAt: now},
+	}); err != nil {
+		t.Fatalf("failed to append actions: %v", err)
+	}
+	erasure, _ := json.Marshal(erasureRecord{Op: opErase, UserID: 1, Tombstone: -1})
+	if err := log.Append(erasure); err != nil {
+		t.Fatalf("failed to append erasure: %v", err)
+	}
+	log.Close()
+
+	actionRepo, err := OpenActionRepo(logPath, "", "../data/actions.json")
+	if err != nil {
+		t.Fatalf("failed to open action repository: %v", err)
+	}
+	defer actionRepo.Close()
+
+	want := []models.Action{
+		{ID: 1, UserID: -1, Type: models.ActionTypeWelcome, CreatedAt: now},
+		{ID: 2, UserID: 2, Type: models.ActionTypeReferUser, TargetUser: -1, CreatedAt: now},
+	}
+	if got := actionRepo.GetAllActions(ctx); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the erasure to be replayed, got %+v", got)
+	}
+
+	payloads := readLogPayloads(t, logPath)
+	if len(payloads) != 2 || bytes.Contains(bytes.Join(payloads, nil), []byte(opErase)) {
+		t.Fatalf("expected the log to be rewritten without the erasure, got %q", payloads)
+	}
+}
+
+// readLogPayloads returns the payloads of the records of the log at path
+func readLogPayloads(t *testing.T, path string) [][]byte {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+
+	records, _, err := readRecords(file, info.Size(), maxRecordSize)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	payloads, _, _ := committedRecords(records)
+	return payloads
+}
@@ -0,0 +1,215 @@
+package action
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+)
+
+// Referral is a REFER_USER action reduced to who referred whom
+type Referral struct {
+	Referrer int `json:"referrer"`
+	User     int `json:"user"`
+	// Removed is set when the referral was deleted after being stored
+	Removed bool `json:"removed,omitempty"`
+}
+
+// userSequence is what the transitions need to know about the actions of a user
+type userSequence struct {
+	// Seen holds the types of the user's actions
+	Seen []models.ActionType `json:"seen"`
+	// Last is when the user's latest action happened
+	Last time.Time `json:"last"`
+}
+
+// derivedState is what the analytics need from the actions, folded in as actions
+// are stored so it doesn't have to be recomputed from every action, and saved
+// in snapshots so it doesn't have to be on startup either
+type derivedState struct {
+	// Applied is how many actions have been folded in
+	Applied int `json:"applied"`
+	// Counts holds the number of actions of each user
+	Counts map[int]int `json:"counts"`
+	// Transitions counts, for each action type, the actions a user performed after
+	// it and before performing it again
+	Transitions map[models.ActionType]map[models.ActionType]int `json:"transitions"`
+	Users       map[int]*userSequence                           `json:"users"`
+	// Referrals lists the referrals in the order they were stored, followed by
+	// their removal when they are deleted
+	Referrals []Referral `json:"referrals"`
+	// stale is set when an action was stored out of chronological order or
+	// deleted, after which the transitions must be recomputed from the sorted actions
+	stale bool
+}
+
+func newDerivedState() *derivedState {
+	return &derivedState{
+		Counts:      make(map[int]int),
+		Transitions: make(map[models.ActionType]map[models.ActionType]int),
+		Users:       make(map[int]*userSequence),
+	}
+}
+
+// fold adds the actions after the ones already applied, skipping deleted ones
+func (d *derivedState) fold(actions []models.Action) {
+	for _, action := range actions[d.Applied:] {
+		if action.DeletedAt != nil {
+			continue
+		}
+
+		d.Counts[action.UserID]++
+		if action.Type == models.ActionTypeReferUser {
+			d.Referrals = append(d.Referrals, Referral{Referrer: action.UserID, User: action.TargetUser})
+		}
+		if !d.stale {
+			d.addTransition(action)
+		}
+	}
+	d.Applied = len(actions)
+}
+
+// remove takes actions that were folded in back out, once they are deleted
+func (d *derivedState) remove(actions []models.Action) {
+	for _, action := range actions {
+		d.Counts[action.UserID]--
+		if d.Counts[action.UserID] <= 0 {
+			delete(d.Counts, action.UserID)
+		}
+		if action.Type == models.ActionTypeReferUser {
+			d.Referrals = append(d.Referrals, Referral{Referrer: action.UserID, User: action.TargetUser, Removed: true})
+		}
+	}
+
+	// A deleted action ends or merges the windows of the other actions of its user
+	d.stale = true
+}
+
+// rekey moves what was folded in for the user from to the user to, given the
+// actions that were moved. The transitions only depend on the order of each
+// user's actions, which doesn't change, and the referrals of the user are
+// replaced by the same referrals of to.
+func (d *derivedState) rekey(from, to int, moved []models.Action) {
+	if count, ok := d.Counts[from]; ok {
+		d.Counts[to] += count
+		delete(d.Counts, from)
+	}
+	if user, ok := d.Users[from]; ok {
+		d.Users[to] = user
+		delete(d.Users, from)
+	}
+
+	for _, action := range moved {
+		if action.DeletedAt != nil || action.Type != models.ActionTypeReferUser {
+			continue
+		}
+
+		previous := Referral{Referrer: action.UserID, User: action.TargetUser}
+		if previous.Referrer == to {
+			previous.Referrer = from
+		}
+		if previous.User == to {
+			previous.User = from
+		}
+		d.Referrals = append(d.Referrals,
+			Referral{Referrer: previous.Referrer, User: previous.User, Removed: true},
+			Referral{Referrer: action.UserID, User: action.TargetUser})
+	}
+}
+
+// addTransition adds an action performed after every other action of its user.
+// It follows the window of the latest action of every other type the user
+// performed, and ends the window of its own type.
+func (d *derivedState) addTransition(action models.Action) {
+	user := d.Users[action.UserID]
+	if user == nil {
+		user = &userSequence{}
+		d.Users[action.UserID] = user
+	}
+
+	// Actions sharing a timestamp are ordered as they were stored
+	if action.CreatedAt.Before(user.Last) {
+		d.stale = true
+		return
+	}
+	user.Last = action.CreatedAt
+
+	seen := false
+	for _, t := range user.Seen {
+		if t == action.Type {
+			seen = true
+			continue
+		}
+		if d.Transitions[t] == nil {
+			d.Transitions[t] = make(map[models.ActionType]int)
+		}
+		d.Transitions[t][action.Type]++
+	}
+	if !seen {
+		user.Seen = append(user.Seen, action.Type)
+	}
+}
+
+// rebuildTransitions recomputes the transitions from the actions sorted by user
+// and time. It stops early with the context's error if the context is cancelled.
+func (d *derivedState) rebuildTransitions(ctx context.Context, actions []models.Action) error {
+	byUser := make(map[int][]models.Action)
+	for _, action := range actions[:d.Applied] {
+		if action.DeletedAt != nil {
+			continue
+		}
+		byUser[action.UserID] = append(byUser[action.UserID], action)
+	}
+
+	d.Transitions = make(map[models.ActionType]map[models.ActionType]int)
+	d.Users = make(map[int]*userSequence, len(byUser))
+	d.stale = false
+
+	i := 0
+	for _, userActions := range byUser {
+		if i%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				d.stale = true
+				return err
+			}
+		}
+		i++
+
+		sort.SliceStable(userActions, func(i, j int) bool {
+			return userActions[i].CreatedAt.Before(userActions[j].CreatedAt)
+		})
+		for _, action := range userActions {
+			d.addTransition(action)
+		}
+	}
+	return nil
+}
+
+// ensureTransitions recomputes the transitions if actions were stored out of order
+func (d *derivedState) ensureTransitions(ctx context.Context, actions []models.Action) error {
+	if !d.stale {
+		return nil
+	}
+	return d.rebuildTransitions(ctx, actions)
+}
+
+// clone returns a deep copy of the state, so it can be saved without holding the lock
+func (d *derivedState) clone() *derivedState {
+	c := &derivedState{
+		Applied:     d.Applied,
+		Counts:      maps.Clone(d.Counts),
+		Transitions: make(map[models.ActionType]map[models.ActionType]int, len(d.Transitions)),
+		Users:       make(map[int]*userSequence, len(d.Users)),
+		Referrals:   slices.Clone(d.Referrals),
+	}
+	for t, next := range d.Transitions {
+		c.Transitions[t] = maps.Clone(next)
+	}
+	for userID, user := range d.Users {
+		c.Users[userID] = &userSequence{Seen: slices.Clone(user.Seen), Last: user.Last}
+	}
+	return c
+}
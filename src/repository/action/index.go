@@ -0,0 +1,46 @@
+package action
+
+import "github.com/AntonioDaria/surfe/src/models"
+
+// actionIndex locates the actions of each user, and the referrals of each
+// referred user, by their position in Actions. It is folded in as actions are
+// stored like the derived state, but is cheap enough to rebuild on startup so
+// it isn't saved in snapshots. Deleted actions keep their position and are
+// skipped when read.
+type actionIndex struct {
+	// applied is how many actions have been indexed
+	applied int
+	byUser  map[int][]int
+	// referralsOf holds the REFER_USER actions of each referred user
+	referralsOf map[int][]int
+}
+
+func newActionIndex() *actionIndex {
+	return &actionIndex{
+		byUser:      make(map[int][]int),
+		referralsOf: make(map[int][]int),
+	}
+}
+
+// fold indexes the actions after the ones already indexed
+func (x *actionIndex) fold(actions []models.Action) {
+	for i := x.applied; i < len(actions); i++ {
+		action := actions[i]
+		x.byUser[action.UserID] = append(x.byUser[action.UserID], i)
+		if action.Type == models.ActionTypeReferUser {
+			x.referralsOf[action.TargetUser] = append(x.referralsOf[action.TargetUser], i)
+		}
+	}
+	x.applied = len(actions)
+}
+
+// collect returns the actions at the given positions that weren't deleted
+func collect(actions []models.Action, positions []int) []models.Action {
+	var result []models.Action
+	for _, pos := range positions {
+		if actions[pos].DeletedAt == nil {
+			result = append(result, actions[pos])
+		}
+	}
+	return result
+}
@@ -0,0 +1,414 @@
+package action
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/atomicfile"
+	"github.com/AntonioDaria/surfe/src/models"
+)
+
+// ErrCorruptLog is returned when a bad record is followed by valid ones. Only
+// the last record can be partially written, so this is not a torn write.
+var ErrCorruptLog = errors.New("action log is corrupt")
+
+const (
+	// recordHeaderSize is the size of the length and checksum preceding every record
+	recordHeaderSize = 8
+	// maxRecordSize bounds the length of a record, so a corrupt length is not
+	// mistaken for a huge record
+	maxRecordSize = 1 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// commitMarker is the payload of the record closing every append. The records of
+// an append cut short by a crash have no marker after them, so they are dropped
+// when the log is opened rather than replayed as a partial batch. Actions are
+// stored as JSON, so none of their records can be taken for a marker.
+var commitMarker = []byte{0}
+
+// Log is an append-only file of records, each made of the big-endian length and
+// CRC-32C checksum of its payload followed by the payload itself. Every append
+// ends with a commit marker record, which isn't returned with the payloads.
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	records int
+}
+
+// LogRecovery describes what was found when opening a log
+type LogRecovery struct {
+	// Records is the number of valid records
+	Records int
+	// TruncatedBytes is the size of the torn write removed from the end of the
+	// log, including the records of an append that wasn't committed
+	TruncatedBytes int64
+}
+
+// OpenLog opens the log at path, creating it if needed, and returns the payloads
+// of its committed records. A record torn by a crash in the middle of a write can
+// only be the last one; it is truncated along with the other records of its
+// append so appending can carry on after the committed records.
+func OpenLog(path string) (*Log, [][]byte, LogRecovery, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, LogRecovery{}, fmt.Errorf("failed to open action log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, LogRecovery{}, fmt.Errorf("failed to open action log: %w", err)
+	}
+
+	records, _, err := readRecords(file, info.Size(), maxRecordSize)
+	if err != nil {
+		file.Close()
+		return nil, nil, LogRecovery{}, err
+	}
+	payloads, valid, committed := committedRecords(records)
+
+	recovery := LogRecovery{Records: len(payloads), TruncatedBytes: info.Size() - valid}
+	if recovery.TruncatedBytes > 0 {
+		if err := file.Truncate(valid); err != nil {
+			file.Close()
+			return nil, nil, LogRecovery{}, fmt.Errorf("failed to truncate torn action log write: %w", err)
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return nil, nil, LogRecovery{}, fmt.Errorf("failed to truncate torn action log write: %w", err)
+		}
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, LogRecovery{}, fmt.Errorf("failed to open action log: %w", err)
+	}
+
+	log := &Log{path: path, file: file, size: valid, records: len(payloads)}
+
+	// Logs written before appends were committed have no marker, and all their
+	// records were written by appends that completed
+	if !committed && len(payloads) > 0 {
+		if err := log.Append(); err != nil {
+			file.Close()
+			return nil, nil, LogRecovery{}, err
+		}
+	}
+
+	return log, payloads, recovery, nil
+}
+
+// committedRecords returns the payloads of the records up to the last commit
+// marker, leaving the markers out, and the offset where that marker ends. A log
+// without any marker is returned whole, with committed false.
+func committedRecords(records [][]byte) (payloads [][]byte, valid int64, committed bool) {
+	var pending [][]byte
+	offset := int64(0)
+	for _, record := range records {
+		offset += recordHeaderSize + int64(len(record))
+		if bytes.Equal(record, commitMarker) {
+			payloads = append(payloads, pending...)
+			pending = pending[:0]
+			valid = offset
+			committed = true
+			continue
+		}
+		pending = append(pending, record)
+	}
+
+	if !committed {
+		return pending, offset, false
+	}
+	return payloads, valid, true
+}
+
+// readRecords reads the records of a log of the given size, none longer than
+// limit, returning their payloads and the offset where the valid records end.
+// A crash can only tear
+// the last write, leaving a partial record or a zero-filled tail behind, so a
+// bad record is only taken for a torn write when no valid record follows it.
+// Otherwise the log was damaged in some other way.
+func readRecords(r io.Reader, size int64, limit int) ([][]byte, int64, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, fmt.Errorf("failed to read action log: %w", err)
+	}
+
+	var payloads [][]byte
+	offset := 0
+	for offset < len(data) {
+		payload, ok := recordAt(data, offset, limit)
+		if !ok {
+			if recordFollows(data, offset+1, limit) {
+				return nil, 0, fmt.Errorf("%w: bad record at offset %d", ErrCorruptLog, offset)
+			}
+			break
+		}
+
+		payloads = append(payloads, payload)
+		offset += recordHeaderSize + len(payload)
+	}
+
+	return payloads, int64(offset), nil
+}
+
+// recordAt returns the payload of the record at offset, if a whole valid one no
+// longer than limit starts there. Records are never empty, so zeros are never
+// taken for a record.
+func recordAt(data []byte, offset int, limit int) ([]byte, bool) {
+	if len(data)-offset < recordHeaderSize {
+		return nil, false
+	}
+
+	length := int64(binary.BigEndian.Uint32(data[offset : offset+4]))
+	checksum := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+	start := offset + recordHeaderSize
+	if length == 0 || length > int64(limit) || length > int64(len(data)-start) {
+		return nil, false
+	}
+
+	payload := data[start : start+int(length)]
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return nil, false
+	}
+	return payload, true
+}
+
+// recordFollows reports whether a valid record starts anywhere from offset on
+func recordFollows(data []byte, offset int, limit int) bool {
+	for ; offset < len(data)-recordHeaderSize; offset++ {
+		if _, ok := recordAt(data, offset, limit); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// appendRecord appends the framing and payload of a record to buf
+func appendRecord(buf []byte, payload []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	return append(buf, payload...)
+}
+
+// encodeRecords frames the payloads as records, followed by a commit marker
+func encodeRecords(payloads [][]byte) ([]byte, error) {
+	var buf []byte
+	for _, payload := range payloads {
+		if len(payload) == 0 {
+			return nil, fmt.Errorf("records can't be empty")
+		}
+		if len(payload) > maxRecordSize {
+			return nil, fmt.Errorf("record of %d bytes exceeds the maximum of %d", len(payload), maxRecordSize)
+		}
+		buf = appendRecord(buf, payload)
+	}
+	return appendRecord(buf, commitMarker), nil
+}
+
+// Append writes the payloads as records and syncs them to disk. The records are
+// durable once it returns, and replayed all together or not at all; on failure
+// the log is rolled back to its previous size.
+func (l *Log) Append(payloads ...[]byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buf, err := encodeRecords(payloads)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.file.Write(buf); err != nil {
+		l.rollback()
+		return fmt.Errorf("failed to append to action log: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		l.rollback()
+		return fmt.Errorf("failed to sync action log: %w", err)
+	}
+
+	l.size += int64(len(buf))
+	l.records += len(payloads)
+	return nil
+}
+
+// Rewrite replaces the log with the given payloads, leaving nothing of the
+// previous records in the file, and appends to the new file from then on
+func (l *Log) Rewrite(payloads ...[]byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buf, err := encodeRecords(payloads)
+	if err != nil {
+		return err
+	}
+
+	file, err := atomicfile.Replace(l.path, buf)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite action log: %w", err)
+	}
+
+	l.file.Close()
+	l.file = file
+	l.size = int64(len(buf))
+	l.records = len(payloads)
+	return nil
+}
+
+// rollback discards a partial append. The lock must be held.
+func (l *Log) rollback() {
+	_ = l.file.Truncate(l.size)
+	_, _ = l.file.Seek(l.size, io.SeekStart)
+}
+
+// Records returns the number of records in the log
+func (l *Log) Records() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.records
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// Ops mark the records that change actions already stored. Actions are stored
+// without an op, so logs written before deletions existed still replay.
+const (
+	opDelete = "delete"
+	opErase  = "erase"
+)
+
+// logRecord is how a record of the action log is decoded
+type logRecord struct {
+	Op string `json:"op,omitempty"`
+	models.Action
+}
+
+// deletionRecord is the record soft deleting an action
+type deletionRecord struct {
+	Op        string    `json:"op"`
+	ID        int       `json:"id"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// erasureRecord is the record that moved the actions of an erased user, and the
+// referrals of them, to a tombstone. Erasures now rewrite the log instead, so
+// it is only found in logs written before, which are rewritten when opened.
+type erasureRecord struct {
+	Op        string `json:"op"`
+	UserID    int    `json:"userId"`
+	Tombstone int    `json:"tombstone"`
+}
+
+// AppendActions appends one record per action, committed together
+func (l *Log) AppendActions(actions []models.Action) error {
+	payloads, err := marshalActions(actions)
+	if err != nil {
+		return err
+	}
+	return l.Append(payloads...)
+}
+
+// RewriteActions replaces the log with one record per action. Deleted actions
+// are written along with when they were deleted.
+func (l *Log) RewriteActions(actions []models.Action) error {
+	payloads, err := marshalActions(actions)
+	if err != nil {
+		return err
+	}
+	return l.Rewrite(payloads...)
+}
+
+func marshalActions(actions []models.Action) ([][]byte, error) {
+	payloads := make([][]byte, len(actions))
+	for i, action := range actions {
+		payload, err := json.Marshal(action)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal action: %w", err)
+		}
+		payloads[i] = payload
+	}
+	return payloads, nil
+}
+
+// AppendDeletions appends one record per soft deleted action, committed together
+func (l *Log) AppendDeletions(actions []models.Action) error {
+	payloads := make([][]byte, len(actions))
+	for i, action := range actions {
+		payload, err := json.Marshal(deletionRecord{Op: opDelete, ID: action.ID, DeletedAt: *action.DeletedAt})
+		if err != nil {
+			return fmt.Errorf("failed to marshal action deletion: %w", err)
+		}
+		payloads[i] = payload
+	}
+	return l.Append(payloads...)
+}
+
+// replay is what replaying the records of a log gives back
+type replay struct {
+	actions []models.Action
+	// lastChange is the index of the last record that changed actions already
+	// stored, or -1 when there is none
+	lastChange int
+	// erased is set when the log holds erasure records
+	erased bool
+}
+
+// replayActions rebuilds the actions from the payloads of the log's records
+func replayActions(payloads [][]byte) (replay, error) {
+	actions := make([]models.Action, 0, len(payloads))
+	positions := make(map[int]int, len(payloads))
+	lastChange := -1
+	erased := false
+
+	for i, payload := range payloads {
+		var record logRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			return replay{}, fmt.Errorf("%w: record %d: %v", ErrCorruptLog, i, err)
+		}
+
+		switch record.Op {
+		case "":
+			positions[record.ID] = len(actions)
+			actions = append(actions, record.Action)
+		case opDelete:
+			pos, ok := positions[record.ID]
+			if !ok || record.DeletedAt == nil {
+				return replay{}, fmt.Errorf("%w: record %d deletes unknown action %d", ErrCorruptLog, i, record.ID)
+			}
+			actions[pos].DeletedAt = record.DeletedAt
+			lastChange = i
+		case opErase:
+			var erasure erasureRecord
+			if err := json.Unmarshal(payload, &erasure); err != nil || !IsTombstone(erasure.Tombstone) {
+				return replay{}, fmt.Errorf("%w: record %d erases to an invalid tombstone", ErrCorruptLog, i)
+			}
+			for pos := range actions {
+				actions[pos] = rekey(actions[pos], erasure.UserID, erasure.Tombstone)
+			}
+			lastChange = i
+			erased = true
+		default:
+			return replay{}, fmt.Errorf("%w: record %d has unknown op %q", ErrCorruptLog, i, record.Op)
+		}
+	}
+
+	return replay{actions: actions, lastChange: lastChange, erased: erased}, nil
+}
@@ -0,0 +1,189 @@
+package action
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// markerSize is the size of the commit marker closing every append
+const markerSize = recordHeaderSize + 1
+
+func writeLog(t *testing.T, payloads ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "actions.log")
+	log, _, _, err := OpenLog(path)
+	assert.NoError(t, err)
+	for _, payload := range payloads {
+		assert.NoError(t, log.Append([]byte(payload)))
+	}
+	assert.NoError(t, log.Close())
+
+	return path
+}
+
+func TestLog_Reopen(t *testing.T) {
+	path := writeLog(t, "first", "second")
+
+	log, payloads, recovery, err := OpenLog(path)
+	assert.NoError(t, err)
+	defer log.Close()
+
+	assert.Equal(t, [][]byte{[]byte("first"), []byte("second")}, payloads)
+	assert.Equal(t, LogRecovery{Records: 2}, recovery)
+	assert.Equal(t, 2, log.Records())
+}
+
+func TestLog_TruncatesTornWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		tear func(data []byte) []byte
+	}{
+		{
+			name: "partial header",
+			tear: func(data []byte) []byte { return append(data, 0, 0, 0) },
+		},
+		{
+			name: "partial payload",
+			tear: func(data []byte) []byte { return appendRecord(data, []byte("third"))[:len(data)+recordHeaderSize+2] },
+		},
+		{
+			name: "zeroed record",
+			tear: func(data []byte) []byte { return append(data, make([]byte, recordHeaderSize)...) },
+		},
+		{
+			name: "zero-filled tail",
+			tear: func(data []byte) []byte { return append(data, make([]byte, 4096)...) },
+		},
+		{
+			name: "bad checksum",
+			tear: func(data []byte) []byte {
+				data = appendRecord(data, []byte("third"))
+				data[len(data)-1] ^= 0xff
+				return data
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeLog(t, "first", "second")
+			data, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			valid := int64(len(data))
+			assert.NoError(t, os.WriteFile(path, tt.tear(data), 0o644))
+
+			log, payloads, recovery, err := OpenLog(path)
+			assert.NoError(t, err)
+			assert.Len(t, payloads, 2)
+			assert.Positive(t, recovery.TruncatedBytes)
+
+			// Appending carries on after the valid records
+			assert.NoError(t, log.Append([]byte("fourth")))
+			assert.NoError(t, log.Close())
+
+			info, err := os.Stat(path)
+			assert.NoError(t, err)
+			assert.Equal(t, valid+recordHeaderSize+int64(len("fourth"))+markerSize, info.Size())
+
+			_, payloads, _, err = OpenLog(path)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("fourth"), payloads[2])
+		})
+	}
+}
+
+func TestLog_DropsUncommittedAppend(t *testing.T) {
+	path := writeLog(t, "first")
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	valid := int64(len(data))
+
+	// A crash after writing the records of an append but before its marker
+	data = appendRecord(data, []byte("second"))
+	data = appendRecord(data, []byte("third"))
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+
+	log, payloads, recovery, err := OpenLog(path)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("first")}, payloads)
+	assert.Equal(t, LogRecovery{Records: 1, TruncatedBytes: int64(len(data)) - valid}, recovery)
+
+	// Appending carries on after the committed records
+	assert.NoError(t, log.Append([]byte("fourth"), []byte("fifth")))
+	assert.NoError(t, log.Close())
+
+	_, payloads, _, err = OpenLog(path)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("first"), []byte("fourth"), []byte("fifth")}, payloads)
+}
+
+func TestLog_CommitsLogWithoutMarkers(t *testing.T) {
+	// Logs written before appends were committed hold records only
+	path := filepath.Join(t.TempDir(), "actions.log")
+	data := appendRecord(appendRecord(nil, []byte("first")), []byte("second"))
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+
+	log, payloads, recovery, err := OpenLog(path)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("first"), []byte("second")}, payloads)
+	assert.Equal(t, LogRecovery{Records: 2}, recovery)
+
+	// They are committed when opened, so a torn append after them is dropped alone
+	assert.NoError(t, log.Close())
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, appendRecord(data, []byte("third")), 0o644))
+
+	_, payloads, _, err = OpenLog(path)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("first"), []byte("second")}, payloads)
+}
+
+func TestLog_RefusesCorruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(data []byte)
+	}{
+		{
+			name:    "bad checksum",
+			corrupt: func(data []byte) { data[recordHeaderSize] ^= 0xff },
+		},
+		{
+			name:    "length past the end",
+			corrupt: func(data []byte) { binary.BigEndian.PutUint32(data, 1000) },
+		},
+		{
+			name:    "length over the maximum",
+			corrupt: func(data []byte) { binary.BigEndian.PutUint32(data, maxRecordSize+1) },
+		},
+		{
+			name:    "zeroed record",
+			corrupt: func(data []byte) { clear(data[:recordHeaderSize+len("first")]) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeLog(t, "first", "second")
+
+			// A bad record followed by valid ones wasn't torn by a crash
+			data, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			tt.corrupt(data)
+			assert.NoError(t, os.WriteFile(path, data, 0o644))
+
+			_, _, _, err = OpenLog(path)
+			assert.ErrorIs(t, err, ErrCorruptLog)
+
+			// and the log is left as it was
+			after, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			assert.Equal(t, data, after)
+		})
+	}
+}
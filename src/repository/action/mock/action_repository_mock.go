@@ -5,9 +5,12 @@
 package mock
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/AntonioDaria/surfe/src/models"
+	action "github.com/AntonioDaria/surfe/src/repository/action"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -34,58 +37,258 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// AddActions mocks base method.
+func (m *MockRepository) AddActions(ctx context.Context, actions []models.Action) ([]models.Action, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddActions", ctx, actions)
+	ret0, _ := ret[0].([]models.Action)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddActions indicates an expected call of AddActions.
+func (mr *MockRepositoryMockRecorder) AddActions(ctx, actions interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddActions", reflect.TypeOf((*MockRepository)(nil).AddActions), ctx, actions)
+}
+
 // CountActionsByUserID mocks base method.
-func (m *MockRepository) CountActionsByUserID(userID int) int {
+func (m *MockRepository) CountActionsByUserID(ctx context.Context, userID int) int {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CountActionsByUserID", userID)
+	ret := m.ctrl.Call(m, "CountActionsByUserID", ctx, userID)
 	ret0, _ := ret[0].(int)
 	return ret0
 }
 
 // CountActionsByUserID indicates an expected call of CountActionsByUserID.
-func (mr *MockRepositoryMockRecorder) CountActionsByUserID(userID interface{}) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) CountActionsByUserID(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountActionsByUserID", reflect.TypeOf((*MockRepository)(nil).CountActionsByUserID), ctx, userID)
+}
+
+// CountActionsByUserIDs mocks base method.
+func (m *MockRepository) CountActionsByUserIDs(ctx context.Context, userIDs []int) map[int]int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountActionsByUserIDs", ctx, userIDs)
+	ret0, _ := ret[0].(map[int]int)
+	return ret0
+}
+
+// CountActionsByUserIDs indicates an expected call of CountActionsByUserIDs.
+func (mr *MockRepositoryMockRecorder) CountActionsByUserIDs(ctx, userIDs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountActionsByUserIDs", reflect.TypeOf((*MockRepository)(nil).CountActionsByUserIDs), ctx, userIDs)
+}
+
+// DeleteActions mocks base method.
+func (m *MockRepository) DeleteActions(ctx context.Context, actionIDs []int, at time.Time) ([]models.Action, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteActions", ctx, actionIDs, at)
+	ret0, _ := ret[0].([]models.Action)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteActions indicates an expected call of DeleteActions.
+func (mr *MockRepositoryMockRecorder) DeleteActions(ctx, actionIDs, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteActions", reflect.TypeOf((*MockRepository)(nil).DeleteActions), ctx, actionIDs, at)
+}
+
+// DeleteUserActions mocks base method.
+func (m *MockRepository) DeleteUserActions(ctx context.Context, userID int, at time.Time) ([]models.Action, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUserActions", ctx, userID, at)
+	ret0, _ := ret[0].([]models.Action)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteUserActions indicates an expected call of DeleteUserActions.
+func (mr *MockRepositoryMockRecorder) DeleteUserActions(ctx, userID, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserActions", reflect.TypeOf((*MockRepository)(nil).DeleteUserActions), ctx, userID, at)
+}
+
+// EraseUser mocks base method.
+func (m *MockRepository) EraseUser(ctx context.Context, userID int) ([]models.Action, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EraseUser", ctx, userID)
+	ret0, _ := ret[0].([]models.Action)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EraseUser indicates an expected call of EraseUser.
+func (mr *MockRepositoryMockRecorder) EraseUser(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EraseUser", reflect.TypeOf((*MockRepository)(nil).EraseUser), ctx, userID)
+}
+
+// GetAction mocks base method.
+func (m *MockRepository) GetAction(ctx context.Context, actionID int) (*models.Action, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAction", ctx, actionID)
+	ret0, _ := ret[0].(*models.Action)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAction indicates an expected call of GetAction.
+func (mr *MockRepositoryMockRecorder) GetAction(ctx, actionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAction", reflect.TypeOf((*MockRepository)(nil).GetAction), ctx, actionID)
+}
+
+// GetActionsByUserIDs mocks base method.
+func (m *MockRepository) GetActionsByUserIDs(ctx context.Context, userIDs []int) map[int][]models.Action {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActionsByUserIDs", ctx, userIDs)
+	ret0, _ := ret[0].(map[int][]models.Action)
+	return ret0
+}
+
+// GetActionsByUserIDs indicates an expected call of GetActionsByUserIDs.
+func (mr *MockRepositoryMockRecorder) GetActionsByUserIDs(ctx, userIDs interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountActionsByUserID", reflect.TypeOf((*MockRepository)(nil).CountActionsByUserID), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActionsByUserIDs", reflect.TypeOf((*MockRepository)(nil).GetActionsByUserIDs), ctx, userIDs)
 }
 
 // GetAllActions mocks base method.
-func (m *MockRepository) GetAllActions() []models.Action {
+func (m *MockRepository) GetAllActions(ctx context.Context) []models.Action {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllActions")
+	ret := m.ctrl.Call(m, "GetAllActions", ctx)
 	ret0, _ := ret[0].([]models.Action)
 	return ret0
 }
 
 // GetAllActions indicates an expected call of GetAllActions.
-func (mr *MockRepositoryMockRecorder) GetAllActions() *gomock.Call {
+func (mr *MockRepositoryMockRecorder) GetAllActions(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllActions", reflect.TypeOf((*MockRepository)(nil).GetAllActions))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllActions", reflect.TypeOf((*MockRepository)(nil).GetAllActions), ctx)
+}
+
+// GetReferrals mocks base method.
+func (m *MockRepository) GetReferrals(ctx context.Context) []action.Referral {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReferrals", ctx)
+	ret0, _ := ret[0].([]action.Referral)
+	return ret0
+}
+
+// GetReferrals indicates an expected call of GetReferrals.
+func (mr *MockRepositoryMockRecorder) GetReferrals(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReferrals", reflect.TypeOf((*MockRepository)(nil).GetReferrals), ctx)
+}
+
+// GetReferralsOf mocks base method.
+func (m *MockRepository) GetReferralsOf(ctx context.Context, userIDs []int) map[int][]models.Action {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReferralsOf", ctx, userIDs)
+	ret0, _ := ret[0].(map[int][]models.Action)
+	return ret0
+}
+
+// GetReferralsOf indicates an expected call of GetReferralsOf.
+func (mr *MockRepositoryMockRecorder) GetReferralsOf(ctx, userIDs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReferralsOf", reflect.TypeOf((*MockRepository)(nil).GetReferralsOf), ctx, userIDs)
 }
 
 // GetSortedActions mocks base method.
-func (m *MockRepository) GetSortedActions() []models.Action {
+func (m *MockRepository) GetSortedActions(ctx context.Context) ([]models.Action, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetSortedActions")
+	ret := m.ctrl.Call(m, "GetSortedActions", ctx)
 	ret0, _ := ret[0].([]models.Action)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetSortedActions indicates an expected call of GetSortedActions.
-func (mr *MockRepositoryMockRecorder) GetSortedActions() *gomock.Call {
+func (mr *MockRepositoryMockRecorder) GetSortedActions(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSortedActions", reflect.TypeOf((*MockRepository)(nil).GetSortedActions))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSortedActions", reflect.TypeOf((*MockRepository)(nil).GetSortedActions), ctx)
 }
 
-// UserExists mocks base method.
-func (m *MockRepository) UserExists(userID int) bool {
+// GetStoredActionsOf mocks base method.
+func (m *MockRepository) GetStoredActionsOf(ctx context.Context, userID int) []models.Action {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UserExists", userID)
-	ret0, _ := ret[0].(bool)
+	ret := m.ctrl.Call(m, "GetStoredActionsOf", ctx, userID)
+	ret0, _ := ret[0].([]models.Action)
 	return ret0
 }
 
-// UserExists indicates an expected call of UserExists.
-func (mr *MockRepositoryMockRecorder) UserExists(userID interface{}) *gomock.Call {
+// GetStoredActionsOf indicates an expected call of GetStoredActionsOf.
+func (mr *MockRepositoryMockRecorder) GetStoredActionsOf(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStoredActionsOf", reflect.TypeOf((*MockRepository)(nil).GetStoredActionsOf), ctx, userID)
+}
+
+// NextActionCounts mocks base method.
+func (m *MockRepository) NextActionCounts(ctx context.Context, actionType models.ActionType) (map[models.ActionType]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NextActionCounts", ctx, actionType)
+	ret0, _ := ret[0].(map[models.ActionType]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NextActionCounts indicates an expected call of NextActionCounts.
+func (mr *MockRepositoryMockRecorder) NextActionCounts(ctx, actionType interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextActionCounts", reflect.TypeOf((*MockRepository)(nil).NextActionCounts), ctx, actionType)
+}
+
+// Version mocks base method.
+func (m *MockRepository) Version(ctx context.Context) uint64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Version", ctx)
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+// Version indicates an expected call of Version.
+func (mr *MockRepositoryMockRecorder) Version(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Version", reflect.TypeOf((*MockRepository)(nil).Version), ctx)
+}
+
+// MockPublisher is a mock of Publisher interface.
+type MockPublisher struct {
+	ctrl     *gomock.Controller
+	recorder *MockPublisherMockRecorder
+}
+
+// MockPublisherMockRecorder is the mock recorder for MockPublisher.
+type MockPublisherMockRecorder struct {
+	mock *MockPublisher
+}
+
+// NewMockPublisher creates a new mock instance.
+func NewMockPublisher(ctrl *gomock.Controller) *MockPublisher {
+	mock := &MockPublisher{ctrl: ctrl}
+	mock.recorder = &MockPublisherMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPublisher) EXPECT() *MockPublisherMockRecorder {
+	return m.recorder
+}
+
+// Publish mocks base method.
+func (m *MockPublisher) Publish(actions ...models.Action) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range actions {
+		varargs = append(varargs, a)
+	}
+	m.ctrl.Call(m, "Publish", varargs...)
+}
+
+// Publish indicates an expected call of Publish.
+func (mr *MockPublisherMockRecorder) Publish(actions ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserExists", reflect.TypeOf((*MockRepository)(nil).UserExists), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Publish", reflect.TypeOf((*MockPublisher)(nil).Publish), actions...)
 }
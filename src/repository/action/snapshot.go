@@ -0,0 +1,73 @@
+package action
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/AntonioDaria/surfe/src/atomicfile"
+)
+
+// ErrCorruptSnapshot is returned when a snapshot fails its checksum
+var ErrCorruptSnapshot = errors.New("action snapshot is corrupt")
+
+// maxSnapshotSize bounds the size of a snapshot. The state grows with the
+// number of users, so snapshots get a larger limit than the records of the log.
+const maxSnapshotSize = 256 << 20
+
+// snapshot is the derived state of the actions at a point of the log
+type snapshot struct {
+	// LastID is the ID of the last action folded into the state, checked against
+	// the log so a snapshot of another log is never used
+	LastID int `json:"lastId"`
+	// Records is how many records of the log the state accounts for, deletions included
+	Records int           `json:"records"`
+	State   *derivedState `json:"state"`
+}
+
+// writeSnapshot saves a snapshot as a single log record, replacing the previous
+// one. Snapshots too large to be read back are refused.
+func writeSnapshot(path string, s snapshot) error {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if len(payload) > maxSnapshotSize {
+		return fmt.Errorf("snapshot of %d bytes exceeds the maximum of %d", len(payload), maxSnapshotSize)
+	}
+
+	if err := atomicfile.Write(path, appendRecord(nil, payload)); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// readSnapshot loads the snapshot saved at path. The error wraps os.ErrNotExist
+// when there is none.
+func readSnapshot(path string) (*snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	payloads, valid, err := readRecords(file, info.Size(), maxSnapshotSize)
+	if err != nil || len(payloads) != 1 || valid != info.Size() {
+		return nil, ErrCorruptSnapshot
+	}
+
+	s := snapshot{State: newDerivedState()}
+	if err := json.Unmarshal(payloads[0], &s); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	if s.State == nil || s.State.Counts == nil || s.State.Transitions == nil || s.State.Users == nil {
+		return nil, fmt.Errorf("%w: incomplete state", ErrCorruptSnapshot)
+	}
+	return &s, nil
+}
@@ -0,0 +1,318 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/AntonioDaria/surfe/src/atomicfile"
+	"github.com/AntonioDaria/surfe/src/models"
+	"go.opentelemetry.io/otel"
+)
+
+// ErrCorruptTrail is returned when a line of the audit file, other than a torn
+// last one, can't be decoded
+var ErrCorruptTrail = errors.New("audit trail is corrupt")
+
+var tracer = otel.Tracer("github.com/AntonioDaria/surfe/src/repository/audit")
+
+// Query selects audit records. The zero value selects every record.
+type Query struct {
+	Entity models.AuditEntity
+	// EntityID restricts the records to a single entity of the type, when set
+	EntityID *int
+	// After skips the records up to this ID, to carry on after a page
+	After int
+	// Limit is how many records are returned at most, every record when zero
+	Limit int
+}
+
+//go:generate mockgen -source=$GOFILE -destination=mock/audit_repository_mock.go -package=mock
+type Repository interface {
+	AddRecords(ctx context.Context, records []models.AuditRecord) ([]models.AuditRecord, error)
+	GetRecords(ctx context.Context, query Query) []models.AuditRecord
+	Redact(ctx context.Context, redactions []Redaction) (int, error)
+}
+
+// Redaction rewrites the entity held by the audit records of an entity
+type Redaction struct {
+	Entity   models.AuditEntity
+	EntityID int
+	// Redact returns the entity as it should be kept, given the entity as recorded
+	Redact func(entity json.RawMessage) (json.RawMessage, error)
+}
+
+// entityKey identifies the entity an audit record is about
+type entityKey struct {
+	entity models.AuditEntity
+	id     int
+}
+
+type RepositoryImpl struct {
+	mu sync.RWMutex
+	// file is where records are appended, one JSON object per line, nil when
+	// the trail is kept in memory
+	file     *os.File
+	filePath string
+	size     int64
+	records  []models.AuditRecord
+	// byEntity holds the positions of the records of each entity
+	byEntity map[entityKey][]int
+	nextID   int
+}
+
+// NewAuditRepo loads the audit trail from the file at filePath, creating it if
+// needed, and appends every new record to it. A line torn by a crash in the
+// middle of a write can only be the last one; it is truncated. The trail is
+// only kept in memory when filePath is empty.
+func NewAuditRepo(filePath string) (*RepositoryImpl, error) {
+	r := &RepositoryImpl{byEntity: make(map[entityKey][]int), nextID: 1}
+	if filePath == "" {
+		return r, nil
+	}
+
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read audit file: %w", err)
+	}
+
+	valid := 0
+	for valid < len(data) {
+		end := bytes.IndexByte(data[valid:], '\n')
+		if end < 0 {
+			break
+		}
+
+		var record models.AuditRecord
+		if err := json.Unmarshal(data[valid:valid+end], &record); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%w: line %d: %v", ErrCorruptTrail, len(r.records)+1, err)
+		}
+		r.index(record)
+		valid += end + 1
+	}
+
+	if valid < len(data) {
+		if err := file.Truncate(int64(valid)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to truncate torn audit write: %w", err)
+		}
+	}
+	if _, err := file.Seek(int64(valid), io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+
+	r.file = file
+	r.filePath = filePath
+	r.size = int64(valid)
+	return r, nil
+}
+
+// index adds a record to the trail. The lock must be held.
+func (r *RepositoryImpl) index(record models.AuditRecord) {
+	key := entityKey{entity: record.Entity, id: record.EntityID}
+	r.byEntity[key] = append(r.byEntity[key], len(r.records))
+	r.records = append(r.records, record)
+	r.nextID = max(r.nextID, record.ID+1)
+}
+
+// AddRecords assigns each record a new ID and appends them to the trail. The
+// records are durable once it returns; either all of them are stored or none.
+func (r *RepositoryImpl) AddRecords(ctx context.Context, records []models.AuditRecord) ([]models.AuditRecord, error) {
+	_, span := tracer.Start(ctx, "audit.Repository.AddRecords")
+	defer span.End()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := make([]models.AuditRecord, len(records))
+	var buf []byte
+	for i, record := range records {
+		record.ID = r.nextID + i
+		stored[i] = record
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			span.RecordError(err)
+			return nil, fmt.Errorf("failed to marshal audit record: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	if r.file != nil {
+		if err := r.write(buf); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	for _, record := range stored {
+		r.index(record)
+	}
+	return stored, nil
+}
+
+// write appends buf to the file and syncs it, rolling the file back to its
+// previous size on failure. The lock must be held.
+func (r *RepositoryImpl) write(buf []byte) error {
+	if _, err := r.file.Write(buf); err != nil {
+		r.rollback()
+		return fmt.Errorf("failed to append to audit file: %w", err)
+	}
+	if err := r.file.Sync(); err != nil {
+		r.rollback()
+		return fmt.Errorf("failed to sync audit file: %w", err)
+	}
+	r.size += int64(len(buf))
+	return nil
+}
+
+// rollback discards a partial append. The lock must be held.
+func (r *RepositoryImpl) rollback() {
+	_ = r.file.Truncate(r.size)
+	_, _ = r.file.Seek(r.size, io.SeekStart)
+}
+
+// Redact rewrites the entity held by the records of each redaction, leaving the
+// records where it is null as they are, and returns how many records were
+// redacted. The file is rewritten so the previous entities aren't kept on disk.
+func (r *RepositoryImpl) Redact(ctx context.Context, redactions []Redaction) (int, error) {
+	_, span := tracer.Start(ctx, "audit.Repository.Redact")
+	defer span.End()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The records are only replaced once the file is, so a failure leaves both as they were
+	records := slices.Clone(r.records)
+	redacted := 0
+	for _, redaction := range redactions {
+		for _, pos := range r.byEntity[entityKey{entity: redaction.Entity, id: redaction.EntityID}] {
+			record := &records[pos]
+			for _, entity := range []*json.RawMessage{&record.Before, &record.After} {
+				if isNull(*entity) {
+					continue
+				}
+				data, err := redaction.Redact(*entity)
+				if err != nil {
+					span.RecordError(err)
+					return 0, fmt.Errorf("failed to redact audit record %d: %w", record.ID, err)
+				}
+				*entity = data
+			}
+			redacted++
+		}
+	}
+	if redacted == 0 {
+		return 0, nil
+	}
+
+	if r.file != nil {
+		if err := r.rewrite(records); err != nil {
+			span.RecordError(err)
+			return 0, err
+		}
+	}
+
+	r.records = records
+	return redacted, nil
+}
+
+// isNull reports whether an entity held by a record is missing, which records
+// read back from the file hold as a JSON null
+func isNull(entity json.RawMessage) bool {
+	return len(entity) == 0 || string(entity) == "null"
+}
+
+// rewrite replaces the file with the given records, and appends to the new file
+// from then on. The lock must be held.
+func (r *RepositoryImpl) rewrite(records []models.AuditRecord) error {
+	var buf []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit record: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	file, err := atomicfile.Replace(r.filePath, buf)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite audit file: %w", err)
+	}
+
+	r.file.Close()
+	r.file = file
+	r.size = int64(len(buf))
+	return nil
+}
+
+// GetRecords returns the records selected by the query, oldest first
+func (r *RepositoryImpl) GetRecords(ctx context.Context, query Query) []models.AuditRecord {
+	_, span := tracer.Start(ctx, "audit.Repository.GetRecords")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Records are stored in the order of their IDs, so the ones after a page
+	// are found by a binary search
+	records := make([]models.AuditRecord, 0)
+	full := func() bool { return query.Limit > 0 && len(records) == query.Limit }
+
+	if query.EntityID != nil {
+		positions := r.byEntity[entityKey{entity: query.Entity, id: *query.EntityID}]
+		start := sort.Search(len(positions), func(i int) bool { return r.records[positions[i]].ID > query.After })
+		for _, pos := range positions[start:] {
+			if full() {
+				break
+			}
+			records = append(records, r.records[pos])
+		}
+		return records
+	}
+
+	start := sort.Search(len(r.records), func(i int) bool { return r.records[i].ID > query.After })
+	for _, record := range r.records[start:] {
+		if full() {
+			break
+		}
+		if query.Entity == "" || record.Entity == query.Entity {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// Close closes the audit file
+func (r *RepositoryImpl) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// Count returns the number of audit records
+func (r *RepositoryImpl) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.records)
+}
@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepositoryImpl_Persists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	repo, err := NewAuditRepo(path)
+	assert.NoError(t, err)
+
+	timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored, err := repo.AddRecords(ctx, []models.AuditRecord{
+		{Entity: models.AuditEntityUser, EntityID: 1, Operation: models.AuditOperationDelete, Actor: "ops", Before: json.RawMessage(`{"id":1}`), After: json.RawMessage(`{"id":1,"deletedAt":"2024-01-01T00:00:00Z"}`), Timestamp: timestamp},
+		{Entity: models.AuditEntityAction, EntityID: 0, Operation: models.AuditOperationDelete, Actor: "ops", Timestamp: timestamp},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, []int{stored[0].ID, stored[1].ID})
+	assert.NoError(t, repo.Close())
+
+	// A new repository picks up where the previous one stopped
+	reloaded, err := NewAuditRepo(path)
+	assert.NoError(t, err)
+	defer reloaded.Close()
+
+	userID := 1
+	records := reloaded.GetRecords(ctx, Query{Entity: models.AuditEntityUser, EntityID: &userID})
+	assert.Len(t, records, 1)
+	assert.Equal(t, "ops", records[0].Actor)
+	assert.JSONEq(t, `{"id":1}`, string(records[0].Before))
+
+	// Action IDs start at zero, which is a valid filter
+	actionID := 0
+	assert.Len(t, reloaded.GetRecords(ctx, Query{Entity: models.AuditEntityAction, EntityID: &actionID}), 1)
+	assert.Len(t, reloaded.GetRecords(ctx, Query{Entity: models.AuditEntityAction}), 1)
+	assert.Len(t, reloaded.GetRecords(ctx, Query{}), 2)
+
+	// Pages carry on after the last record of the previous one
+	assert.Equal(t, 1, reloaded.GetRecords(ctx, Query{Limit: 1})[0].ID)
+	assert.Equal(t, 2, reloaded.GetRecords(ctx, Query{After: 1, Limit: 1})[0].ID)
+	assert.Empty(t, reloaded.GetRecords(ctx, Query{Entity: models.AuditEntityUser, EntityID: &userID, After: 1}))
+
+	added, err := reloaded.AddRecords(ctx, []models.AuditRecord{{Entity: models.AuditEntityUser, EntityID: 2, Operation: models.AuditOperationDelete}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, added[0].ID)
+}
+
+func TestNewAuditRepo_TruncatesTornWrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	repo, err := NewAuditRepo(path)
+	assert.NoError(t, err)
+	_, err = repo.AddRecords(ctx, []models.AuditRecord{{Entity: models.AuditEntityUser, EntityID: 1, Operation: models.AuditOperationDelete}})
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Close())
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`{"id":2,"entity":"us`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	reloaded, err := NewAuditRepo(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reloaded.Count())
+
+	_, err = reloaded.AddRecords(ctx, []models.AuditRecord{{Entity: models.AuditEntityUser, EntityID: 2, Operation: models.AuditOperationDelete}})
+	assert.NoError(t, err)
+	assert.NoError(t, reloaded.Close())
+
+	reloaded, err = NewAuditRepo(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, reloaded.Count())
+}
+
+func TestNewAuditRepo_RefusesCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	assert.NoError(t, os.WriteFile(path, []byte("not json\n{\"id\":2}\n"), 0o644))
+
+	_, err := NewAuditRepo(path)
+	assert.ErrorIs(t, err, ErrCorruptTrail)
+}
+
+func replace(data string) func(json.RawMessage) (json.RawMessage, error) {
+	return func(json.RawMessage) (json.RawMessage, error) { return json.RawMessage(data), nil }
+}
+
+func TestRepositoryImpl_Redact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	repo, err := NewAuditRepo(path)
+	assert.NoError(t, err)
+
+	_, err = repo.AddRecords(ctx, []models.AuditRecord{
+		{Entity: models.AuditEntityUser, EntityID: 1, Operation: models.AuditOperationDelete, Before: json.RawMessage(`{"id":1,"name":"Ferdinande"}`), After: json.RawMessage(`{"id":1,"name":"Ferdinande","deletedAt":"2024-01-01T00:00:00Z"}`)},
+		{Entity: models.AuditEntityUser, EntityID: 2, Operation: models.AuditOperationDelete, Before: json.RawMessage(`{"id":2,"name":"Amelie"}`)},
+	})
+	assert.NoError(t, err)
+
+	redacted, err := repo.Redact(ctx, []Redaction{{Entity: models.AuditEntityUser, EntityID: 1, Redact: replace(`{"id":1}`)}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, redacted)
+	assert.NoError(t, repo.Close())
+
+	// Entities that were null stay null once read back from the file
+	repo, err = NewAuditRepo(path)
+	assert.NoError(t, err)
+	_, err = repo.Redact(ctx, []Redaction{{Entity: models.AuditEntityUser, EntityID: 2, Redact: replace(`{"id":2}`)}})
+	assert.NoError(t, err)
+	userID := 2
+	assert.JSONEq(t, `null`, string(repo.GetRecords(ctx, Query{Entity: models.AuditEntityUser, EntityID: &userID})[0].After))
+
+	// Records appended after the rewrite land in the new file
+	_, err = repo.AddRecords(ctx, []models.AuditRecord{{Entity: models.AuditEntityUser, EntityID: 1, Operation: models.AuditOperationErase, After: json.RawMessage(`{"id":1}`)}})
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "Ferdinande")
+	assert.NotContains(t, string(data), "Amelie")
+
+	reloaded, err := NewAuditRepo(path)
+	assert.NoError(t, err)
+	defer reloaded.Close()
+
+	userID = 1
+	records := reloaded.GetRecords(ctx, Query{Entity: models.AuditEntityUser, EntityID: &userID})
+	assert.Len(t, records, 2)
+	assert.JSONEq(t, `{"id":1}`, string(records[0].Before))
+	assert.JSONEq(t, `{"id":1}`, string(records[0].After))
+	assert.JSONEq(t, `null`, string(records[1].Before))
+	assert.Equal(t, models.AuditOperationErase, records[1].Operation)
+}
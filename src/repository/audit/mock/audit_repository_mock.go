@@ -0,0 +1,81 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: audit_repository.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	models "github.com/AntonioDaria/surfe/src/models"
+	audit "github.com/AntonioDaria/surfe/src/repository/audit"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockRepository is a mock of Repository interface.
+type MockRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRepositoryMockRecorder
+}
+
+// MockRepositoryMockRecorder is the mock recorder for MockRepository.
+type MockRepositoryMockRecorder struct {
+	mock *MockRepository
+}
+
+// NewMockRepository creates a new mock instance.
+func NewMockRepository(ctrl *gomock.Controller) *MockRepository {
+	mock := &MockRepository{ctrl: ctrl}
+	mock.recorder = &MockRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AddRecords mocks base method.
+func (m *MockRepository) AddRecords(ctx context.Context, records []models.AuditRecord) ([]models.AuditRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddRecords", ctx, records)
+	ret0, _ := ret[0].([]models.AuditRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddRecords indicates an expected call of AddRecords.
+func (mr *MockRepositoryMockRecorder) AddRecords(ctx, records interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddRecords", reflect.TypeOf((*MockRepository)(nil).AddRecords), ctx, records)
+}
+
+// GetRecords mocks base method.
+func (m *MockRepository) GetRecords(ctx context.Context, query audit.Query) []models.AuditRecord {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecords", ctx, query)
+	ret0, _ := ret[0].([]models.AuditRecord)
+	return ret0
+}
+
+// GetRecords indicates an expected call of GetRecords.
+func (mr *MockRepositoryMockRecorder) GetRecords(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecords", reflect.TypeOf((*MockRepository)(nil).GetRecords), ctx, query)
+}
+
+// Redact mocks base method.
+func (m *MockRepository) Redact(ctx context.Context, redactions []audit.Redaction) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Redact", ctx, redactions)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Redact indicates an expected call of Redact.
+func (mr *MockRepositoryMockRecorder) Redact(ctx, redactions interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Redact", reflect.TypeOf((*MockRepository)(nil).Redact), ctx, redactions)
+}
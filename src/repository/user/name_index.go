@@ -0,0 +1,187 @@
+package user
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AntonioDaria/surfe/src/models"
+)
+
+// minSimilarity is how similar a name must be to the query to match it without
+// starting with it, as trigram similarity or the share of characters that
+// don't need editing
+const minSimilarity = 0.65
+
+// nameEntry is a user's name as it is searched, with the user's position
+type nameEntry struct {
+	name string
+	pos  int
+}
+
+// userIndex finds users by ID, name and signup date without going through every
+// user. It holds positions in the repository's users and must be rebuilt
+// whenever a name changes.
+type userIndex struct {
+	// byID holds the position of every user by ID
+	byID map[int]int
+	// byName holds the names of every user, lowercased and sorted, so the names
+	// starting with a prefix are next to each other
+	byName []nameEntry
+	// trigrams holds the positions of the users whose name contains each trigram
+	trigrams map[string][]int
+	// byCreatedAt holds the positions of every user sorted by signup date
+	byCreatedAt []int
+}
+
+func newUserIndex(users []models.User) *userIndex {
+	idx := &userIndex{
+		byID:        make(map[int]int, len(users)),
+		byName:      make([]nameEntry, 0, len(users)),
+		trigrams:    make(map[string][]int),
+		byCreatedAt: make([]int, len(users)),
+	}
+
+	for pos, user := range users {
+		idx.byCreatedAt[pos] = pos
+		// Lookups went through the users in order, so the first of duplicate IDs is kept
+		if _, ok := idx.byID[user.ID]; !ok {
+			idx.byID[user.ID] = pos
+		}
+		if user.Name == "" {
+			continue
+		}
+
+		name := normalizeName(user.Name)
+		idx.byName = append(idx.byName, nameEntry{name: name, pos: pos})
+		for _, trigram := range trigrams(name) {
+			idx.trigrams[trigram] = append(idx.trigrams[trigram], pos)
+		}
+	}
+
+	sort.Slice(idx.byName, func(i, j int) bool { return idx.byName[i].name < idx.byName[j].name })
+	sort.SliceStable(idx.byCreatedAt, func(i, j int) bool {
+		return users[idx.byCreatedAt[i]].CreatedAt.Before(users[idx.byCreatedAt[j]].CreatedAt)
+	})
+	return idx
+}
+
+// normalizeName returns a name as it is indexed and searched
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// matchName returns the position of every user whose name matches the query,
+// with how relevant it is. Names starting with the query rank above the others,
+// which only match when they are similar enough to it.
+func (idx *userIndex) matchName(users []models.User, query string) map[int]float64 {
+	query = normalizeName(query)
+	matches := make(map[int]float64)
+
+	start := sort.Search(len(idx.byName), func(i int) bool { return idx.byName[i].name >= query })
+	for _, entry := range idx.byName[start:] {
+		if !strings.HasPrefix(entry.name, query) {
+			break
+		}
+		// The closer the name is to the query the more relevant it is, the exact name being the most
+		matches[entry.pos] = 1 + float64(utf8.RuneCountInString(query))/float64(utf8.RuneCountInString(entry.name))
+	}
+
+	// Only names sharing a trigram with the query can be similar to it
+	queryTrigrams := trigrams(query)
+	shared := make(map[int]int)
+	for _, trigram := range queryTrigrams {
+		for _, pos := range idx.trigrams[trigram] {
+			shared[pos]++
+		}
+	}
+
+	for pos, count := range shared {
+		if _, ok := matches[pos]; ok {
+			continue
+		}
+
+		name := normalizeName(users[pos].Name)
+		nameTrigrams := len(trigrams(name))
+		similarity := float64(count) / float64(len(queryTrigrams)+nameTrigrams-count)
+
+		longest := max(utf8.RuneCountInString(name), utf8.RuneCountInString(query))
+		similarity = max(similarity, 1-float64(editDistance(query, name))/float64(longest))
+
+		if similarity >= minSimilarity {
+			matches[pos] = similarity
+		}
+	}
+
+	return matches
+}
+
+// createdBetween returns the positions of the users who signed up from from
+// included to to excluded, in signup order. A zero bound leaves that side open.
+func (idx *userIndex) createdBetween(users []models.User, from, to time.Time) []int {
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(idx.byCreatedAt), func(i int) bool {
+			return !users[idx.byCreatedAt[i]].CreatedAt.Before(from)
+		})
+	}
+
+	end := len(idx.byCreatedAt)
+	if !to.IsZero() {
+		end = sort.Search(len(idx.byCreatedAt), func(i int) bool {
+			return !users[idx.byCreatedAt[i]].CreatedAt.Before(to)
+		})
+	}
+
+	if start >= end {
+		return nil
+	}
+	return idx.byCreatedAt[start:end]
+}
+
+// trigrams returns the distinct three character sequences of a name, padded so
+// its start and end count as well
+func trigrams(name string) []string {
+	runes := []rune("  " + name + " ")
+	seen := make(map[string]bool, len(runes))
+	result := make([]string, 0, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		trigram := string(runes[i : i+3])
+		if !seen[trigram] {
+			seen[trigram] = true
+			result = append(result, trigram)
+		}
+	}
+	return result
+}
+
+// editDistance returns how many characters must be inserted, deleted, replaced
+// or swapped with their neighbour to turn a into b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// Only the last three rows are needed to count swaps
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
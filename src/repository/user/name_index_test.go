@@ -0,0 +1,26 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_editDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{a: "pier", b: "pier", distance: 0},
+		{a: "pier", b: "peir", distance: 1},
+		{a: "amelie", b: "amelia", distance: 1},
+		{a: "amber", b: "amer", distance: 1},
+		{a: "", b: "amber", distance: 5},
+		{a: "zoë", b: "zoe", distance: 1},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.distance, editDistance(tt.a, tt.b), "%s -> %s", tt.a, tt.b)
+		assert.Equal(t, tt.distance, editDistance(tt.b, tt.a), "%s -> %s", tt.b, tt.a)
+	}
+}
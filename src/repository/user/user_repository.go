@@ -1,23 +1,87 @@
 package user
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/AntonioDaria/surfe/src/atomicfile"
 	"github.com/AntonioDaria/surfe/src/models"
+	"go.opentelemetry.io/otel"
 )
 
 var ErrUserNotFound = fmt.Errorf("user not found")
 
+var tracer = otel.Tracer("github.com/AntonioDaria/surfe/src/repository/user")
+
 //go:generate mockgen -source=$GOFILE -destination=mock/repository_mock.go -package=mock
 
 type Repository interface {
-	GetUserByID(userID int) (*models.User, error)
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+	GetUsersByIDs(ctx context.Context, userIDs []int) map[int]*models.User
+	GetStoredUser(ctx context.Context, userID int) (*models.User, error)
+	DeleteUser(ctx context.Context, userID int, at time.Time) (*models.User, error)
+	EraseUser(ctx context.Context, userID int, at time.Time) (*models.User, error)
+	SearchUsers(ctx context.Context, query SearchQuery) SearchResult
+}
+
+// SearchSort is the order of search results
+type SearchSort string
+
+const (
+	// SortRelevance puts the names closest to the searched name first, and orders
+	// by ID when no name is searched
+	SortRelevance     SearchSort = "relevance"
+	SortName          SearchSort = "name"
+	SortNameDesc      SearchSort = "-name"
+	SortCreatedAt     SearchSort = "createdAt"
+	SortCreatedAtDesc SearchSort = "-createdAt"
+)
+
+// IsValid reports whether the sort is one the search supports
+func (s SearchSort) IsValid() bool {
+	switch s {
+	case SortRelevance, SortName, SortNameDesc, SortCreatedAt, SortCreatedAtDesc:
+		return true
+	}
+	return false
+}
+
+// SearchQuery selects users by name and signup date. The zero value selects every user.
+type SearchQuery struct {
+	// Name matches the users whose name starts with it, ignoring case, or is similar to it
+	Name string
+	// CreatedFrom and CreatedTo select the users who signed up from CreatedFrom
+	// included to CreatedTo excluded. A zero bound leaves that side open.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Sort        SearchSort
+	// Offset is how many matching users to skip, and Limit how many to return at most
+	Offset int
+	Limit  int
+}
+
+// SearchResult is a page of the users matching a search
+type SearchResult struct {
+	Users []models.User
+	// Total is how many users match the search, on every page
+	Total int
 }
 
 type RepositoryImpl struct {
+	mu sync.RWMutex
+	// users holds every user, soft deleted ones included
 	users []models.User
+	// filePath is where the users are saved on every write, nothing is saved when empty
+	filePath string
+	// index finds users by ID, name and signup date
+	index *userIndex
 }
 
 // NewUserRepo loads user data from a JSON file and initializes UserRepo
@@ -32,15 +96,264 @@ func NewUserRepo(filePath string) (*RepositoryImpl, error) {
 		return nil, fmt.Errorf("failed to unmarshal user data: %w", err)
 	}
 
-	return &RepositoryImpl{users: users}, nil
+	return &RepositoryImpl{users: users, index: newUserIndex(users)}, nil
+}
+
+// OpenUserRepo loads the users from the JSON file at filePath, and saves them
+// there on every write. When the file doesn't exist yet, the users are loaded
+// from the JSON file at seedPath instead. Nothing is saved when filePath is empty.
+func OpenUserRepo(filePath, seedPath string) (*RepositoryImpl, error) {
+	if filePath != "" {
+		if _, err := os.Stat(filePath); err == nil {
+			r, err := NewUserRepo(filePath)
+			if err != nil {
+				return nil, err
+			}
+			r.filePath = filePath
+			return r, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read user file: %w", err)
+		}
+	}
+
+	r, err := NewUserRepo(seedPath)
+	if err != nil {
+		return nil, err
+	}
+	r.filePath = filePath
+	return r, nil
 }
 
 // GetUserByID retrieves a user by their ID
-func (r *RepositoryImpl) GetUserByID(userID int) (*models.User, error) {
+func (r *RepositoryImpl) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
+	_, span := tracer.Start(ctx, "user.Repository.GetUserByID")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pos, ok := r.index.byID[userID]
+	if !ok || r.users[pos].DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+	user := r.users[pos]
+	return &user, nil
+}
+
+// GetStoredUser retrieves a user by their ID whether or not they were deleted.
+// It returns ErrUserNotFound when the user doesn't exist.
+func (r *RepositoryImpl) GetStoredUser(ctx context.Context, userID int) (*models.User, error) {
+	_, span := tracer.Start(ctx, "user.Repository.GetStoredUser")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pos, ok := r.index.byID[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	user := r.users[pos]
+	return &user, nil
+}
+
+// GetUsersByIDs retrieves several users through the index, keyed by ID.
+// Users that don't exist or were deleted are left out.
+func (r *RepositoryImpl) GetUsersByIDs(ctx context.Context, userIDs []int) map[int]*models.User {
+	_, span := tracer.Start(ctx, "user.Repository.GetUsersByIDs")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make(map[int]*models.User, len(userIDs))
+	for _, id := range userIDs {
+		pos, ok := r.index.byID[id]
+		if !ok || r.users[pos].DeletedAt != nil {
+			continue
+		}
+		user := r.users[pos]
+		users[id] = &user
+	}
+	return users
+}
+
+// DeleteUser soft deletes a user and returns them as deleted. It returns
+// ErrUserNotFound when the user doesn't exist or was already deleted.
+func (r *RepositoryImpl) DeleteUser(ctx context.Context, userID int, at time.Time) (*models.User, error) {
+	_, span := tracer.Start(ctx, "user.Repository.DeleteUser")
+	defer span.End()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.index.byID[userID]
+	if !ok || r.users[i].DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+
+	r.users[i].DeletedAt = &at
+	if err := r.save(); err != nil {
+		r.users[i].DeletedAt = nil
+		span.RecordError(err)
+		return nil, err
+	}
+
+	user := r.users[i]
+	return &user, nil
+}
+
+// EraseUser removes the personal data of a user, deleting them if they weren't
+// already, and returns them as erased. Erasing a user twice leaves them as they
+// were, so an erasure that failed halfway can be retried. It returns
+// ErrUserNotFound when the user doesn't exist.
+func (r *RepositoryImpl) EraseUser(ctx context.Context, userID int, at time.Time) (*models.User, error) {
+	_, span := tracer.Start(ctx, "user.Repository.EraseUser")
+	defer span.End()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.index.byID[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if r.users[i].ErasedAt != nil {
+		user := r.users[i]
+		return &user, nil
+	}
+
+	previous := r.users[i]
+	erased := models.User{ID: previous.ID, CreatedAt: previous.CreatedAt, DeletedAt: previous.DeletedAt, ErasedAt: &at}
+	if erased.DeletedAt == nil {
+		erased.DeletedAt = &at
+	}
+
+	r.users[i] = erased
+	if err := r.save(); err != nil {
+		r.users[i] = previous
+		span.RecordError(err)
+		return nil, err
+	}
+
+	// The erased name mustn't be found, or kept, by the index
+	r.index = newUserIndex(r.users)
+	return &erased, nil
+}
+
+// SearchUsers returns a page of the users who weren't deleted and match the
+// query. Users are found through the index, so only the users matching the
+// name, or else the signup dates, are gone through.
+func (r *RepositoryImpl) SearchUsers(ctx context.Context, query SearchQuery) SearchResult {
+	_, span := tracer.Start(ctx, "user.Repository.SearchUsers")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var relevance map[int]float64
+	var candidates []int
+	switch {
+	case query.Name != "":
+		relevance = r.index.matchName(r.users, query.Name)
+		candidates = make([]int, 0, len(relevance))
+		for pos := range relevance {
+			candidates = append(candidates, pos)
+		}
+	case !query.CreatedFrom.IsZero() || !query.CreatedTo.IsZero():
+		candidates = r.index.createdBetween(r.users, query.CreatedFrom, query.CreatedTo)
+	default:
+		candidates = r.index.byCreatedAt
+	}
+
+	matches := make([]models.User, 0, len(candidates))
+	scores := make(map[int]float64, len(relevance))
+	for _, pos := range candidates {
+		user := r.users[pos]
+		if user.DeletedAt != nil {
+			continue
+		}
+		if !query.CreatedFrom.IsZero() && user.CreatedAt.Before(query.CreatedFrom) {
+			continue
+		}
+		if !query.CreatedTo.IsZero() && !user.CreatedAt.Before(query.CreatedTo) {
+			continue
+		}
+		matches = append(matches, user)
+		if relevance != nil {
+			scores[user.ID] = relevance[pos]
+		}
+	}
+
+	sortUsers(matches, query.Sort, scores)
+
+	result := SearchResult{Users: []models.User{}, Total: len(matches)}
+	if query.Offset < len(matches) {
+		end := len(matches)
+		if query.Limit > 0 {
+			end = min(end, query.Offset+query.Limit)
+		}
+		result.Users = matches[query.Offset:end]
+	}
+	return result
+}
+
+// sortUsers orders search results, breaking ties by ID so pages are stable
+func sortUsers(users []models.User, order SearchSort, scores map[int]float64) {
+	less := func(a, b models.User) bool { return a.ID < b.ID }
+	switch order {
+	case SortName:
+		less = func(a, b models.User) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case SortNameDesc:
+		less = func(a, b models.User) bool { return strings.ToLower(a.Name) > strings.ToLower(b.Name) }
+	case SortCreatedAt:
+		less = func(a, b models.User) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case SortCreatedAtDesc:
+		less = func(a, b models.User) bool { return a.CreatedAt.After(b.CreatedAt) }
+	default:
+		if len(scores) > 0 {
+			less = func(a, b models.User) bool { return scores[a.ID] > scores[b.ID] }
+		}
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		if less(users[i], users[j]) {
+			return true
+		}
+		if less(users[j], users[i]) {
+			return false
+		}
+		return users[i].ID < users[j].ID
+	})
+}
+
+// save writes the users to the repository's file. The lock must be held.
+func (r *RepositoryImpl) save() error {
+	if r.filePath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(r.users)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user data: %w", err)
+	}
+
+	if err := atomicfile.Write(r.filePath, data); err != nil {
+		return fmt.Errorf("failed to save user data: %w", err)
+	}
+	return nil
+}
+
+// Count returns the number of users that weren't deleted
+func (r *RepositoryImpl) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
 	for _, user := range r.users {
-		if user.ID == userID {
-			return &user, nil
+		if user.DeletedAt == nil {
+			count++
 		}
 	}
-	return nil, ErrUserNotFound
+	return count
 }
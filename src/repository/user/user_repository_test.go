@@ -1,7 +1,11 @@
 package user
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -14,7 +18,7 @@ func Test_GetUserByID(t *testing.T) {
 	}
 
 	// Act
-	user, err := userRepo.GetUserByID(1)
+	user, err := userRepo.GetUserByID(context.Background(), 1)
 
 	// Assert
 	if err != nil {
@@ -27,3 +31,158 @@ func Test_GetUserByID(t *testing.T) {
 
 	assert.Equal(t, "Ferdinande", user.Name)
 }
+
+func Test_GetUsersByIDs(t *testing.T) {
+	userRepo, err := NewUserRepo("../data/users.json")
+	if err != nil {
+		t.Fatalf("failed to create user repository: %v", err)
+	}
+
+	users := userRepo.GetUsersByIDs(context.Background(), []int{1, 2, 1, 1000})
+
+	// Duplicates are collapsed and unknown users left out
+	assert.Len(t, users, 2)
+	assert.Equal(t, "Ferdinande", users[1].Name)
+	assert.Equal(t, 2, users[2].ID)
+}
+
+func Test_Count(t *testing.T) {
+	userRepo, err := NewUserRepo("../data/users.json")
+	if err != nil {
+		t.Fatalf("failed to create user repository: %v", err)
+	}
+
+	assert.Equal(t, 1000, userRepo.Count())
+}
+
+func Test_DeleteUser(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	userRepo, err := OpenUserRepo(path, "../data/users.json")
+	if err != nil {
+		t.Fatalf("failed to create user repository: %v", err)
+	}
+
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	deleted, err := userRepo.DeleteUser(ctx, 1, at)
+	assert.NoError(t, err)
+	assert.Equal(t, at, *deleted.DeletedAt)
+
+	// Deleted users are left out of every read
+	_, err = userRepo.GetUserByID(ctx, 1)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	assert.Len(t, userRepo.GetUsersByIDs(ctx, []int{1, 2}), 1)
+	assert.Equal(t, 999, userRepo.Count())
+
+	// But for the read of the users still stored
+	stored, err := userRepo.GetStoredUser(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, stored)
+	_, err = userRepo.GetStoredUser(ctx, 5000)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	_, err = userRepo.DeleteUser(ctx, 1, at)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	// The deletion is saved, and the file used from then on
+	reloaded, err := OpenUserRepo(path, "../data/users.json")
+	assert.NoError(t, err)
+	_, err = reloaded.GetUserByID(ctx, 1)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	assert.Equal(t, 999, reloaded.Count())
+}
+
+func Test_EraseUser(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	userRepo, err := OpenUserRepo(path, "../data/users.json")
+	if err != nil {
+		t.Fatalf("failed to create user repository: %v", err)
+	}
+
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = userRepo.DeleteUser(ctx, 1, deletedAt)
+	assert.NoError(t, err)
+
+	// Deleted users can still be erased, and keep when they were deleted
+	at := deletedAt.Add(time.Hour)
+	erased, err := userRepo.EraseUser(ctx, 1, at)
+	assert.NoError(t, err)
+	assert.Empty(t, erased.Name)
+	assert.Equal(t, deletedAt, *erased.DeletedAt)
+	assert.Equal(t, at, *erased.ErasedAt)
+
+	// Erasing again changes nothing
+	again, err := userRepo.EraseUser(ctx, 1, at.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, erased, again)
+
+	erased, err = userRepo.EraseUser(ctx, 2, at)
+	assert.NoError(t, err)
+	assert.Equal(t, at, *erased.DeletedAt)
+	_, err = userRepo.GetUserByID(ctx, 2)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	_, err = userRepo.EraseUser(ctx, 5000, at)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	// The names are gone from the saved file
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "Ferdinande")
+	assert.NotContains(t, string(data), "Amelie")
+}
+
+func Test_SearchUsers(t *testing.T) {
+	ctx := context.Background()
+	userRepo, err := NewUserRepo("../data/users.json")
+	if err != nil {
+		t.Fatalf("failed to create user repository: %v", err)
+	}
+
+	names := func(result SearchResult) []string {
+		names := make([]string, len(result.Users))
+		for i, user := range result.Users {
+			names[i] = user.Name
+		}
+		return names
+	}
+
+	// Prefixes ignore case, and the closest names come first
+	result := userRepo.SearchUsers(ctx, SearchQuery{Name: "AM", Sort: SortName})
+	assert.Equal(t, []string{"Amabel", "Amalie", "Amandie", "Amargo", "Amber", "Amelie"}, names(result))
+
+	// Names with a typo are found, behind the ones starting with the query
+	result = userRepo.SearchUsers(ctx, SearchQuery{Name: "amalei"})
+	assert.Equal(t, "Amalie", result.Users[0].Name)
+	result = userRepo.SearchUsers(ctx, SearchQuery{Name: "Ferdinadne"})
+	assert.Equal(t, []string{"Ferdinande"}, names(result))
+
+	// Signup dates are bounded from included to excluded
+	from := time.Date(2020, 7, 14, 0, 0, 0, 0, time.UTC)
+	result = userRepo.SearchUsers(ctx, SearchQuery{CreatedFrom: from, CreatedTo: from.AddDate(0, 0, 1)})
+	assert.Equal(t, []string{"Ferdinande"}, names(result))
+	result = userRepo.SearchUsers(ctx, SearchQuery{Name: "am", CreatedTo: from})
+	for _, user := range result.Users {
+		assert.True(t, user.CreatedAt.Before(from))
+	}
+
+	// Pages are cut from the sorted matches
+	all := userRepo.SearchUsers(ctx, SearchQuery{Sort: SortCreatedAtDesc})
+	assert.Equal(t, 1000, all.Total)
+	page := userRepo.SearchUsers(ctx, SearchQuery{Sort: SortCreatedAtDesc, Offset: 10, Limit: 5})
+	assert.Equal(t, 1000, page.Total)
+	assert.Equal(t, all.Users[10:15], page.Users)
+	assert.Empty(t, userRepo.SearchUsers(ctx, SearchQuery{Offset: 1000}).Users)
+
+	// Deleted users aren't found, and erased ones not even by their former name
+	_, err = userRepo.DeleteUser(ctx, 2, from)
+	assert.NoError(t, err)
+	_, err = userRepo.EraseUser(ctx, 1, from)
+	assert.NoError(t, err)
+	assert.NotContains(t, names(userRepo.SearchUsers(ctx, SearchQuery{Name: "am"})), "Amelie")
+	assert.Zero(t, userRepo.SearchUsers(ctx, SearchQuery{Name: "Ferdinande"}).Total)
+	assert.Equal(t, 998, userRepo.SearchUsers(ctx, SearchQuery{}).Total)
+}
@@ -0,0 +1,188 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AntonioDaria/surfe/src/atomicfile"
+	"github.com/AntonioDaria/surfe/src/models"
+)
+
+// ErrCorruptDeliveryLog is returned when a line in the middle of the delivery log
+// can't be decoded. Only the last line can be partially written, so this is not
+// a torn write.
+var ErrCorruptDeliveryLog = errors.New("webhook delivery log is corrupt")
+
+// minCompactRecords is how many records the delivery log can hold before it is
+// compacted, so a small log isn't rewritten every few writes
+const minCompactRecords = 1024
+
+// deliveryRecord is a line of the delivery log: a delivery as it was added or
+// updated, the IDs of the deliveries pruned from the log, or the last delivery
+// ID assigned, which compaction records as the latest deliveries may be gone
+type deliveryRecord struct {
+	Delivery *models.WebhookDelivery `json:"delivery,omitempty"`
+	Pruned   []int                   `json:"pruned,omitempty"`
+	LastID   int                     `json:"lastId,omitempty"`
+}
+
+// deliveryLog is an append-only file with one JSON line per deliveryRecord, so
+// an attempt only writes the delivery it updated. Replaying the records in order
+// gives back the deliveries. Once most records are outdated, the log is
+// compacted to one record per delivery.
+type deliveryLog struct {
+	path string
+	file *os.File
+	size int64
+	// records is how many records the file holds
+	records int
+}
+
+// openDeliveryLog opens the log at path, creating it if needed, and replays its
+// records into the deliveries and the last delivery ID assigned. A line torn by
+// a crash in the middle of a write can only be the last one; it is truncated so
+// appending can carry on after the valid records.
+func openDeliveryLog(path string) (*deliveryLog, []models.WebhookDelivery, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, 0, fmt.Errorf("failed to read webhook delivery log: %w", err)
+	}
+
+	var deliveries []models.WebhookDelivery
+	positions := make(map[int]int)
+	pruned := make(map[int]bool)
+	records := 0
+	lastID := 0
+
+	valid := 0
+	for valid < len(data) {
+		end := bytes.IndexByte(data[valid:], '\n')
+
+		var record deliveryRecord
+		if end < 0 || json.Unmarshal(data[valid:valid+end], &record) != nil {
+			if end < 0 || valid+end+1 == len(data) {
+				break
+			}
+			return nil, nil, 0, fmt.Errorf("%w: bad record at offset %d", ErrCorruptDeliveryLog, valid)
+		}
+
+		if d := record.Delivery; d != nil {
+			lastID = max(lastID, d.ID)
+			if pos, ok := positions[d.ID]; ok {
+				deliveries[pos] = *d
+			} else {
+				positions[d.ID] = len(deliveries)
+				deliveries = append(deliveries, *d)
+			}
+		}
+		for _, id := range record.Pruned {
+			pruned[id] = true
+		}
+		lastID = max(lastID, record.LastID)
+
+		records++
+		valid += end + 1
+	}
+
+	if len(pruned) > 0 {
+		kept := deliveries[:0]
+		for _, d := range deliveries {
+			if !pruned[d.ID] {
+				kept = append(kept, d)
+			}
+		}
+		deliveries = kept
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to open webhook delivery log: %w", err)
+	}
+	l := &deliveryLog{path: path, file: file, size: int64(valid), records: records}
+
+	if valid < len(data) {
+		if err := file.Truncate(l.size); err != nil {
+			file.Close()
+			return nil, nil, 0, fmt.Errorf("failed to truncate torn webhook delivery log write: %w", err)
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return nil, nil, 0, fmt.Errorf("failed to truncate torn webhook delivery log write: %w", err)
+		}
+	}
+	if _, err := file.Seek(l.size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, 0, fmt.Errorf("failed to open webhook delivery log: %w", err)
+	}
+
+	return l, deliveries, lastID, nil
+}
+
+func encodeRecords(records []deliveryRecord) ([]byte, error) {
+	var buf []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal webhook delivery: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	return buf, nil
+}
+
+// append writes the records and syncs them to disk. On failure the log is
+// rolled back to its previous size.
+func (l *deliveryLog) append(records []deliveryRecord) error {
+	buf, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.file.Write(buf); err != nil {
+		l.rollback()
+		return fmt.Errorf("failed to append to webhook delivery log: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		l.rollback()
+		return fmt.Errorf("failed to sync webhook delivery log: %w", err)
+	}
+
+	l.size += int64(len(buf))
+	l.records += len(records)
+	return nil
+}
+
+// rollback discards a partial append
+func (l *deliveryLog) rollback() {
+	_ = l.file.Truncate(l.size)
+	_, _ = l.file.Seek(l.size, io.SeekStart)
+}
+
+// compact replaces the log with one record per delivery followed by the last
+// delivery ID assigned, and appends to the new file from then on
+func (l *deliveryLog) compact(deliveries []models.WebhookDelivery, lastID int) error {
+	records := make([]deliveryRecord, len(deliveries), len(deliveries)+1)
+	for i := range deliveries {
+		records[i] = deliveryRecord{Delivery: &deliveries[i]}
+	}
+	if lastID > 0 {
+		records = append(records, deliveryRecord{LastID: lastID})
+	}
+	buf, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+
+	file, err := atomicfile.Replace(l.path, buf)
+	if err != nil {
+		return fmt.Errorf("failed to compact webhook delivery log: %w", err)
+	}
+
+	l.file.Close()
+	l.file, l.size, l.records = file, int64(len(buf)), len(records)
+	return nil
+}
@@ -0,0 +1,363 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/AntonioDaria/surfe/src/atomicfile"
+	"github.com/AntonioDaria/surfe/src/models"
+	"go.opentelemetry.io/otel"
+)
+
+var (
+	ErrWebhookNotFound  = fmt.Errorf("webhook not found")
+	ErrDeliveryNotFound = fmt.Errorf("delivery not found")
+)
+
+var tracer = otel.Tracer("github.com/AntonioDaria/surfe/src/repository/webhook")
+
+// MaxDeliveriesPerWebhook is how many deliveries are kept in the log of each
+// webhook. The oldest completed deliveries are dropped first.
+const MaxDeliveriesPerWebhook = 1000
+
+//go:generate mockgen -source=$GOFILE -destination=mock/webhook_repository_mock.go -package=mock
+type Repository interface {
+	CreateWebhook(ctx context.Context, webhook models.Webhook) (*models.Webhook, error)
+	GetWebhook(ctx context.Context, webhookID int) (*models.Webhook, error)
+	GetWebhooks(ctx context.Context) []models.Webhook
+	AddDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) ([]models.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	GetDeliveries(ctx context.Context, webhookID int) ([]models.WebhookDelivery, error)
+	GetPendingDeliveries(ctx context.Context) []models.WebhookDelivery
+	EraseUser(ctx context.Context, userID int) error
+}
+
+// state is the content of the webhook file
+type state struct {
+	Webhooks []models.Webhook `json:"webhooks"`
+}
+
+type RepositoryImpl struct {
+	mu sync.RWMutex
+	// filePath is where the webhooks are saved, nothing is saved when empty
+	filePath       string
+	webhooks       []models.Webhook
+	deliveries     []models.WebhookDelivery
+	nextWebhookID  int
+	nextDeliveryID int
+	// deliveryLog records every change to the deliveries, nil when they aren't saved
+	deliveryLog *deliveryLog
+}
+
+// NewWebhookRepo loads the webhooks from a JSON file, which is rewritten when a
+// webhook is created, and their deliveries from a log in deliveriesPath, which
+// every delivery and attempt is appended to. A missing file starts an empty
+// repository, and nothing is saved to an empty path.
+func NewWebhookRepo(filePath, deliveriesPath string) (*RepositoryImpl, error) {
+	r := &RepositoryImpl{filePath: filePath}
+
+	if filePath != "" {
+		file, err := os.ReadFile(filePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("failed to read webhook file: %w", err)
+		default:
+			var s state
+			if err := json.Unmarshal(file, &s); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal webhook data: %w", err)
+			}
+			r.webhooks = s.Webhooks
+		}
+	}
+	for _, w := range r.webhooks {
+		r.nextWebhookID = max(r.nextWebhookID, w.ID)
+	}
+
+	if deliveriesPath != "" {
+		log, deliveries, lastID, err := openDeliveryLog(deliveriesPath)
+		if err != nil {
+			return nil, err
+		}
+		r.deliveryLog = log
+		r.deliveries = deliveries
+		r.nextDeliveryID = lastID
+	}
+	for _, d := range r.deliveries {
+		r.nextDeliveryID = max(r.nextDeliveryID, d.ID)
+	}
+
+	return r, nil
+}
+
+// CreateWebhook stores a webhook, assigning its ID
+func (r *RepositoryImpl) CreateWebhook(ctx context.Context, webhook models.Webhook) (*models.Webhook, error) {
+	_, span := tracer.Start(ctx, "webhook.Repository.CreateWebhook")
+	defer span.End()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextWebhookID++
+	webhook.ID = r.nextWebhookID
+	r.webhooks = append(r.webhooks, webhook)
+
+	if err := r.save(); err != nil {
+		r.webhooks = r.webhooks[:len(r.webhooks)-1]
+		r.nextWebhookID--
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// GetWebhook retrieves a webhook by its ID
+func (r *RepositoryImpl) GetWebhook(ctx context.Context, webhookID int) (*models.Webhook, error) {
+	_, span := tracer.Start(ctx, "webhook.Repository.GetWebhook")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, w := range r.webhooks {
+		if w.ID == webhookID {
+			return &w, nil
+		}
+	}
+	return nil, ErrWebhookNotFound
+}
+
+// GetWebhooks returns every webhook, in the order they were created
+func (r *RepositoryImpl) GetWebhooks(ctx context.Context) []models.Webhook {
+	_, span := tracer.Start(ctx, "webhook.Repository.GetWebhooks")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]models.Webhook(nil), r.webhooks...)
+}
+
+// AddDeliveries stores a batch of deliveries with a single write, assigning their IDs
+func (r *RepositoryImpl) AddDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) ([]models.WebhookDelivery, error) {
+	_, span := tracer.Start(ctx, "webhook.Repository.AddDeliveries")
+	defer span.End()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, previousID := r.deliveries, r.nextDeliveryID
+
+	stored := make([]models.WebhookDelivery, len(deliveries))
+	records := make([]deliveryRecord, 0, len(deliveries)+1)
+	r.deliveries = append([]models.WebhookDelivery(nil), r.deliveries...)
+	for i, d := range deliveries {
+		r.nextDeliveryID++
+		d.ID = r.nextDeliveryID
+		r.deliveries = append(r.deliveries, d)
+		stored[i] = d
+		records = append(records, deliveryRecord{Delivery: &stored[i]})
+	}
+	if pruned := r.prune(); len(pruned) > 0 {
+		records = append(records, deliveryRecord{Pruned: pruned})
+	}
+
+	if err := r.appendDeliveries(records); err != nil {
+		r.deliveries, r.nextDeliveryID = previous, previousID
+		span.RecordError(err)
+		return nil, err
+	}
+	return stored, nil
+}
+
+// UpdateDelivery replaces a stored delivery with the same ID
+func (r *RepositoryImpl) UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	_, span := tracer.Start(ctx, "webhook.Repository.UpdateDelivery")
+	defer span.End()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.deliveries {
+		if r.deliveries[i].ID != delivery.ID {
+			continue
+		}
+
+		previous := r.deliveries[i]
+		r.deliveries[i] = delivery
+		if err := r.appendDeliveries([]deliveryRecord{{Delivery: &delivery}}); err != nil {
+			r.deliveries[i] = previous
+			span.RecordError(err)
+			return err
+		}
+		return nil
+	}
+
+	// The delivery may have been pruned from the log meanwhile
+	return ErrDeliveryNotFound
+}
+
+// GetDeliveries returns the delivery log of a webhook, most recent first
+func (r *RepositoryImpl) GetDeliveries(ctx context.Context, webhookID int) ([]models.WebhookDelivery, error) {
+	_, span := tracer.Start(ctx, "webhook.Repository.GetDeliveries")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	found := false
+	for _, w := range r.webhooks {
+		if w.ID == webhookID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrWebhookNotFound
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	for i := len(r.deliveries) - 1; i >= 0; i-- {
+		if r.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, r.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// GetPendingDeliveries returns the deliveries still to be attempted, in the order they were created
+func (r *RepositoryImpl) GetPendingDeliveries(ctx context.Context) []models.WebhookDelivery {
+	_, span := tracer.Start(ctx, "webhook.Repository.GetPendingDeliveries")
+	defer span.End()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var pending []models.WebhookDelivery
+	for _, d := range r.deliveries {
+		if d.Status == models.DeliveryStatusPending {
+			pending = append(pending, d)
+		}
+	}
+	return pending
+}
+
+// EraseUser drops the deliveries of the actions involving a user, and compacts
+// the delivery log so the actions delivered are gone from the file too.
+// Attempts still in flight for them stop, as the deliveries are no longer found.
+func (r *RepositoryImpl) EraseUser(ctx context.Context, userID int) error {
+	_, span := tracer.Start(ctx, "webhook.Repository.EraseUser")
+	defer span.End()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := make([]models.WebhookDelivery, 0, len(r.deliveries))
+	for _, d := range r.deliveries {
+		if !d.Action.Involves(userID) {
+			kept = append(kept, d)
+		}
+	}
+	if len(kept) == len(r.deliveries) {
+		return nil
+	}
+
+	if r.deliveryLog != nil {
+		if err := r.deliveryLog.compact(kept, r.nextDeliveryID); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+	r.deliveries = kept
+	return nil
+}
+
+// prune drops the oldest completed deliveries of the webhooks over
+// MaxDeliveriesPerWebhook, returning their IDs. The lock must be held.
+func (r *RepositoryImpl) prune() []int {
+	counts := make(map[int]int)
+	for _, d := range r.deliveries {
+		counts[d.WebhookID]++
+	}
+
+	excess := make(map[int]int)
+	for webhookID, count := range counts {
+		if count > MaxDeliveriesPerWebhook {
+			excess[webhookID] = count - MaxDeliveriesPerWebhook
+		}
+	}
+	if len(excess) == 0 {
+		return nil
+	}
+
+	var pruned []int
+	kept := r.deliveries[:0]
+	for _, d := range r.deliveries {
+		if excess[d.WebhookID] > 0 && d.Status != models.DeliveryStatusPending {
+			excess[d.WebhookID]--
+			pruned = append(pruned, d.ID)
+			continue
+		}
+		kept = append(kept, d)
+	}
+	r.deliveries = kept
+	return pruned
+}
+
+// appendDeliveries appends records to the delivery log, and compacts it once
+// most of its records are outdated. The lock must be held.
+func (r *RepositoryImpl) appendDeliveries(records []deliveryRecord) error {
+	if r.deliveryLog == nil {
+		return nil
+	}
+
+	if err := r.deliveryLog.append(records); err != nil {
+		return err
+	}
+
+	// The records are durable by now, so a failed compaction is tried again
+	// on the next write rather than failing this one
+	if r.deliveryLog.records > max(2*len(r.deliveries), minCompactRecords) {
+		_ = r.deliveryLog.compact(r.deliveries, r.nextDeliveryID)
+	}
+	return nil
+}
+
+// save writes the webhooks to the repository's file. The lock must be held.
+func (r *RepositoryImpl) save() error {
+	if r.filePath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(state{Webhooks: r.webhooks})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook data: %w", err)
+	}
+
+	if err := atomicfile.Write(r.filePath, data); err != nil {
+		return fmt.Errorf("failed to save webhook data: %w", err)
+	}
+	return nil
+}
+
+// Count returns the number of webhooks
+func (r *RepositoryImpl) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.webhooks)
+}
+
+// Close closes the delivery log
+func (r *RepositoryImpl) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.deliveryLog == nil {
+		return nil
+	}
+	return r.deliveryLog.file.Close()
+}
@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepositoryImpl_Persists(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path, deliveriesPath := filepath.Join(dir, "webhooks.json"), filepath.Join(dir, "deliveries.log")
+
+	repo, err := NewWebhookRepo(path, deliveriesPath)
+	assert.NoError(t, err)
+
+	created, err := repo.CreateWebhook(ctx, models.Webhook{URL: "http://example.com/hook", Secret: "secret"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, created.ID)
+
+	stored, err := repo.AddDeliveries(ctx, []models.WebhookDelivery{
+		{WebhookID: 1, Event: models.ActionTypeReferUser, Status: models.DeliveryStatusPending},
+		{WebhookID: 1, Event: models.ActionTypeWelcome, Status: models.DeliveryStatusPending},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, []int{stored[0].ID, stored[1].ID})
+
+	completed := stored[0]
+	completed.Status = models.DeliveryStatusSucceeded
+	completed.Attempts = 1
+	assert.NoError(t, repo.UpdateDelivery(ctx, completed))
+
+	// A new repository picks up where the previous one stopped
+	reloaded, err := NewWebhookRepo(path, deliveriesPath)
+	assert.NoError(t, err)
+
+	webhook, err := reloaded.GetWebhook(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", webhook.Secret)
+
+	deliveries, err := reloaded.GetDeliveries(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, []int{deliveries[0].ID, deliveries[1].ID})
+	assert.Equal(t, models.DeliveryStatusSucceeded, deliveries[1].Status)
+
+	pending := reloaded.GetPendingDeliveries(ctx)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, 2, pending[0].ID)
+
+	created, err = reloaded.CreateWebhook(ctx, models.Webhook{URL: "http://example.com/other"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, created.ID)
+}
+
+func TestRepositoryImpl_NotFound(t *testing.T) {
+	repo, err := NewWebhookRepo("", "")
+	assert.NoError(t, err)
+
+	_, err = repo.GetWebhook(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrWebhookNotFound)
+
+	_, err = repo.GetDeliveries(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrWebhookNotFound)
+
+	err = repo.UpdateDelivery(context.Background(), models.WebhookDelivery{ID: 1})
+	assert.ErrorIs(t, err, ErrDeliveryNotFound)
+}
+
+func TestRepositoryImpl_PrunesDeliveries(t *testing.T) {
+	ctx := context.Background()
+	repo, err := NewWebhookRepo("", "")
+	assert.NoError(t, err)
+
+	_, err = repo.CreateWebhook(ctx, models.Webhook{URL: "http://example.com/hook"})
+	assert.NoError(t, err)
+
+	// The first delivery is still pending, so the oldest completed one goes instead
+	deliveries := make([]models.WebhookDelivery, MaxDeliveriesPerWebhook+1)
+	for i := range deliveries {
+		deliveries[i] = models.WebhookDelivery{WebhookID: 1, Status: models.DeliveryStatusSucceeded, CreatedAt: time.Now()}
+	}
+	deliveries[0].Status = models.DeliveryStatusPending
+
+	_, err = repo.AddDeliveries(ctx, deliveries)
+	assert.NoError(t, err)
+
+	log, err := repo.GetDeliveries(ctx, 1)
+	assert.NoError(t, err)
+	assert.Len(t, log, MaxDeliveriesPerWebhook)
+	assert.Equal(t, 1, log[len(log)-1].ID)
+	assert.Equal(t, 3, log[len(log)-2].ID)
+}
+
+func TestRepositoryImpl_DeliveryLog(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path, deliveriesPath := filepath.Join(dir, "webhooks.json"), filepath.Join(dir, "deliveries.log")
+
+	repo, err := NewWebhookRepo(path, deliveriesPath)
+	assert.NoError(t, err)
+	_, err = repo.CreateWebhook(ctx, models.Webhook{URL: "http://example.com/hook"})
+	assert.NoError(t, err)
+	webhooks, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	stored, err := repo.AddDeliveries(ctx, []models.WebhookDelivery{
+		{WebhookID: 1, Status: models.DeliveryStatusPending},
+		{WebhookID: 1, Status: models.DeliveryStatusPending},
+	})
+	assert.NoError(t, err)
+
+	// An attempt appends the delivery it updated, and leaves the webhooks alone
+	retried := stored[1]
+	retried.Attempts = 1
+	assert.NoError(t, repo.UpdateDelivery(ctx, retried))
+
+	deliveries, err := os.ReadFile(deliveriesPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, bytes.Count(deliveries, []byte("\n")))
+	saved, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, webhooks, saved)
+	assert.NoError(t, repo.Close())
+
+	// A line torn by a crash is dropped, and the log carries on after it
+	assert.NoError(t, os.WriteFile(deliveriesPath, append(deliveries, `{"delivery":{"id":2,"webh`...), 0o644))
+
+	reloaded, err := NewWebhookRepo(path, deliveriesPath)
+	assert.NoError(t, err)
+	log, err := reloaded.GetDeliveries(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, []int{log[0].ID, log[1].ID})
+	assert.Equal(t, 1, log[0].Attempts)
+
+	_, err = reloaded.AddDeliveries(ctx, []models.WebhookDelivery{{WebhookID: 1, Status: models.DeliveryStatusPending}})
+	assert.NoError(t, err)
+	assert.NoError(t, reloaded.Close())
+
+	reloaded, err = NewWebhookRepo(path, deliveriesPath)
+	assert.NoError(t, err)
+	log, err = reloaded.GetDeliveries(ctx, 1)
+	assert.NoError(t, err)
+	assert.Len(t, log, 3)
+
+	// Anywhere else, a bad line means the log is corrupt
+	assert.NoError(t, os.WriteFile(deliveriesPath, []byte("{\"delivery\":\n{}\n"), 0o644))
+	_, err = NewWebhookRepo(path, deliveriesPath)
+	assert.ErrorIs(t, err, ErrCorruptDeliveryLog)
+}
+
+func TestRepositoryImpl_CompactsDeliveryLog(t *testing.T) {
+	ctx := context.Background()
+	deliveriesPath := filepath.Join(t.TempDir(), "deliveries.log")
+
+	repo, err := NewWebhookRepo("", deliveriesPath)
+	assert.NoError(t, err)
+	stored, err := repo.AddDeliveries(ctx, []models.WebhookDelivery{{WebhookID: 1, Status: models.DeliveryStatusPending}})
+	assert.NoError(t, err)
+
+	// Every attempt adds a record, until the log is rewritten with the delivery alone
+	delivery := stored[0]
+	for i := 0; i < minCompactRecords; i++ {
+		delivery.Attempts++
+		assert.NoError(t, repo.UpdateDelivery(ctx, delivery))
+	}
+	assert.Less(t, repo.deliveryLog.records, minCompactRecords)
+
+	delivery.Status = models.DeliveryStatusSucceeded
+	assert.NoError(t, repo.UpdateDelivery(ctx, delivery))
+	assert.NoError(t, repo.Close())
+
+	reloaded, err := NewWebhookRepo("", deliveriesPath)
+	assert.NoError(t, err)
+	assert.Empty(t, reloaded.GetPendingDeliveries(ctx))
+	assert.Equal(t, []models.WebhookDelivery{delivery}, reloaded.deliveries)
+}
+
+func TestRepositoryImpl_EraseUser(t *testing.T) {
+	ctx := context.Background()
+	deliveriesPath := filepath.Join(t.TempDir(), "deliveries.log")
+
+	repo, err := NewWebhookRepo("", deliveriesPath)
+	assert.NoError(t, err)
+	stored, err := repo.AddDeliveries(ctx, []models.WebhookDelivery{
+		{WebhookID: 1, Status: models.DeliveryStatusPending, Action: models.Action{ID: 1, UserID: 7, Type: models.ActionTypeWelcome}},
+		{WebhookID: 1, Status: models.DeliveryStatusPending, Action: models.Action{ID: 2, UserID: 8, Type: models.ActionTypeReferUser, TargetUser: 9}},
+		{WebhookID: 1, Status: models.DeliveryStatusPending, Action: models.Action{ID: 3, UserID: 9, Type: models.ActionTypeWelcome}},
+	})
+	assert.NoError(t, err)
+
+	// The deliveries of the user's actions and of the referrals of them are gone,
+	// from the log as well
+	assert.NoError(t, repo.EraseUser(ctx, 9))
+	assert.Equal(t, []models.WebhookDelivery{stored[0]}, repo.GetPendingDeliveries(ctx))
+	assert.ErrorIs(t, repo.UpdateDelivery(ctx, stored[2]), ErrDeliveryNotFound)
+
+	data, err := os.ReadFile(deliveriesPath)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), `"userId":9`)
+	assert.NotContains(t, string(data), `"targetUser":9`)
+	assert.NoError(t, repo.Close())
+
+	// The IDs of the dropped deliveries aren't assigned again
+	reloaded, err := NewWebhookRepo("", deliveriesPath)
+	assert.NoError(t, err)
+	defer reloaded.Close()
+	added, err := reloaded.AddDeliveries(ctx, []models.WebhookDelivery{{WebhookID: 1, Status: models.DeliveryStatusPending}})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, added[0].ID)
+}
@@ -0,0 +1,20 @@
+// Package requestid carries the correlation ID of a request in its context, so
+// services can tell which request a change was made by without depending on
+// the HTTP layer.
+package requestid
+
+import "context"
+
+// contextKey stores the request ID in a context
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// FromContext returns the request ID stored in ctx, if any
+func FromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
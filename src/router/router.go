@@ -2,31 +2,325 @@ package router
 
 import (
 	"github.com/AntonioDaria/surfe/src/handlers/action"
+	"github.com/AntonioDaria/surfe/src/handlers/audit"
+	"github.com/AntonioDaria/surfe/src/handlers/docs"
+	"github.com/AntonioDaria/surfe/src/handlers/graphql"
+	"github.com/AntonioDaria/surfe/src/handlers/health"
+	"github.com/AntonioDaria/surfe/src/handlers/profile"
+	"github.com/AntonioDaria/surfe/src/handlers/stream"
 	"github.com/AntonioDaria/surfe/src/handlers/user"
+	"github.com/AntonioDaria/surfe/src/handlers/webhook"
+	"github.com/AntonioDaria/surfe/src/metrics"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/middleware/deadline"
+	"github.com/AntonioDaria/surfe/src/middleware/deprecation"
+	"github.com/AntonioDaria/surfe/src/middleware/idempotency"
+	"github.com/AntonioDaria/surfe/src/middleware/ratelimit"
+	"github.com/AntonioDaria/surfe/src/openapi"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 )
 
 type Handlers struct {
-	UserHandler   *user.Handler
-	ActionHandler *action.Handler
+	UserHandler    *user.Handler
+	ActionHandler  *action.Handler
+	HealthHandler  *health.Handler
+	DocsHandler    *docs.Handler
+	GraphQLHandler *graphql.Handler
+	StreamHandler  *stream.Handler
+	WebhookHandler *webhook.Handler
+	AuditHandler   *audit.Handler
+	ProfileHandler *profile.Handler
 }
 
-func New(handlers *Handlers) *fiber.App {
+// Middlewares holds the optional middlewares applied to specific routes.
+// A nil middleware is skipped.
+type Middlewares struct {
+	// Auth protects every route with its scope. Routes are public when it is nil.
+	Auth      *auth.Authenticator
+	RateLimit *ratelimit.Limiter
+	// IPRateLimit limits every request of an IP address before authentication
+	IPRateLimit fiber.Handler
+	// Deadlines bounds how long each route may spend on a request
+	Deadlines *deadline.Deadlines
+	// Validator rejects requests whose parameters don't match the OpenAPI document
+	Validator *openapi.Validator
+	// Deprecation marks the responses of the unversioned legacy routes
+	Deprecation *deprecation.Policy
+	// Idempotency replays responses to requests repeated with the same Idempotency-Key
+	Idempotency *idempotency.Middleware
+	// RequestLog runs first on every request, so it also logs recovered panics
+	RequestLog fiber.Handler
+	// Tracing starts a span for every request
+	Tracing fiber.Handler
+	// Metrics records every request and serves GET /metrics
+	Metrics *metrics.Metrics
+}
+
+func New(handlers *Handlers, middlewares *Middlewares) *fiber.App {
 	router := fiber.New()
 
+	if middlewares == nil {
+		middlewares = &Middlewares{}
+	}
+
+	if middlewares.RequestLog != nil {
+		router.Use(middlewares.RequestLog)
+	}
+
+	if middlewares.Tracing != nil {
+		router.Use(middlewares.Tracing)
+	}
+
+	if middlewares.Metrics != nil {
+		router.Use(middlewares.Metrics.Middleware())
+	}
+
 	// Add Recover middleware to handle panics
 	router.Use(recover.New(recover.Config{
 		EnableStackTrace: true,
 	}))
 
-	// User endpoint
-	router.Get("/user/:id", handlers.UserHandler.GetUserByIDHandler)
+	// Health endpoints, probed by orchestrators without credentials
+	if handlers.HealthHandler != nil {
+		router.Get("/healthz", handlers.HealthHandler.HealthzHandler)
+		router.Get("/readyz", handlers.HealthHandler.ReadyzHandler)
+		router.Get("/version", handlers.HealthHandler.VersionHandler)
+	}
+
+	// API documentation
+	if handlers.DocsHandler != nil {
+		router.Get("/openapi.json", handlers.DocsHandler.OpenAPIHandler)
+		router.Get("/docs", handlers.DocsHandler.DocsHandler)
+	}
 
-	// Action endpoints
-	router.Get("/users/:id/actions/count", handlers.ActionHandler.GetActionCountByUserIDHandler)
-	router.Get("/actions/:actionType/next", handlers.ActionHandler.GetNextActionProbabilitiesHandler)
-	router.Get("/actions/referral", handlers.ActionHandler.GetReferralIndexHandler)
+	// Metrics endpoint, scraped by Prometheus
+	if middlewares.Metrics != nil {
+		router.Get("/metrics", middlewares.Metrics.Handler())
+	}
+
+	// Version 1 keeps the original paths
+	v1 := middlewares.api(router, "/v1")
+	v1.route(fiber.MethodGet, "/user/:id", auth.ScopeUsersRead,
+		handlers.UserHandler.GetUserByIDHandler, middlewares.requireSelf("id"))
+	v1.route(fiber.MethodDelete, "/user/:id", auth.ScopeUsersWrite,
+		handlers.UserHandler.DeleteUserHandler, middlewares.requireSelf("id"))
+	registerUserRoutes(v1, handlers)
+	registerActionRoutes(v1, handlers)
+	registerStreamRoutes(v1, handlers)
+	registerWebhookRoutes(v1, handlers)
+	registerAuditRoutes(v1, handlers)
+
+	// Version 2, where the user endpoint is plural like the others
+	v2 := middlewares.api(router, "/v2")
+	v2.route(fiber.MethodGet, "/users/:id", auth.ScopeUsersRead,
+		handlers.UserHandler.GetUserByIDHandler, middlewares.requireSelf("id"))
+	v2.route(fiber.MethodDelete, "/users/:id", auth.ScopeUsersWrite,
+		handlers.UserHandler.DeleteUserHandler, middlewares.requireSelf("id"))
+	registerUserRoutes(v2, handlers)
+	registerActionRoutes(v2, handlers)
+	registerStreamRoutes(v2, handlers)
+	registerWebhookRoutes(v2, handlers)
+	registerAuditRoutes(v2, handlers)
+
+	// GraphQL is unversioned, its schema evolves by deprecating fields instead
+	if handlers.GraphQLHandler != nil {
+		graphQL := middlewares.api(router, "")
+		graphQL.route(fiber.MethodGet, "/graphql", auth.ScopeUsersRead,
+			handlers.GraphQLHandler.GraphQLHandler)
+		graphQL.route(fiber.MethodPost, "/graphql", auth.ScopeUsersRead,
+			handlers.GraphQLHandler.GraphQLHandler)
+	}
+
+	// Unversioned aliases of version 1, kept for existing clients until their sunset
+	legacy := middlewares.api(router, "", middlewares.deprecated("/v1"))
+	legacy.route(fiber.MethodGet, "/user/:id", auth.ScopeUsersRead,
+		handlers.UserHandler.GetUserByIDHandler, middlewares.requireSelf("id"))
+	registerActionRoutes(legacy, handlers)
 
 	return router
 }
+
+// registerActionRoutes registers the action endpoints, which are the same in every version
+func registerActionRoutes(api *api, handlers *Handlers) {
+	api.route(fiber.MethodGet, "/users/:id/actions/count", auth.ScopeUsersRead,
+		handlers.ActionHandler.GetActionCountByUserIDHandler, api.middlewares.requireSelf("id"))
+	api.route(fiber.MethodGet, "/actions/:actionType/next", auth.ScopeAnalyticsRead,
+		handlers.ActionHandler.GetNextActionProbabilitiesHandler)
+	api.route(fiber.MethodGet, "/actions/referral", auth.ScopeAnalyticsRead,
+		handlers.ActionHandler.GetReferralIndexHandler)
+	api.route(fiber.MethodPost, "/actions/bulk", auth.ScopeActionsWrite,
+		handlers.ActionHandler.CreateActionsBulkHandler, api.middlewares.idempotency("/actions/bulk"))
+}
+
+// registerUserRoutes registers the search and batch lookups of users, their
+// profiles and the export and erasure of their data, which only exist in the
+// versioned APIs
+func registerUserRoutes(api *api, handlers *Handlers) {
+	api.route(fiber.MethodGet, "/users", auth.ScopeUsersRead,
+		handlers.UserHandler.SearchUsersHandler, api.middlewares.requireAllUsers())
+	api.route(fiber.MethodPost, "/users/batch", auth.ScopeUsersRead,
+		handlers.UserHandler.GetUsersBatchHandler)
+	api.route(fiber.MethodPost, "/users/actions/count/batch", auth.ScopeUsersRead,
+		handlers.ActionHandler.GetActionCountsBatchHandler)
+	api.route(fiber.MethodGet, "/users/:id/data-export", auth.ScopeUsersRead,
+		handlers.UserHandler.ExportUserDataHandler, api.middlewares.requireSelf("id"))
+	api.route(fiber.MethodPost, "/users/:id/erase", auth.ScopeUsersWrite,
+		handlers.UserHandler.EraseUserHandler, api.middlewares.requireSelf("id"))
+
+	if handlers.ProfileHandler == nil {
+		return
+	}
+
+	api.route(fiber.MethodGet, "/users/:id/profile", auth.ScopeUsersRead,
+		handlers.ProfileHandler.GetProfileHandler, api.middlewares.requireSelf("id"))
+}
+
+// registerStreamRoutes registers the live streams, which were added after the
+// unversioned paths were deprecated and so only exist in the versioned APIs
+func registerStreamRoutes(api *api, handlers *Handlers) {
+	if handlers.StreamHandler == nil {
+		return
+	}
+
+	api.stream(fiber.MethodGet, "/actions/stream", auth.ScopeUsersRead,
+		handlers.StreamHandler.ActionsStreamHandler)
+	api.stream(fiber.MethodGet, "/actions/referral/ws", auth.ScopeAnalyticsRead,
+		handlers.StreamHandler.ReferralIndexSocketHandler)
+}
+
+// registerWebhookRoutes registers the webhook endpoints, which only exist in the versioned APIs
+func registerWebhookRoutes(api *api, handlers *Handlers) {
+	if handlers.WebhookHandler == nil {
+		return
+	}
+
+	api.route(fiber.MethodPost, "/webhooks", auth.ScopeWebhooksManage,
+		handlers.WebhookHandler.CreateWebhookHandler, api.middlewares.idempotency("/webhooks"))
+	api.route(fiber.MethodGet, "/webhooks/:id/deliveries", auth.ScopeWebhooksManage,
+		handlers.WebhookHandler.GetDeliveriesHandler)
+}
+
+// registerAuditRoutes registers the deletion of actions and the audit trail
+// recording it, which only exist in the versioned APIs
+func registerAuditRoutes(api *api, handlers *Handlers) {
+	api.route(fiber.MethodDelete, "/actions/:id", auth.ScopeActionsWrite,
+		handlers.ActionHandler.DeleteActionHandler)
+
+	if handlers.AuditHandler == nil {
+		return
+	}
+
+	api.route(fiber.MethodGet, "/audit", auth.ScopeAuditRead,
+		handlers.AuditHandler.GetAuditHandler)
+}
+
+// api registers the routes of one API version under its path prefix
+type api struct {
+	router      fiber.Router
+	middlewares *Middlewares
+	prefix      string
+	// leading run before any other middleware of the version's routes
+	leading []fiber.Handler
+}
+
+func (m *Middlewares) api(router fiber.Router, prefix string, leading ...fiber.Handler) *api {
+	return &api{router: router, middlewares: m, prefix: prefix, leading: leading}
+}
+
+// route registers handler at the prefixed path behind the IP rate limit,
+// authentication for scope, rate limiting, the route's deadline, request
+// validation and then the given route specific middlewares. Rate limits and deadlines are configured by the
+// unprefixed path, and a client shares its rate limit across versions.
+func (a *api) route(method, path string, scope auth.Scope, handler fiber.Handler, middlewares ...fiber.Handler) {
+	a.add(method, path, scope, a.middlewares.deadline(path), handler, middlewares...)
+}
+
+// stream registers a long lived streaming route like route does, but without
+// a deadline, as streams stay open until the client disconnects
+func (a *api) stream(method, path string, scope auth.Scope, handler fiber.Handler, middlewares ...fiber.Handler) {
+	a.add(method, path, scope, nil, handler, middlewares...)
+}
+
+func (a *api) add(method, path string, scope auth.Scope, deadline fiber.Handler, handler fiber.Handler, middlewares ...fiber.Handler) {
+	m := a.middlewares
+
+	chain := append([]fiber.Handler{}, a.leading...)
+	chain = append(chain, m.IPRateLimit, m.requireScope(scope), m.rateLimit(path), deadline, m.validate(method, a.prefix+path))
+	chain = append(chain, middlewares...)
+
+	handlers := make([]fiber.Handler, 0, len(chain)+1)
+	for _, h := range chain {
+		if h != nil {
+			handlers = append(handlers, h)
+		}
+	}
+
+	a.router.Add(method, a.prefix+path, append(handlers, handler)...)
+}
+
+// requireScope returns the auth middleware for scope, or nil when auth is disabled
+func (m *Middlewares) requireScope(scope auth.Scope) fiber.Handler {
+	if m.Auth == nil {
+		return nil
+	}
+	return m.Auth.Require(scope)
+}
+
+// requireSelf restricts token holders to their own user, or returns nil when auth is disabled
+func (m *Middlewares) requireSelf(param string) fiber.Handler {
+	if m.Auth == nil {
+		return nil
+	}
+	return auth.RequireSelf(param)
+}
+
+// requireAllUsers keeps token holders out of routes going through every user, or
+// returns nil when auth is disabled
+func (m *Middlewares) requireAllUsers() fiber.Handler {
+	if m.Auth == nil {
+		return nil
+	}
+	return auth.RequireAllUsers()
+}
+
+// rateLimit returns the rate limiter for the route, or nil when rate limiting is disabled
+func (m *Middlewares) rateLimit(path string) fiber.Handler {
+	if m.RateLimit == nil {
+		return nil
+	}
+	return m.RateLimit.Route(path)
+}
+
+// deadline returns the deadline middleware for the route, or nil when deadlines are disabled
+func (m *Middlewares) deadline(path string) fiber.Handler {
+	if m.Deadlines == nil {
+		return nil
+	}
+	return m.Deadlines.Route(path)
+}
+
+// validate returns the request validation middleware for the route, or nil when validation is disabled
+func (m *Middlewares) validate(method, path string) fiber.Handler {
+	if m.Validator == nil {
+		return nil
+	}
+	return m.Validator.Route(method, path)
+}
+
+// idempotency returns the idempotency middleware for the route, or nil when it is disabled
+func (m *Middlewares) idempotency(path string) fiber.Handler {
+	if m.Idempotency == nil {
+		return nil
+	}
+	return m.Idempotency.Route(path)
+}
+
+// deprecated returns the middleware marking a route deprecated in favour of the
+// same path under prefix, or nil when no deprecation policy is set
+func (m *Middlewares) deprecated(prefix string) fiber.Handler {
+	if m.Deprecation == nil {
+		return nil
+	}
+	return m.Deprecation.Successor(prefix)
+}
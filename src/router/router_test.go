@@ -0,0 +1,223 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/handlers/action"
+	"github.com/AntonioDaria/surfe/src/handlers/audit"
+	"github.com/AntonioDaria/surfe/src/handlers/docs"
+	"github.com/AntonioDaria/surfe/src/handlers/graphql"
+	"github.com/AntonioDaria/surfe/src/handlers/health"
+	"github.com/AntonioDaria/surfe/src/handlers/profile"
+	"github.com/AntonioDaria/surfe/src/handlers/stream"
+	"github.com/AntonioDaria/surfe/src/handlers/user"
+	"github.com/AntonioDaria/surfe/src/handlers/utils"
+	"github.com/AntonioDaria/surfe/src/handlers/webhook"
+	health_state "github.com/AntonioDaria/surfe/src/health"
+	"github.com/AntonioDaria/surfe/src/metrics"
+	"github.com/AntonioDaria/surfe/src/middleware/deprecation"
+	"github.com/AntonioDaria/surfe/src/openapi"
+	action_repo "github.com/AntonioDaria/surfe/src/repository/action"
+	user_repo "github.com/AntonioDaria/surfe/src/repository/user"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// newDocumentedApp builds the router with every optional handler and middleware enabled
+func newDocumentedApp(t *testing.T) (*fiber.App, *openapi.Document) {
+	logger := zerolog.New(os.Stderr)
+
+	doc, err := openapi.Load()
+	assert.NoError(t, err)
+
+	app := New(&Handlers{
+		UserHandler:    user.NewHandler(nil, logger),
+		ActionHandler:  action.NewHandler(nil, logger),
+		HealthHandler:  health.NewHandler(health_state.NewState(), logger),
+		DocsHandler:    docs.NewHandler(openapi.Spec()),
+		GraphQLHandler: graphql.NewHandler(nil, logger),
+		StreamHandler:  stream.NewHandler(nil, nil, time.Second, logger),
+		WebhookHandler: webhook.NewHandler(nil, logger),
+		AuditHandler:   audit.NewHandler(nil, logger),
+		ProfileHandler: profile.NewHandler(nil, logger),
+	}, &Middlewares{
+		Metrics:   metrics.New(),
+		Validator: openapi.NewValidator(doc),
+		Deprecation: &deprecation.Policy{
+			Deprecated: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			Sunset:     time.Date(2027, 4, 19, 0, 0, 0, 0, time.UTC),
+		},
+	})
+
+	return app, doc
+}
+
+func TestRoutes_Documented(t *testing.T) {
+	app, doc := newDocumentedApp(t)
+
+	registered := make(map[string]bool)
+	for _, route := range app.GetRoutes(true) {
+		// Fiber adds a HEAD route for every GET route
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+
+		key := route.Method + " " + openapi.PathTemplate(route.Path)
+		registered[key] = true
+
+		_, ok := doc.Operation(route.Method, route.Path)
+		assert.True(t, ok, "route %s %s is missing from the OpenAPI document", route.Method, route.Path)
+	}
+
+	// And the document doesn't describe routes that don't exist
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			key := strings.ToUpper(method) + " " + path
+			assert.True(t, registered[key], "documented operation %s is not registered", key)
+		}
+	}
+}
+
+func TestRoutes_Versions(t *testing.T) {
+	app, _ := newDocumentedApp(t)
+
+	tests := []struct {
+		target     string
+		status     int
+		deprecated bool
+	}{
+		// Invalid parameters are rejected by validation, before the handlers
+		{target: "/v1/user/abc", status: http.StatusBadRequest},
+		{target: "/v2/users/abc", status: http.StatusBadRequest},
+		{target: "/user/abc", status: http.StatusBadRequest, deprecated: true},
+		{target: "/users/abc/actions/count", status: http.StatusBadRequest, deprecated: true},
+		{target: "/v1/actions/DANCE/next", status: http.StatusBadRequest},
+		// The singular user path doesn't exist in version 2
+		{target: "/v2/user/1", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			resp, _ := app.Test(httptest.NewRequest(http.MethodGet, tt.target, nil), -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			if tt.deprecated {
+				assert.NotEmpty(t, resp.Header.Get(deprecation.HeaderDeprecation))
+				assert.Equal(t, "Mon, 19 Apr 2027 00:00:00 GMT", resp.Header.Get(deprecation.HeaderSunset))
+				assert.Equal(t, `</v1`+tt.target+`>; rel="successor-version"`, resp.Header.Get(fiber.HeaderLink))
+			} else {
+				assert.Empty(t, resp.Header.Get(deprecation.HeaderDeprecation))
+			}
+		})
+	}
+}
+
+// newIntegrationApp builds the router with the user and action handlers backed
+// by real services and repositories loaded from the seed data
+func newIntegrationApp(t *testing.T) *fiber.App {
+	logger := zerolog.New(os.Stderr)
+
+	actionRepo, err := action_repo.NewActionRepo("../repository/data/actions.json")
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	userRepo, err := user_repo.NewUserRepo("../repository/data/users.json")
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	doc, err := openapi.Load()
+	assert.NoError(t, err)
+
+	return New(&Handlers{
+		UserHandler:   user.NewHandler(user_s.NewUserService(userRepo, actionRepo), logger),
+		ActionHandler: action.NewHandler(action_s.NewActionService(actionRepo, userRepo), logger),
+	}, &Middlewares{
+		Validator: openapi.NewValidator(doc),
+	})
+}
+
+func TestIntegration_ActionCount(t *testing.T) {
+	app := newIntegrationApp(t)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		count  int
+	}{
+		{name: "user with actions", target: "/v1/users/1/actions/count", status: http.StatusOK, count: 49},
+		// User 12 exists without having performed any action
+		{name: "user without actions", target: "/v1/users/12/actions/count", status: http.StatusOK, count: 0},
+		{name: "user without actions in version 2", target: "/v2/users/12/actions/count", status: http.StatusOK, count: 0},
+		{name: "user without actions on the legacy path", target: "/users/12/actions/count", status: http.StatusOK, count: 0},
+		{name: "unknown user", target: "/v1/users/1000/actions/count", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := app.Test(httptest.NewRequest(http.MethodGet, tt.target, nil), -1)
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			if tt.status == http.StatusOK {
+				var response action.ActionCountResponse
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+				assert.Equal(t, tt.count, response.Count)
+			}
+		})
+	}
+}
+
+func TestIntegration_ActionCount_DeletedUser(t *testing.T) {
+	app := newIntegrationApp(t)
+
+	resp, _ := app.Test(httptest.NewRequest(http.MethodDelete, "/v2/users/12", nil), -1)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Deleted users no longer exist, even though they never had actions to delete
+	resp, _ = app.Test(httptest.NewRequest(http.MethodGet, "/v2/users/12/actions/count", nil), -1)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestIntegration_ActionCountsBatch(t *testing.T) {
+	app := newIntegrationApp(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/users/actions/count/batch", strings.NewReader(`{"ids":[1,12,1000]}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var response action.BatchActionCountsResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+
+	// The count of the user without actions is reported rather than omitted
+	assert.Equal(t, 2, response.Found)
+	assert.Equal(t, 1, response.NotFound)
+	if assert.NotNil(t, response.Results[12].Count) {
+		assert.Equal(t, 0, *response.Results[12].Count)
+	}
+	assert.Equal(t, 49, *response.Results[1].Count)
+	assert.Equal(t, utils.BatchStatusNotFound, response.Results[1000].Status)
+}
+
+func TestIntegration_NextActions_UnknownType(t *testing.T) {
+	app := newIntegrationApp(t)
+
+	// Unknown action types are rejected before the handler, as the document lists the known ones
+	resp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/v1/actions/DANCE/next", nil), -1)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var errResp utils.ErrorResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	assert.Contains(t, errResp.Error, `Invalid path parameter "actionType"`)
+}
@@ -2,53 +2,136 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/AntonioDaria/surfe/src/health"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
-	app    *fiber.App
-	logger zerolog.Logger
+	app        *fiber.App
+	grpcServer *grpc.Server
+	grpcAddr   string
+	logger     zerolog.Logger
+	health     *health.State
+	drainDelay time.Duration
 }
 
-func New(logger zerolog.Logger, httpRouter *fiber.App) *Server {
+// New creates the server. On SIGTERM it reports not ready and keeps serving
+// for drainDelay, so load balancers stop routing to it before it shuts down.
+// The gRPC server is optional, and is served on grpcAddr when set.
+func New(logger zerolog.Logger, httpRouter *fiber.App, grpcServer *grpc.Server, grpcAddr string, healthState *health.State, drainDelay time.Duration) *Server {
 	return &Server{
-		app:    httpRouter,
-		logger: logger,
+		app:        httpRouter,
+		grpcServer: grpcServer,
+		grpcAddr:   grpcAddr,
+		logger:     logger,
+		health:     healthState,
+		drainDelay: drainDelay,
 	}
 }
 
+// Run serves until a shutdown signal is received or one of the servers fails,
+// and then shuts both down gracefully. It returns the failure, if any.
 func (s *Server) Run() error {
+	// Listen before serving so a port already in use fails the startup
+	var grpcListener net.Listener
+	if s.grpcServer != nil {
+		var err error
+		grpcListener, err = net.Listen("tcp", s.grpcAddr)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Either server failing stops both, like a shutdown signal does
+	serveErrs := make(chan error, 2)
+
 	// Run the server in a separate goroutine
 	go func() {
 		s.logger.Info().Msg("🚀 Starting HTTP Server")
 		if err := s.app.Listen(":3000"); err != nil {
-			s.logger.Fatal().Err(err).Msg("Server failure")
+			serveErrs <- fmt.Errorf("HTTP server failure: %w", err)
 		}
 	}()
 
+	if s.grpcServer != nil {
+		go func() {
+			s.logger.Info().Str("addr", s.grpcAddr).Msg("🚀 Starting gRPC Server")
+			if err := s.grpcServer.Serve(grpcListener); err != nil {
+				serveErrs <- fmt.Errorf("gRPC server failure: %w", err)
+			}
+		}()
+	}
+
 	// Set up channel to listen for shutdown signals
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
-	// Block until a signal is received
-	<-osSignals
+	// Block until a signal is received or a server fails
+	var serveErr error
+	select {
+	case sig := <-osSignals:
+		// Report not ready straight away so load balancers start draining
+		s.health.MarkShuttingDown()
+
+		if sig == syscall.SIGTERM && s.drainDelay > 0 {
+			s.logger.Info().Dur("delay", s.drainDelay).Msg("🟠 Draining HTTP Server")
+			time.Sleep(s.drainDelay)
+		}
+	case serveErr = <-serveErrs:
+		s.logger.Error().Err(serveErr).Msg("Server failure")
+		s.health.MarkShuttingDown()
+	}
+
 	s.logger.Info().Msg("🔴 Shutting down HTTP Server")
 
 	// Create a context with timeout for graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Both servers finish their in-flight requests at the same time
+	var wg sync.WaitGroup
+	if s.grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.shutdownGRPC(ctx)
+		}()
+	}
+
 	if err := s.app.ShutdownWithContext(ctx); err != nil {
 		s.logger.Error().Err(err).Msg("Server forced to shutdown")
 	} else {
 		s.logger.Info().Msg("🔴 HTTP Server shutdown complete")
 	}
 
-	return nil
+	wg.Wait()
+	return serveErr
+}
+
+// shutdownGRPC waits for in-flight calls to finish, and cancels the remaining
+// ones once ctx is done
+func (s *Server) shutdownGRPC(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		s.logger.Info().Msg("🔴 gRPC Server shutdown complete")
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+		s.logger.Error().Msg("gRPC Server forced to shutdown")
+	}
 }
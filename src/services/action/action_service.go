@@ -1,63 +1,165 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math"
+	"time"
 
 	act_type "github.com/AntonioDaria/surfe/src/models"
 	"github.com/AntonioDaria/surfe/src/repository/action"
+	user_repo "github.com/AntonioDaria/surfe/src/repository/user"
+	audit_s "github.com/AntonioDaria/surfe/src/services/audit"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = otel.Tracer("github.com/AntonioDaria/surfe/src/services/action")
+
+// cancelCheckInterval is how many steps of a long computation run between checks for cancellation
+const cancelCheckInterval = 1024
+
+var (
+	ErrUnknownActionType  = fmt.Errorf("unknown action type")
+	ErrTargetUserRequired = fmt.Errorf("target user is required for referrals")
+	ErrTargetUserNotFound = fmt.Errorf("target user not found")
+)
+
+// BulkResult is the outcome of one item submitted to AddActions.
+// Exactly one of Action and Err is set.
+type BulkResult struct {
+	Action *act_type.Action
+	Err    error
+}
+
 //go:generate mockgen -source=$GOFILE -destination=mock/action_service_mock.go -package=mock
 type Service interface {
-	GetActionCountByUserID(userID int) (int, error)
-	GetNextActionProbabilities(actionType act_type.ActionType) map[act_type.ActionType]float64
-	GetReferralIndex() map[int]int
+	GetActionCountByUserID(ctx context.Context, userID int) (int, error)
+	GetActionCountsByUserIDs(ctx context.Context, userIDs []int) map[int]int
+	GetNextActionProbabilities(ctx context.Context, actionType act_type.ActionType) (map[act_type.ActionType]float64, error)
+	GetReferralIndex(ctx context.Context) (map[int]int, error)
+	AddActions(ctx context.Context, actions []act_type.Action) ([]BulkResult, error)
+	DatasetVersion(ctx context.Context) uint64
+	GetActionsByUserIDs(ctx context.Context, userIDs []int) (map[int][]act_type.Action, error)
+	GetReferrers(ctx context.Context, userIDs []int) (map[int]int, error)
+	DeleteAction(ctx context.Context, actionID int, canDelete func(userID int) bool) (*act_type.Action, error)
 }
 
 type ServiceImpl struct {
 	actionRepo action.Repository
+	userRepo   user_repo.Repository
+	cache      *resultCache
+	referrals  *referralGraph
+	// auditor records the actions stored and deleted, nothing is recorded when nil
+	auditor audit_s.Service
 }
 
-func NewActionService(actionRepo action.Repository) *ServiceImpl {
-	return &ServiceImpl{actionRepo: actionRepo}
+func NewActionService(actionRepo action.Repository, userRepo user_repo.Repository) *ServiceImpl {
+	return &ServiceImpl{
+		actionRepo: actionRepo,
+		userRepo:   userRepo,
+		cache:      newResultCache(),
+		referrals:  newReferralGraph(),
+	}
 }
 
-// CountActionsByUserID counts the number of actions performed by a user
-func (s *ServiceImpl) GetActionCountByUserID(userID int) (int, error) {
-	if !s.actionRepo.UserExists(userID) {
-		return 0, action.ErrUserNotFound
+// SetAuditor sets the audit trail the actions stored and deleted from now on are recorded in
+func (s *ServiceImpl) SetAuditor(auditor audit_s.Service) {
+	s.auditor = auditor
+}
+
+// DatasetVersion returns the version of the actions the analytics are computed from.
+// Analytics results don't change until it does.
+func (s *ServiceImpl) DatasetVersion(ctx context.Context) uint64 {
+	return s.actionRepo.Version(ctx)
+}
+
+// GetActionCountByUserID counts the number of actions performed by a user, which
+// is zero for users who performed none. It returns action.ErrUserNotFound when
+// the user doesn't exist or was deleted.
+func (s *ServiceImpl) GetActionCountByUserID(ctx context.Context, userID int) (int, error) {
+	ctx, span := tracer.Start(ctx, "action.Service.GetActionCountByUserID")
+	defer span.End()
+
+	// Users are found in the user repository, as having no actions doesn't mean not existing
+	if _, err := s.userRepo.GetUserByID(ctx, userID); err != nil {
+		if errors.Is(err, user_repo.ErrUserNotFound) {
+			return 0, action.ErrUserNotFound
+		}
+		span.RecordError(err)
+		return 0, err
 	}
 
 	// Get the action count if the user exists
-	return s.actionRepo.CountActionsByUserID(userID), nil
+	return s.actionRepo.CountActionsByUserID(ctx, userID), nil
 }
 
-func (s *ServiceImpl) GetNextActionProbabilities(actionType act_type.ActionType) map[act_type.ActionType]float64 {
-	sortedActions := s.actionRepo.GetSortedActions()
-	nextActionCounts := make(map[act_type.ActionType]int)
-	totalCount := 0
+// GetActionCountsByUserIDs counts the actions of several users at once, keyed by
+// user ID. As with GetActionCountByUserID, users who performed no action count
+// zero and users who don't exist or were deleted are left out.
+func (s *ServiceImpl) GetActionCountsByUserIDs(ctx context.Context, userIDs []int) map[int]int {
+	ctx, span := tracer.Start(ctx, "action.Service.GetActionCountsByUserIDs")
+	defer span.End()
+	span.SetAttributes(attribute.Int("users.count", len(userIDs)))
 
-	// Iterate through sorted actions to find occurrences of actionType
-	for i := 0; i < len(sortedActions); i++ {
-		currentAction := sortedActions[i]
+	users := s.userRepo.GetUsersByIDs(ctx, userIDs)
+	existing := make([]int, 0, len(users))
+	for _, id := range userIDs {
+		if _, ok := users[id]; ok {
+			existing = append(existing, id)
+		}
+	}
+
+	// The repository leaves out the users without actions, who exist all the same
+	counts := s.actionRepo.CountActionsByUserIDs(ctx, existing)
+	for _, id := range existing {
+		if _, ok := counts[id]; !ok {
+			counts[id] = 0
+		}
+	}
+	return counts
+}
 
-		// If current action matches actionType, count subsequent actions by the same user
-		if currentAction.Type == actionType {
-			for j := i + 1; j < len(sortedActions); j++ {
-				nextAction := sortedActions[j]
+// GetNextActionProbabilities returns how likely each action type is to follow actionType,
+// or ErrUnknownActionType when actionType isn't a known type.
+// Results are cached until the dataset changes and must not be modified.
+// It stops early with the context's error if the context is cancelled.
+func (s *ServiceImpl) GetNextActionProbabilities(ctx context.Context, actionType act_type.ActionType) (map[act_type.ActionType]float64, error) {
+	ctx, span := tracer.Start(ctx, "action.Service.GetNextActionProbabilities")
+	defer span.End()
+	span.SetAttributes(attribute.String("action.type", string(actionType)))
 
-				// Stop counting if we encounter another instance of actionType or a different user
-				if nextAction.UserID != currentAction.UserID || nextAction.Type == actionType {
-					break
-				}
+	if !actionType.IsValid() {
+		err := fmt.Errorf("%w: %q", ErrUnknownActionType, actionType)
+		span.RecordError(err)
+		return nil, err
+	}
 
-				// Count this next action
-				nextActionCounts[nextAction.Type]++
-				totalCount++
-			}
+	cacheable := s.cache != nil
+	cacheKey := "next:" + string(actionType)
+	var version uint64
+	if cacheable {
+		version = s.actionRepo.Version(ctx)
+		if cached, ok := s.cache.get(version, cacheKey); ok {
+			span.SetAttributes(attribute.Bool("cache.hit", true))
+			return cached.(map[act_type.ActionType]float64), nil
 		}
 	}
 
+	// The repository keeps the counts up to date as actions are stored
+	nextActionCounts, err := s.actionRepo.NextActionCounts(ctx, actionType)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	totalCount := 0
+	for _, count := range nextActionCounts {
+		totalCount += count
+	}
+
 	// Calculate probabilities by dividing each next action count by the total count
 	probabilities := make(map[act_type.ActionType]float64)
 	for action, count := range nextActionCounts {
@@ -65,68 +167,210 @@ func (s *ServiceImpl) GetNextActionProbabilities(actionType act_type.ActionType)
 		probabilities[action] = math.Round(probability*100) / 100 // rounds to 2 decimal places
 	}
 
-	return probabilities
+	if cacheable {
+		s.cache.put(version, cacheKey, probabilities)
+	}
+
+	return probabilities, nil
 }
 
-func (s *ServiceImpl) GetReferralIndex() map[int]int {
-	// Build an adjacency list from refer actions
-	adjacencyList := make(map[int][]int)
-	for _, action := range s.actionRepo.GetAllActions() {
-		if action.Type == act_type.ActionTypeReferUser {
-			referrer := action.UserID
-			referred := action.TargetUser
-			adjacencyList[referrer] = append(adjacencyList[referrer], referred)
+// GetReferralIndex returns the number of users each user referred, directly or indirectly.
+// The index is maintained incrementally as actions are stored, so only the users
+// affected by new referrals are recomputed. Results are cached until the dataset
+// changes and must not be modified.
+// It stops early with the context's error if the context is cancelled.
+func (s *ServiceImpl) GetReferralIndex(ctx context.Context) (map[int]int, error) {
+	ctx, span := tracer.Start(ctx, "action.Service.GetReferralIndex")
+	defer span.End()
+
+	const cacheKey = "referral"
+	var version uint64
+	if s.cache != nil {
+		version = s.actionRepo.Version(ctx)
+		if cached, ok := s.cache.get(version, cacheKey); ok {
+			span.SetAttributes(attribute.Bool("cache.hit", true))
+			return cached.(map[int]int), nil
 		}
 	}
 
-	// Final referral index map to store results for each user
-	referralIndex := make(map[int]int)
-	visited := make(map[int]bool)
-	inCycle := make(map[int]bool)
+	// Services built without a graph compute the index from scratch
+	referrals := s.referrals
+	if referrals == nil {
+		referrals = newReferralGraph()
+	}
+
+	referralIndex, err := referrals.sync(ctx, s.actionRepo.GetReferrals(ctx))
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	s.cache.put(version, cacheKey, referralIndex)
+
+	return referralIndex, nil
+}
+
+// OnReferralIndexChange sets the function called with the users whose referral
+// index changed, and their new index, whenever the index is brought up to date.
+// The first call reports the index of every user with referrals.
+func (s *ServiceImpl) OnReferralIndexChange(listener func(changes map[int]int)) {
+	s.referrals.setListener(listener)
+}
+
+// GetActionsByUserIDs returns the actions of several users, keyed by user ID
+// in the order they were recorded. Users without actions are left out.
+func (s *ServiceImpl) GetActionsByUserIDs(ctx context.Context, userIDs []int) (map[int][]act_type.Action, error) {
+	ctx, span := tracer.Start(ctx, "action.Service.GetActionsByUserIDs")
+	defer span.End()
+	span.SetAttributes(attribute.Int("users.count", len(userIDs)))
+
+	return s.actionRepo.GetActionsByUserIDs(ctx, userIDs), nil
+}
 
-	// DFS function to calculate referral index with cycle detection
-	var dfs func(userID int, path map[int]bool) int
-	dfs = func(userID int, path map[int]bool) int {
-		if path[userID] {
-			// If a node is revisited in the same path, we have a cycle
-			for node := range path {
-				inCycle[node] = true // Mark all nodes in the path as part of a cycle
+// GetReferrers returns who referred each of the given users. A user referred
+// several times is attributed to the earliest referral, and users nobody
+// referred are left out.
+func (s *ServiceImpl) GetReferrers(ctx context.Context, userIDs []int) (map[int]int, error) {
+	ctx, span := tracer.Start(ctx, "action.Service.GetReferrers")
+	defer span.End()
+	span.SetAttributes(attribute.Int("users.count", len(userIDs)))
+
+	referrers := make(map[int]int)
+	for user, referrals := range s.actionRepo.GetReferralsOf(ctx, userIDs) {
+		earliest := referrals[0]
+		for _, referral := range referrals[1:] {
+			if referral.CreatedAt.Before(earliest.CreatedAt) {
+				earliest = referral
 			}
-			return 0
 		}
+		referrers[user] = earliest.UserID
+	}
 
-		if visited[userID] {
-			// If already visited and calculated, return the cached result
-			return referralIndex[userID]
-		}
+	return referrers, nil
+}
+
+// AddActions validates each action and stores the valid ones as a single batch.
+// Results are returned in the same order as the input. Every stored action is
+// recorded in the audit trail; the actions stay stored when that fails.
+func (s *ServiceImpl) AddActions(ctx context.Context, actions []act_type.Action) ([]BulkResult, error) {
+	ctx, span := tracer.Start(ctx, "action.Service.AddActions")
+	defer span.End()
+	span.SetAttributes(attribute.Int("actions.count", len(actions)))
 
-		// Mark this user as visited in the current path
-		path[userID] = true
-		visited[userID] = true
-		count := 0
+	results := make([]BulkResult, len(actions))
+	valid := make([]act_type.Action, 0, len(actions))
+	validIdx := make([]int, 0, len(actions))
 
-		// Traverse each referral
-		for _, referred := range adjacencyList[userID] {
-			count += 1 + dfs(referred, path)
+	for i, a := range actions {
+		if err := s.validateAction(ctx, a); err != nil {
+			results[i].Err = err
+			continue
 		}
 
-		// Unmark this user from the current path
-		path[userID] = false
-		referralIndex[userID] = count
-		return count
+		if a.CreatedAt.IsZero() {
+			a.CreatedAt = time.Now().UTC()
+		}
+		if a.Type != act_type.ActionTypeReferUser {
+			a.TargetUser = 0
+		}
+		// Actions are only deleted through DeleteAction, so it is recorded
+		a.DeletedAt = nil
+
+		valid = append(valid, a)
+		validIdx = append(validIdx, i)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	stored, err := s.actionRepo.AddActions(ctx, valid)
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("failed to store actions: %w", err)
 	}
+	changes := make([]audit_s.Change, len(stored))
+	for i, a := range stored {
+		a := a
+		results[validIdx[i]].Action = &a
+		changes[i] = audit_s.ActionCreated(a)
+	}
+
+	// The actions are stored whether or not they are recorded, and failing would
+	// have clients store them again when they retry
+	if err := s.audit(ctx, changes...); err != nil {
+		span.RecordError(err)
+		zerolog.Ctx(ctx).Error().Err(err).Int("actions", len(stored)).Msg("Failed to record stored actions in the audit trail")
+	}
+
+	return results, nil
+}
+
+// DeleteAction soft deletes an action and records it in the audit trail. It
+// returns action.ErrActionNotFound when the action doesn't exist or was already
+// deleted, and when canDelete, if set, refuses the user who performed it, so
+// callers can't tell the actions they may not delete from missing ones.
+func (s *ServiceImpl) DeleteAction(ctx context.Context, actionID int, canDelete func(userID int) bool) (*act_type.Action, error) {
+	ctx, span := tracer.Start(ctx, "action.Service.DeleteAction")
+	defer span.End()
+	span.SetAttributes(attribute.Int("action.id", actionID))
 
-	// Calculate the referral index for each user
-	for userID := range adjacencyList {
-		if !visited[userID] {
-			dfs(userID, make(map[int]bool))
+	if canDelete != nil {
+		existing, err := s.actionRepo.GetAction(ctx, actionID)
+		if err != nil {
+			return nil, err
+		}
+		if !canDelete(existing.UserID) {
+			return nil, action.ErrActionNotFound
 		}
 	}
 
-	// Adjust referral indices for nodes in cycles
-	for node := range inCycle {
-		referralIndex[node] = len(inCycle) - 1
+	deleted, err := s.actionRepo.DeleteActions(ctx, []int{actionID}, time.Now().UTC())
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("failed to delete action: %w", err)
+	}
+	if len(deleted) == 0 {
+		return nil, action.ErrActionNotFound
+	}
+
+	// The action is deleted whether or not it is recorded, like stored actions
+	if err := s.audit(ctx, audit_s.ActionDeleted(deleted[0])); err != nil {
+		span.RecordError(err)
+		zerolog.Ctx(ctx).Error().Err(err).Int("action", actionID).Msg("Failed to record the deleted action in the audit trail")
+	}
+	return &deleted[0], nil
+}
+
+// audit records the changes in the audit trail, when the service has one
+func (s *ServiceImpl) audit(ctx context.Context, changes ...audit_s.Change) error {
+	if s.auditor == nil {
+		return nil
+	}
+	return s.auditor.Record(ctx, changes...)
+}
+
+// validateAction checks an action against the action type registry and the user repository
+func (s *ServiceImpl) validateAction(ctx context.Context, a act_type.Action) error {
+	if !a.Type.IsValid() {
+		return fmt.Errorf("%w: %q", ErrUnknownActionType, a.Type)
+	}
+
+	if _, err := s.userRepo.GetUserByID(ctx, a.UserID); err != nil {
+		return err
+	}
+
+	if a.Type == act_type.ActionTypeReferUser {
+		if a.TargetUser == 0 {
+			return ErrTargetUserRequired
+		}
+		if _, err := s.userRepo.GetUserByID(ctx, a.TargetUser); err != nil {
+			if errors.Is(err, user_repo.ErrUserNotFound) {
+				return ErrTargetUserNotFound
+			}
+			return err
+		}
 	}
 
-	return referralIndex
+	return nil
 }
@@ -1,6 +1,9 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"maps"
 	"reflect"
 	"testing"
 	"time"
@@ -9,6 +12,10 @@ import (
 	act_type "github.com/AntonioDaria/surfe/src/models"
 	"github.com/AntonioDaria/surfe/src/repository/action"
 	"github.com/AntonioDaria/surfe/src/repository/action/mock"
+	user_repo "github.com/AntonioDaria/surfe/src/repository/user"
+	user_mock "github.com/AntonioDaria/surfe/src/repository/user/mock"
+	audit_s "github.com/AntonioDaria/surfe/src/services/audit"
+	audit_mock "github.com/AntonioDaria/surfe/src/services/audit/mock"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -17,18 +24,19 @@ func Test_CountActionsByUserID(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	// Create a new mock repository
+	// Create new mock repositories
 	actionRepo := mock.NewMockRepository(ctrl)
-	actionService := NewActionService(actionRepo)
+	userRepo := user_mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, userRepo)
 
-	// Define expected behavior for UserExists
-	actionRepo.EXPECT().UserExists(1).Return(true)
+	// Define expected behavior for GetUserByID
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1}, nil)
 
 	// Define expected behavior for CountActionsByUserID
-	actionRepo.EXPECT().CountActionsByUserID(1).Return(2)
+	actionRepo.EXPECT().CountActionsByUserID(gomock.Any(), 1).Return(2)
 
 	// Act
-	count, err := actionService.GetActionCountByUserID(1)
+	count, err := actionService.GetActionCountByUserID(context.Background(), 1)
 	assert.NoError(t, err)
 
 	// Assert
@@ -37,24 +45,58 @@ func Test_CountActionsByUserID(t *testing.T) {
 	}
 }
 
+func Test_CountActionsByUserID_No_Actions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	userRepo := user_mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, userRepo)
+
+	// The user exists without having performed any action
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1}, nil)
+	actionRepo.EXPECT().CountActionsByUserID(gomock.Any(), 1).Return(0)
+
+	count, err := actionService.GetActionCountByUserID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
 func Test_CountActionsByUserID_User_Not_Found(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	// Create a new mock repository
+	// Create new mock repositories
 	actionRepo := mock.NewMockRepository(ctrl)
-	actionService := NewActionService(actionRepo)
+	userRepo := user_mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, userRepo)
 
-	// Define expected behavior for UserExists
-	actionRepo.EXPECT().UserExists(1).Return(false)
+	// Define expected behavior for GetUserByID
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(nil, user_repo.ErrUserNotFound)
 
 	// Act
-	count, err := actionService.GetActionCountByUserID(1)
+	count, err := actionService.GetActionCountByUserID(context.Background(), 1)
 	assert.Error(t, err)
 	assert.Equal(t, action.ErrUserNotFound, err)
 	assert.Equal(t, 0, count)
 }
 
+func Test_GetActionCountsByUserIDs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	userRepo := user_mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, userRepo)
+
+	// User 3 doesn't exist, and user 2 performed no action
+	userRepo.EXPECT().GetUsersByIDs(gomock.Any(), []int{1, 2, 3}).Return(map[int]*models.User{1: {ID: 1}, 2: {ID: 2}})
+	actionRepo.EXPECT().CountActionsByUserIDs(gomock.Any(), []int{1, 2}).Return(map[int]int{1: 3})
+
+	counts := actionService.GetActionCountsByUserIDs(context.Background(), []int{1, 2, 3})
+	assert.Equal(t, map[int]int{1: 3, 2: 0}, counts)
+}
+
 func TestServiceImpl_GetNextActionProbabilities(t *testing.T) {
 	// Define timestamps for test actions
 	time1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
@@ -153,7 +195,9 @@ func TestServiceImpl_GetNextActionProbabilities(t *testing.T) {
 			s := &ServiceImpl{
 				actionRepo: tt.fields.actionRepo,
 			}
-			if got := s.GetNextActionProbabilities(tt.args.actionType); !reflect.DeepEqual(got, tt.want) {
+			got, err := s.GetNextActionProbabilities(context.Background(), tt.args.actionType)
+			assert.NoError(t, err)
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("ServiceImpl.GetNextActionProbabilities() = %v, want %v", got, tt.want)
 			}
 		})
@@ -177,7 +221,8 @@ func TestServiceImpl_GetReferralIndex(t *testing.T) {
 	}
 
 	// Call the service function directly
-	referralIndex := actionService.GetReferralIndex()
+	referralIndex, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
 
 	// Assert expected referral indices
 	assert.Equal(t, 4, referralIndex[1]) // User 1 referred 2, 3, 4, 5
@@ -201,7 +246,8 @@ func TestServiceImpl_GetReferralIndex_No_Referrals(t *testing.T) {
 	}
 
 	// Call the service function directly
-	referralIndex := actionService.GetReferralIndex()
+	referralIndex, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
 
 	// Assert no referrals
 	assert.Equal(t, 0, referralIndex[1])
@@ -224,7 +270,8 @@ func TestServiceImpl_GetReferralIndex_Circular_Referral(t *testing.T) {
 	}
 
 	// Call the service function directly
-	referralIndex := actionService.GetReferralIndex()
+	referralIndex, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
 
 	// Assert expected referral indices for a circular referral chain
 	assert.Equal(t, 2, referralIndex[1]) // User 1 has 2 indirect referrals (2 and 3)
@@ -232,3 +279,584 @@ func TestServiceImpl_GetReferralIndex_Circular_Referral(t *testing.T) {
 	assert.Equal(t, 2, referralIndex[3]) // User 3 has 2 indirect referrals (1 and 2)
 
 }
+
+func TestServiceImpl_GetReferralIndex_Self_Referral(t *testing.T) {
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 1},
+			{ID: 2, UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 3},
+			{ID: 3, UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 3},
+		},
+	}
+
+	actionService := NewActionService(actionRepo, nil)
+
+	referralIndex, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+
+	// A user referring themselves is a cycle of one, and every referral counts
+	assert.Equal(t, 0, referralIndex[1])
+	assert.Equal(t, 2, referralIndex[2])
+}
+
+func TestServiceImpl_GetReferralIndex_Diamond(t *testing.T) {
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 2},
+			{ID: 2, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 3},
+			{ID: 3, UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 4},
+			{ID: 4, UserID: 3, Type: act_type.ActionTypeReferUser, TargetUser: 4},
+			{ID: 5, UserID: 5, Type: act_type.ActionTypeReferUser, TargetUser: 6},
+			{ID: 6, UserID: 6, Type: act_type.ActionTypeReferUser, TargetUser: 7},
+			{ID: 7, UserID: 7, Type: act_type.ActionTypeReferUser, TargetUser: 6},
+		},
+	}
+
+	actionService := NewActionService(actionRepo, nil)
+
+	referralIndex, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+
+	// User 4 is counted through both user 2 and user 3, and the users of a
+	// cycle count as the other users in it
+	assert.Equal(t, map[int]int{1: 4, 2: 1, 3: 1, 4: 0, 5: 2, 6: 1, 7: 1}, referralIndex)
+}
+
+func TestServiceImpl_GetReferralIndex_Several_Cycles(t *testing.T) {
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 2},
+			{ID: 2, UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 1},
+			{ID: 3, UserID: 3, Type: act_type.ActionTypeReferUser, TargetUser: 1},
+		},
+	}
+	actionService := NewActionService(actionRepo, nil)
+
+	referralIndex, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 1, 2: 1, 3: 2}, referralIndex)
+
+	// The users of every cycle count the other users found on any cycle, so a
+	// new cycle changes the index of the users on the first one and of user 3
+	_, err = actionRepo.AddActions(context.Background(), []models.Action{
+		{ID: 4, UserID: 4, Type: act_type.ActionTypeReferUser, TargetUser: 4},
+	})
+	assert.NoError(t, err)
+	referralIndex, err = actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 2, 2: 2, 3: 3, 4: 2}, referralIndex)
+
+	// And breaking a cycle changes them back
+	_, err = actionRepo.DeleteActions(context.Background(), []int{4}, time.Now())
+	assert.NoError(t, err)
+	referralIndex, err = actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 1, 2: 1, 3: 2}, referralIndex)
+
+	fresh, err := NewActionService(actionRepo, nil).GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, fresh, referralIndex)
+}
+
+func TestServiceImpl_GetReferralIndex_Matches_Baseline(t *testing.T) {
+	actionRepo, err := action.NewActionRepo("../../repository/data/actions.json")
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	referralIndex, err := NewActionService(actionRepo, nil).GetReferralIndex(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, baselineReferralIndex(actionRepo.GetAllActions(ctx)), referralIndex)
+
+	// Users 147, 191 and 802 referred themselves, so they count each other
+	assert.Equal(t, 2, referralIndex[147])
+	assert.Equal(t, 2, referralIndex[191])
+	assert.Equal(t, 2, referralIndex[802])
+
+	// Folding the referrals in one at a time gives the same index at every step
+	referrals := actionRepo.GetReferrals(ctx)
+	graph := newReferralGraph()
+	for n := 1; n <= len(referrals); n++ {
+		referralIndex, err := graph.sync(ctx, referrals[:n])
+		assert.NoError(t, err)
+
+		actions := make([]models.Action, 0, n)
+		for _, referral := range referrals[:n] {
+			actions = append(actions, models.Action{UserID: referral.Referrer, Type: act_type.ActionTypeReferUser, TargetUser: referral.User})
+		}
+		if !assert.Equal(t, baselineReferralIndex(actions), referralIndex, "after %d referrals", n) {
+			return
+		}
+	}
+}
+
+// baselineReferralIndex is the referral index as it was computed before it was
+// maintained incrementally, from every action at once. It also counts users who
+// can reach a cycle as on it when they happen to be visited first, so it only
+// gives a stable result when nobody else can reach the users on a cycle, as in
+// the dataset.
+func baselineReferralIndex(actions []models.Action) map[int]int {
+	// Build an adjacency list from refer actions
+	adjacencyList := make(map[int][]int)
+	for _, action := range actions {
+		if action.Type == act_type.ActionTypeReferUser {
+			referrer := action.UserID
+			referred := action.TargetUser
+			adjacencyList[referrer] = append(adjacencyList[referrer], referred)
+		}
+	}
+
+	// Final referral index map to store results for each user
+	referralIndex := make(map[int]int)
+	visited := make(map[int]bool)
+	inCycle := make(map[int]bool)
+
+	// DFS function to calculate referral index with cycle detection
+	var dfs func(userID int, path map[int]bool) int
+	dfs = func(userID int, path map[int]bool) int {
+		if path[userID] {
+			// If a node is revisited in the same path, we have a cycle
+			for node := range path {
+				inCycle[node] = true // Mark all nodes in the path as part of a cycle
+			}
+			return 0
+		}
+
+		if visited[userID] {
+			// If already visited and calculated, return the cached result
+			return referralIndex[userID]
+		}
+
+		// Mark this user as visited in the current path
+		path[userID] = true
+		visited[userID] = true
+		count := 0
+
+		// Traverse each referral
+		for _, referred := range adjacencyList[userID] {
+			count += 1 + dfs(referred, path)
+		}
+
+		// Unmark this user from the current path
+		path[userID] = false
+		referralIndex[userID] = count
+		return count
+	}
+
+	// Calculate the referral index for each user
+	for userID := range adjacencyList {
+		if !visited[userID] {
+			dfs(userID, make(map[int]bool))
+		}
+	}
+
+	// Adjust referral indices for nodes in cycles
+	for node := range inCycle {
+		referralIndex[node] = len(inCycle) - 1
+	}
+
+	return referralIndex
+}
+
+func TestServiceImpl_OnReferralIndexChange(t *testing.T) {
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 2},
+			{ID: 2, UserID: 3, Type: act_type.ActionTypeReferUser, TargetUser: 4},
+		},
+	}
+	actionService := NewActionService(actionRepo, nil)
+
+	var changes []map[int]int
+	actionService.OnReferralIndexChange(func(c map[int]int) {
+		changes = append(changes, maps.Clone(c))
+	})
+
+	_, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+
+	// User 2 referring user 5 only changes the index of users 1 and 2
+	_, err = actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 5},
+	})
+	assert.NoError(t, err)
+
+	referralIndex, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 2, 2: 1, 3: 1, 4: 0, 5: 0}, referralIndex)
+
+	// Only the users whose index changed are reported
+	_, err = actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 4, Type: act_type.ActionTypeReferUser, TargetUser: 5},
+	})
+	assert.NoError(t, err)
+	referralIndex, err = actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 2, 2: 1, 3: 2, 4: 1, 5: 0}, referralIndex)
+
+	// Closing a cycle updates everyone in it and the users who can reach it,
+	// like a graph built from scratch would
+	_, err = actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 5, Type: act_type.ActionTypeReferUser, TargetUser: 2},
+	})
+	assert.NoError(t, err)
+	referralIndex, err = actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 2, 2: 1, 3: 3, 4: 2, 5: 1}, referralIndex)
+
+	fresh, err := NewActionService(actionRepo, nil).GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, fresh, referralIndex)
+
+	assert.Equal(t, []map[int]int{{1: 1, 3: 1}, {1: 2, 2: 1}, {3: 2, 4: 1}, {3: 3, 4: 2, 5: 1}}, changes)
+}
+
+func TestServiceImpl_GetNextActionProbabilities_Cancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, user_mock.NewMockRepository(ctrl))
+
+	actionRepo.EXPECT().Version(gomock.Any()).Return(uint64(0))
+	actionRepo.EXPECT().NextActionCounts(gomock.Any(), act_type.ActionTypeAddContact).Return(nil, context.DeadlineExceeded)
+
+	probabilities, err := actionService.GetNextActionProbabilities(context.Background(), act_type.ActionTypeAddContact)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, probabilities)
+}
+
+func TestServiceImpl_GetReferralIndex_Cancelled(t *testing.T) {
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 2},
+			{ID: 2, UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 3},
+		},
+	}
+
+	actionService := &ServiceImpl{
+		actionRepo: actionRepo,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	referralIndex, err := actionService.GetReferralIndex(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, referralIndex)
+}
+
+func TestServiceImpl_GetNextActionProbabilities_Cached(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, user_mock.NewMockRepository(ctrl))
+
+	counts := map[act_type.ActionType]int{act_type.ActionTypeViewContacts: 3}
+
+	// The counts are only read once per dataset version
+	gomock.InOrder(
+		actionRepo.EXPECT().Version(gomock.Any()).Return(uint64(1)).Times(2),
+		actionRepo.EXPECT().Version(gomock.Any()).Return(uint64(2)),
+	)
+	actionRepo.EXPECT().NextActionCounts(gomock.Any(), act_type.ActionTypeAddContact).Return(counts, nil).Times(2)
+
+	for i := 0; i < 3; i++ {
+		probabilities, err := actionService.GetNextActionProbabilities(context.Background(), act_type.ActionTypeAddContact)
+		assert.NoError(t, err)
+		assert.Equal(t, map[act_type.ActionType]float64{act_type.ActionTypeViewContacts: 1}, probabilities)
+	}
+}
+
+func TestServiceImpl_GetNextActionProbabilities_UnknownType(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// Unknown types are rejected before reaching the repository or the cache
+	actionRepo := mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, user_mock.NewMockRepository(ctrl))
+
+	probabilities, err := actionService.GetNextActionProbabilities(context.Background(), "UNKNOWN")
+	assert.ErrorIs(t, err, ErrUnknownActionType)
+	assert.Nil(t, probabilities)
+}
+
+func TestServiceImpl_GetReferralIndex_Cached(t *testing.T) {
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 2},
+		},
+	}
+	actionService := NewActionService(actionRepo, nil)
+
+	referralIndex, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, referralIndex[1])
+
+	// Writing through the repository invalidates the cached index
+	_, err = actionRepo.AddActions(context.Background(), []models.Action{
+		{UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 3},
+	})
+	assert.NoError(t, err)
+
+	referralIndex, err = actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, referralIndex[1])
+}
+
+func TestServiceImpl_GetActionsByUserIDs(t *testing.T) {
+	time1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: act_type.ActionTypeAddContact, CreatedAt: time1},
+			{ID: 2, UserID: 2, Type: act_type.ActionTypeAddContact, CreatedAt: time1},
+			{ID: 3, UserID: 1, Type: act_type.ActionTypeEditContact, CreatedAt: time1},
+			{ID: 4, UserID: 3, Type: act_type.ActionTypeWelcome, CreatedAt: time1},
+		},
+	}
+	actionService := NewActionService(actionRepo, nil)
+
+	actions, err := actionService.GetActionsByUserIDs(context.Background(), []int{1, 3, 4})
+	assert.NoError(t, err)
+
+	assert.Len(t, actions, 2)
+	assert.Equal(t, []models.Action{actionRepo.Actions[0], actionRepo.Actions[2]}, actions[1])
+	assert.Equal(t, []models.Action{actionRepo.Actions[3]}, actions[3])
+	assert.Empty(t, actions[4])
+}
+
+func TestServiceImpl_GetReferrers(t *testing.T) {
+	time1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 2, CreatedAt: time1.Add(time.Hour)},
+			{ID: 2, UserID: 3, Type: act_type.ActionTypeReferUser, TargetUser: 2, CreatedAt: time1},
+			{ID: 3, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 4, CreatedAt: time1},
+			{ID: 4, UserID: 5, Type: act_type.ActionTypeAddContact, CreatedAt: time1},
+		},
+	}
+	actionService := NewActionService(actionRepo, nil)
+
+	referrers, err := actionService.GetReferrers(context.Background(), []int{2, 4, 5})
+	assert.NoError(t, err)
+
+	// User 2 was referred by 3 first, and nobody referred user 5
+	assert.Equal(t, map[int]int{2: 3, 4: 1}, referrers)
+}
+
+func TestServiceImpl_AddActions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	userRepo := user_mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, userRepo)
+
+	createdAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	input := []models.Action{
+		{UserID: 1, Type: act_type.ActionTypeAddContact, CreatedAt: createdAt},
+		{UserID: 1, Type: act_type.ActionType("UNKNOWN")},
+		{UserID: 9999, Type: act_type.ActionTypeViewContacts},
+		{UserID: 1, Type: act_type.ActionTypeReferUser},
+		{UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 9999},
+		{UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 3, CreatedAt: createdAt},
+	}
+
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1}, nil).AnyTimes()
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 2).Return(&models.User{ID: 2}, nil).AnyTimes()
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 3).Return(&models.User{ID: 3}, nil).AnyTimes()
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 9999).Return(nil, user_repo.ErrUserNotFound).AnyTimes()
+
+	// Only the valid actions are stored, in a single batch
+	actionRepo.EXPECT().AddActions(gomock.Any(), []models.Action{input[0], input[5]}).Return([]models.Action{
+		{ID: 10, UserID: 1, Type: act_type.ActionTypeAddContact, CreatedAt: createdAt},
+		{ID: 11, UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 3, CreatedAt: createdAt},
+	}, nil)
+
+	results, err := actionService.AddActions(context.Background(), input)
+	assert.NoError(t, err)
+	assert.Len(t, results, len(input))
+
+	assert.Equal(t, 10, results[0].Action.ID)
+	assert.ErrorIs(t, results[1].Err, ErrUnknownActionType)
+	assert.ErrorIs(t, results[2].Err, user_repo.ErrUserNotFound)
+	assert.ErrorIs(t, results[3].Err, ErrTargetUserRequired)
+	assert.ErrorIs(t, results[4].Err, ErrTargetUserNotFound)
+	assert.Equal(t, 11, results[5].Action.ID)
+}
+
+func TestServiceImpl_AddActions_None_Valid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// The repository must not be called when every action is rejected
+	actionRepo := mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, user_mock.NewMockRepository(ctrl))
+
+	results, err := actionService.AddActions(context.Background(), []models.Action{{UserID: 1, Type: act_type.ActionType("UNKNOWN")}})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, ErrUnknownActionType)
+	assert.Nil(t, results[0].Action)
+}
+
+func TestServiceImpl_GetReferralIndex_Deleted(t *testing.T) {
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: act_type.ActionTypeReferUser, TargetUser: 2},
+			{ID: 2, UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 3},
+			{ID: 3, UserID: 2, Type: act_type.ActionTypeReferUser, TargetUser: 3},
+			{ID: 4, UserID: 3, Type: act_type.ActionTypeReferUser, TargetUser: 4},
+		},
+	}
+	actionService := NewActionService(actionRepo, nil)
+
+	var changes []map[int]int
+	actionService.OnReferralIndexChange(func(c map[int]int) {
+		changes = append(changes, maps.Clone(c))
+	})
+
+	referralIndex, err := actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 5, 2: 4, 3: 1, 4: 0}, referralIndex)
+
+	// User 2 referred user 3 twice, so one of them going only takes one away
+	_, err = actionRepo.DeleteActions(context.Background(), []int{2}, time.Now())
+	assert.NoError(t, err)
+	referralIndex, err = actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 3, 2: 2, 3: 1, 4: 0}, referralIndex)
+
+	// Users left without referrals are dropped, like a graph built from scratch would
+	_, err = actionRepo.DeleteActions(context.Background(), []int{1, 4}, time.Now())
+	assert.NoError(t, err)
+	referralIndex, err = actionService.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{2: 1, 3: 0}, referralIndex)
+
+	fresh, err := NewActionService(actionRepo, nil).GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, fresh, referralIndex)
+
+	assert.Equal(t, []map[int]int{{1: 5, 2: 4, 3: 1}, {1: 3, 2: 2}, {1: 0, 2: 1, 3: 0}}, changes)
+}
+
+func TestServiceImpl_DeleteAction(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	auditor := audit_mock.NewMockService(ctrl)
+	actionService := NewActionService(actionRepo, nil)
+	actionService.SetAuditor(auditor)
+
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	deleted := models.Action{ID: 7, UserID: 1, Type: act_type.ActionTypeWelcome, DeletedAt: &deletedAt}
+	actionRepo.EXPECT().DeleteActions(gomock.Any(), []int{7}, gomock.Any()).Return([]models.Action{deleted}, nil)
+	auditor.EXPECT().Record(gomock.Any(), audit_s.ActionDeleted(deleted)).Return(nil)
+
+	got, err := actionService.DeleteAction(context.Background(), 7, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &deleted, got)
+
+	// Nothing is recorded when there was nothing to delete
+	actionRepo.EXPECT().DeleteActions(gomock.Any(), []int{8}, gomock.Any()).Return(nil, nil)
+
+	_, err = actionService.DeleteAction(context.Background(), 8, nil)
+	assert.ErrorIs(t, err, action.ErrActionNotFound)
+}
+
+func TestServiceImpl_DeleteAction_AuditFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	auditor := audit_mock.NewMockService(ctrl)
+	actionService := NewActionService(actionRepo, nil)
+	actionService.SetAuditor(auditor)
+
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	deleted := models.Action{ID: 7, UserID: 1, Type: act_type.ActionTypeWelcome, DeletedAt: &deletedAt}
+	actionRepo.EXPECT().DeleteActions(gomock.Any(), []int{7}, gomock.Any()).Return([]models.Action{deleted}, nil)
+	auditor.EXPECT().Record(gomock.Any(), gomock.Any()).Return(errors.New("disk full"))
+
+	// The action was deleted, so the deletion succeeds
+	got, err := actionService.DeleteAction(context.Background(), 7, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &deleted, got)
+}
+
+func TestServiceImpl_DeleteAction_Owner(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	actionService := NewActionService(actionRepo, nil)
+
+	owned := models.Action{ID: 7, UserID: 1, Type: act_type.ActionTypeWelcome}
+	actionRepo.EXPECT().GetAction(gomock.Any(), 7).Return(&owned, nil).Times(2)
+
+	// Actions of users the caller may not delete are reported as not found
+	_, err := actionService.DeleteAction(context.Background(), 7, func(userID int) bool { return userID == 2 })
+	assert.ErrorIs(t, err, action.ErrActionNotFound)
+
+	deleted := owned
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	deleted.DeletedAt = &deletedAt
+	actionRepo.EXPECT().DeleteActions(gomock.Any(), []int{7}, gomock.Any()).Return([]models.Action{deleted}, nil)
+
+	got, err := actionService.DeleteAction(context.Background(), 7, func(userID int) bool { return userID == 1 })
+	assert.NoError(t, err)
+	assert.Equal(t, &deleted, got)
+}
+
+func TestServiceImpl_AddActions_Audited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	userRepo := user_mock.NewMockRepository(ctrl)
+	auditor := audit_mock.NewMockService(ctrl)
+	actionService := NewActionService(actionRepo, userRepo)
+	actionService.SetAuditor(auditor)
+
+	createdAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored := models.Action{ID: 10, UserID: 1, Type: act_type.ActionTypeWelcome, CreatedAt: createdAt}
+
+	// Clients can't store actions that are already deleted
+	deletedAt := time.Now()
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1}, nil)
+	actionRepo.EXPECT().AddActions(gomock.Any(), []models.Action{{UserID: 1, Type: act_type.ActionTypeWelcome, CreatedAt: createdAt}}).
+		Return([]models.Action{stored}, nil)
+	auditor.EXPECT().Record(gomock.Any(), audit_s.ActionCreated(stored)).Return(nil)
+
+	results, err := actionService.AddActions(context.Background(), []models.Action{
+		{UserID: 1, Type: act_type.ActionTypeWelcome, CreatedAt: createdAt, DeletedAt: &deletedAt},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, results[0].Action.ID)
+}
+
+func TestServiceImpl_AddActions_AuditFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	actionRepo := mock.NewMockRepository(ctrl)
+	userRepo := user_mock.NewMockRepository(ctrl)
+	auditor := audit_mock.NewMockService(ctrl)
+	actionService := NewActionService(actionRepo, userRepo)
+	actionService.SetAuditor(auditor)
+
+	createdAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored := models.Action{ID: 10, UserID: 1, Type: act_type.ActionTypeWelcome, CreatedAt: createdAt}
+
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1}, nil)
+	actionRepo.EXPECT().AddActions(gomock.Any(), gomock.Any()).Return([]models.Action{stored}, nil)
+	auditor.EXPECT().Record(gomock.Any(), audit_s.ActionCreated(stored)).Return(errors.New("disk full"))
+
+	// The actions are stored, so they are returned rather than retried by the client
+	results, err := actionService.AddActions(context.Background(), []models.Action{
+		{UserID: 1, Type: act_type.ActionTypeWelcome, CreatedAt: createdAt},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, results[0].Action.ID)
+}
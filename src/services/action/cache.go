@@ -0,0 +1,52 @@
+package services
+
+import "sync"
+
+// resultCache holds analytics results computed from one version of the
+// actions dataset. Entries for older versions are dropped as soon as a result
+// for a newer version is stored. A nil cache never hits.
+type resultCache struct {
+	mu      sync.Mutex
+	version uint64
+	entries map[string]any
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{entries: make(map[string]any)}
+}
+
+// get returns the result stored under key for the given dataset version
+func (c *resultCache) get(version uint64, key string) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version != c.version {
+		return nil, false
+	}
+	value, ok := c.entries[key]
+	return value, ok
+}
+
+// put stores a result computed from the given dataset version, unless the
+// cache already holds results for a newer one
+func (c *resultCache) put(version uint64, key string, value any) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version < c.version {
+		return
+	}
+	if version > c.version {
+		c.version = version
+		c.entries = make(map[string]any)
+	}
+	c.entries[key] = value
+}
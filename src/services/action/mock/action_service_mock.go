@@ -5,9 +5,11 @@
 package mock
 
 import (
+	context "context"
 	reflect "reflect"
 
 	models "github.com/AntonioDaria/surfe/src/models"
+	services "github.com/AntonioDaria/surfe/src/services/action"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -34,45 +36,135 @@ func (m *MockService) EXPECT() *MockServiceMockRecorder {
 	return m.recorder
 }
 
+// AddActions mocks base method.
+func (m *MockService) AddActions(ctx context.Context, actions []models.Action) ([]services.BulkResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddActions", ctx, actions)
+	ret0, _ := ret[0].([]services.BulkResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddActions indicates an expected call of AddActions.
+func (mr *MockServiceMockRecorder) AddActions(ctx, actions interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddActions", reflect.TypeOf((*MockService)(nil).AddActions), ctx, actions)
+}
+
+// DatasetVersion mocks base method.
+func (m *MockService) DatasetVersion(ctx context.Context) uint64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DatasetVersion", ctx)
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+// DatasetVersion indicates an expected call of DatasetVersion.
+func (mr *MockServiceMockRecorder) DatasetVersion(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DatasetVersion", reflect.TypeOf((*MockService)(nil).DatasetVersion), ctx)
+}
+
+// DeleteAction mocks base method.
+func (m *MockService) DeleteAction(ctx context.Context, actionID int, canDelete func(int) bool) (*models.Action, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAction", ctx, actionID, canDelete)
+	ret0, _ := ret[0].(*models.Action)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteAction indicates an expected call of DeleteAction.
+func (mr *MockServiceMockRecorder) DeleteAction(ctx, actionID, canDelete interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAction", reflect.TypeOf((*MockService)(nil).DeleteAction), ctx, actionID, canDelete)
+}
+
 // GetActionCountByUserID mocks base method.
-func (m *MockService) GetActionCountByUserID(userID int) (int, error) {
+func (m *MockService) GetActionCountByUserID(ctx context.Context, userID int) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetActionCountByUserID", userID)
+	ret := m.ctrl.Call(m, "GetActionCountByUserID", ctx, userID)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetActionCountByUserID indicates an expected call of GetActionCountByUserID.
-func (mr *MockServiceMockRecorder) GetActionCountByUserID(userID interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) GetActionCountByUserID(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActionCountByUserID", reflect.TypeOf((*MockService)(nil).GetActionCountByUserID), ctx, userID)
+}
+
+// GetActionCountsByUserIDs mocks base method.
+func (m *MockService) GetActionCountsByUserIDs(ctx context.Context, userIDs []int) map[int]int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActionCountsByUserIDs", ctx, userIDs)
+	ret0, _ := ret[0].(map[int]int)
+	return ret0
+}
+
+// GetActionCountsByUserIDs indicates an expected call of GetActionCountsByUserIDs.
+func (mr *MockServiceMockRecorder) GetActionCountsByUserIDs(ctx, userIDs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActionCountsByUserIDs", reflect.TypeOf((*MockService)(nil).GetActionCountsByUserIDs), ctx, userIDs)
+}
+
+// GetActionsByUserIDs mocks base method.
+func (m *MockService) GetActionsByUserIDs(ctx context.Context, userIDs []int) (map[int][]models.Action, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActionsByUserIDs", ctx, userIDs)
+	ret0, _ := ret[0].(map[int][]models.Action)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetActionsByUserIDs indicates an expected call of GetActionsByUserIDs.
+func (mr *MockServiceMockRecorder) GetActionsByUserIDs(ctx, userIDs interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActionCountByUserID", reflect.TypeOf((*MockService)(nil).GetActionCountByUserID), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActionsByUserIDs", reflect.TypeOf((*MockService)(nil).GetActionsByUserIDs), ctx, userIDs)
 }
 
 // GetNextActionProbabilities mocks base method.
-func (m *MockService) GetNextActionProbabilities(actionType models.ActionType) map[models.ActionType]float64 {
+func (m *MockService) GetNextActionProbabilities(ctx context.Context, actionType models.ActionType) (map[models.ActionType]float64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetNextActionProbabilities", actionType)
+	ret := m.ctrl.Call(m, "GetNextActionProbabilities", ctx, actionType)
 	ret0, _ := ret[0].(map[models.ActionType]float64)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetNextActionProbabilities indicates an expected call of GetNextActionProbabilities.
-func (mr *MockServiceMockRecorder) GetNextActionProbabilities(actionType interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) GetNextActionProbabilities(ctx, actionType interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNextActionProbabilities", reflect.TypeOf((*MockService)(nil).GetNextActionProbabilities), actionType)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNextActionProbabilities", reflect.TypeOf((*MockService)(nil).GetNextActionProbabilities), ctx, actionType)
 }
 
 // GetReferralIndex mocks base method.
-func (m *MockService) GetReferralIndex() map[int]int {
+func (m *MockService) GetReferralIndex(ctx context.Context) (map[int]int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetReferralIndex")
+	ret := m.ctrl.Call(m, "GetReferralIndex", ctx)
 	ret0, _ := ret[0].(map[int]int)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetReferralIndex indicates an expected call of GetReferralIndex.
-func (mr *MockServiceMockRecorder) GetReferralIndex() *gomock.Call {
+func (mr *MockServiceMockRecorder) GetReferralIndex(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReferralIndex", reflect.TypeOf((*MockService)(nil).GetReferralIndex), ctx)
+}
+
+// GetReferrers mocks base method.
+func (m *MockService) GetReferrers(ctx context.Context, userIDs []int) (map[int]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReferrers", ctx, userIDs)
+	ret0, _ := ret[0].(map[int]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetReferrers indicates an expected call of GetReferrers.
+func (mr *MockServiceMockRecorder) GetReferrers(ctx, userIDs interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReferralIndex", reflect.TypeOf((*MockService)(nil).GetReferralIndex))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReferrers", reflect.TypeOf((*MockService)(nil).GetReferrers), ctx, userIDs)
 }
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/AntonioDaria/surfe/src/repository/action"
+)
+
+// referralGraph keeps the referral index up to date as actions are stored and
+// deleted. Each referral a user made counts one plus the index of the user they
+// referred, so users referred more than once, or through several paths, count
+// every time. Users on a referral cycle, including users who referred
+// themselves, all get the number of other users found on any cycle instead, as
+// the index has always been computed. Adding or removing a referral can only
+// change the index of the referrer and of the users who can reach the referrer,
+// so only those are recomputed, from the index of the users they referred,
+// unless the number of users on a cycle changes, which also changes the index
+// of every user on a cycle and of the users who can reach them. The tombstones
+// of erased users stay in the graph, so the index of the users who referred
+// them doesn't change, but aren't reported.
+type referralGraph struct {
+	mu sync.Mutex
+	// applied is how many referrals of the dataset have been folded into the graph
+	applied int
+	// referred counts the referrals from each referrer to each user, as a user
+	// can be referred more than once by the same referrer
+	referred  map[int]map[int]int
+	referrers map[int]map[int]bool
+	index     map[int]int
+	// onCycle holds the users on a referral cycle, and cycleUsers how many there
+	// were when the index was computed
+	onCycle    map[int]bool
+	cycleUsers int
+	// dirty holds the users whose index must be recomputed
+	dirty map[int]bool
+	// changes holds the index changes not reported to the listener yet
+	changes  map[int]int
+	listener func(changes map[int]int)
+}
+
+func newReferralGraph() *referralGraph {
+	g := &referralGraph{}
+	g.reset()
+	return g
+}
+
+func (g *referralGraph) reset() {
+	g.applied = 0
+	g.referred = make(map[int]map[int]int)
+	g.referrers = make(map[int]map[int]bool)
+	g.index = make(map[int]int)
+	g.onCycle = make(map[int]bool)
+	g.cycleUsers = 0
+	g.dirty = make(map[int]bool)
+	g.changes = make(map[int]int)
+}
+
+// setListener sets the function called with the users whose index changed,
+// and their new index, every time the graph catches up with the dataset
+func (g *referralGraph) setListener(listener func(changes map[int]int)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.listener = listener
+}
+
+// sync folds the referrals stored since the last call into the graph and returns
+// a copy of the index. It stops early with the context's error if the context
+// is cancelled, and the next call carries on from there.
+func (g *referralGraph) sync(ctx context.Context, referrals []action.Referral) (map[int]int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Referrals are only ever appended, fewer referrals means they were replaced
+	if len(referrals) < g.applied {
+		g.reset()
+	}
+
+	for _, referral := range referrals[g.applied:] {
+		if referral.Removed {
+			g.removeReferral(referral.Referrer, referral.User)
+		} else {
+			g.addReferral(referral.Referrer, referral.User)
+		}
+	}
+	g.applied = len(referrals)
+
+	computed, onCycle, err := g.recompute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.onCycle = onCycle
+	g.cycleUsers = len(onCycle)
+	for userID, count := range computed {
+		if previous, ok := g.index[userID]; !ok || previous != count {
+			g.index[userID] = count
+			if previous != count && !action.IsTombstone(userID) {
+				g.changes[userID] = count
+			}
+		}
+	}
+	clear(g.dirty)
+
+	// Reporting under the lock keeps the listener's view in the order of the syncs
+	if len(g.changes) > 0 {
+		if g.listener != nil {
+			g.listener(g.changes)
+		}
+		g.changes = make(map[int]int)
+	}
+
+	index := make(map[int]int, len(g.index))
+	for userID, count := range g.index {
+		if !action.IsTombstone(userID) {
+			index[userID] = count
+		}
+	}
+	return index, nil
+}
+
+// addReferral records that referrer referred the user, marking the referrer
+// and everyone who can reach them for recomputation
+func (g *referralGraph) addReferral(referrer, user int) {
+	if _, ok := g.index[user]; !ok {
+		g.index[user] = 0
+	}
+	if g.referred[referrer] == nil {
+		g.referred[referrer] = make(map[int]int)
+	}
+	g.referred[referrer][user]++
+
+	if g.referrers[user] == nil {
+		g.referrers[user] = make(map[int]bool)
+	}
+	g.referrers[user][referrer] = true
+
+	g.markDirty(referrer)
+}
+
+// removeReferral takes back a referral from referrer to the user, marking the
+// referrer and everyone who can reach them for recomputation. Users left
+// without any referral are dropped from the index.
+func (g *referralGraph) removeReferral(referrer, user int) {
+	if g.referred[referrer][user] == 0 {
+		return
+	}
+	g.referred[referrer][user]--
+	g.markDirty(referrer)
+	if g.referred[referrer][user] > 0 {
+		return
+	}
+
+	delete(g.referred[referrer], user)
+	if len(g.referred[referrer]) == 0 {
+		delete(g.referred, referrer)
+	}
+	delete(g.referrers[user], referrer)
+	if len(g.referrers[user]) == 0 {
+		delete(g.referrers, user)
+	}
+
+	g.dropIfIsolated(referrer)
+	g.dropIfIsolated(user)
+}
+
+// dropIfIsolated removes a user no referral leads to or from the index
+func (g *referralGraph) dropIfIsolated(userID int) {
+	if len(g.referred[userID]) > 0 || len(g.referrers[userID]) > 0 {
+		return
+	}
+
+	if previous, ok := g.index[userID]; ok {
+		delete(g.index, userID)
+		if previous != 0 && !action.IsTombstone(userID) {
+			g.changes[userID] = 0
+		}
+	}
+	delete(g.onCycle, userID)
+	delete(g.dirty, userID)
+}
+
+// markDirty marks a user whose referrals changed, and everyone who can reach them, for recomputation
+func (g *referralGraph) markDirty(referrer int) {
+	// The ancestors of a dirty user were marked along with it
+	if g.dirty[referrer] {
+		return
+	}
+
+	queue := []int{referrer}
+	g.dirty[referrer] = true
+	for len(queue) > 0 {
+		userID := queue[0]
+		queue = queue[1:]
+
+		for ancestor := range g.referrers[userID] {
+			if !g.dirty[ancestor] {
+				g.dirty[ancestor] = true
+				queue = append(queue, ancestor)
+			}
+		}
+	}
+}
+
+// recompute works out the index of the dirty users and the users now on a
+// cycle, leaving the graph as it is so a cancelled sync can be carried on by the
+// next one. The users who aren't dirty can't reach dirty ones, so whether they
+// are on a cycle hasn't changed, and unless the number of users on a cycle
+// changed their index is still current.
+func (g *referralGraph) recompute(ctx context.Context) (map[int]int, map[int]bool, error) {
+	groups, err := g.groupDirty(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	onCycle := g.usersOnCycle(groups)
+	if len(onCycle) != g.cycleUsers {
+		for userID := range g.onCycle {
+			g.markDirty(userID)
+		}
+		if groups, err = g.groupDirty(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Groups come after the groups they referred, so every user is computed
+	// once from the index of the users they referred
+	computed := make(map[int]int, len(g.dirty))
+	for _, group := range groups {
+		if g.isCycle(group) {
+			for _, member := range group {
+				computed[member] = len(onCycle) - 1
+			}
+			continue
+		}
+
+		userID := group[0]
+		count := 0
+		for referred, times := range g.referred[userID] {
+			index, ok := computed[referred]
+			if !ok {
+				index = g.index[referred]
+			}
+			count += times * (1 + index)
+		}
+		computed[userID] = count
+	}
+	return computed, onCycle, nil
+}
+
+// usersOnCycle returns the users on a cycle, given the groups of the dirty users
+func (g *referralGraph) usersOnCycle(groups [][]int) map[int]bool {
+	onCycle := make(map[int]bool, len(g.onCycle))
+	for userID := range g.onCycle {
+		if !g.dirty[userID] {
+			onCycle[userID] = true
+		}
+	}
+	for _, group := range groups {
+		if g.isCycle(group) {
+			for _, member := range group {
+				onCycle[member] = true
+			}
+		}
+	}
+	return onCycle
+}
+
+// isCycle reports whether the users of a group referred each other, or the only
+// user of the group referred themselves
+func (g *referralGraph) isCycle(group []int) bool {
+	return len(group) > 1 || g.referred[group[0]][group[0]] > 0
+}
+
+// groupDirty groups the dirty users who referred each other with Tarjan's
+// algorithm, which completes a group only after the groups it referred, and
+// returns the groups in the order they were completed
+func (g *referralGraph) groupDirty(ctx context.Context) ([][]int, error) {
+	var groups [][]int
+	order := make(map[int]int, len(g.dirty))
+	low := make(map[int]int, len(g.dirty))
+	onStack := make(map[int]bool)
+	var stack []int
+
+	var err error
+	steps := 0
+
+	var visit func(userID int)
+	visit = func(userID int) {
+		steps++
+		if steps%cancelCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+		}
+
+		order[userID] = len(order)
+		low[userID] = order[userID]
+		stack = append(stack, userID)
+		onStack[userID] = true
+
+		for referred := range g.referred[userID] {
+			if !g.dirty[referred] {
+				continue
+			}
+			if _, seen := order[referred]; !seen {
+				visit(referred)
+				if err != nil {
+					return
+				}
+				low[userID] = min(low[userID], low[referred])
+			} else if onStack[referred] {
+				low[userID] = min(low[userID], order[referred])
+			}
+		}
+		if low[userID] != order[userID] {
+			return
+		}
+
+		// The user is the first of a group, made of the users above them on the stack
+		first := len(stack) - 1
+		for stack[first] != userID {
+			first--
+		}
+		group := append([]int(nil), stack[first:]...)
+		stack = stack[:first]
+		for _, member := range group {
+			onStack[member] = false
+		}
+		groups = append(groups, group)
+	}
+
+	for userID := range g.dirty {
+		if _, seen := order[userID]; !seen {
+			visit(userID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/audit"
+	"github.com/AntonioDaria/surfe/src/requestid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.Tracer("github.com/AntonioDaria/surfe/src/services/audit")
+
+var (
+	ErrUnknownEntity  = fmt.Errorf("unknown audit entity")
+	ErrEntityRequired = fmt.Errorf("entity is required to filter by ID")
+	ErrInvalidPage    = fmt.Errorf("invalid page")
+)
+
+const (
+	// DefaultPageLimit is how many records a page holds when no limit is given
+	DefaultPageLimit = 100
+	// MaxPageLimit is how many records a page holds at most
+	MaxPageLimit = 1000
+)
+
+// Page is a page of the audit trail
+type Page struct {
+	Records []models.AuditRecord
+	// Next is the ID of the last record of the page when more records follow,
+	// to get the next page with. It is zero on the last page.
+	Next int
+}
+
+// SystemActor is recorded for changes made outside of an authenticated request
+const SystemActor = "system"
+
+// Change is a change to record in the audit trail
+type Change struct {
+	Entity    models.AuditEntity
+	EntityID  int
+	Operation models.AuditOperation
+	// Before and After are the entity before and after the change, nil when it didn't exist
+	Before any
+	After  any
+}
+
+// ActionCreated returns the change storing an action
+func ActionCreated(action models.Action) Change {
+	return Change{Entity: models.AuditEntityAction, EntityID: action.ID, Operation: models.AuditOperationCreate, After: action}
+}
+
+// ActionDeleted returns the change soft deleting an action, given the deleted action
+func ActionDeleted(action models.Action) Change {
+	before := action
+	before.DeletedAt = nil
+	return Change{Entity: models.AuditEntityAction, EntityID: action.ID, Operation: models.AuditOperationDelete, Before: before, After: action}
+}
+
+// UserDeleted returns the change soft deleting a user, given the deleted user
+func UserDeleted(user models.User) Change {
+	before := user
+	before.DeletedAt = nil
+	return Change{Entity: models.AuditEntityUser, EntityID: user.ID, Operation: models.AuditOperationDelete, Before: before, After: user}
+}
+
+// UserErased returns the change erasing a user, given the erased user. The user
+// as they were before isn't recorded, as that is the data being erased.
+func UserErased(user models.User) Change {
+	return Change{Entity: models.AuditEntityUser, EntityID: user.ID, Operation: models.AuditOperationErase, After: user}
+}
+
+//go:generate mockgen -source=$GOFILE -destination=mock/audit_service_mock.go -package=mock
+type Service interface {
+	Record(ctx context.Context, changes ...Change) error
+	GetRecords(ctx context.Context, query audit.Query) (Page, error)
+	RedactUser(ctx context.Context, userID int, moved []models.Action) error
+}
+
+type ServiceImpl struct {
+	auditRepo audit.Repository
+}
+
+func NewAuditService(auditRepo audit.Repository) *ServiceImpl {
+	return &ServiceImpl{auditRepo: auditRepo}
+}
+
+// Record stores one audit record per change, attributed to the principal and
+// request of the context and all made at the same time
+func (s *ServiceImpl) Record(ctx context.Context, changes ...Change) error {
+	ctx, span := tracer.Start(ctx, "audit.Service.Record")
+	defer span.End()
+	span.SetAttributes(attribute.Int("changes.count", len(changes)))
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	actor := SystemActor
+	if principal := auth.PrincipalFromContext(ctx); principal != nil && principal.Name != "" {
+		actor = principal.Name
+	}
+	requestID := requestid.FromContext(ctx)
+	timestamp := time.Now().UTC()
+
+	records := make([]models.AuditRecord, len(changes))
+	for i, change := range changes {
+		before, err := marshalEntity(change.Before)
+		if err != nil {
+			return err
+		}
+		after, err := marshalEntity(change.After)
+		if err != nil {
+			return err
+		}
+
+		records[i] = models.AuditRecord{
+			Entity:    change.Entity,
+			EntityID:  change.EntityID,
+			Operation: change.Operation,
+			Actor:     actor,
+			RequestID: requestID,
+			Before:    before,
+			After:     after,
+			Timestamp: timestamp,
+		}
+	}
+
+	if _, err := s.auditRepo.AddRecords(ctx, records); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to record audit trail: %w", err)
+	}
+	return nil
+}
+
+// marshalEntity returns the entity as JSON, or nil when there is none
+func marshalEntity(entity any) (json.RawMessage, error) {
+	if entity == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audited entity: %w", err)
+	}
+	return data, nil
+}
+
+// GetRecords returns a page of the audit trail of an entity type, or of a
+// single entity when the query has an entity ID, oldest first. Every record is
+// selected when the query has no entity. Pages hold DefaultPageLimit records
+// unless asked otherwise.
+func (s *ServiceImpl) GetRecords(ctx context.Context, query audit.Query) (Page, error) {
+	ctx, span := tracer.Start(ctx, "audit.Service.GetRecords")
+	defer span.End()
+
+	if query.Entity != "" && !query.Entity.IsValid() {
+		return Page{}, fmt.Errorf("%w: %q", ErrUnknownEntity, query.Entity)
+	}
+	if query.Entity == "" && query.EntityID != nil {
+		return Page{}, ErrEntityRequired
+	}
+	if query.Limit == 0 {
+		query.Limit = DefaultPageLimit
+	}
+	if query.Limit < 0 || query.Limit > MaxPageLimit {
+		return Page{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidPage, MaxPageLimit)
+	}
+	if query.After < 0 {
+		return Page{}, fmt.Errorf("%w: cursor must not be negative", ErrInvalidPage)
+	}
+
+	// One more record is read to tell whether another page follows
+	limit := query.Limit
+	query.Limit++
+	page := Page{Records: s.auditRepo.GetRecords(ctx, query)}
+	if len(page.Records) > limit {
+		page.Records = page.Records[:limit]
+		page.Next = page.Records[limit-1].ID
+	}
+	return page, nil
+}
+
+// RedactUser removes an erased user from the audit trail: their personal data
+// from the records of the user, and their ID from the records of the actions
+// that were moved from them to a tombstone, given the moved actions
+func (s *ServiceImpl) RedactUser(ctx context.Context, userID int, moved []models.Action) error {
+	ctx, span := tracer.Start(ctx, "audit.Service.RedactUser")
+	defer span.End()
+	span.SetAttributes(attribute.Int("user.id", userID))
+
+	redactions := make([]audit.Redaction, 0, len(moved)+1)
+	redactions = append(redactions, audit.Redaction{
+		Entity:   models.AuditEntityUser,
+		EntityID: userID,
+		Redact: func(entity json.RawMessage) (json.RawMessage, error) {
+			var user models.User
+			if err := json.Unmarshal(entity, &user); err != nil {
+				return nil, err
+			}
+			user.Name = ""
+			return json.Marshal(user)
+		},
+	})
+	for _, a := range moved {
+		a := a
+		redactions = append(redactions, audit.Redaction{
+			Entity:   models.AuditEntityAction,
+			EntityID: a.ID,
+			Redact: func(entity json.RawMessage) (json.RawMessage, error) {
+				var recorded models.Action
+				if err := json.Unmarshal(entity, &recorded); err != nil {
+					return nil, err
+				}
+				if recorded.UserID == userID {
+					recorded.UserID = a.UserID
+				}
+				if recorded.TargetUser == userID {
+					recorded.TargetUser = a.TargetUser
+				}
+				return json.Marshal(recorded)
+			},
+		})
+	}
+
+	if _, err := s.auditRepo.Redact(ctx, redactions); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to redact audit trail: %w", err)
+	}
+	return nil
+}
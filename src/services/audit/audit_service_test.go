@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/audit"
+	"github.com/AntonioDaria/surfe/src/repository/audit/mock"
+	"github.com/AntonioDaria/surfe/src/requestid"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceImpl_Record(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	auditRepo := mock.NewMockRepository(ctrl)
+	auditService := NewAuditService(auditRepo)
+
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	user := models.User{ID: 1, Name: "Ferdinande", DeletedAt: &deletedAt}
+
+	var stored []models.AuditRecord
+	auditRepo.EXPECT().AddRecords(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, records []models.AuditRecord) ([]models.AuditRecord, error) {
+			stored = records
+			return records, nil
+		})
+
+	ctx := auth.ContextWithPrincipal(context.Background(), &auth.Principal{Name: "ops"})
+	ctx = requestid.NewContext(ctx, "req-1")
+	err := auditService.Record(ctx,
+		UserDeleted(user),
+		ActionCreated(models.Action{ID: 7, Type: models.ActionTypeWelcome, UserID: 1}))
+	assert.NoError(t, err)
+
+	assert.Len(t, stored, 2)
+	assert.Equal(t, "ops", stored[0].Actor)
+	assert.Equal(t, "req-1", stored[0].RequestID)
+	assert.Equal(t, models.AuditOperationDelete, stored[0].Operation)
+	assert.NotContains(t, string(stored[0].Before), "deletedAt")
+	assert.Contains(t, string(stored[0].After), "deletedAt")
+
+	// Creations have nothing before them
+	assert.Equal(t, models.AuditEntityAction, stored[1].Entity)
+	assert.Nil(t, stored[1].Before)
+	assert.Equal(t, stored[0].Timestamp, stored[1].Timestamp)
+}
+
+func TestServiceImpl_Record_Without_Principal(t *testing.T) {
+	auditRepo, err := audit.NewAuditRepo("")
+	assert.NoError(t, err)
+	auditService := NewAuditService(auditRepo)
+
+	assert.NoError(t, auditService.Record(context.Background(), ActionCreated(models.Action{ID: 1})))
+
+	page, err := auditService.GetRecords(context.Background(), audit.Query{Entity: models.AuditEntityAction})
+	assert.NoError(t, err)
+	assert.Equal(t, SystemActor, page.Records[0].Actor)
+}
+
+func TestServiceImpl_GetRecords_Pages(t *testing.T) {
+	ctx := context.Background()
+	auditRepo, err := audit.NewAuditRepo("")
+	assert.NoError(t, err)
+	auditService := NewAuditService(auditRepo)
+
+	for id := 1; id <= 5; id++ {
+		assert.NoError(t, auditService.Record(ctx, ActionCreated(models.Action{ID: id})))
+	}
+	assert.NoError(t, auditService.Record(ctx, UserDeleted(models.User{ID: 1})))
+
+	var ids []int
+	query := audit.Query{Entity: models.AuditEntityAction, Limit: 2}
+	for {
+		page, err := auditService.GetRecords(ctx, query)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Records), 2)
+		for _, record := range page.Records {
+			ids = append(ids, record.EntityID)
+		}
+		if page.Next == 0 {
+			break
+		}
+		query.After = page.Next
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, ids)
+
+	// A page that ends with the last record is the last one
+	page, err := auditService.GetRecords(ctx, audit.Query{Limit: 6})
+	assert.NoError(t, err)
+	assert.Len(t, page.Records, 6)
+	assert.Zero(t, page.Next)
+
+	page, err = auditService.GetRecords(ctx, audit.Query{})
+	assert.NoError(t, err)
+	assert.Len(t, page.Records, 6)
+}
+
+func TestServiceImpl_GetRecords_Invalid(t *testing.T) {
+	auditService := NewAuditService(nil)
+
+	_, err := auditService.GetRecords(context.Background(), audit.Query{Entity: "webhook"})
+	assert.ErrorIs(t, err, ErrUnknownEntity)
+
+	id := 1
+	_, err = auditService.GetRecords(context.Background(), audit.Query{EntityID: &id})
+	assert.ErrorIs(t, err, ErrEntityRequired)
+
+	_, err = auditService.GetRecords(context.Background(), audit.Query{Limit: MaxPageLimit + 1})
+	assert.ErrorIs(t, err, ErrInvalidPage)
+
+	_, err = auditService.GetRecords(context.Background(), audit.Query{After: -1})
+	assert.ErrorIs(t, err, ErrInvalidPage)
+}
+
+func TestServiceImpl_RedactUser(t *testing.T) {
+	ctx := context.Background()
+	auditRepo, err := audit.NewAuditRepo("")
+	assert.NoError(t, err)
+	auditService := NewAuditService(auditRepo)
+
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, auditService.Record(ctx,
+		UserDeleted(models.User{ID: 1, Name: "Ferdinande", DeletedAt: &deletedAt}),
+		ActionCreated(models.Action{ID: 7, Type: models.ActionTypeReferUser, UserID: 2, TargetUser: 1})))
+
+	moved := []models.Action{{ID: 7, Type: models.ActionTypeReferUser, UserID: 2, TargetUser: -1}}
+	assert.NoError(t, auditService.RedactUser(ctx, 1, moved))
+
+	// The user's records keep everything but their personal data
+	userID := 1
+	page, err := auditService.GetRecords(ctx, audit.Query{Entity: models.AuditEntityUser, EntityID: &userID})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(page.Records[0].Before), "Ferdinande")
+	assert.Contains(t, string(page.Records[0].After), "deletedAt")
+
+	actionID := 7
+	page, err = auditService.GetRecords(ctx, audit.Query{Entity: models.AuditEntityAction, EntityID: &actionID})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":7,"type":"REFER_USER","userId":2,"targetUser":-1,"createdAt":"0001-01-01T00:00:00Z"}`, string(page.Records[0].After))
+	assert.Nil(t, page.Records[0].Before)
+}
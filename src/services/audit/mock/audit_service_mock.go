@@ -0,0 +1,86 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: audit_service.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	models "github.com/AntonioDaria/surfe/src/models"
+	audit "github.com/AntonioDaria/surfe/src/repository/audit"
+	services "github.com/AntonioDaria/surfe/src/services/audit"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockService is a mock of Service interface.
+type MockService struct {
+	ctrl     *gomock.Controller
+	recorder *MockServiceMockRecorder
+}
+
+// MockServiceMockRecorder is the mock recorder for MockService.
+type MockServiceMockRecorder struct {
+	mock *MockService
+}
+
+// NewMockService creates a new mock instance.
+func NewMockService(ctrl *gomock.Controller) *MockService {
+	mock := &MockService{ctrl: ctrl}
+	mock.recorder = &MockServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockService) EXPECT() *MockServiceMockRecorder {
+	return m.recorder
+}
+
+// GetRecords mocks base method.
+func (m *MockService) GetRecords(ctx context.Context, query audit.Query) (services.Page, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecords", ctx, query)
+	ret0, _ := ret[0].(services.Page)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecords indicates an expected call of GetRecords.
+func (mr *MockServiceMockRecorder) GetRecords(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecords", reflect.TypeOf((*MockService)(nil).GetRecords), ctx, query)
+}
+
+// Record mocks base method.
+func (m *MockService) Record(ctx context.Context, changes ...services.Change) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx}
+	for _, a := range changes {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Record", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Record indicates an expected call of Record.
+func (mr *MockServiceMockRecorder) Record(ctx interface{}, changes ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx}, changes...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Record", reflect.TypeOf((*MockService)(nil).Record), varargs...)
+}
+
+// RedactUser mocks base method.
+func (m *MockService) RedactUser(ctx context.Context, userID int, moved []models.Action) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RedactUser", ctx, userID, moved)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RedactUser indicates an expected call of RedactUser.
+func (mr *MockServiceMockRecorder) RedactUser(ctx, userID, moved interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RedactUser", reflect.TypeOf((*MockService)(nil).RedactUser), ctx, userID, moved)
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/action"
+	"github.com/AntonioDaria/surfe/src/repository/user"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+	user_s "github.com/AntonioDaria/surfe/src/services/user"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.Tracer("github.com/AntonioDaria/surfe/src/services/profile")
+
+// Profile is a user along with a summary of their activity
+type Profile struct {
+	User         models.User
+	TotalActions int
+	// ActionCounts holds the number of actions of each type the user performed
+	ActionCounts map[models.ActionType]int
+	// FirstActionAt and LastActionAt are nil when the user performed no action
+	FirstActionAt *time.Time
+	LastActionAt  *time.Time
+	// ReferralIndex is the number of users the user referred, directly or indirectly
+	ReferralIndex int
+	// ReferredBy is the user who first referred the user, nil when nobody did
+	ReferredBy *int
+	// DaysActive is the number of distinct days, in UTC, the user performed actions on
+	DaysActive int
+}
+
+//go:generate mockgen -source=$GOFILE -destination=mock/profile_service_mock.go -package=mock
+type Service interface {
+	GetProfile(ctx context.Context, userID int) (*Profile, error)
+}
+
+type ServiceImpl struct {
+	userService   user_s.Service
+	actionService action_s.Service
+}
+
+func NewProfileService(userService user_s.Service, actionService action_s.Service) *ServiceImpl {
+	return &ServiceImpl{userService: userService, actionService: actionService}
+}
+
+// GetProfile joins a user with a summary of their actions and referrals. It
+// returns user.ErrUserNotFound when the user doesn't exist or was deleted.
+func (s *ServiceImpl) GetProfile(ctx context.Context, userID int) (*Profile, error) {
+	ctx, span := tracer.Start(ctx, "profile.Service.GetProfile")
+	defer span.End()
+	span.SetAttributes(attribute.Int("user.id", userID))
+
+	u, err := s.userService.GetUserByID(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, user.ErrUserNotFound) {
+			span.RecordError(err)
+		}
+		return nil, err
+	}
+
+	actions, err := s.actionService.GetActionsByUserIDs(ctx, []int{userID})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	referralIndex, err := s.actionService.GetReferralIndex(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	referrers, err := s.actionService.GetReferrers(ctx, []int{userID})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	profile := summarize(actions[userID])
+	profile.User = *u
+	profile.ReferralIndex = referralIndex[userID]
+	// Referrals by erased users lead to a tombstone rather than a user
+	if referrer, ok := referrers[userID]; ok && !action.IsTombstone(referrer) {
+		profile.ReferredBy = &referrer
+	}
+	return profile, nil
+}
+
+// summarize counts the actions of a user by type and day
+func summarize(actions []models.Action) *Profile {
+	profile := &Profile{
+		TotalActions: len(actions),
+		ActionCounts: make(map[models.ActionType]int),
+	}
+
+	days := make(map[string]bool)
+	for _, a := range actions {
+		profile.ActionCounts[a.Type]++
+		days[a.CreatedAt.UTC().Format(time.DateOnly)] = true
+
+		// Actions are kept in the order they were recorded, which isn't always chronological
+		if profile.FirstActionAt == nil || a.CreatedAt.Before(*profile.FirstActionAt) {
+			createdAt := a.CreatedAt
+			profile.FirstActionAt = &createdAt
+		}
+		if profile.LastActionAt == nil || a.CreatedAt.After(*profile.LastActionAt) {
+			createdAt := a.CreatedAt
+			profile.LastActionAt = &createdAt
+		}
+	}
+	profile.DaysActive = len(days)
+
+	return profile
+}
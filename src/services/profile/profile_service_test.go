@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/user"
+	action_mock "github.com/AntonioDaria/surfe/src/services/action/mock"
+	user_mock "github.com/AntonioDaria/surfe/src/services/user/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetProfile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userService := user_mock.NewMockService(ctrl)
+	actionService := action_mock.NewMockService(ctrl)
+	profileService := NewProfileService(userService, actionService)
+
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 1, 3, 18, 0, 0, 0, time.UTC)
+	u := &models.User{ID: 1, Name: "Ferdinande"}
+
+	userService.EXPECT().GetUserByID(gomock.Any(), 1).Return(u, nil)
+	actionService.EXPECT().GetActionsByUserIDs(gomock.Any(), []int{1}).Return(map[int][]models.Action{
+		1: {
+			{ID: 1, UserID: 1, Type: models.ActionTypeWelcome, CreatedAt: first.Add(time.Hour)},
+			{ID: 2, UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2, CreatedAt: last},
+			// Stored out of order
+			{ID: 3, UserID: 1, Type: models.ActionTypeWelcome, CreatedAt: first},
+		},
+	}, nil)
+	actionService.EXPECT().GetReferralIndex(gomock.Any()).Return(map[int]int{1: 3, 4: 1}, nil)
+	actionService.EXPECT().GetReferrers(gomock.Any(), []int{1}).Return(map[int]int{1: 4}, nil)
+
+	profile, err := profileService.GetProfile(context.Background(), 1)
+	assert.NoError(t, err)
+
+	referrer := 4
+	assert.Equal(t, &Profile{
+		User:          *u,
+		TotalActions:  3,
+		ActionCounts:  map[models.ActionType]int{models.ActionTypeWelcome: 2, models.ActionTypeReferUser: 1},
+		FirstActionAt: &first,
+		LastActionAt:  &last,
+		ReferralIndex: 3,
+		ReferredBy:    &referrer,
+		DaysActive:    2,
+	}, profile)
+}
+
+func TestGetProfile_WithoutActions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userService := user_mock.NewMockService(ctrl)
+	actionService := action_mock.NewMockService(ctrl)
+	profileService := NewProfileService(userService, actionService)
+
+	userService.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1}, nil)
+	actionService.EXPECT().GetActionsByUserIDs(gomock.Any(), []int{1}).Return(map[int][]models.Action{}, nil)
+	actionService.EXPECT().GetReferralIndex(gomock.Any()).Return(map[int]int{}, nil)
+	// Referred by a user who was erased since
+	actionService.EXPECT().GetReferrers(gomock.Any(), []int{1}).Return(map[int]int{1: -1}, nil)
+
+	profile, err := profileService.GetProfile(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Zero(t, profile.TotalActions)
+	assert.Empty(t, profile.ActionCounts)
+	assert.Nil(t, profile.FirstActionAt)
+	assert.Nil(t, profile.ReferredBy)
+	assert.Zero(t, profile.DaysActive)
+}
+
+func TestGetProfile_UserNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userService := user_mock.NewMockService(ctrl)
+	profileService := NewProfileService(userService, action_mock.NewMockService(ctrl))
+
+	userService.EXPECT().GetUserByID(gomock.Any(), 5000).Return(nil, user.ErrUserNotFound)
+
+	_, err := profileService.GetProfile(context.Background(), 5000)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+}
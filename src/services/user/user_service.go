@@ -1,25 +1,254 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/action"
 	"github.com/AntonioDaria/surfe/src/repository/user"
+	audit_s "github.com/AntonioDaria/surfe/src/services/audit"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = otel.Tracer("github.com/AntonioDaria/surfe/src/services/user")
+
 //go:generate mockgen -source=$GOFILE -destination=mock/services_mock.go -package=mock
 
 type Service interface {
-	GetUserByID(userID int) (*models.User, error)
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+	GetUsersByIDs(ctx context.Context, userIDs []int) map[int]*models.User
+	DeleteUser(ctx context.Context, userID int) error
+	ExportUserData(ctx context.Context, userID int) (*UserData, error)
+	EraseUser(ctx context.Context, userID int) error
+	SearchUsers(ctx context.Context, query user.SearchQuery) (user.SearchResult, error)
+}
+
+var (
+	ErrUnknownSort      = fmt.Errorf("unknown sort")
+	ErrInvalidDateRange = fmt.Errorf("createdFrom must be before createdTo")
+	ErrInvalidPage      = fmt.Errorf("invalid page")
+)
+
+const (
+	// DefaultSearchLimit is how many users a search returns when no limit is given
+	DefaultSearchLimit = 20
+	// MaxSearchLimit is how many users a search returns at most
+	MaxSearchLimit = 100
+)
+
+// UserData is everything stored about a user, as exported for them
+type UserData struct {
+	User models.User
+	// Actions are the actions the user performed, in the order they were recorded
+	Actions []models.Action
+	// ReferralsMade are the users the user referred, and ReferralsReceived the
+	// referrals other users made of them
+	ReferralsMade     []models.Action
+	ReferralsReceived []models.Action
 }
 
 type ServiceImpl struct {
-	userRepo user.Repository
+	userRepo   user.Repository
+	actionRepo action.Repository
+	// auditor records the users deleted, nothing is recorded when nil
+	auditor audit_s.Service
+	// erasers purge the copies of an erased user's data held outside the repositories
+	erasers []func(ctx context.Context, userID int) error
+}
+
+func NewUserService(userRepo user.Repository, actionRepo action.Repository) *ServiceImpl {
+	return &ServiceImpl{userRepo: userRepo, actionRepo: actionRepo}
 }
 
-func NewUserService(userRepo user.Repository) *ServiceImpl {
-	return &ServiceImpl{userRepo: userRepo}
+// SetAuditor sets the audit trail the users deleted from now on are recorded in
+func (s *ServiceImpl) SetAuditor(auditor audit_s.Service) {
+	s.auditor = auditor
+}
+
+// OnErase adds a function purging the copies of a user's data held outside the
+// repositories, called on every erasure. It must succeed when there is nothing
+// left to purge, so that an erasure can be retried.
+func (s *ServiceImpl) OnErase(eraser func(ctx context.Context, userID int) error) {
+	s.erasers = append(s.erasers, eraser)
 }
 
 // GetUserByID retrieves a user by ID through the repository
-func (s *ServiceImpl) GetUserByID(userID int) (*models.User, error) {
-	return s.userRepo.GetUserByID(userID)
+func (s *ServiceImpl) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "user.Service.GetUserByID")
+	defer span.End()
+
+	return s.userRepo.GetUserByID(ctx, userID)
+}
+
+// GetUsersByIDs retrieves several users at once, keyed by ID. Users that don't exist are left out.
+func (s *ServiceImpl) GetUsersByIDs(ctx context.Context, userIDs []int) map[int]*models.User {
+	ctx, span := tracer.Start(ctx, "user.Service.GetUsersByIDs")
+	defer span.End()
+
+	return s.userRepo.GetUsersByIDs(ctx, userIDs)
+}
+
+// DeleteUser soft deletes a user along with their actions and the referrals of
+// them, and records every deletion in the audit trail. It returns
+// user.ErrUserNotFound when the user doesn't exist or was already deleted.
+func (s *ServiceImpl) DeleteUser(ctx context.Context, userID int) error {
+	ctx, span := tracer.Start(ctx, "user.Service.DeleteUser")
+	defer span.End()
+	span.SetAttributes(attribute.Int("user.id", userID))
+
+	if _, err := s.userRepo.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+
+	// The actions go first, so if deleting them fails the user is still there
+	// for the deletion to be retried
+	at := time.Now().UTC()
+	actions, err := s.actionRepo.DeleteUserActions(ctx, userID, at)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to delete the user's actions: %w", err)
+	}
+
+	changes := make([]audit_s.Change, 0, len(actions)+1)
+	for _, a := range actions {
+		changes = append(changes, audit_s.ActionDeleted(a))
+	}
+
+	deleted, err := s.userRepo.DeleteUser(ctx, userID, at)
+	if err == nil {
+		changes = append(changes, audit_s.UserDeleted(*deleted))
+	}
+
+	// The deletions that went through are recorded even when the user's failed
+	if auditErr := s.audit(ctx, changes...); auditErr != nil && err == nil {
+		err = auditErr
+	}
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// ExportUserData gathers the user and every action involving them that is
+// still stored, soft deleted ones included, as long as the data is held. It
+// returns user.ErrUserNotFound when the user doesn't exist.
+func (s *ServiceImpl) ExportUserData(ctx context.Context, userID int) (*UserData, error) {
+	ctx, span := tracer.Start(ctx, "user.Service.ExportUserData")
+	defer span.End()
+	span.SetAttributes(attribute.Int("user.id", userID))
+
+	u, err := s.userRepo.GetStoredUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &UserData{
+		User:              *u,
+		Actions:           []models.Action{},
+		ReferralsMade:     []models.Action{},
+		ReferralsReceived: []models.Action{},
+	}
+	for _, a := range s.actionRepo.GetStoredActionsOf(ctx, userID) {
+		if a.UserID == userID {
+			data.Actions = append(data.Actions, a)
+			if a.Type == models.ActionTypeReferUser {
+				data.ReferralsMade = append(data.ReferralsMade, a)
+			}
+		}
+		if a.Type == models.ActionTypeReferUser && a.TargetUser == userID {
+			data.ReferralsReceived = append(data.ReferralsReceived, a)
+		}
+	}
+
+	return data, nil
+}
+
+// EraseUser removes the personal data of a user, whether or not they were
+// deleted: their name is erased, and their actions and the referrals of them
+// are moved to a tombstone so the analytics keep counting them without leading
+// back to the user. The copies held elsewhere are purged by the erasers set
+// with OnErase, their audit trail is redacted the same way, and the erasure
+// recorded. Erasing a user again completes an erasure that failed halfway. It
+// returns user.ErrUserNotFound when the user doesn't exist.
+func (s *ServiceImpl) EraseUser(ctx context.Context, userID int) error {
+	ctx, span := tracer.Start(ctx, "user.Service.EraseUser")
+	defer span.End()
+	span.SetAttributes(attribute.Int("user.id", userID))
+
+	erased, err := s.userRepo.EraseUser(ctx, userID, time.Now().UTC())
+	if err != nil {
+		if !errors.Is(err, user.ErrUserNotFound) {
+			span.RecordError(err)
+		}
+		return err
+	}
+
+	moved, err := s.actionRepo.EraseUser(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to erase the user's actions: %w", err)
+	}
+
+	for _, erase := range s.erasers {
+		if err := erase(ctx, userID); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	if s.auditor == nil {
+		return nil
+	}
+	if err := s.auditor.RedactUser(ctx, userID, moved); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if err := s.auditor.Record(ctx, audit_s.UserErased(*erased)); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// SearchUsers returns a page of the users matching the query. Results are
+// sorted by relevance and limited to DefaultSearchLimit unless asked otherwise.
+func (s *ServiceImpl) SearchUsers(ctx context.Context, query user.SearchQuery) (user.SearchResult, error) {
+	ctx, span := tracer.Start(ctx, "user.Service.SearchUsers")
+	defer span.End()
+
+	if query.Sort == "" {
+		query.Sort = user.SortRelevance
+	}
+	if !query.Sort.IsValid() {
+		return user.SearchResult{}, fmt.Errorf("%w: %q", ErrUnknownSort, query.Sort)
+	}
+	if !query.CreatedFrom.IsZero() && !query.CreatedTo.IsZero() && !query.CreatedFrom.Before(query.CreatedTo) {
+		return user.SearchResult{}, ErrInvalidDateRange
+	}
+	if query.Limit == 0 {
+		query.Limit = DefaultSearchLimit
+	}
+	if query.Limit < 0 || query.Limit > MaxSearchLimit {
+		return user.SearchResult{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidPage, MaxSearchLimit)
+	}
+	if query.Offset < 0 {
+		return user.SearchResult{}, fmt.Errorf("%w: offset can't be negative", ErrInvalidPage)
+	}
+
+	result := s.userRepo.SearchUsers(ctx, query)
+	span.SetAttributes(attribute.Int("users.total", result.Total))
+	return result, nil
+}
+
+// audit records the changes in the audit trail, when the service has one
+func (s *ServiceImpl) audit(ctx context.Context, changes ...audit_s.Change) error {
+	if s.auditor == nil {
+		return nil
+	}
+	return s.auditor.Record(ctx, changes...)
 }
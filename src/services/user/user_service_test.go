@@ -1,13 +1,19 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"github.com/AntonioDaria/surfe/src/models"
 
 	"github.com/AntonioDaria/surfe/src/repository/user/mock"
 
 	"testing"
+	"time"
 
+	action_mock "github.com/AntonioDaria/surfe/src/repository/action/mock"
 	"github.com/AntonioDaria/surfe/src/repository/user"
+	audit_s "github.com/AntonioDaria/surfe/src/services/audit"
+	audit_mock "github.com/AntonioDaria/surfe/src/services/audit/mock"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,14 +24,14 @@ func TestGetUserByID(t *testing.T) {
 
 	// Create a new mock repository
 	userRepo := mock.NewMockRepository(ctrl)
-	userService := NewUserService(userRepo)
+	userService := NewUserService(userRepo, nil)
 
 	// Define expected behavior for GetUserByID
 	expectedUser := &models.User{ID: 1, Name: "Ferdinande"}
-	userRepo.EXPECT().GetUserByID(1).Return(expectedUser, nil)
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(expectedUser, nil)
 
 	// Act
-	user, err := userService.GetUserByID(1)
+	user, err := userService.GetUserByID(context.Background(), 1)
 
 	// Assert
 	assert.NoError(t, err)
@@ -39,15 +45,218 @@ func TestGetUserByID_UserNotFound(t *testing.T) {
 
 	// Create a new mock repository
 	userRepo := mock.NewMockRepository(ctrl)
-	userService := NewUserService(userRepo)
+	userService := NewUserService(userRepo, nil)
 
 	// Define expected behavior for GetUserByID
-	userRepo.EXPECT().GetUserByID(1).Return(nil, user.ErrUserNotFound)
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(nil, user.ErrUserNotFound)
 
 	// Act
-	found_user, err := userService.GetUserByID(1)
+	found_user, err := userService.GetUserByID(context.Background(), 1)
 
 	// Assert
 	assert.ErrorIs(t, err, user.ErrUserNotFound)
 	assert.Nil(t, found_user)
 }
+
+func TestGetUsersByIDs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := mock.NewMockRepository(ctrl)
+	userService := NewUserService(userRepo, nil)
+
+	expected := map[int]*models.User{1: {ID: 1, Name: "Ferdinande"}}
+	userRepo.EXPECT().GetUsersByIDs(gomock.Any(), []int{1, 1000}).Return(expected)
+
+	users := userService.GetUsersByIDs(context.Background(), []int{1, 1000})
+
+	assert.Equal(t, expected, users)
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := mock.NewMockRepository(ctrl)
+	actionRepo := action_mock.NewMockRepository(ctrl)
+	auditor := audit_mock.NewMockService(ctrl)
+	userService := NewUserService(userRepo, actionRepo)
+	userService.SetAuditor(auditor)
+
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	deletedUser := &models.User{ID: 1, Name: "Ferdinande", DeletedAt: &deletedAt}
+	deletedAction := models.Action{ID: 7, UserID: 1, Type: models.ActionTypeWelcome, DeletedAt: &deletedAt}
+
+	// The user's actions are deleted first, at the same time as the user
+	gomock.InOrder(
+		userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(&models.User{ID: 1, Name: "Ferdinande"}, nil),
+		actionRepo.EXPECT().DeleteUserActions(gomock.Any(), 1, gomock.Any()).Return([]models.Action{deletedAction}, nil),
+		userRepo.EXPECT().DeleteUser(gomock.Any(), 1, gomock.Any()).Return(deletedUser, nil),
+		auditor.EXPECT().Record(gomock.Any(), audit_s.ActionDeleted(deletedAction), audit_s.UserDeleted(*deletedUser)).Return(nil),
+	)
+
+	assert.NoError(t, userService.DeleteUser(context.Background(), 1))
+}
+
+func TestDeleteUser_UserNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// Nothing is deleted or recorded for a user that doesn't exist
+	userRepo := mock.NewMockRepository(ctrl)
+	userService := NewUserService(userRepo, action_mock.NewMockRepository(ctrl))
+	userService.SetAuditor(audit_mock.NewMockService(ctrl))
+
+	userRepo.EXPECT().GetUserByID(gomock.Any(), 1).Return(nil, user.ErrUserNotFound)
+
+	assert.ErrorIs(t, userService.DeleteUser(context.Background(), 1), user.ErrUserNotFound)
+}
+
+func TestExportUserData(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := mock.NewMockRepository(ctrl)
+	actionRepo := action_mock.NewMockRepository(ctrl)
+	userService := NewUserService(userRepo, actionRepo)
+
+	// A soft deleted user's data is still held, so it is exported with their deleted actions
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	welcome := models.Action{ID: 1, UserID: 1, Type: models.ActionTypeWelcome, DeletedAt: &deletedAt}
+	referralMade := models.Action{ID: 2, UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2, DeletedAt: &deletedAt}
+	referralReceived := models.Action{ID: 3, UserID: 3, Type: models.ActionTypeReferUser, TargetUser: 1, DeletedAt: &deletedAt}
+	deletedUser := &models.User{ID: 1, Name: "Ferdinande", DeletedAt: &deletedAt}
+	userRepo.EXPECT().GetStoredUser(gomock.Any(), 1).Return(deletedUser, nil)
+	actionRepo.EXPECT().GetStoredActionsOf(gomock.Any(), 1).Return([]models.Action{
+		welcome, referralMade, referralReceived,
+	})
+
+	data, err := userService.ExportUserData(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, &UserData{
+		User:              *deletedUser,
+		Actions:           []models.Action{welcome, referralMade},
+		ReferralsMade:     []models.Action{referralMade},
+		ReferralsReceived: []models.Action{referralReceived},
+	}, data)
+}
+
+func TestExportUserData_UserNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := mock.NewMockRepository(ctrl)
+	userService := NewUserService(userRepo, action_mock.NewMockRepository(ctrl))
+
+	userRepo.EXPECT().GetStoredUser(gomock.Any(), 5000).Return(nil, user.ErrUserNotFound)
+
+	_, err := userService.ExportUserData(context.Background(), 5000)
+	assert.ErrorIs(t, err, user.ErrUserNotFound)
+}
+
+func TestEraseUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := mock.NewMockRepository(ctrl)
+	actionRepo := action_mock.NewMockRepository(ctrl)
+	auditor := audit_mock.NewMockService(ctrl)
+	userService := NewUserService(userRepo, actionRepo)
+	userService.SetAuditor(auditor)
+
+	var purged []int
+	userService.OnErase(func(ctx context.Context, userID int) error {
+		purged = append(purged, userID)
+		return nil
+	})
+
+	erasedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	erasedUser := &models.User{ID: 1, DeletedAt: &erasedAt, ErasedAt: &erasedAt}
+	moved := []models.Action{{ID: 7, UserID: -1, Type: models.ActionTypeWelcome}}
+
+	// The user goes first, so an erasure that fails afterwards can be retried
+	gomock.InOrder(
+		userRepo.EXPECT().EraseUser(gomock.Any(), 1, gomock.Any()).Return(erasedUser, nil),
+		actionRepo.EXPECT().EraseUser(gomock.Any(), 1).Return(moved, nil),
+		auditor.EXPECT().RedactUser(gomock.Any(), 1, moved).Return(nil),
+		auditor.EXPECT().Record(gomock.Any(), audit_s.UserErased(*erasedUser)).Return(nil),
+	)
+
+	assert.NoError(t, userService.EraseUser(context.Background(), 1))
+	assert.Equal(t, []int{1}, purged)
+}
+
+func TestEraseUser_EraserFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := mock.NewMockRepository(ctrl)
+	actionRepo := action_mock.NewMockRepository(ctrl)
+	auditor := audit_mock.NewMockService(ctrl)
+	userService := NewUserService(userRepo, actionRepo)
+	userService.SetAuditor(auditor)
+
+	errPurge := errors.New("purge failed")
+	userService.OnErase(func(ctx context.Context, userID int) error { return errPurge })
+
+	erasedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	userRepo.EXPECT().EraseUser(gomock.Any(), 1, gomock.Any()).Return(&models.User{ID: 1, ErasedAt: &erasedAt}, nil)
+	actionRepo.EXPECT().EraseUser(gomock.Any(), 1).Return(nil, nil)
+
+	// The erasure isn't recorded as done, so it is retried
+	assert.ErrorIs(t, userService.EraseUser(context.Background(), 1), errPurge)
+}
+
+func TestEraseUser_UserNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := mock.NewMockRepository(ctrl)
+	userService := NewUserService(userRepo, action_mock.NewMockRepository(ctrl))
+
+	userRepo.EXPECT().EraseUser(gomock.Any(), 5000, gomock.Any()).Return(nil, user.ErrUserNotFound)
+
+	assert.ErrorIs(t, userService.EraseUser(context.Background(), 5000), user.ErrUserNotFound)
+}
+
+func TestSearchUsers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := mock.NewMockRepository(ctrl)
+	userService := NewUserService(userRepo, nil)
+
+	// Searches are sorted by relevance and limited by default
+	userRepo.EXPECT().SearchUsers(gomock.Any(), user.SearchQuery{Name: "am", Sort: user.SortRelevance, Limit: DefaultSearchLimit}).
+		Return(user.SearchResult{Users: []models.User{{ID: 2, Name: "Amelie"}}, Total: 1})
+
+	result, err := userService.SearchUsers(context.Background(), user.SearchQuery{Name: "am"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Total)
+}
+
+func TestSearchUsers_Invalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userService := NewUserService(mock.NewMockRepository(ctrl), nil)
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query user.SearchQuery
+		err   error
+	}{
+		{name: "unknown sort", query: user.SearchQuery{Sort: "age"}, err: ErrUnknownSort},
+		{name: "empty date range", query: user.SearchQuery{CreatedFrom: day, CreatedTo: day}, err: ErrInvalidDateRange},
+		{name: "limit too high", query: user.SearchQuery{Limit: MaxSearchLimit + 1}, err: ErrInvalidPage},
+		{name: "negative offset", query: user.SearchQuery{Offset: -1}, err: ErrInvalidPage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := userService.SearchUsers(context.Background(), tt.query)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
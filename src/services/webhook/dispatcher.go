@@ -0,0 +1,326 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/events"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/webhook"
+	"github.com/rs/zerolog"
+)
+
+// Headers sent with every webhook request
+const (
+	HeaderEvent     = "X-Surfe-Event"
+	HeaderDelivery  = "X-Surfe-Delivery"
+	HeaderTimestamp = "X-Surfe-Timestamp"
+	// HeaderSignature carries Sign of the timestamp and the body
+	HeaderSignature = "X-Surfe-Signature"
+)
+
+const (
+	// maxConcurrentAttempts bounds how many webhook requests are in flight at once
+	maxConcurrentAttempts = 16
+	// maxRetryBackoff caps the exponential backoff between attempts
+	maxRetryBackoff = time.Hour
+)
+
+// Payload is the JSON body delivered to webhooks
+type Payload struct {
+	DeliveryID int               `json:"deliveryId"`
+	Event      models.ActionType `json:"event"`
+	Action     models.Action     `json:"action"`
+}
+
+// DispatcherConfig sets how deliveries are attempted
+type DispatcherConfig struct {
+	// MaxAttempts is how many times a delivery is attempted before it fails
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for every following one
+	Backoff time.Duration
+	// Timeout bounds each attempt
+	Timeout time.Duration
+	// Client sends the requests. When nil, a client that only reaches public
+	// addresses and doesn't follow redirects is used.
+	Client *http.Client
+}
+
+// Dispatcher delivers the actions published to the hub to the webhooks
+// registered for them, retrying the attempts that may succeed later with
+// exponential backoff
+type Dispatcher struct {
+	webhookRepo webhook.Repository
+	config      DispatcherConfig
+	logger      zerolog.Logger
+
+	wg       sync.WaitGroup
+	inFlight chan struct{}
+}
+
+func NewDispatcher(webhookRepo webhook.Repository, config DispatcherConfig, logger zerolog.Logger) *Dispatcher {
+	if config.Client == nil {
+		config.Client = newClient(publicOnly)
+	}
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+
+	return &Dispatcher{
+		webhookRepo: webhookRepo,
+		config:      config,
+		logger:      logger,
+		inFlight:    make(chan struct{}, maxConcurrentAttempts),
+	}
+}
+
+// Sign returns the signature of a webhook request: the hex encoded HMAC-SHA256,
+// keyed with the webhook's secret, of the timestamp, a dot and the body
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run delivers the actions published to the hub until ctx is done. Deliveries
+// left pending by a previous run are resumed first. It returns once the attempts
+// in flight are over; deliveries still pending are resumed by the next run.
+func (d *Dispatcher) Run(ctx context.Context, hub *events.Hub) {
+	for _, delivery := range d.webhookRepo.GetPendingDeliveries(ctx) {
+		d.start(ctx, delivery)
+	}
+
+	lastEventID := ""
+	for ctx.Err() == nil {
+		sub := hub.Subscribe(lastEventID, events.Filter{})
+		if sub.Gap() {
+			d.logger.Error().Str("last_event_id", lastEventID).Msg("Webhook dispatcher fell behind the replay buffer, some events won't be delivered")
+		}
+		lastEventID = d.dispatch(ctx, sub, lastEventID)
+		sub.Close()
+
+		if ctx.Err() == nil {
+			d.logger.Warn().Msg("Webhook dispatcher fell behind, resuming from the replay buffer")
+		}
+	}
+
+	d.wg.Wait()
+}
+
+// dispatch records a delivery for every webhook matching the events of the
+// subscription until it is dropped or ctx is done, returning the last event ID seen
+func (d *Dispatcher) dispatch(ctx context.Context, sub *events.Subscription, lastEventID string) string {
+	for {
+		var batch []events.Event
+		select {
+		case <-ctx.Done():
+			return lastEventID
+		case event, ok := <-sub.Events():
+			if !ok {
+				return lastEventID
+			}
+			batch = append(batch, event)
+		}
+
+		// Deliveries of the events already waiting are stored with a single write
+		for drained := false; !drained; {
+			select {
+			case event, ok := <-sub.Events():
+				if !ok {
+					drained = true
+					break
+				}
+				batch = append(batch, event)
+			default:
+				drained = true
+			}
+		}
+		lastEventID = batch[len(batch)-1].ID
+
+		d.record(ctx, batch)
+	}
+}
+
+// record stores a pending delivery of each event to each matching webhook and starts them
+func (d *Dispatcher) record(ctx context.Context, batch []events.Event) {
+	webhooks := d.webhookRepo.GetWebhooks(ctx)
+	if len(webhooks) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	var deliveries []models.WebhookDelivery
+	for _, event := range batch {
+		for _, w := range webhooks {
+			if !w.Matches(event.Action.Type) {
+				continue
+			}
+			deliveries = append(deliveries, models.WebhookDelivery{
+				WebhookID: w.ID,
+				Event:     event.Action.Type,
+				Action:    event.Action,
+				Status:    models.DeliveryStatusPending,
+				CreatedAt: now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	stored, err := d.webhookRepo.AddDeliveries(ctx, deliveries)
+	if err != nil {
+		d.logger.Error().Err(err).Int("deliveries", len(deliveries)).Msg("Failed to record webhook deliveries")
+		return
+	}
+	for _, delivery := range stored {
+		d.start(ctx, delivery)
+	}
+}
+
+// start attempts a delivery in the background until it succeeds, fails for good or ctx is done
+func (d *Dispatcher) start(ctx context.Context, delivery models.WebhookDelivery) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.deliver(ctx, delivery)
+	}()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.WebhookDelivery) {
+	logger := d.logger.With().Int("webhook_id", delivery.WebhookID).Int("delivery_id", delivery.ID).Logger()
+
+	for delivery.Status == models.DeliveryStatusPending {
+		if delivery.NextAttemptAt != nil {
+			timer := time.NewTimer(time.Until(*delivery.NextAttemptAt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		statusCode, err := d.attempt(ctx, delivery)
+		if ctx.Err() != nil {
+			// Interrupted attempts don't count, the next run makes them again
+			return
+		}
+
+		now := time.Now().UTC()
+		delivery.Attempts++
+		delivery.StatusCode = statusCode
+		delivery.Error = ""
+		delivery.NextAttemptAt = nil
+
+		switch {
+		case err == nil:
+			delivery.Status = models.DeliveryStatusSucceeded
+			delivery.CompletedAt = &now
+		case delivery.Attempts >= d.config.MaxAttempts || !retryable(statusCode, err):
+			delivery.Status = models.DeliveryStatusFailed
+			delivery.Error = err.Error()
+			delivery.CompletedAt = &now
+			logger.Warn().Err(err).Int("attempts", delivery.Attempts).Msg("Webhook delivery failed")
+		default:
+			next := now.Add(d.backoff(delivery.Attempts))
+			delivery.Error = err.Error()
+			delivery.NextAttemptAt = &next
+		}
+
+		if err := d.webhookRepo.UpdateDelivery(ctx, delivery); err != nil {
+			logger.Error().Err(err).Msg("Failed to update webhook delivery")
+			if errors.Is(err, webhook.ErrDeliveryNotFound) {
+				return
+			}
+		}
+	}
+}
+
+// attempt sends a delivery once, returning the response status code if there was one
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.WebhookDelivery) (int, error) {
+	select {
+	case d.inFlight <- struct{}{}:
+		defer func() { <-d.inFlight }()
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	w, err := d.webhookRepo.GetWebhook(ctx, delivery.WebhookID)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := json.Marshal(Payload{DeliveryID: delivery.ID, Event: delivery.Event, Action: delivery.Action})
+	if err != nil {
+		return 0, err
+	}
+
+	if d.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(delivery.Event))
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.ID))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(w.Secret, timestamp, body))
+
+	resp, err := d.config.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Draining the body lets the connection be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether a failed attempt may succeed when made again: the
+// receiver was unavailable, overloaded or couldn't be reached. Other responses,
+// such as 404 or 410, mean the receiver won't take the delivery, and a webhook
+// that was removed or points at a forbidden address won't change either.
+func retryable(statusCode int, err error) bool {
+	switch {
+	case errors.Is(err, ErrForbiddenAddress), errors.Is(err, webhook.ErrWebhookNotFound):
+		return false
+	case statusCode == 0:
+		return true
+	default:
+		return statusCode == http.StatusTooManyRequests || statusCode >= 500
+	}
+}
+
+// backoff returns the delay before the attempt following the given number of attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.config.Backoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.Tracer("github.com/AntonioDaria/surfe/src/services/webhook")
+
+var (
+	ErrInvalidURL   = fmt.Errorf("webhook URL must be an absolute http or https URL")
+	ErrUnknownEvent = fmt.Errorf("unknown event type")
+)
+
+// secretPrefix tells webhook secrets apart from other credentials
+const secretPrefix = "whsec_"
+
+//go:generate mockgen -source=$GOFILE -destination=mock/webhook_service_mock.go -package=mock
+type Service interface {
+	CreateWebhook(ctx context.Context, endpoint string, events []models.ActionType) (*models.Webhook, error)
+	GetDeliveries(ctx context.Context, webhookID int) ([]models.WebhookDelivery, error)
+	EraseUser(ctx context.Context, userID int) error
+}
+
+type ServiceImpl struct {
+	webhookRepo webhook.Repository
+}
+
+func NewWebhookService(webhookRepo webhook.Repository) *ServiceImpl {
+	return &ServiceImpl{webhookRepo: webhookRepo}
+}
+
+// CreateWebhook registers an endpoint notified of the actions of the given types,
+// or of every action when events is empty. The webhook is returned with the
+// secret its payloads are signed with, and belongs to the principal of ctx.
+func (s *ServiceImpl) CreateWebhook(ctx context.Context, endpoint string, events []models.ActionType) (*models.Webhook, error) {
+	ctx, span := tracer.Start(ctx, "webhook.Service.CreateWebhook")
+	defer span.End()
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	seen := make(map[models.ActionType]bool, len(events))
+	unique := make([]models.ActionType, 0, len(events))
+	for _, event := range events {
+		if !event.IsValid() {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownEvent, event)
+		}
+		if !seen[event] {
+			seen[event] = true
+			unique = append(unique, event)
+		}
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	owner := ""
+	if principal := auth.PrincipalFromContext(ctx); principal != nil {
+		owner = principal.ID()
+	}
+
+	return s.webhookRepo.CreateWebhook(ctx, models.Webhook{
+		URL:       u.String(),
+		Events:    unique,
+		Secret:    secret,
+		Owner:     owner,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// GetDeliveries returns the delivery log of a webhook, most recent first. As the
+// deliveries carry the actions delivered, only the owner of the webhook and
+// admins can read them; the webhooks of others are reported as not found.
+func (s *ServiceImpl) GetDeliveries(ctx context.Context, webhookID int) ([]models.WebhookDelivery, error) {
+	ctx, span := tracer.Start(ctx, "webhook.Service.GetDeliveries")
+	defer span.End()
+	span.SetAttributes(attribute.Int("webhook.id", webhookID))
+
+	w, err := s.webhookRepo.GetWebhook(ctx, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	if principal := auth.PrincipalFromContext(ctx); principal != nil {
+		if principal.ID() != w.Owner && !principal.HasScope(auth.ScopeAdmin) {
+			return nil, webhook.ErrWebhookNotFound
+		}
+	}
+
+	return s.webhookRepo.GetDeliveries(ctx, webhookID)
+}
+
+// EraseUser drops the deliveries of the actions involving an erased user, which
+// would otherwise keep the actions as they were before the erasure
+func (s *ServiceImpl) EraseUser(ctx context.Context, userID int) error {
+	ctx, span := tracer.Start(ctx, "webhook.Service.EraseUser")
+	defer span.End()
+	span.SetAttributes(attribute.Int("user.id", userID))
+
+	if err := s.webhookRepo.EraseUser(ctx, userID); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to erase the user's webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AntonioDaria/surfe/src/events"
+	"github.com/AntonioDaria/surfe/src/middleware/auth"
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/webhook"
+	"github.com/AntonioDaria/surfe/src/repository/webhook/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceImpl_CreateWebhook(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	webhookRepo := mock.NewMockRepository(ctrl)
+	webhookService := NewWebhookService(webhookRepo)
+
+	webhookRepo.EXPECT().CreateWebhook(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, w models.Webhook) (*models.Webhook, error) {
+			w.ID = 1
+			return &w, nil
+		})
+
+	ctx := auth.ContextWithPrincipal(context.Background(), &auth.Principal{Name: "partner"})
+	created, err := webhookService.CreateWebhook(ctx, "https://example.com/hook",
+		[]models.ActionType{models.ActionTypeReferUser, models.ActionTypeReferUser, models.ActionTypeConnectCRM})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, created.ID)
+	assert.Equal(t, "key:partner", created.Owner)
+	assert.Equal(t, []models.ActionType{models.ActionTypeReferUser, models.ActionTypeConnectCRM}, created.Events)
+	assert.True(t, strings.HasPrefix(created.Secret, secretPrefix))
+}
+
+func TestServiceImpl_CreateWebhook_Invalid(t *testing.T) {
+	webhookService := NewWebhookService(nil)
+
+	_, err := webhookService.CreateWebhook(context.Background(), "ftp://example.com", nil)
+	assert.ErrorIs(t, err, ErrInvalidURL)
+
+	_, err = webhookService.CreateWebhook(context.Background(), "/hook", nil)
+	assert.ErrorIs(t, err, ErrInvalidURL)
+
+	_, err = webhookService.CreateWebhook(context.Background(), "http://example.com", []models.ActionType{"DANCE"})
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+}
+
+func TestServiceImpl_GetDeliveries_Owner(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	webhookRepo := mock.NewMockRepository(ctrl)
+	webhookService := NewWebhookService(webhookRepo)
+
+	deliveries := []models.WebhookDelivery{{ID: 1, WebhookID: 1}}
+	webhookRepo.EXPECT().GetWebhook(gomock.Any(), 1).Return(&models.Webhook{ID: 1, Owner: "key:partner"}, nil).Times(3)
+	webhookRepo.EXPECT().GetDeliveries(gomock.Any(), 1).Return(deliveries, nil).Times(2)
+
+	tests := []struct {
+		principal *auth.Principal
+		err       error
+	}{
+		{principal: &auth.Principal{Name: "partner"}},
+		{principal: &auth.Principal{Name: "ops", Scopes: []auth.Scope{auth.ScopeAdmin}}},
+		// A token whose subject is the name of the owning key is someone else
+		{principal: &auth.Principal{Name: "partner", Subject: "partner"}, err: webhook.ErrWebhookNotFound},
+	}
+	for _, tt := range tests {
+		got, err := webhookService.GetDeliveries(auth.ContextWithPrincipal(context.Background(), tt.principal), 1)
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, deliveries, got)
+	}
+}
+
+// receiver records the webhook requests it gets, answering with the given statuses
+// in turn. Responses redirect to the URL in the next query parameter, if any.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	r := &receiver{statuses: statuses, received: make(chan struct{}, 100)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		r.mu.Unlock()
+
+		if next := req.URL.Query().Get("next"); next != "" {
+			w.Header().Set("Location", next)
+		}
+		w.WriteHeader(status)
+		r.received <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+
+	return r, server
+}
+
+func (r *receiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for range n {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook was not delivered")
+		}
+	}
+}
+
+// runDispatcher runs a dispatcher until the test ends. The receivers listen on
+// loopback, so its client doesn't vet addresses.
+func runDispatcher(t *testing.T, repo webhook.Repository, hub *events.Hub, maxAttempts int) {
+	run(t, NewDispatcher(repo, DispatcherConfig{
+		MaxAttempts: maxAttempts,
+		Backoff:     10 * time.Millisecond,
+		Timeout:     time.Second,
+		Client:      newClient(nil),
+	}, zerolog.New(os.Stderr)), hub)
+}
+
+func run(t *testing.T, dispatcher *Dispatcher, hub *events.Hub) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatcher.Run(ctx, hub)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Wait for the dispatcher to subscribe, so no action is published before it listens
+	for hub.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForDelivery waits until the first delivery of the webhook is no longer pending
+func waitForDelivery(t *testing.T, repo webhook.Repository, webhookID int) models.WebhookDelivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, err := repo.GetDeliveries(context.Background(), webhookID)
+		assert.NoError(t, err)
+		if len(deliveries) > 0 && deliveries[len(deliveries)-1].Status != models.DeliveryStatusPending {
+			return deliveries[len(deliveries)-1]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatal("delivery did not complete")
+	return models.WebhookDelivery{}
+}
+
+func TestDispatcher_Delivers(t *testing.T) {
+	recv, server := newReceiver(t)
+
+	repo, _ := webhook.NewWebhookRepo("", "")
+	hub := events.NewHub(10)
+	created, err := NewWebhookService(repo).CreateWebhook(context.Background(), server.URL,
+		[]models.ActionType{models.ActionTypeReferUser})
+	assert.NoError(t, err)
+	runDispatcher(t, repo, hub, 3)
+
+	// Only the actions of the registered types are delivered
+	hub.Publish(
+		models.Action{ID: 1, UserID: 1, Type: models.ActionTypeWelcome},
+		models.Action{ID: 2, UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2},
+	)
+	recv.wait(t, 1)
+
+	delivery := waitForDelivery(t, repo, created.ID)
+	assert.Equal(t, models.DeliveryStatusSucceeded, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusOK, delivery.StatusCode)
+
+	recv.mu.Lock()
+	defer recv.mu.Unlock()
+	assert.Len(t, recv.requests, 1)
+
+	req, body := recv.requests[0], recv.bodies[0]
+	assert.Equal(t, string(models.ActionTypeReferUser), req.Header.Get(HeaderEvent))
+	assert.Equal(t, strconv.Itoa(delivery.ID), req.Header.Get(HeaderDelivery))
+
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, Sign(created.Secret, timestamp, body), req.Header.Get(HeaderSignature))
+
+	var payload Payload
+	assert.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, delivery.ID, payload.DeliveryID)
+	assert.Equal(t, 2, payload.Action.ID)
+}
+
+func TestDispatcher_Retries(t *testing.T) {
+	recv, server := newReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+
+	repo, _ := webhook.NewWebhookRepo("", "")
+	hub := events.NewHub(10)
+	created, err := NewWebhookService(repo).CreateWebhook(context.Background(), server.URL, nil)
+	assert.NoError(t, err)
+	runDispatcher(t, repo, hub, 3)
+
+	hub.Publish(models.Action{ID: 1, UserID: 1, Type: models.ActionTypeConnectCRM})
+	recv.wait(t, 3)
+
+	delivery := waitForDelivery(t, repo, created.ID)
+	assert.Equal(t, models.DeliveryStatusSucceeded, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+}
+
+func TestDispatcher_GivesUp(t *testing.T) {
+	recv, server := newReceiver(t, http.StatusBadGateway, http.StatusBadGateway)
+
+	repo, _ := webhook.NewWebhookRepo("", "")
+	hub := events.NewHub(10)
+	created, err := NewWebhookService(repo).CreateWebhook(context.Background(), server.URL, nil)
+	assert.NoError(t, err)
+	runDispatcher(t, repo, hub, 2)
+
+	hub.Publish(models.Action{ID: 1, UserID: 1, Type: models.ActionTypeConnectCRM})
+	recv.wait(t, 2)
+
+	delivery := waitForDelivery(t, repo, created.ID)
+	assert.Equal(t, models.DeliveryStatusFailed, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Equal(t, http.StatusBadGateway, delivery.StatusCode)
+	assert.Equal(t, "unexpected status 502", delivery.Error)
+	assert.NotNil(t, delivery.CompletedAt)
+}
+
+func TestDispatcher_PermanentFailures(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusGone, http.StatusFound} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			redirected, target := newReceiver(t)
+			recv, server := newReceiver(t, status)
+
+			repo, _ := webhook.NewWebhookRepo("", "")
+			hub := events.NewHub(10)
+			created, err := NewWebhookService(repo).CreateWebhook(context.Background(), server.URL+"?next="+target.URL, nil)
+			assert.NoError(t, err)
+			runDispatcher(t, repo, hub, 3)
+
+			hub.Publish(models.Action{ID: 1, UserID: 1, Type: models.ActionTypeConnectCRM})
+			recv.wait(t, 1)
+
+			// The receiver won't take the delivery, so it isn't attempted again,
+			// and redirects aren't followed
+			delivery := waitForDelivery(t, repo, created.ID)
+			assert.Equal(t, models.DeliveryStatusFailed, delivery.Status)
+			assert.Equal(t, 1, delivery.Attempts)
+			assert.Equal(t, status, delivery.StatusCode)
+
+			redirected.mu.Lock()
+			defer redirected.mu.Unlock()
+			assert.Empty(t, redirected.requests)
+		})
+	}
+}
+
+func TestDispatcher_RefusesPrivateAddresses(t *testing.T) {
+	recv, server := newReceiver(t)
+
+	repo, _ := webhook.NewWebhookRepo("", "")
+	hub := events.NewHub(10)
+	created, err := NewWebhookService(repo).CreateWebhook(context.Background(), server.URL, nil)
+	assert.NoError(t, err)
+
+	// The default client only dials public addresses, and the receiver is on loopback
+	run(t, NewDispatcher(repo, DispatcherConfig{MaxAttempts: 3, Backoff: 10 * time.Millisecond}, zerolog.Nop()), hub)
+
+	hub.Publish(models.Action{ID: 1, UserID: 1, Type: models.ActionTypeConnectCRM})
+
+	delivery := waitForDelivery(t, repo, created.ID)
+	assert.Equal(t, models.DeliveryStatusFailed, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Contains(t, delivery.Error, ErrForbiddenAddress.Error())
+
+	recv.mu.Lock()
+	defer recv.mu.Unlock()
+	assert.Empty(t, recv.requests)
+}
+
+func TestPublicOnly(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "10.1.2.3:80", "192.168.0.1:80", "169.254.169.254:80", "0.0.0.0:80", "[::ffff:127.0.0.1]:80", "[fd00::1]:80"} {
+		assert.ErrorIs(t, publicOnly("tcp", address, nil), ErrForbiddenAddress, address)
+	}
+	for _, address := range []string{"93.184.216.34:443", "[2606:2800:220:1:248:1893:25c8:1946]:443"} {
+		assert.NoError(t, publicOnly("tcp", address, nil), address)
+	}
+}
+
+func TestDispatcher_ResumesPendingDeliveries(t *testing.T) {
+	recv, server := newReceiver(t)
+
+	repo, _ := webhook.NewWebhookRepo("", "")
+	created, err := NewWebhookService(repo).CreateWebhook(context.Background(), server.URL, nil)
+	assert.NoError(t, err)
+
+	// Left pending by a previous run
+	_, err = repo.AddDeliveries(context.Background(), []models.WebhookDelivery{{
+		WebhookID: created.ID,
+		Event:     models.ActionTypeWelcome,
+		Action:    models.Action{ID: 1, Type: models.ActionTypeWelcome},
+		Status:    models.DeliveryStatusPending,
+		Attempts:  1,
+	}})
+	assert.NoError(t, err)
+
+	runDispatcher(t, repo, events.NewHub(10), 3)
+	recv.wait(t, 1)
+
+	delivery := waitForDelivery(t, repo, created.ID)
+	assert.Equal(t, models.DeliveryStatusSucceeded, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	dispatcher := NewDispatcher(nil, DispatcherConfig{Backoff: time.Second}, zerolog.Nop())
+
+	assert.Equal(t, time.Second, dispatcher.backoff(1))
+	assert.Equal(t, 2*time.Second, dispatcher.backoff(2))
+	assert.Equal(t, 8*time.Second, dispatcher.backoff(4))
+	assert.Equal(t, maxRetryBackoff, dispatcher.backoff(40))
+}
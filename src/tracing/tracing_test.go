@@ -0,0 +1,191 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AntonioDaria/surfe/src/models"
+	"github.com/AntonioDaria/surfe/src/repository/action"
+	action_s "github.com/AntonioDaria/surfe/src/services/action"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider recording spans in memory for the duration of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	if _, err := Setup(Config{Exporter: ExporterNone}); err != nil {
+		t.Fatalf("failed to set up tracing: %v", err)
+	}
+
+	return recorder
+}
+
+func TestMiddleware_Spans_Across_Layers(t *testing.T) {
+	recorder := recordSpans(t)
+
+	actionRepo := &action.RepositoryImpl{
+		Actions: []models.Action{
+			{ID: 1, UserID: 1, Type: models.ActionTypeReferUser, TargetUser: 2},
+		},
+	}
+	actionService := action_s.NewActionService(actionRepo, nil)
+
+	app := fiber.New()
+	app.Use(Middleware())
+	app.Get("/actions/referral", func(c *fiber.Ctx) error {
+		referralIndex, err := actionService.GetReferralIndex(c.UserContext())
+		if err != nil {
+			return err
+		}
+		return c.JSON(referralIndex)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/actions/referral", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := recorder.Ended()
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		names[span.Name()] = span
+	}
+
+	server, ok := names["GET /actions/referral"]
+	if !ok {
+		t.Fatalf("missing server span, got %v", names)
+	}
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+
+	// The server span continues the incoming trace
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+
+	// Service and repository spans are nested below it
+	service := names["action.Service.GetReferralIndex"]
+	repository := names["action.Repository.GetReferrals"]
+	if service == nil || repository == nil {
+		t.Fatalf("missing service or repository span, got %v", names)
+	}
+	assert.Equal(t, server.SpanContext().SpanID(), service.Parent().SpanID())
+	assert.Equal(t, service.SpanContext().SpanID(), repository.Parent().SpanID())
+}
+
+func TestMiddleware_Unmatched_Route(t *testing.T) {
+	recorder := recordSpans(t)
+
+	app := fiber.New()
+	app.Use(Middleware())
+
+	req := httptest.NewRequest(http.MethodGet, "/nope", nil)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "GET", spans[0].Name())
+}
+
+func TestSetup_File_Exporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	filePath := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(Config{ServiceName: "surfe", Exporter: ExporterFile, FilePath: filePath})
+	if err != nil {
+		t.Fatalf("failed to set up tracing: %v", err)
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent-span")
+	_, span := otel.Tracer("test").Start(ctx, "test-span", trace.WithSpanKind(trace.SpanKindServer))
+	span.SetAttributes(attribute.Int("user.id", 7))
+	span.SetStatus(codes.Error, "failed")
+	span.End()
+	parent.End()
+
+	// Shutting down flushes the batched spans to the file
+	assert.NoError(t, shutdown(context.Background()))
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("failed to read trace file: %v", err)
+	}
+
+	// Each line is an OTLP JSON TracesData, with hex IDs and 64 bit integers as strings
+	var exported struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value struct{ StringValue string }
+				}
+			}
+			ScopeSpans []struct {
+				Scope struct{ Name string }
+				Spans []struct {
+					TraceID           string `json:"traceId"`
+					SpanID            string `json:"spanId"`
+					ParentSpanID      string `json:"parentSpanId"`
+					Name              string
+					Kind              int
+					StartTimeUnixNano string
+					Attributes        []struct {
+						Key   string
+						Value struct{ IntValue string }
+					}
+					Status struct {
+						Code    int
+						Message string
+					}
+				}
+			}
+		}
+	}
+	err = json.NewDecoder(strings.NewReader(string(content))).Decode(&exported)
+	assert.NoError(t, err)
+
+	resourceSpans := exported.ResourceSpans[0]
+	assert.Equal(t, "service.name", resourceSpans.Resource.Attributes[0].Key)
+	assert.Equal(t, "surfe", resourceSpans.Resource.Attributes[0].Value.StringValue)
+	assert.Equal(t, "test", resourceSpans.ScopeSpans[0].Scope.Name)
+
+	spans := resourceSpans.ScopeSpans[0].Spans
+	assert.Len(t, spans, 2)
+	exportedSpan, exportedParent := spans[0], spans[1]
+	assert.Equal(t, "test-span", exportedSpan.Name)
+	assert.Equal(t, 2, exportedSpan.Kind)
+	assert.Regexp(t, "^[0-9a-f]{32}$", exportedSpan.TraceID)
+	assert.Regexp(t, "^[0-9a-f]{16}$", exportedSpan.SpanID)
+	assert.Equal(t, exportedParent.SpanID, exportedSpan.ParentSpanID)
+	assert.Regexp(t, "^[0-9]+$", exportedSpan.StartTimeUnixNano)
+	assert.Equal(t, "user.id", exportedSpan.Attributes[0].Key)
+	assert.Equal(t, "7", exportedSpan.Attributes[0].Value.IntValue)
+	assert.Equal(t, 2, exportedSpan.Status.Code)
+	assert.Equal(t, "failed", exportedSpan.Status.Message)
+}
+
+func TestSetup_Unknown_Exporter(t *testing.T) {
+	_, err := Setup(Config{Exporter: "jaeger"})
+	assert.Error(t, err)
+}